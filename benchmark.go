@@ -2,537 +2,510 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
+	"bifrost-benchmarks/pkg/bench"
+
 	"github.com/joho/godotenv"
-	"github.com/shirou/gopsutil/net"
-	"github.com/shirou/gopsutil/v3/process"
-	vegeta "github.com/tsenart/vegeta/v12/lib"
 )
 
-// Provider represents an API provider to be benchmarked
-type Provider struct {
-	Name     string
-	Endpoint string
-	Port     string
-	Payload  []byte
-}
-
-// BenchmarkResult holds the metrics from a benchmark run
-type BenchmarkResult struct {
-	ProviderName      string
-	Metrics           *vegeta.Metrics
-	CPUUsage          float64
-	ServerMemoryStats []ServerMemStat
-	DropReasons       map[string]int // Track reasons for dropped requests
-}
-
-// MemStat captures memory statistics
-type MemStat struct {
-	Alloc      uint64
-	TotalAlloc uint64
-	Sys        uint64
-	NumGC      uint32
-}
-
-// ServerMemStat captures server memory usage over time
-type ServerMemStat struct {
-	Timestamp  time.Time
-	RSS        uint64  // Resident Set Size in bytes
-	VMS        uint64  // Virtual Memory Size in bytes
-	MemPercent float64 // Memory usage as percentage
-}
-
 func main() {
+	// Subcommands are dispatched before the benchmark flags are parsed
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			if err := bench.RunHistory(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "certs":
+			if err := bench.RunCerts(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "export":
+			if err := bench.RunExport(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "trend":
+			if err := bench.RunTrend(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+	}
+
 	// Define command line flags
 	rate := flag.Int("rate", 500, "Requests per second")
 	duration := flag.Int("duration", 10, "Duration of test in seconds")
 	outputFile := flag.String("output", "results.json", "Output file for results")
+	dbPath := flag.String("db", "", "SQLite database to append every run to, instead of overwriting the output file")
+	scenario := flag.String("scenario", "default", "Scenario name recorded with the run in the results database")
+	var tags bench.Tags
+	flag.Var(&tags, "tag", "name=value label saved with every result and usable by trend and history to filter and group runs (repeatable)")
 	cooldown := flag.Int("cooldown", 60, "Cooldown period between tests in seconds")
 	provider := flag.String("provider", "", "Specific provider to benchmark (bifrost, portkey, braintrust, llmlite, openrouter)")
-	bigPayload := flag.Bool("big-payload", false, "Use a bigger payload")
+	bigPayload := flag.Bool("big-payload", false, "Use a bigger payload (about 2KB)")
+	payloadSize := flag.String("payload-size", "", "Prompt size (e.g., 1KB, 50KB, or huge for 8MB), overrides -big-payload")
+	payloadSizes := flag.String("payload-sizes", "", "Comma separated prompt sizes to sweep (e.g., 1KB,10KB,50KB,200KB)")
+	payloadSweepOutput := flag.String("payload-sweep-output", "payload-sweep.csv", "Output file for the payload size table")
 	model := flag.String("model", "gpt-4o-mini", "Model to use")
 	suffix := flag.String("suffix", "v1", "Suffix to add to the url route")
+	host := flag.String("host", "localhost", "Host of providers configured by port (e.g., 127.0.0.1 or ::1 to pin IPv4 or IPv6 loopback)")
+	routeName := flag.String("route", bench.ChatRoute, "API route to benchmark (chat, embeddings, moderations, rerank, messages, or one from -routes-config)")
+	routesConfig := flag.String("routes-config", "", "JSON file declaring extra routes with their method, path template and payload")
+	staticPayload := flag.Bool("static-payload", false, "Send the same body with every request, without the request index and timestamp, e.g. to benchmark response caching")
+	modelMix := flag.String("model-mix", "", "Weighted models or aliases sent as-is per request (e.g., fast=3,smart=1), overrides -model")
+	settle := flag.Bool("settle", false, "Between providers, wait until the target's RSS and CPU return to their pre-attack baseline instead of the fixed -cooldown")
+	settleRSSTolerance := flag.Float64("settle-rss-tolerance", 10, "RSS tolerance above baseline in percent for -settle")
+	settleCPUTolerance := flag.Float64("settle-cpu-tolerance", 5, "CPU tolerance above baseline in percentage points for -settle")
+	settleMaxWait := flag.Duration("settle-max-wait", 5*time.Minute, "Maximum time to wait for the target to settle")
+	live := flag.Bool("live", false, "Show a live dashboard (RPS, rolling P50/P99, error rate, server RSS) during each attack")
+	vus := flag.Int("vus", 0, "Run N virtual users that each loop request, think, request instead of sending at -rate")
+	vusSweep := flag.String("vus-sweep", "", "Comma separated virtual user counts to sweep, printing a concurrency vs latency table (e.g., 10,50,100,500)")
+	vusSweepOutput := flag.String("vus-sweep-output", "vus-sweep.csv", "Output file for the virtual user sweep table")
+	thinkMin := flag.Duration("think-min", 100*time.Millisecond, "Shortest time a virtual user waits between a response and its next request")
+	thinkMax := flag.Duration("think-max", 500*time.Millisecond, "Longest time a virtual user waits between a response and its next request")
+	correctOmission := flag.Bool("correct-omission", false, "Measure reported latencies from each request's scheduled send time, correcting for coordinated omission")
+	arrivalTrace := flag.String("arrival-trace", "", "Send requests at the arrival times in this file (one RFC 3339 timestamp or number of seconds per line) instead of at -rate, looping it to fill -duration")
+	traceSpeed := flag.Float64("trace-speed", 1, "Replay -arrival-trace this many times faster (e.g. 60 plays an hour of traffic in a minute)")
+	alignStart := flag.Duration("align-start", 0, "Start every provider's attack on the next wall-clock multiple of this (e.g. 1m), to line runs up with external monitoring (0 starts at once)")
+	slowest := flag.Int("slowest", 10, "Number of slowest requests kept per provider, with the target's Server-Timing breakdown")
+	validate := flag.Bool("validate", false, "Validate that 200 responses are well-formed chat completions")
+	samplePhases := flag.Float64("sample-phases", 0, "Fraction of requests (e.g. 0.01) traced with httptrace to break latency into DNS, connect, TLS, TTFB and body read, 0 to disable")
+	verifyBody := flag.Bool("verify-body", false, "Compare each request body with the SHA-256 the mocker echoes with -body-checksum, failing corrupted or truncated ones")
+	targeter := flag.String("targeter", bench.DefaultTargeter, "Targeter building each request body, as name or name:arg (default, jsonl:<file> or one registered with bench.RegisterTargeter)")
+	engine := flag.String("engine", bench.DefaultEngine, "Load engine sending the requests, as name or name:arg (vegeta, native or native:workers, k6 or k6:/path/to/k6, or one registered with bench.RegisterEngine). k6 schedules requests itself, so its runs have no omission correction or late request report, and burn rates and steady state count from its first request")
+	mockerURL := flag.String("mocker-url", "", "Mocker base URL (e.g., http://localhost:8000) to correlate per-request traces with")
+	traceOutput := flag.String("trace-output", "traces.jsonl", "Output file for per-request trace breakdowns")
+	rawOutput := flag.String("raw-output", "", "Stream every request's timestamp, status, latency, bytes and error to this JSONL file, gzip-compressed if it ends in .gz (e.g. results.jsonl.gz)")
+	soak := flag.Duration("soak", 0, "Run a soak test of this length (e.g., 2h) instead of -duration")
+	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "Interval between soak test snapshots")
+	snapshotOutput := flag.String("snapshot-output", "soak.jsonl", "Output file for soak test snapshots")
+	sweepGateway := flag.String("sweep-gateway", "", "Path to a Bifrost gateway binary to restart for each sweep parameter set")
+	sweepArgs := flag.String("sweep-args", "", "Extra space separated arguments passed to the gateway on every sweep run")
+	sweepConcurrency := flag.String("sweep-concurrency", "5000", "Comma separated Bifrost concurrency values to sweep")
+	sweepBufferSize := flag.String("sweep-buffer-size", "5000", "Comma separated Bifrost buffer sizes to sweep")
+	sweepPoolSize := flag.String("sweep-pool-size", "5000", "Comma separated Bifrost initial pool sizes to sweep")
+	sweepServerConcurrency := flag.String("sweep-server-concurrency", "0", "Comma separated fasthttp server concurrency values to sweep")
+	sweepGOMAXPROCS := flag.String("sweep-gomaxprocs", "0", "Comma separated gateway GOMAXPROCS values to sweep (0 for the gateway default)")
+	sweepGOGC := flag.String("sweep-gogc", "", "Comma separated gateway GOGC values to sweep, e.g. 100,200,off (empty for the default)")
+	sweepGOMEMLIMIT := flag.String("sweep-gomemlimit", "", "Comma separated gateway GOMEMLIMIT values to sweep, e.g. 256MiB,1GiB (empty for the default)")
+	sweepOutput := flag.String("sweep-output", "sweep.csv", "Output file for the sweep tuning table")
+	tlsCA := flag.String("tls-ca", "", "CA certificate to trust; switches provider endpoints to https")
+	tlsCert := flag.String("tls-cert", "", "Client certificate for mutual TLS")
+	tlsKey := flag.String("tls-key", "", "Client private key for mutual TLS")
+	sloSuccess := flag.Float64("slo-success", 0, "Success rate SLO in percent (e.g., 99.5); reports each provider's breaking point, the first rolling window burning the error budget too fast (0 disables)")
+	sloWindow := flag.Duration("slo-window", 10*time.Second, "Rolling window the -slo-success burn rate is computed over (whole seconds)")
+	burnThreshold := flag.Float64("burn-threshold", 1, "Burn rate that counts as breaching -slo-success; 1 spends the error budget exactly as fast as allowed")
+	repeat := flag.Int("repeat", 1, "Run every provider this many times and report the spread of key metrics and which provider differences are within noise")
+	repeatOutput := flag.String("repeat-output", "repeat.csv", "Output file for the -repeat statistics")
+	useHTTP3 := flag.Bool("http3", false, "Attack every provider over HTTP/3 (QUIC); endpoints must be https")
+	units := flag.String("units", "ms", "Latency unit in printed reports (ns, us, ms, s, or auto)")
+	locale := flag.String("locale", "none", "Number formatting in printed reports (none, en, de, fr)")
+	providersConfig := flag.String("providers-config", "", "JSON file declaring providers, headers, auth and body fields (default: built-in Bifrost, Litellm, Helicone)")
+	composeFile := flag.String("compose", "", "docker-compose file whose services with benchmark.* labels are the providers, instead of -providers-config")
+	costTargetP99 := flag.Duration("cost-target-p99", 0, "P99 a provider must stay within for its price-performance score (requests per dollar, from the cost section of the providers config) to count (0 for no target)")
+	assert := flag.String("assert", "", "Comma separated SLO assertions checked per provider (e.g., \"p99<50ms,success>99.5\"); exits with status 3 if any fails")
+	matrix := flag.String("matrix", "", "JSON file with rates, durations, payloads and providers to run every combination of")
+	matrixOutput := flag.String("matrix-output", "matrix.json", "Output file for scenario matrix results, keyed by combination")
+	charts := flag.String("charts", "", "Directory to write latency percentile and server memory charts to after the run")
+	chartFormat := flag.String("chart-format", "svg", "Chart file format (svg or png)")
+	monitor := flag.String("monitor", "", "How target resources are sampled, overriding the providers config: process (by port) for every provider, or docker:<container> for the one provider benchmarked")
+	metricsURL := flag.String("metrics-url", "", "Metrics endpoint exposing goroutine counts (e.g., http://localhost:3001/metrics) of the one provider benchmarked, overriding its metrics_url")
+	profile := flag.Bool("profile", false, "Capture a CPU and a heap profile mid-run from providers with a pprof URL (Bifrost run with -pprof)")
+	profileSeconds := flag.Int("profile-seconds", bench.DefaultProfileSeconds, "Length of the CPU profile captured with -profile, in seconds")
+	profileDir := flag.String("profile-dir", "", "Directory for the profiles captured with -profile (default: next to -output)")
 
 	flag.Parse()
 
-	// Initialize providers
-	providers := initializeProviders(*bigPayload, *model, *suffix)
+	var err error
+	if err = bench.SetReportFormat(*units, *locale); err != nil {
+		log.Fatalf("Invalid report format: %v", err)
+	}
 
-	// Filter providers if specific provider is requested
-	if *provider != "" {
-		filteredProviders := make([]Provider, 0)
-		for _, p := range providers {
-			if strings.EqualFold(p.Name, *provider) {
-				filteredProviders = append(filteredProviders, p)
-				break
-			}
-		}
-		if len(filteredProviders) == 0 {
-			log.Fatalf("Provider '%s' not found. Available providers: %v", *provider, getProviderNames(providers))
+	var assertions []bench.SLOAssertion
+	if *assert != "" {
+		if assertions, err = bench.ParseAssertions(*assert); err != nil {
+			log.Fatalf("Invalid -assert: %v", err)
 		}
-		providers = filteredProviders
-	} else {
-		fmt.Println("No specific provider specified. Running benchmarks for all providers...")
 	}
 
-	// Run benchmarks
-	results := runBenchmarks(providers, *rate, *duration, *cooldown)
+	if *alignStart < 0 {
+		log.Fatalf("-align-start must not be negative")
+	}
+	if *sloSuccess < 0 || *sloSuccess >= 100 {
+		log.Fatalf("-slo-success must be between 0 and 100, leaving an error budget")
+	}
+	if *repeat < 1 {
+		log.Fatalf("-repeat must be at least 1")
+	}
 
-	// Save results
-	saveResults(results, *outputFile)
-}
+	if *chartFormat != "svg" && *chartFormat != "png" {
+		log.Fatalf("Invalid -chart-format %q, use svg or png", *chartFormat)
+	}
 
-// Helper function to get provider names
-func getProviderNames(providers []Provider) []string {
-	names := make([]string, len(providers))
-	for i, p := range providers {
-		names[i] = strings.ToLower(p.Name)
+	route, err := bench.FindRoute(*routeName, *routesConfig)
+	if err != nil {
+		log.Fatalf("Invalid -route: %v", err)
+	}
+	if _, _, err := bench.LookupTargeter(*targeter); err != nil {
+		log.Fatalf("Invalid -targeter: %v", err)
+	}
+	if *profileSeconds <= 0 {
+		log.Fatalf("-profile-seconds must be positive")
+	}
+	if *profileDir == "" {
+		*profileDir = filepath.Dir(*outputFile)
+	}
+	if _, _, err := bench.LookupEngine(*engine); err != nil {
+		log.Fatalf("Invalid -engine: %v", err)
+	}
+	if engineName, _, _ := strings.Cut(*engine, ":"); engineName != bench.DefaultEngine {
+		if *vus > 0 || *vusSweep != "" {
+			log.Fatalf("-vus and -vus-sweep send with the runner's own client and only apply to the vegeta engine")
+		}
+		if *arrivalTrace != "" {
+			log.Fatalf("-arrival-trace only applies to the vegeta engine, the others send at a constant rate")
+		}
+		if engineName == "k6" && *correctOmission {
+			log.Fatalf("-correct-omission does not apply to k6, which doesn't report when each request was scheduled")
+		}
+		if engineName == "k6" && (*validate || *verifyBody || *mockerURL != "") {
+			log.Printf("Warning: k6 keeps no response bodies, headers or request IDs, so -validate, -verify-body and traces come up empty")
+		}
+		if *samplePhases > 0 {
+			log.Printf("Warning: -sample-phases traces net/http requests and comes up empty with the %s engine", engineName)
+		}
+	}
+	if *samplePhases < 0 || *samplePhases > 1 {
+		log.Fatalf("-sample-phases must be between 0 and 1")
+	}
+	if route.Name != bench.ChatRoute && (*payloadSize != "" || *payloadSizes != "" || *bigPayload || *validate) {
+		log.Fatalf("-big-payload, -payload-size, -payload-sizes and -validate only apply to the chat route")
+	}
+	if *vus < 0 || *thinkMin < 0 || *thinkMax < *thinkMin {
+		log.Fatalf("-vus must not be negative and -think-max must be at least -think-min")
+	}
+	if *arrivalTrace != "" && (*vus > 0 || *vusSweep != "") {
+		log.Fatalf("-arrival-trace sets when requests are sent and can't be combined with virtual users, which send when the previous response arrives")
+	}
+	if (*vus > 0 || *vusSweep != "") && *correctOmission {
+		log.Fatalf("-correct-omission does not apply to virtual users, which send no request before the previous one returns")
 	}
-	return names
-}
 
-func initializeProviders(bigPayload bool, model string, suffix string) []Provider {
-	// Load environment variables from .env file
+	// Load ports and credentials from the .env file, then initialize providers
 	if err := godotenv.Load(); err != nil {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
-
-	var payload []byte
-
-	if bigPayload {
-		// Create payload template with dynamic content placeholders
-		payload, _ = json.Marshal(map[string]interface{}{
-			"messages": []map[string]string{
-				{
-					"role": "user",
-					"content": "This is a benchmark request #{request_index} at #{timestamp}. " +
-						"Please provide a comprehensive analysis of the following topics: " +
-						"1. Explain the concept of Proxy Gateway in the context of AI, including its architecture, benefits, and use cases. " +
-						"2. Discuss the role of load balancing and request routing in AI proxy gateways. " +
-						"3. Analyze the impact of caching and rate limiting on AI service performance. " +
-						"4. Describe common challenges in implementing AI proxy gateways and potential solutions. " +
-						"5. Compare different AI proxy gateway implementations and their trade-offs. " +
-						"6. What is the difference between a proxy gateway and a reverse proxy? " +
-						"7. What is the difference between a proxy gateway and a load balancer? " +
-						"8. What is the difference between a proxy gateway and a web server? " +
-						"9. What is the difference between a proxy gateway and a CDN? " +
-						"10. What is the difference between a proxy gateway and a firewall? " +
-						"11. What is the difference between a proxy gateway and a VPN? " +
-						"12. What is the difference between a proxy gateway and a WAF? " +
-						"13. What is the difference between a proxy gateway and a DDoS protection service? " +
-						"14. What is the difference between a proxy gateway and a DNS server? " +
-						"15. What is the difference between a proxy gateway and a web application firewall? " +
-						"16. What is the difference between a proxy gateway and a load balancer? " +
-						"17. What is the difference between a proxy gateway and a web server? " +
-						"18. What is the difference between a proxy gateway and a CDN? " +
-						"19. What is the difference between a proxy gateway and a firewall? " +
-						"20. What is the difference between a proxy gateway and a VPN? " +
-						"Please provide detailed explanations with examples and technical details for each point. ",
-				},
-			},
-			"model": "openai/" + model,
-		})
-	} else {
-		payload, _ = json.Marshal(map[string]interface{}{
-			"messages": []map[string]string{
-				{
-					"role":    "user",
-					"content": "This is a benchmark request #{request_index} at #{timestamp}. How are you?",
-				},
-			},
-			"model": "openai/" + model,
-		})
-	}
-
-	baseUrl := "http://localhost:%s/%s/chat/completions"
-
-	// Create providers with ports from .env
-	providers := []Provider{
-		{
-			Name:     "Bifrost",
-			Endpoint: fmt.Sprintf(baseUrl, os.Getenv("BIFROST_PORT"), suffix),
-			Port:     os.Getenv("BIFROST_PORT"),
-			Payload:  payload,
-		},
-		{
-			Name:     "Litellm",
-			Endpoint: fmt.Sprintf(baseUrl, os.Getenv("LITELLM_PORT"), suffix),
-			Port:     os.Getenv("LITELLM_PORT"),
-			Payload:  payload,
-		},
-		// {
-		// 	Name:     "Portkey",
-		// 	Endpoint: fmt.Sprintf(baseUrl, os.Getenv("PORTKEY_PORT")),
-		// 	Port:     os.Getenv("PORTKEY_PORT"),
-		// 	Payload:  payload,
-		// },
-		// {
-		// 	Name:     "Braintrust",
-		// 	Endpoint: fmt.Sprintf(baseUrl, os.Getenv("BRAINTRUST_PORT")),
-		// 	Port:     os.Getenv("BRAINTRUST_PORT"),
-		// 	Payload:  payload,
-		// },
-		// {
-		// 	Name:     "LLMLite",
-		// 	Endpoint: fmt.Sprintf(baseUrl, os.Getenv("LLMLITE_PORT")),
-		// 	Port:     os.Getenv("LLMLITE_PORT"),
-		// 	Payload:  payload,
-		// },
-		// {
-		// 	Name:     "OpenRouter",
-		// 	Endpoint: fmt.Sprintf(baseUrl, os.Getenv("OPENROUTER_PORT")),
-		// 	Port:     os.Getenv("OPENROUTER_PORT"),
-		// 	Payload:  payload,
-		// },
-		{
-			Name:     "Helicone",
-			Endpoint: fmt.Sprintf(baseUrl, os.Getenv("HELICONE_PORT"), suffix),
-			Port:     os.Getenv("HELICONE_PORT"),
-			Payload:  payload,
-		},
-	}
-
-	return providers
-}
-
-func runBenchmarks(providers []Provider, rate int, duration int, cooldown int) []BenchmarkResult {
-	results := make([]BenchmarkResult, 0, len(providers))
-
-	for i, provider := range providers {
-		fmt.Printf("Benchmarking %s...\n", provider.Name)
-
-		httpTransport := &http.Transport{
-			Proxy:               http.ProxyFromEnvironment,
-			MaxIdleConnsPerHost: 100000,
-			MaxConnsPerHost:     0,
-			IdleConnTimeout:     10 * time.Second,
-			// Optionally tune TLS and other settings if needed
+	var providers []bench.Target
+	if *composeFile != "" {
+		if *providersConfig != "" {
+			log.Fatalf("-compose and -providers-config both declare the providers, use one of them")
 		}
-
-		httpClient := &http.Client{
-			Transport: httpTransport,
-			Timeout:   240 * time.Second, // adjust as necessary
+		providers, err = bench.LoadComposeTargets(*bigPayload, *model, *suffix, *host, *composeFile, route)
+		if err != nil {
+			log.Fatalf("Error discovering providers: %v", err)
 		}
-
-		// Define the attack
-		targeter := createTargeter(provider)
-		attacker := vegeta.NewAttacker(vegeta.Client(httpClient))
-
-		// Setup memory monitoring for the server
-		var serverMemStats []ServerMemStat
-		var memMutex sync.Mutex
-		stopMonitoring := make(chan struct{})
-		var wg sync.WaitGroup
-
-		// Initialize drop reasons tracking
-		dropReasons := make(map[string]int)
-
-		// Start server memory monitoring
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			p, err := getProcessByPort(provider.Port)
-			if err != nil {
-				log.Printf("Warning: Could not find process on port %s: %v", provider.Port, err)
-				return
-			}
-
-			monitorServerMemory(p, stopMonitoring, &serverMemStats, &memMutex)
-		}()
-
-		// Create context with timeout for the attack
-		ctx, cancel := context.WithTimeout(context.Background(),
-			time.Duration(240)*time.Second) // Changed to 240s
-		defer cancel()
-
-		// Run the benchmark
-		var metrics vegeta.Metrics
-		attackRate := vegeta.Rate{Freq: rate, Per: time.Second}
-		for res := range attacker.Attack(targeter, attackRate, time.Duration(duration)*time.Second, provider.Name) {
-			metrics.Add(res)
-
-			// Track drop reasons
-			if res.Error != "" {
-				dropReasons[res.Error]++
-			} else if res.Code != 200 {
-				dropReasons[fmt.Sprintf("HTTP %d", res.Code)]++
-			}
-
-			// Check if context is done
-			select {
-			case <-ctx.Done():
-				log.Printf("Attack for %s timed out", provider.Name)
-				dropReasons["context_timeout"]++
-				goto EndAttack
-			default:
-				// Continue with the attack
+		fmt.Printf("Discovered %d providers in %s:\n", len(providers), *composeFile)
+		for _, provider := range providers {
+			monitor := "process on port " + provider.Port
+			if provider.Container != "" {
+				monitor = "container " + provider.Container
 			}
+			fmt.Printf("  %s: %s, monitoring the %s\n", provider.Name, provider.Endpoint, monitor)
 		}
-
-	EndAttack:
-		metrics.Close()
-
-		// Stop memory monitoring
-		close(stopMonitoring)
-		wg.Wait()
-
-		// Lock while copying memory stats to ensure thread safety
-		memMutex.Lock()
-		serverMemStatsCopy := make([]ServerMemStat, len(serverMemStats))
-		copy(serverMemStatsCopy, serverMemStats)
-		memMutex.Unlock()
-
-		// Add results
-		results = append(results, BenchmarkResult{
-			ProviderName:      provider.Name,
-			Metrics:           &metrics,
-			ServerMemoryStats: serverMemStatsCopy,
-			DropReasons:       dropReasons,
-		})
-
-		fmt.Println(metrics.StatusCodes)
-
-		// Print summary
-		fmt.Printf("Results for %s:\n", provider.Name)
-		fmt.Printf("  Requests: %d\n", metrics.Requests)
-		fmt.Printf("  Request Rate: %.2f/s\n", metrics.Rate)
-		fmt.Printf("  Success Rate: %.2f%%\n", 100.0*metrics.Success)
-		fmt.Printf("  Mean Latency: %s\n", metrics.Latencies.Mean)
-		fmt.Printf("  P50 Latency: %s\n", metrics.Latencies.P50)
-		fmt.Printf("  P99 Latency: %s\n", metrics.Latencies.P99)
-		fmt.Printf("  Max Latency: %s\n", metrics.Latencies.Max)
-		fmt.Printf("  Throughput: %.2f/s\n", metrics.Throughput)
-
-		// Print server memory stats summary if available
-		if len(serverMemStatsCopy) > 0 {
-			var peakMem uint64
-			for _, stat := range serverMemStatsCopy {
-				if stat.RSS > peakMem {
-					peakMem = stat.RSS
-				}
-			}
-			fmt.Printf("  Server Peak Memory: %.2f MB\n\n", float64(peakMem)/(1024*1024))
-		} else {
-			fmt.Println("  No server memory statistics available")
+	} else {
+		providers, err = bench.LoadTargets(*bigPayload, *model, *suffix, *host, *providersConfig, route)
+		if err != nil {
+			log.Fatalf("Error loading providers: %v", err)
 		}
-
-		// Apply cooldown period between tests (except after the last one)
-		if i < len(providers)-1 && cooldown > 0 {
-			fmt.Printf("Cooling down for %d seconds...\n", cooldown)
-			time.Sleep(time.Duration(cooldown) * time.Second)
+	}
+	if *payloadSize != "" {
+		size, err := bench.ParseByteSize(*payloadSize)
+		if err != nil {
+			log.Fatalf("Invalid -payload-size: %v", err)
+		}
+		payload := bench.SizedPayload(*model, size)
+		for i := range providers {
+			providers[i].Payload = payload
+		}
+	}
+	if *staticPayload {
+		for i := range providers {
+			providers[i].Payload = bench.WithoutPlaceholders(providers[i].Payload)
+		}
+	}
+	if *modelMix != "" {
+		mix, err := bench.ParseModelMix(*modelMix)
+		if err != nil {
+			log.Fatalf("Invalid -model-mix: %v", err)
+		}
+		for i := range providers {
+			providers[i].ModelMix = mix
 		}
 	}
 
-	return results
-}
-
-// getProcessByPort uses a more efficient approach to find a process by port
-func getProcessByPort(port string) (*process.Process, error) {
-	portNum, err := strconv.ParseUint(port, 10, 32)
-	if err != nil {
-		return nil, fmt.Errorf("invalid port number: %v", err)
+	// Switch every endpoint to https when a CA is given
+	var tlsConfig *tls.Config
+	if *tlsCA != "" {
+		var err error
+		tlsConfig, err = bench.LoadClientTLSConfig(*tlsCA, *tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Error loading TLS configuration: %v", err)
+		}
+		for i := range providers {
+			providers[i].Endpoint = "https://" + strings.TrimPrefix(providers[i].Endpoint, "http://")
+		}
 	}
 
-	conns, err := net.Connections("tcp")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get connections: %v", err)
+	for i := range providers {
+		if *useHTTP3 {
+			providers[i].Protocol = bench.ProtocolHTTP3
+		}
+		if err := bench.ParseProtocol(providers[i].Protocol, providers[i].Endpoint, providers[i].Socket); err != nil {
+			log.Fatalf("Error configuring %s: %v", providers[i].Name, err)
+		}
 	}
 
-	for _, conn := range conns {
-		if conn.Laddr.Port == uint32(portNum) && conn.Status == "LISTEN" {
-			p, err := process.NewProcess(conn.Pid)
-			if err != nil {
-				continue
+	// Filter providers if specific provider is requested
+	if *provider != "" {
+		filteredProviders := make([]bench.Target, 0)
+		for _, p := range providers {
+			if strings.EqualFold(p.Name, *provider) {
+				filteredProviders = append(filteredProviders, p)
+				break
 			}
-			cmdline, _ := p.Cmdline()
-			fmt.Printf("Found process on port %s: PID=%d, Cmdline=%s\n", port, conn.Pid, cmdline)
-			return p, nil
 		}
+		if len(filteredProviders) == 0 {
+			log.Fatalf("Provider '%s' not found. Available providers: %v", *provider, bench.TargetNames(providers))
+		}
+		providers = filteredProviders
+	} else {
+		fmt.Println("No specific provider specified. Running benchmarks for all providers...")
 	}
 
-	return nil, fmt.Errorf("no process found listening on port %s", port)
-}
-
-// monitorServerMemory collects memory stats of the server process
-func monitorServerMemory(p *process.Process, stop <-chan struct{}, stats *[]ServerMemStat, mutex *sync.Mutex) {
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	// One container would be sampled as the server of every provider
+	if *monitor != "" {
+		container, err := bench.ParseMonitor(*monitor)
+		if err != nil {
+			log.Fatalf("Invalid -monitor: %v", err)
+		}
+		if container != "" && len(providers) > 1 {
+			log.Fatalf("-monitor docker:<container> monitors one gateway; select a provider with -provider or set monitor per provider in the providers config")
+		}
+		for i := range providers {
+			providers[i].Container = container
+		}
+	}
 
-	for {
-		select {
-		case <-stop:
-			return
-		case <-ticker.C:
-			memInfo, err := p.MemoryInfo()
-			if err != nil {
-				continue
-			}
+	// One gateway's endpoint would report its goroutines for every provider
+	if *metricsURL != "" {
+		if len(providers) > 1 {
+			log.Fatalf("-metrics-url is one gateway's endpoint; select a provider with -provider or set metrics_url per provider in the providers config")
+		}
+		providers[0].MetricsURL = *metricsURL
+	}
 
-			memPercent, err := p.MemoryPercent()
-			if err != nil {
-				memPercent = 0.0
-			}
+	opts := bench.Scenario{
+		Rate:     *rate,
+		Duration: *duration,
+		Cooldown: *cooldown,
+		Validate: *validate,
+		Slowest:  *slowest,
+		Live:     *live,
+
+		VerifyBody:      *verifyBody,
+		PhaseSample:     *samplePhases,
+		CorrectOmission: *correctOmission,
+		MockerURL:       *mockerURL,
+		Targeter:        *targeter,
+		Engine:          *engine,
+		Tags:            tags,
+		AlignStart:      *alignStart,
+		CostTargetP99:   *costTargetP99,
+
+		VirtualUsers: *vus,
+		ThinkMin:     *thinkMin,
+		ThinkMax:     *thinkMax,
+
+		TLSConfig: tlsConfig,
+
+		Profile:        *profile,
+		ProfileSeconds: *profileSeconds,
+		ProfileDir:     *profileDir,
+
+		SLOSuccess:    *sloSuccess,
+		SLOWindow:     *sloWindow,
+		BurnThreshold: *burnThreshold,
+
+		Settle:             *settle,
+		SettleRSSTolerance: *settleRSSTolerance,
+		SettleCPUTolerance: *settleCPUTolerance,
+		SettleMaxWait:      *settleMaxWait,
+	}
 
-			memStat := ServerMemStat{
-				Timestamp:  time.Now(),
-				RSS:        memInfo.RSS, // Resident Set Size
-				VMS:        memInfo.VMS, // Virtual Memory Size
-				MemPercent: float64(memPercent),
+	// Every result is streamed as it arrives, across all providers, sweeps and repeats
+	if *rawOutput != "" {
+		raw, err := bench.CreateRawResults(*rawOutput)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		defer func() {
+			if err := raw.Close(); err != nil {
+				log.Printf("Warning: %v", err)
 			}
+		}()
+		opts.RawOutput = raw
+	}
 
-			mutex.Lock()
-			*stats = append(*stats, memStat)
-			mutex.Unlock()
+	if *arrivalTrace != "" {
+		trace, err := bench.LoadArrivalTrace(*arrivalTrace, *traceSpeed)
+		if err != nil {
+			log.Fatalf("Invalid -arrival-trace: %v", err)
 		}
+		opts.ArrivalTrace = trace
 	}
-}
-
-func createTargeter(provider Provider) vegeta.Targeter {
-	// Create a counter for round-robin message selection
-	var requestCounter int64
-	var counterMutex sync.Mutex
-
-	return func(tgt *vegeta.Target) error {
-		// Get next message index in round-robin fashion
-		counterMutex.Lock()
-		requestCounter++
-		counterMutex.Unlock()
 
-		// Create payload with the selected message
-		var payload map[string]interface{}
-		if err := json.Unmarshal(provider.Payload, &payload); err != nil {
-			return err
+	// Soak mode replaces the regular duration with a long run and periodic snapshots
+	if *soak > 0 {
+		snapshotFile, err := os.Create(*snapshotOutput)
+		if err != nil {
+			log.Fatalf("Error creating snapshot file: %v", err)
 		}
+		defer snapshotFile.Close()
 
-		text := payload["messages"].([]interface{})[0].(map[string]interface{})["content"].(string)
-
-		// Replace placeholders with values
-		updatedText := strings.ReplaceAll(text, "#{request_index}", fmt.Sprintf("%d", requestCounter))
-		updatedText = strings.ReplaceAll(updatedText, "#{timestamp}", time.Now().Format(time.RFC3339))
-
-		payload["messages"].([]interface{})[0].(map[string]interface{})["content"] = updatedText
+		opts.Duration = int(soak.Seconds())
+		opts.SnapshotInterval = *snapshotInterval
+		opts.SnapshotFile = snapshotFile
+		fmt.Printf("Soak test: %s per provider, snapshots every %s to %s\n", *soak, *snapshotInterval, *snapshotOutput)
+	}
 
-		// Marshal the updated payload
-		updatedPayload, err := json.Marshal(payload)
-		if err != nil {
-			return err
+	// Sweep mode restarts the Bifrost gateway for every parameter set instead of comparing providers
+	if *sweepGateway != "" {
+		cfg := bench.SweepConfig{
+			Binary:         *sweepGateway,
+			ExtraArgs:      strings.Fields(*sweepArgs),
+			StartupTimeout: 30 * time.Second,
+			Output:         *sweepOutput,
 		}
-
-		tgt.Method = "POST"
-		tgt.URL = provider.Endpoint
-		tgt.Body = updatedPayload
-		tgt.Header = http.Header{
-			"Content-Type": []string{"application/json"},
-			"x-bf-vk":      []string{"f452b625-a65e-4dfd-b48d-0ee3ba0e8d46"},
+		var err error
+		if cfg.Concurrency, err = bench.ParseIntList(*sweepConcurrency); err != nil {
+			log.Fatalf("Invalid -sweep-concurrency: %v", err)
+		}
+		if cfg.BufferSize, err = bench.ParseIntList(*sweepBufferSize); err != nil {
+			log.Fatalf("Invalid -sweep-buffer-size: %v", err)
+		}
+		if cfg.InitialPoolSize, err = bench.ParseIntList(*sweepPoolSize); err != nil {
+			log.Fatalf("Invalid -sweep-pool-size: %v", err)
+		}
+		if cfg.ServerConcurrency, err = bench.ParseIntList(*sweepServerConcurrency); err != nil {
+			log.Fatalf("Invalid -sweep-server-concurrency: %v", err)
+		}
+		if cfg.GOMAXPROCS, err = bench.ParseIntList(*sweepGOMAXPROCS); err != nil {
+			log.Fatalf("Invalid -sweep-gomaxprocs: %v", err)
 		}
+		cfg.GOGC = bench.ParseStringList(*sweepGOGC)
+		cfg.GOMEMLIMIT = bench.ParseStringList(*sweepGOMEMLIMIT)
 
-		if provider.Name == "Portkey" {
-			openaiApiKey := os.Getenv("OPENAI_API_KEY")
-			if openaiApiKey == "" {
-				return fmt.Errorf("OPENAI_API_KEY is not set")
+		for _, p := range providers {
+			if strings.EqualFold(p.Name, "bifrost") {
+				bench.RunSweep(p, cfg, opts)
+				return
 			}
-
-			tgt.Header.Set("x-portkey-config", fmt.Sprintf(`{"provider":"openai","api_key":"%s"}`, openaiApiKey))
 		}
-
-		return nil
+		log.Fatalf("Sweep requires the bifrost provider")
 	}
-}
 
-func saveResults(results []BenchmarkResult, outputFile string) {
-	type SerializableResult struct {
-		Requests           uint64         `json:"requests"`
-		Rate               float64        `json:"rate"`
-		SuccessRate        float64        `json:"success_rate"`
-		MeanLatencyMs      float64        `json:"mean_latency_ms"`
-		P50LatencyMs       float64        `json:"p50_latency_ms"`
-		P99LatencyMs       float64        `json:"p99_latency_ms"`
-		MaxLatencyMs       float64        `json:"max_latency_ms"`
-		ThroughputRPS      float64        `json:"throughput_rps"`
-		Timestamp          string         `json:"timestamp"`
-		StatusCodeCounts   map[string]int `json:"status_code_counts"`
-		ServerPeakMemoryMB float64        `json:"server_peak_memory_mb"`
-		ServerAvgMemoryMB  float64        `json:"server_avg_memory_mb"`
-		DropReasons        map[string]int `json:"drop_reasons"` // Add drop reasons to serialized output
-	}
-
-	// Create a map with provider names as keys
-	resultsMap := make(map[string]SerializableResult)
-
-	// Try to read existing results file
-	if _, err := os.Stat(outputFile); err == nil {
-		fileData, err := os.ReadFile(outputFile)
+	// Matrix mode runs the cross product of rates, durations, payloads and providers
+	if *matrix != "" {
+		spec, err := bench.LoadMatrixSpec(*matrix, opts, *bigPayload, providers, route)
 		if err != nil {
-			log.Printf("Warning: Could not read existing results file: %v", err)
-		} else {
-			if err := json.Unmarshal(fileData, &resultsMap); err != nil {
-				log.Printf("Warning: Could not parse existing results file: %v", err)
-				resultsMap = make(map[string]SerializableResult)
-			}
+			log.Fatalf("Invalid -matrix: %v", err)
 		}
+		bench.RunMatrix(providers, spec, *model, route, opts, *matrixOutput)
+		return
 	}
 
-	// Update or add new results
-	for _, res := range results {
-		// Count status codes
-		statusCodes := make(map[string]int)
-		for code, count := range res.Metrics.StatusCodes {
-			statusCodes[code] = int(count)
+	// Virtual user sweep mode repeats the closed-loop scenario for every user count
+	if *vusSweep != "" {
+		users, err := bench.ParseIntList(*vusSweep)
+		if err != nil {
+			log.Fatalf("Invalid -vus-sweep: %v", err)
 		}
-
-		// Calculate peak and average server memory if available
-		var peakMem uint64
-		var totalMem uint64
-		for _, stat := range res.ServerMemoryStats {
-			if stat.RSS > peakMem {
-				peakMem = stat.RSS
+		for _, n := range users {
+			if n <= 0 {
+				log.Fatalf("Invalid -vus-sweep: user counts must be positive, got %d", n)
 			}
-			totalMem += stat.RSS
 		}
+		bench.RunVirtualUserSweep(providers, users, opts, *vusSweepOutput)
+		return
+	}
 
-		var avgMem float64
-		if len(res.ServerMemoryStats) > 0 {
-			avgMem = float64(totalMem) / float64(len(res.ServerMemoryStats)) / (1024 * 1024)
+	// Payload sweep mode repeats the scenario for every prompt size
+	if *payloadSizes != "" {
+		sizes, err := bench.ParseByteSizes(*payloadSizes)
+		if err != nil {
+			log.Fatalf("Invalid -payload-sizes: %v", err)
 		}
+		bench.RunPayloadSweep(providers, sizes, *model, opts, *payloadSweepOutput)
+		return
+	}
 
-		resultsMap[strings.ToLower(res.ProviderName)] = SerializableResult{
-			Requests:           res.Metrics.Requests,
-			Rate:               res.Metrics.Rate,
-			SuccessRate:        100.0 * res.Metrics.Success,
-			MeanLatencyMs:      float64(res.Metrics.Latencies.Mean) / float64(time.Millisecond),
-			P50LatencyMs:       float64(res.Metrics.Latencies.P50) / float64(time.Millisecond),
-			P99LatencyMs:       float64(res.Metrics.Latencies.P99) / float64(time.Millisecond),
-			MaxLatencyMs:       float64(res.Metrics.Latencies.Max) / float64(time.Millisecond),
-			ThroughputRPS:      res.Metrics.Throughput,
-			Timestamp:          time.Now().Format(time.RFC3339),
-			StatusCodeCounts:   statusCodes,
-			ServerPeakMemoryMB: float64(peakMem) / (1024 * 1024),
-			ServerAvgMemoryMB:  avgMem,
-			// DropReasons:        res.DropReasons, // Include drop reasons in output
+	// Run benchmarks, repeatedly to tell real differences from run-to-run noise
+	var results []bench.Result
+	if *repeat > 1 {
+		rounds := bench.RunRepeats(providers, *repeat, opts, *repeatOutput)
+		if *dbPath != "" {
+			for _, round := range rounds[:len(rounds)-1] {
+				if err := bench.SaveResultsDB(round, *dbPath, *scenario); err != nil {
+					log.Fatalf("Error saving results: %v", err)
+				}
+			}
 		}
+		results = rounds[len(rounds)-1]
+	} else {
+		results = bench.NewRunner(opts).Run(context.Background(), providers)
 	}
-
-	// Serialize to JSON
-	jsonData, err := json.MarshalIndent(resultsMap, "", "  ")
-	if err != nil {
-		log.Fatalf("Error marshaling results: %v", err)
+	bench.PrintPriceRanking(results)
+	passed := true
+	if len(assertions) > 0 {
+		passed = bench.EvaluateAssertions(results, assertions)
 	}
 
-	// Write to file
-	err = os.WriteFile(outputFile, jsonData, 0644)
-	if err != nil {
-		log.Fatalf("Error writing results to file: %v", err)
+	// Save results
+	if *dbPath != "" {
+		if err := bench.SaveResultsDB(results, *dbPath, *scenario); err != nil {
+			log.Fatalf("Error saving results: %v", err)
+		}
+	} else if err := bench.SaveResults(results, *outputFile); err != nil {
+		log.Fatalf("Error saving results: %v", err)
+	}
+	if *mockerURL != "" {
+		bench.SaveTraces(results, *traceOutput)
+	}
+	if *charts != "" {
+		if err := bench.WriteCharts(results, *charts, *chartFormat); err != nil {
+			log.Printf("Warning: %v", err)
+		}
 	}
 
-	fmt.Printf("Results saved to %s\n", outputFile)
+	if !passed {
+		// os.Exit skips the deferred close
+		if opts.RawOutput != nil {
+			if err := opts.RawOutput.Close(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+		fmt.Println("SLO assertions failed")
+		os.Exit(bench.SLOFailureExitCode)
+	}
 }
@@ -2,16 +2,21 @@ module github.com/maximhq/bifrost-gateway
 
 go 1.24.1
 
-require github.com/maximhq/bifrost/core v1.1.13
+require (
+	github.com/bytedance/sonic v1.14.0
+	github.com/json-iterator/go v1.1.12
+	github.com/maximhq/bifrost/core v1.1.13
+)
 
 require (
 	cloud.google.com/go/compute/metadata v0.3.0 // indirect
-	github.com/bytedance/sonic v1.14.0 // indirect
 	github.com/bytedance/sonic/loader v0.3.0 // indirect
 	github.com/cloudwego/base64x v0.1.5 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/mark3labs/mcp-go v0.32.0 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/spf13/cast v1.7.1 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
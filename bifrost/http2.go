@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/maximhq/bifrost-gateway/lib"
+)
+
+// serveHTTP2 serves the gateway through an HTTP/2 terminating frontend, over TLS when a
+// certificate is configured and as h2c otherwise, until the frontend is shut down
+func serveHTTP2(frontend *lib.HTTP2Frontend) {
+	var ln net.Listener
+	if listenAddr != "" {
+		ln = unixListener(unixSocketPath())
+	} else if isWorker() {
+		ln = workerListener()
+	} else {
+		var err error
+		if ln, err = net.Listen(listenNetwork, ":"+port); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	}
+
+	var err error
+	if tlsCert != "" {
+		fmt.Printf("Bifrost API server starting on port %s with TLS and HTTP/2...\n", port)
+		err = frontend.ServeTLS(ln, tlsCert, tlsKey)
+	} else {
+		fmt.Printf("Bifrost API server starting on port %s with h2c...\n", port)
+		err = frontend.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Server error: %v", err)
+	}
+}
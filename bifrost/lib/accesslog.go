@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/valyala/fasthttp"
+)
+
+// accessLogFlushInterval bounds how long a written entry can sit in the buffer
+const accessLogFlushInterval = time.Second
+
+const (
+	accessLogBatchSize = 64 * 1024 // Most bytes written at once
+	pipeBuf            = 4096      // PIPE_BUF on Linux, the largest write that never interleaves with others on a pipe
+)
+
+// AccessLogEntry is one JSON line of the access log
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Model     string    `json:"model,omitempty"`
+	Status    int       `json:"status"`
+	LatencyMs float64   `json:"latency_ms"`
+	BytesIn   int       `json:"bytes_in"`
+	BytesOut  int       `json:"bytes_out"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// AccessLog writes sampled requests as JSON lines. Handlers only hand entries to a buffered
+// channel; encoding and writing happen on a single background goroutine, and entries are
+// dropped rather than blocking requests when the writer falls behind.
+type AccessLog struct {
+	sampleRate float64
+	batchSize  int
+	entries    chan AccessLogEntry
+	done       chan struct{}
+	closeOnce  sync.Once
+
+	written atomic.Int64
+	dropped atomic.Int64
+}
+
+// NewAccessLog logs the given fraction of requests to w, buffering up to bufferSize entries.
+// shared is set when other processes write to w too, like workers sharing stdout, so lines are
+// written in batches of at most PIPE_BUF that can't interleave with theirs.
+func NewAccessLog(w io.Writer, sampleRate float64, bufferSize int, shared bool) *AccessLog {
+	a := &AccessLog{
+		sampleRate: sampleRate,
+		batchSize:  accessLogBatchSize,
+		entries:    make(chan AccessLogEntry, max(bufferSize, 1)),
+		done:       make(chan struct{}),
+	}
+	if shared {
+		a.batchSize = pipeBuf
+	}
+	go a.run(w)
+	return a
+}
+
+// run encodes entries until the channel is closed, flushing at least every accessLogFlushInterval.
+// Only whole lines are written, each batch in a single write of at most batchSize bytes.
+func (a *AccessLog) run(w io.Writer) {
+	defer close(a.done)
+
+	var batch, line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	flush := func() {
+		if batch.Len() == 0 {
+			return
+		}
+		if _, err := w.Write(batch.Bytes()); err != nil {
+			log.Printf("Warning: failed to flush access log: %v", err)
+		}
+		batch.Reset()
+	}
+	ticker := time.NewTicker(accessLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case entry, ok := <-a.entries:
+			if !ok {
+				flush()
+				return
+			}
+			line.Reset()
+			if err := enc.Encode(entry); err != nil {
+				log.Printf("Warning: failed to write access log: %v", err)
+				continue
+			}
+			if batch.Len()+line.Len() > a.batchSize {
+				flush()
+			}
+			batch.Write(line.Bytes())
+			a.written.Add(1)
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Wrap logs a sample of the requests served by next
+func (a *AccessLog) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if a.sampleRate < 1 && rand.Float64() >= a.sampleRate {
+			next(ctx)
+			return
+		}
+
+		start := time.Now()
+		next(ctx)
+
+		entry := AccessLogEntry{
+			Time:      start,
+			Method:    string(ctx.Method()),
+			Path:      string(ctx.Path()),
+			Status:    ctx.Response.StatusCode(),
+			LatencyMs: float64(time.Since(start)) / float64(time.Millisecond),
+			BytesIn:   requestBodySize(ctx),
+			BytesOut:  len(ctx.Response.Body()),
+			RequestID: string(ctx.Request.Header.Peek(RequestIDHeader)),
+		}
+		// A streamed body has already been consumed, so its model is not logged
+		if !bodyStreaming.streams(ctx) {
+			if node, err := sonic.Get(ctx.PostBody(), "model"); err == nil {
+				entry.Model, _ = node.String()
+			}
+		}
+
+		select {
+		case a.entries <- entry:
+		default:
+			a.dropped.Add(1)
+		}
+	}
+}
+
+// Close flushes the buffered entries and reports how many were written and dropped
+func (a *AccessLog) Close() {
+	a.closeOnce.Do(func() {
+		close(a.entries)
+		<-a.done
+		log.Printf("Access log: %d entries written, %d dropped", a.written.Load(), a.dropped.Load())
+	})
+}
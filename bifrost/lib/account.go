@@ -3,35 +3,103 @@ package lib
 import (
 	"context"
 	"fmt"
-	"time"
+	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/maximhq/bifrost/core/schemas"
 )
 
+// openAIModels are the models the account's OpenAI keys serve
+var openAIModels = []string{"gpt-4o-mini", "gpt-4o", "gpt-4-turbo", "gpt-3.5-turbo"}
+
+// DefaultUpstreamURL is where OpenAI requests go without an upstream override
+const DefaultUpstreamURL = "https://api.openai.com"
+
+// ValidateUpstreamURL checks an OpenAI base URL override, e.g. http://localhost:8000 for the
+// mocker. Paths like /v1/chat/completions are appended to it, so it must not end in /v1.
+func ValidateUpstreamURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q must be an http or https URL, e.g. http://localhost:8000", raw)
+	}
+	if strings.HasSuffix(strings.TrimRight(u.Path, "/"), "/v1") {
+		return fmt.Errorf("%q must be the base URL without /v1, which is appended per endpoint", raw)
+	}
+	return nil
+}
+
+// AccountSettings is the reloadable configuration of a BaseAccount
+type AccountSettings struct {
+	APIKey   string
+	Keys     []WeightedKey // Every key requests are spread over; APIKey is the first
+	ProxyURL string
+
+	Concurrency int
+	BufferSize  int
+	Network     schemas.NetworkConfig // Base URL, request timeout and retry policy
+}
+
 // CustomAccount implements the Account interface
 type BaseAccount struct {
-	apiKey   string
+	mu sync.RWMutex
+
+	keys     *KeyBalancer
 	proxyURL string
 
 	concurrency int
 	bufferSize  int
+	network     schemas.NetworkConfig // Request timeout and retry policy
 }
 
-func NewBaseAccount(apiKey string, proxyURL string, concurrency int, bufferSize int) *BaseAccount {
-	return &BaseAccount{
-		apiKey:      apiKey,
-		proxyURL:    proxyURL,
-		concurrency: concurrency,
-		bufferSize:  bufferSize,
-	}
+// NewBaseAccount creates an account whose keys are picked by keys' strategy
+func NewBaseAccount(settings AccountSettings, keys *KeyBalancer) *BaseAccount {
+	account := &BaseAccount{keys: keys}
+	account.Update(settings)
+	return account
 }
 
+// Update swaps in new settings. Keys are read by Bifrost on every request, so a new key takes
+// effect immediately. It reports whether provider settings changed, which only take effect once
+// the provider is rebuilt with Bifrost.UpdateProviderConcurrency.
+func (a *BaseAccount) Update(settings AccountSettings) (providerChanged bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	providerChanged = a.proxyURL != settings.ProxyURL ||
+		a.concurrency != settings.Concurrency ||
+		a.bufferSize != settings.BufferSize ||
+		!sameNetwork(a.network, settings.Network)
+
+	a.keys.SetKeys(settings.Keys)
+	a.proxyURL = settings.ProxyURL
+	a.concurrency = settings.Concurrency
+	a.bufferSize = settings.BufferSize
+	a.network = settings.Network
+	return providerChanged
+}
+
+// sameNetwork compares the network settings the gateway sets; extra headers are never set
+func sameNetwork(a, b schemas.NetworkConfig) bool {
+	return a.BaseURL == b.BaseURL &&
+		a.DefaultRequestTimeoutInSeconds == b.DefaultRequestTimeoutInSeconds &&
+		a.MaxRetries == b.MaxRetries &&
+		a.RetryBackoffInitial == b.RetryBackoffInitial &&
+		a.RetryBackoffMax == b.RetryBackoffMax
+}
+
+// GetKeysForProvider hands Bifrost the one key the balancer picked, so the strategy, not
+// Bifrost's own weighted selection, decides which key serves the request
 func (a *BaseAccount) GetKeysForProvider(ctx *context.Context, providerKey schemas.ModelProvider) ([]schemas.Key, error) {
 	if providerKey == schemas.OpenAI {
+		requestCtx := context.Background()
+		if ctx != nil && *ctx != nil {
+			requestCtx = *ctx
+		}
 		return []schemas.Key{
 			{
-				Value:  a.apiKey,
-				Models: []string{"gpt-4o-mini", "gpt-4o", "gpt-4-turbo", "gpt-3.5-turbo"},
+				Value:  a.keys.pick(requestCtx),
+				Models: openAIModels,
 				Weight: 1.0,
 			},
 		}, nil
@@ -40,21 +108,24 @@ func (a *BaseAccount) GetKeysForProvider(ctx *context.Context, providerKey schem
 	return nil, fmt.Errorf("unsupported provider: %s", providerKey)
 }
 
+// Models returns the models each configured provider serves
+func (a *BaseAccount) Models() map[schemas.ModelProvider][]string {
+	return map[schemas.ModelProvider][]string{schemas.OpenAI: openAIModels}
+}
+
 func (baseAccount *BaseAccount) GetConfiguredProviders() ([]schemas.ModelProvider, error) {
 	return []schemas.ModelProvider{schemas.OpenAI}, nil
 }
 
 // GetConcurrencyAndBufferSizeForProvider returns the concurrency and buffer size settings for a provider
 func (baseAccount *BaseAccount) GetConfigForProvider(providerKey schemas.ModelProvider) (*schemas.ProviderConfig, error) {
+	baseAccount.mu.RLock()
+	defer baseAccount.mu.RUnlock()
+
 	switch providerKey {
 	case schemas.OpenAI:
 		config := &schemas.ProviderConfig{
-			NetworkConfig: schemas.NetworkConfig{
-				DefaultRequestTimeoutInSeconds: 12,
-				MaxRetries:                     3,
-				RetryBackoffInitial:            100 * time.Millisecond,
-				RetryBackoffMax:                5 * time.Second,
-			},
+			NetworkConfig: baseAccount.network,
 			ConcurrencyAndBufferSize: schemas.ConcurrencyAndBufferSize{
 				Concurrency: baseAccount.concurrency,
 				BufferSize:  baseAccount.bufferSize,
@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Admission rejects requests with 429 once Bifrost's workers and queue are full, instead of
+// letting them block. Bifrost does not expose its queue, so depth is derived from in-flight
+// requests: anything beyond the worker count is waiting in the provider buffer.
+type Admission struct {
+	workers    int64
+	capacity   int64
+	retryAfter string
+
+	inFlight atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewAdmission creates admission control for the given worker concurrency and buffer size
+func NewAdmission(concurrency int, bufferSize int, retryAfter time.Duration) *Admission {
+	seconds := int(retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &Admission{
+		workers:    int64(concurrency),
+		capacity:   int64(concurrency + bufferSize),
+		retryAfter: strconv.Itoa(seconds),
+	}
+}
+
+// Wrap admits a request to next only while there is room in the queue
+func (a *Admission) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if a.inFlight.Add(1) > a.capacity {
+			a.inFlight.Add(-1)
+			a.rejected.Add(1)
+			ctx.Response.Header.Set("Retry-After", a.retryAfter)
+			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+			ctx.SetContentType("application/json")
+			ctx.SetBodyString(`{"error":{"message":"gateway queue is full","type":"queue_full"}}`)
+			return
+		}
+		defer a.inFlight.Add(-1)
+		next(ctx)
+	}
+}
+
+// QueueDepth returns the number of requests waiting for a Bifrost worker
+func (a *Admission) QueueDepth() int64 {
+	if a == nil {
+		return 0
+	}
+	return max(a.inFlight.Load()-a.workers, 0)
+}
+
+// InFlight returns the number of admitted requests not yet answered
+func (a *Admission) InFlight() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.inFlight.Load()
+}
+
+// Rejected returns the number of requests turned away with 429
+func (a *Admission) Rejected() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.rejected.Load()
+}
@@ -0,0 +1,456 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	bifrost "github.com/maximhq/bifrost/core"
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+// anthropicRequest is the body of an Anthropic Messages API request
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	MaxTokens     int                  `json:"max_tokens"`
+	System        json.RawMessage      `json:"system"` // A string or an array of text blocks
+	Messages      []anthropicMessage   `json:"messages"`
+	Temperature   *float64             `json:"temperature"`
+	TopP          *float64             `json:"top_p"`
+	TopK          *int                 `json:"top_k"`
+	StopSequences []string             `json:"stop_sequences"`
+	Stream        bool                 `json:"stream"`
+	Tools         []anthropicTool      `json:"tools"`
+	ToolChoice    *anthropicToolChoice `json:"tool_choice"`
+	Metadata      *struct {
+		UserID string `json:"user_id"`
+	} `json:"metadata"`
+}
+
+// anthropicMessage is a conversation turn, its content a string or an array of blocks
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// anthropicBlock is a content block of any type; only the fields of its type are set
+type anthropicBlock struct {
+	Type   string `json:"type"` // text, image, tool_use or tool_result
+	Text   string `json:"text"`
+	Source *struct {
+		Type      string `json:"type"` // base64 or url
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+		URL       string `json:"url"`
+	} `json:"source"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"` // Tool result, a string or an array of text blocks
+}
+
+type anthropicTool struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	InputSchema schemas.FunctionParameters `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type                   string `json:"type"` // auto, any, tool or none
+	Name                   string `json:"name"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use"`
+}
+
+// anthropicResponse is the body of a Messages API response
+type anthropicResponse struct {
+	ID           string             `json:"id"`
+	Type         string             `json:"type"`
+	Role         string             `json:"role"`
+	Model        string             `json:"model"`
+	Content      []anthropicContent `json:"content"`
+	StopReason   *string            `json:"stop_reason"`
+	StopSequence *string            `json:"stop_sequence"`
+	Usage        struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicContent struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// anthropicStopReasons maps OpenAI finish reasons to Anthropic stop reasons
+var anthropicStopReasons = map[string]string{
+	"stop":           "end_turn",
+	"length":         "max_tokens",
+	"tool_calls":     "tool_use",
+	"function_call":  "tool_use",
+	"content_filter": "refusal",
+}
+
+// AnthropicStats counts the requests served on the Anthropic ingress
+type AnthropicStats struct {
+	Requests        int64   `json:"requests"`
+	Rejected        int64   `json:"rejected"`          // Requests answered with 400 before reaching Bifrost
+	MeanTranslateUs float64 `json:"mean_translate_us"` // Mean time spent translating a request and its response
+}
+
+// AnthropicIngress serves the Anthropic Messages API on top of Bifrost, so clients using Anthropic
+// SDKs can be load-tested against the gateway. Requests are translated to BifrostRequests and
+// Bifrost's responses back; streaming is not supported.
+type AnthropicIngress struct {
+	client *bifrost.Bifrost
+	routes *RoutingTable
+
+	requests    atomic.Int64
+	rejected    atomic.Int64
+	translated  atomic.Int64 // Requests answered with a translated response
+	translateNs atomic.Int64
+}
+
+// NewAnthropicIngress creates the ingress. Models are resolved with the routing table like chat completions.
+func NewAnthropicIngress(client *bifrost.Bifrost, routes *RoutingTable) *AnthropicIngress {
+	return &AnthropicIngress{client: client, routes: routes}
+}
+
+// writeAnthropicError answers with an Anthropic style error
+func writeAnthropicError(ctx *fasthttp.RequestCtx, status int, errType string, message string) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"type": errType, "message": message},
+	})
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+// Handler serves POST /v1/messages. The time spent translating is reported in a Server-Timing
+// header next to the Bifrost call, so the runner's slowest requests show the ingress overhead.
+func (a *AnthropicIngress) Handler() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		a.requests.Add(1)
+		start := time.Now()
+
+		var req anthropicRequest
+		if err := DecodeBody(ctx, &req); err != nil {
+			a.rejected.Add(1)
+			if errors.Is(err, errBodyTooLarge) {
+				writeAnthropicError(ctx, fasthttp.StatusRequestEntityTooLarge, "request_too_large", err.Error())
+				return
+			}
+			writeAnthropicError(ctx, fasthttp.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid request format: %v", err))
+			return
+		}
+		bifrostReq, err := a.translateRequest(ctx, &req)
+		if err != nil {
+			a.rejected.Add(1)
+			writeAnthropicError(ctx, fasthttp.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+
+		bifrostStart := time.Now()
+		resp, bifrostErr := a.client.ChatCompletionRequest(ctx, bifrostReq)
+		bifrostTime := time.Since(bifrostStart)
+		if bifrostErr != nil {
+			status := fasthttp.StatusInternalServerError
+			if bifrostErr.StatusCode != nil {
+				status = *bifrostErr.StatusCode
+			}
+			writeAnthropicError(ctx, status, "api_error", bifrostErr.Error.Message)
+			return
+		}
+
+		body, err := json.Marshal(translateResponse(req.Model, resp))
+		if err != nil {
+			writeAnthropicError(ctx, fasthttp.StatusInternalServerError, "api_error", fmt.Sprintf("failed to encode response: %v", err))
+			return
+		}
+		translateTime := time.Since(start) - bifrostTime
+		a.translated.Add(1)
+		a.translateNs.Add(int64(translateTime))
+
+		ctx.Response.Header.Add("Server-Timing", timingEntry("translate", translateTime)+", "+timingEntry("bifrost", bifrostTime))
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType("application/json")
+		ctx.SetBody(body)
+	}
+}
+
+// Stats returns the ingress counters, nil when the ingress is disabled
+func (a *AnthropicIngress) Stats() *AnthropicStats {
+	if a == nil {
+		return nil
+	}
+	stats := &AnthropicStats{Requests: a.requests.Load(), Rejected: a.rejected.Load()}
+	if translated := a.translated.Load(); translated > 0 {
+		stats.MeanTranslateUs = float64(a.translateNs.Load()) / float64(translated) / 1000
+	}
+	return stats
+}
+
+// translateRequest converts a Messages request to a chat completion BifrostRequest. The system
+// prompt becomes a system message, tool results become tool messages and tool uses tool calls.
+func (a *AnthropicIngress) translateRequest(ctx *fasthttp.RequestCtx, req *anthropicRequest) (*schemas.BifrostRequest, error) {
+	if req.Model == "" {
+		return nil, fmt.Errorf("model: field required")
+	}
+	if req.MaxTokens < 1 {
+		return nil, fmt.Errorf("max_tokens: must be at least 1")
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("messages: at least one message is required")
+	}
+	if req.Stream {
+		return nil, fmt.Errorf("stream: streaming is not supported by this gateway")
+	}
+
+	var messages []schemas.BifrostMessage
+	if len(req.System) > 0 && string(req.System) != "null" {
+		system, err := anthropicText(req.System)
+		if err != nil {
+			return nil, fmt.Errorf("system: %v", err)
+		}
+		messages = append(messages, schemas.BifrostMessage{
+			Role:    schemas.ModelChatMessageRoleSystem,
+			Content: schemas.MessageContent{ContentStr: &system},
+		})
+	}
+	for i, m := range req.Messages {
+		translated, err := translateMessage(m)
+		if err != nil {
+			return nil, fmt.Errorf("messages.%d: %v", i, err)
+		}
+		messages = append(messages, translated...)
+	}
+
+	params := &schemas.ModelParameters{
+		MaxTokens:   &req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		TopK:        req.TopK,
+	}
+	if len(req.StopSequences) > 0 {
+		params.StopSequences = &req.StopSequences
+	}
+	if req.Metadata != nil && req.Metadata.UserID != "" {
+		params.User = &req.Metadata.UserID
+	}
+	if len(req.Tools) > 0 {
+		tools := make([]schemas.Tool, len(req.Tools))
+		for i, t := range req.Tools {
+			tools[i] = schemas.Tool{Type: "function", Function: schemas.Function{Name: t.Name, Description: t.Description, Parameters: t.InputSchema}}
+		}
+		params.Tools = &tools
+	}
+	if c := req.ToolChoice; c != nil {
+		choice, err := translateToolChoice(c)
+		if err != nil {
+			return nil, fmt.Errorf("tool_choice: %v", err)
+		}
+		params.ToolChoice = choice
+		if c.DisableParallelToolUse {
+			parallel := false
+			params.ParallelToolCalls = &parallel
+		}
+	}
+
+	provider, model := a.routes.ResolveRequest(ctx, req.Model)
+	return &schemas.BifrostRequest{
+		Provider: provider,
+		Model:    model,
+		Input:    schemas.RequestInput{ChatCompletionInput: &messages},
+		Params:   params,
+	}, nil
+}
+
+// translateMessage converts one turn. A user turn's tool results come first, as tool messages
+// answering the previous assistant turn's tool calls, followed by the rest of its content.
+func translateMessage(m anthropicMessage) ([]schemas.BifrostMessage, error) {
+	if m.Role != "user" && m.Role != "assistant" {
+		return nil, fmt.Errorf("role: must be user or assistant, got %q", m.Role)
+	}
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return []schemas.BifrostMessage{{Role: schemas.ModelChatMessageRole(m.Role), Content: schemas.MessageContent{ContentStr: &text}}}, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(m.Content, &blocks); err != nil {
+		return nil, fmt.Errorf("content: must be a string or an array of content blocks")
+	}
+
+	if m.Role == "assistant" {
+		var texts []string
+		var calls []schemas.ToolCall
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				texts = append(texts, b.Text)
+			case "tool_use":
+				callType, id, name, args := "function", b.ID, b.Name, string(b.Input)
+				if args == "" {
+					args = "{}"
+				}
+				calls = append(calls, schemas.ToolCall{Type: &callType, ID: &id, Function: schemas.FunctionCall{Name: &name, Arguments: args}})
+			default:
+				return nil, fmt.Errorf("content: unsupported block type %q in an assistant message", b.Type)
+			}
+		}
+		content := strings.Join(texts, "\n")
+		msg := schemas.BifrostMessage{Role: schemas.ModelChatMessageRoleAssistant, Content: schemas.MessageContent{ContentStr: &content}}
+		if len(calls) > 0 {
+			msg.AssistantMessage = &schemas.AssistantMessage{ToolCalls: &calls}
+		}
+		return []schemas.BifrostMessage{msg}, nil
+	}
+
+	var messages []schemas.BifrostMessage
+	var parts []schemas.ContentBlock
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			text := b.Text
+			parts = append(parts, schemas.ContentBlock{Type: schemas.ContentBlockTypeText, Text: &text})
+		case "image":
+			if b.Source == nil {
+				return nil, fmt.Errorf("content: image block without a source")
+			}
+			url := b.Source.URL
+			if b.Source.Type == "base64" {
+				url = "data:" + b.Source.MediaType + ";base64," + b.Source.Data
+			}
+			parts = append(parts, schemas.ContentBlock{Type: schemas.ContentBlockTypeImage, ImageURL: &schemas.ImageURLStruct{URL: url}})
+		case "tool_result":
+			result := ""
+			if len(b.Content) > 0 {
+				var err error
+				if result, err = anthropicText(b.Content); err != nil {
+					return nil, fmt.Errorf("content: tool result %s: %v", b.ToolUseID, err)
+				}
+			}
+			id := b.ToolUseID
+			messages = append(messages, schemas.BifrostMessage{
+				Role:        schemas.ModelChatMessageRoleTool,
+				Content:     schemas.MessageContent{ContentStr: &result},
+				ToolMessage: &schemas.ToolMessage{ToolCallID: &id},
+			})
+		default:
+			return nil, fmt.Errorf("content: unsupported block type %q in a user message", b.Type)
+		}
+	}
+	if len(parts) > 0 {
+		messages = append(messages, schemas.BifrostMessage{Role: schemas.ModelChatMessageRoleUser, Content: schemas.MessageContent{ContentBlocks: &parts}})
+	}
+	return messages, nil
+}
+
+// anthropicText reads a system prompt or tool result, a string or an array of text blocks joined by newlines
+func anthropicText(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("must be a string or an array of text blocks")
+	}
+	texts := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		if b.Type != "text" {
+			return "", fmt.Errorf("unsupported block type %q, only text is supported", b.Type)
+		}
+		texts = append(texts, b.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// translateToolChoice maps auto, any, tool and none to their OpenAI equivalents
+func translateToolChoice(c *anthropicToolChoice) (*schemas.ToolChoice, error) {
+	var choice string
+	switch c.Type {
+	case "auto":
+		choice = string(schemas.ToolChoiceTypeAuto)
+	case "any":
+		choice = string(schemas.ToolChoiceTypeRequired)
+	case "none":
+		choice = string(schemas.ToolChoiceTypeNone)
+	case "tool":
+		if c.Name == "" {
+			return nil, fmt.Errorf("name: required when type is tool")
+		}
+		return &schemas.ToolChoice{ToolChoiceStruct: &schemas.ToolChoiceStruct{
+			Type:     schemas.ToolChoiceTypeFunction,
+			Function: schemas.ToolChoiceFunction{Name: c.Name},
+		}}, nil
+	default:
+		return nil, fmt.Errorf("type: must be auto, any, tool or none, got %q", c.Type)
+	}
+	return &schemas.ToolChoice{ToolChoiceStr: &choice}, nil
+}
+
+// translateResponse converts Bifrost's chat completion to a Messages response for the requested model
+func translateResponse(model string, resp *schemas.BifrostResponse) anthropicResponse {
+	out := anthropicResponse{
+		ID:      "msg_" + strings.TrimPrefix(resp.ID, "chatcmpl-"),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   model,
+		Content: []anthropicContent{},
+	}
+	if resp.Usage != nil {
+		out.Usage.InputTokens = resp.Usage.PromptTokens
+		out.Usage.OutputTokens = resp.Usage.CompletionTokens
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].BifrostNonStreamResponseChoice == nil {
+		return out
+	}
+
+	choice := resp.Choices[0]
+	msg := choice.Message
+	if msg.Content.ContentStr != nil && *msg.Content.ContentStr != "" {
+		out.Content = append(out.Content, anthropicContent{Type: "text", Text: *msg.Content.ContentStr})
+	} else if msg.Content.ContentBlocks != nil {
+		for _, b := range *msg.Content.ContentBlocks {
+			if b.Type == schemas.ContentBlockTypeText && b.Text != nil {
+				out.Content = append(out.Content, anthropicContent{Type: "text", Text: *b.Text})
+			}
+		}
+	}
+	if msg.AssistantMessage != nil && msg.AssistantMessage.ToolCalls != nil {
+		for _, call := range *msg.AssistantMessage.ToolCalls {
+			block := anthropicContent{Type: "tool_use", Input: json.RawMessage("{}")}
+			if call.ID != nil {
+				block.ID = *call.ID
+			}
+			if call.Function.Name != nil {
+				block.Name = *call.Function.Name
+			}
+			// Arguments are whatever the model produced, not necessarily valid JSON
+			if json.Valid([]byte(call.Function.Arguments)) {
+				block.Input = json.RawMessage(call.Function.Arguments)
+			}
+			out.Content = append(out.Content, block)
+		}
+	}
+
+	if choice.StopString != nil {
+		reason := "stop_sequence"
+		out.StopReason, out.StopSequence = &reason, choice.StopString
+	} else if choice.FinishReason != nil {
+		reason, ok := anthropicStopReasons[*choice.FinishReason]
+		if !ok {
+			reason = *choice.FinishReason
+		}
+		out.StopReason = &reason
+	}
+	return out
+}
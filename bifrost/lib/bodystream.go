@@ -0,0 +1,173 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/bytedance/sonic"
+	"github.com/valyala/fasthttp"
+)
+
+// errBodyTooLarge is returned by the body decoders for streamed bodies over the size limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// BodyStreamStats are reported on /metrics under "body_streaming"
+type BodyStreamStats struct {
+	ThresholdBytes int   `json:"threshold_bytes"`
+	MaxBodyBytes   int   `json:"max_body_bytes"`
+	Streamed       int64 `json:"streamed"`       // Bodies decoded straight from the connection
+	StreamedBytes  int64 `json:"streamed_bytes"` // Bytes read from those bodies
+	Buffered       int64 `json:"buffered"`       // Bodies at or under the threshold, read into memory first
+	TooLarge       int64 `json:"too_large"`      // Bodies rejected with 413
+}
+
+// BodyStreaming decodes request bodies over a size threshold directly from the connection
+// instead of first copying them into fasthttp's request buffer. It requires the server to run
+// with StreamRequestBody, which makes MaxRequestBodySize a prefetch size rather than a limit,
+// so the limit is enforced here instead. Middleware that inspects the body (the cache,
+// idempotency keys, strict validation, model pools and virtual keys) still reads it into memory.
+type BodyStreaming struct {
+	threshold     int
+	maxBody       int
+	bufferChunked bool // Read chunked bodies into memory up to maxBody before any middleware does
+
+	streamed      atomic.Int64
+	streamedBytes atomic.Int64
+	buffered      atomic.Int64
+	tooLarge      atomic.Int64
+}
+
+// bodyStreaming is nil unless EnableBodyStreaming was called, in which case handlers stream
+// bodies over its threshold
+var bodyStreaming *BodyStreaming
+
+// EnableBodyStreaming streams bodies larger than threshold bytes and rejects bodies larger than
+// maxBody. inspected tells that middleware reading the whole body is enabled. fasthttp would read
+// a chunked body for it without any limit, so chunked bodies are then read up to maxBody first
+// instead of streamed. Call it before serving, and set the server's StreamRequestBody.
+func EnableBodyStreaming(threshold int, maxBody int, inspected bool) (*BodyStreaming, error) {
+	if threshold <= 0 || maxBody < threshold {
+		return nil, fmt.Errorf("stream threshold must be positive and no larger than the body limit")
+	}
+	bodyStreaming = &BodyStreaming{threshold: threshold, maxBody: maxBody, bufferChunked: inspected}
+	return bodyStreaming, nil
+}
+
+// streams reports whether the request's body is read from the connection rather than buffered.
+// Chunked bodies have no length up front, so they are always streamed.
+func (s *BodyStreaming) streams(ctx *fasthttp.RequestCtx) bool {
+	if s == nil || ctx.RequestBodyStream() == nil {
+		return false
+	}
+	length := ctx.Request.Header.ContentLength()
+	return length < 0 || length > s.threshold
+}
+
+// Wrap rejects bodies whose Content-Length is over the limit with 413 before any middleware
+// reads them. Chunked bodies are cut off at the limit while they are decoded, or read up to it
+// here when middleware inspects them.
+func (s *BodyStreaming) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if ctx.Request.Header.ContentLength() > s.maxBody {
+			s.tooLarge.Add(1)
+			writeBodyTooLarge(ctx, s.maxBody)
+			return
+		}
+		if s.bufferChunked && ctx.Request.Header.ContentLength() < 0 && ctx.RequestBodyStream() != nil {
+			body, err := io.ReadAll(&io.LimitedReader{R: ctx.RequestBodyStream(), N: int64(s.maxBody) + 1})
+			if len(body) > s.maxBody {
+				s.tooLarge.Add(1)
+				// The rest of the body is never read, so the connection can't be reused
+				ctx.SetConnectionClose()
+				writeBodyTooLarge(ctx, s.maxBody)
+				return
+			}
+			if err != nil {
+				WriteBodyError(ctx, err)
+				return
+			}
+			// A raw body replaces the stream, so handlers treat it as buffered
+			ctx.Request.SetBodyRaw(body)
+		}
+		next(ctx)
+	}
+}
+
+// Stats returns the streaming counters, nil when streaming is disabled
+func (s *BodyStreaming) Stats() *BodyStreamStats {
+	if s == nil {
+		return nil
+	}
+	return &BodyStreamStats{
+		ThresholdBytes: s.threshold,
+		MaxBodyBytes:   s.maxBody,
+		Streamed:       s.streamed.Load(),
+		StreamedBytes:  s.streamedBytes.Load(),
+		Buffered:       s.buffered.Load(),
+		TooLarge:       s.tooLarge.Load(),
+	}
+}
+
+// bodyDecoder is satisfied by both encoding/json's and sonic's stream decoders
+type bodyDecoder interface {
+	Decode(v interface{}) error
+}
+
+// decodeBody decodes the request body into v, from the connection when the body is streamed
+func decodeBody(ctx *fasthttp.RequestCtx, v interface{}, unmarshal func([]byte, interface{}) error, newDecoder func(io.Reader) bodyDecoder) error {
+	s := bodyStreaming
+	if !s.streams(ctx) {
+		if s != nil {
+			s.buffered.Add(1)
+		}
+		return unmarshal(ctx.PostBody(), v)
+	}
+
+	s.streamed.Add(1)
+	body := &io.LimitedReader{R: ctx.RequestBodyStream(), N: int64(s.maxBody) + 1}
+	err := newDecoder(body).Decode(v)
+	s.streamedBytes.Add(int64(s.maxBody) + 1 - body.N)
+	if body.N == 0 {
+		s.tooLarge.Add(1)
+		return errBodyTooLarge
+	}
+	return err
+}
+
+// DecodeBody decodes a JSON request body with encoding/json
+func DecodeBody(ctx *fasthttp.RequestCtx, v interface{}) error {
+	return decodeBody(ctx, v, json.Unmarshal, func(r io.Reader) bodyDecoder { return json.NewDecoder(r) })
+}
+
+// decodeBodySonic decodes a JSON request body with sonic
+func decodeBodySonic(ctx *fasthttp.RequestCtx, v interface{}) error {
+	return decodeBody(ctx, v, sonic.Unmarshal, func(r io.Reader) bodyDecoder { return sonic.ConfigDefault.NewDecoder(r) })
+}
+
+// WriteBodyError answers a request whose body could not be decoded: 413 for bodies over the
+// streaming limit, otherwise 400 with the decode error
+func WriteBodyError(ctx *fasthttp.RequestCtx, err error) {
+	if errors.Is(err, errBodyTooLarge) {
+		writeBodyTooLarge(ctx, bodyStreaming.maxBody)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusBadRequest)
+	ctx.SetBodyString(fmt.Sprintf("invalid request format: %v", err))
+}
+
+func writeBodyTooLarge(ctx *fasthttp.RequestCtx, maxBody int) {
+	ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+	ctx.SetBodyString(fmt.Sprintf("request body exceeds %d bytes", maxBody))
+}
+
+// requestBodySize is the size of the request body without reading it, since a streamed body is
+// gone once the handler has decoded it
+func requestBodySize(ctx *fasthttp.RequestCtx) int {
+	if bodyStreaming.streams(ctx) {
+		return max(ctx.Request.Header.ContentLength(), 0)
+	}
+	return len(ctx.PostBody())
+}
@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// BreakerSettings configure a circuit breaker
+type BreakerSettings struct {
+	ErrorRate   float64       // Fraction of failed requests in the window that opens the breaker
+	MinRequests int           // Requests the window needs before its error rate counts
+	Window      time.Duration // Rolling window the error rate is computed over, in whole seconds
+	OpenFor     time.Duration // How long the breaker stays open before probing
+	Probes      int           // Requests let through while half-open; all must succeed to close
+}
+
+// breakerBucket counts the outcomes of one second
+type breakerBucket struct {
+	second   int64
+	total    int64
+	failures int64
+}
+
+// BreakerStats are the breaker state and counters, reported on /metrics
+type BreakerStats struct {
+	State       string    `json:"state"`
+	StateSince  time.Time `json:"state_since"`
+	ErrorRate   float64   `json:"error_rate"` // In the current window
+	Requests    int64     `json:"requests"`   // In the current window
+	Opened      int64     `json:"opened"`     // Times the breaker opened
+	Rejected    int64     `json:"rejected"`   // Requests answered with 503 without calling Bifrost
+	ProbesSent  int64     `json:"probes_sent"`
+	ProbeFailed int64     `json:"probes_failed"`
+}
+
+// CircuitBreaker stops calling Bifrost while the upstream is failing. It opens when the error
+// rate over the window crosses the threshold, rejects requests with 503 while open, then lets a
+// few probes through and closes only if they all succeed. Responses with a 5xx status count as
+// failures.
+type CircuitBreaker struct {
+	settings   BreakerSettings
+	retryAfter string
+
+	mu           sync.Mutex
+	state        string
+	since        time.Time
+	buckets      []breakerBucket
+	probesOut    int // Probes in flight or answered in this half-open period
+	probesPassed int
+
+	opened      int64
+	rejected    int64
+	probesSent  int64
+	probeFailed int64
+}
+
+// NewCircuitBreaker validates the settings and creates a closed breaker
+func NewCircuitBreaker(s BreakerSettings) (*CircuitBreaker, error) {
+	if s.ErrorRate <= 0 || s.ErrorRate > 1 {
+		return nil, fmt.Errorf("error rate must be in (0, 1], got %g", s.ErrorRate)
+	}
+	if s.Window < time.Second || s.OpenFor <= 0 || s.Probes < 1 || s.MinRequests < 1 {
+		return nil, fmt.Errorf("the window must be at least 1s, the open duration positive and probes and minimum requests at least 1")
+	}
+	seconds := int(math.Ceil(s.OpenFor.Seconds()))
+	return &CircuitBreaker{
+		settings:   s,
+		retryAfter: strconv.Itoa(seconds),
+		state:      BreakerClosed,
+		since:      time.Now(),
+		buckets:    make([]breakerBucket, int(s.Window/time.Second)),
+	}, nil
+}
+
+// setState moves the breaker to a new state, starting a fresh window or probe round
+func (b *CircuitBreaker) setState(state string, now time.Time) {
+	b.state, b.since = state, now
+	b.probesOut, b.probesPassed = 0, 0
+	switch state {
+	case BreakerOpen:
+		b.opened++
+	case BreakerClosed:
+		clear(b.buckets)
+	}
+}
+
+// windowCounts sums the outcomes of the last window
+func (b *CircuitBreaker) windowCounts(now time.Time) (total, failures int64) {
+	oldest := now.Unix() - int64(len(b.buckets)) + 1
+	for _, bucket := range b.buckets {
+		if bucket.second >= oldest {
+			total += bucket.total
+			failures += bucket.failures
+		}
+	}
+	return total, failures
+}
+
+// admit decides whether a request may call Bifrost, and whether it is a half-open probe
+func (b *CircuitBreaker) admit(now time.Time) (allowed bool, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && now.Sub(b.since) >= b.settings.OpenFor {
+		b.setState(BreakerHalfOpen, now)
+	}
+	switch b.state {
+	case BreakerOpen:
+		b.rejected++
+		return false, false
+	case BreakerHalfOpen:
+		if b.probesOut >= b.settings.Probes {
+			b.rejected++
+			return false, false
+		}
+		b.probesOut++
+		b.probesSent++
+		return true, true
+	}
+	return true, false
+}
+
+// record counts the outcome of an admitted request and changes state when it tips the balance
+func (b *CircuitBreaker) record(now time.Time, failed bool, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		if b.state != BreakerHalfOpen {
+			return
+		}
+		if failed {
+			b.probeFailed++
+			b.setState(BreakerOpen, now)
+		} else if b.probesPassed++; b.probesPassed >= b.settings.Probes {
+			b.setState(BreakerClosed, now)
+		}
+		return
+	}
+	if b.state != BreakerClosed {
+		return // Requests admitted before the breaker opened
+	}
+
+	second := now.Unix()
+	bucket := &b.buckets[second%int64(len(b.buckets))]
+	if bucket.second != second {
+		*bucket = breakerBucket{second: second}
+	}
+	bucket.total++
+	if failed {
+		bucket.failures++
+	}
+
+	total, failures := b.windowCounts(now)
+	if total >= int64(b.settings.MinRequests) && float64(failures) >= b.settings.ErrorRate*float64(total) {
+		b.setState(BreakerOpen, now)
+	}
+}
+
+// Wrap calls next through the breaker, answering 503 with Retry-After while it is open
+func (b *CircuitBreaker) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		allowed, probe := b.admit(time.Now())
+		if !allowed {
+			ctx.Response.Header.Set("Retry-After", b.retryAfter)
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			ctx.SetContentType("application/json")
+			ctx.SetBodyString(`{"error":{"message":"circuit breaker is open","type":"circuit_open"}}`)
+			return
+		}
+		next(ctx)
+		b.record(time.Now(), ctx.Response.StatusCode() >= 500, probe)
+	}
+}
+
+// Stats returns the breaker state and counters, nil when the breaker is disabled
+func (b *CircuitBreaker) Stats() *BreakerStats {
+	if b == nil {
+		return nil
+	}
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == BreakerOpen && now.Sub(b.since) >= b.settings.OpenFor {
+		state = BreakerHalfOpen // Becomes half-open on the next request
+	}
+	stats := &BreakerStats{
+		State:       state,
+		StateSince:  b.since,
+		Opened:      b.opened,
+		Rejected:    b.rejected,
+		ProbesSent:  b.probesSent,
+		ProbeFailed: b.probeFailed,
+	}
+	if b.state == BreakerClosed {
+		total, failures := b.windowCounts(now)
+		stats.Requests = total
+		if total > 0 {
+			stats.ErrorRate = float64(failures) / float64(total)
+		}
+	}
+	return stats
+}
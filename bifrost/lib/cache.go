@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// cacheKey is the hash of a normalized request body
+type cacheKey [sha256.Size]byte
+
+// cachedResponse is a response stored in the cache or shared with coalesced requests
+type cachedResponse struct {
+	status      int
+	contentType []byte
+	body        []byte
+	expires     time.Time
+}
+
+// pendingCall is a request being served whose identical requests wait for its response
+type pendingCall struct {
+	done chan struct{}
+	resp cachedResponse
+}
+
+// abandonedResponse is what the requests waiting on a call get when its handler panics, so
+// they are never left blocked on it
+var abandonedResponse = cachedResponse{
+	status:      fasthttp.StatusBadGateway,
+	contentType: []byte("application/json"),
+	body:        []byte(`{"error":{"message":"the request this one waited for failed","type":"upstream_error"}}`),
+}
+
+// ResponseCache answers identical requests from memory for a TTL, and coalesces identical
+// requests arriving while the first is still being served into a single upstream call.
+// Requests are identical when their JSON bodies are equal, regardless of key order and whitespace.
+type ResponseCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu       sync.Mutex
+	entries  map[cacheKey]cachedResponse
+	order    []cacheKey // Store order, oldest first, for eviction
+	inFlight map[cacheKey]*pendingCall
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	coalesced atomic.Int64
+	evictions atomic.Int64
+}
+
+// CacheStats is the state of the response cache, reported on /metrics
+type CacheStats struct {
+	Entries   int   `json:"entries"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Coalesced int64 `json:"coalesced"` // Requests that waited for an identical in-flight request
+	Evictions int64 `json:"evictions"`
+}
+
+// NewResponseCache keeps successful responses for ttl, at most maxEntries of them
+func NewResponseCache(ttl time.Duration, maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		ttl:        ttl,
+		maxEntries: max(maxEntries, 1),
+		entries:    make(map[cacheKey]cachedResponse),
+		inFlight:   make(map[cacheKey]*pendingCall),
+	}
+}
+
+// normalizedKey hashes the body with sorted keys and no insignificant whitespace
+func normalizedKey(body []byte) (cacheKey, bool) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return cacheKey{}, false
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return cacheKey{}, false
+	}
+	return sha256.Sum256(normalized), true
+}
+
+// Wrap serves cached and coalesced responses, calling next only for the first of identical requests
+func (c *ResponseCache) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		key, ok := normalizedKey(ctx.PostBody())
+		if !ok {
+			next(ctx)
+			return
+		}
+
+		c.mu.Lock()
+		if entry, found := c.entries[key]; found && time.Now().Before(entry.expires) {
+			c.mu.Unlock()
+			c.hits.Add(1)
+			writeCached(ctx, entry, "HIT")
+			return
+		}
+		if call, found := c.inFlight[key]; found {
+			c.mu.Unlock()
+			c.coalesced.Add(1)
+			<-call.done
+			writeCached(ctx, call.resp, "COALESCED")
+			return
+		}
+		call := &pendingCall{done: make(chan struct{}), resp: abandonedResponse}
+		c.inFlight[key] = call
+		c.mu.Unlock()
+
+		// Release the waiters even if next panics, in which case they get abandonedResponse
+		defer func() {
+			c.mu.Lock()
+			delete(c.inFlight, key)
+			if call.resp.status == fasthttp.StatusOK {
+				c.store(key, call.resp)
+			}
+			c.mu.Unlock()
+			close(call.done)
+		}()
+
+		c.misses.Add(1)
+		next(ctx)
+		ctx.Response.Header.Set("X-Cache", "MISS")
+
+		// Copy the response, since fasthttp reuses its buffers once the handler returns
+		call.resp = cachedResponse{
+			status:      ctx.Response.StatusCode(),
+			contentType: append([]byte(nil), ctx.Response.Header.ContentType()...),
+			body:        append([]byte(nil), ctx.Response.Body()...),
+			expires:     time.Now().Add(c.ttl),
+		}
+	}
+}
+
+// store adds an entry, evicting the oldest ones beyond maxEntries. A key stored again, after its
+// entry expired, moves to the back of the eviction order; entries expire in store order, so its
+// old position is near the front. The caller holds c.mu.
+func (c *ResponseCache) store(key cacheKey, resp cachedResponse) {
+	if _, exists := c.entries[key]; exists {
+		if i := slices.Index(c.order, key); i >= 0 {
+			c.order = slices.Delete(c.order, i, i+1)
+		}
+	}
+	c.order = append(c.order, key)
+	c.entries[key] = resp
+
+	for len(c.entries) > c.maxEntries {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.entries, oldest)
+		c.evictions.Add(1)
+	}
+}
+
+// writeCached answers a request with a stored response
+func writeCached(ctx *fasthttp.RequestCtx, resp cachedResponse, cacheStatus string) {
+	ctx.SetStatusCode(resp.status)
+	ctx.Response.Header.SetContentTypeBytes(resp.contentType)
+	ctx.Response.Header.Set("X-Cache", cacheStatus)
+	ctx.SetBody(resp.body)
+}
+
+// Stats returns the cache counters, nil when caching is disabled
+func (c *ResponseCache) Stats() *CacheStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	entries := len(c.entries)
+	c.mu.Unlock()
+
+	return &CacheStats{
+		Entries:   entries,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Coalesced: c.coalesced.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
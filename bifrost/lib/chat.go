@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/maximhq/bifrost/core/schemas"
+)
+
+// ChatRequest is an OpenAI chat completion request. Every message role, multi-part content
+// (text and image_url parts), tool calls and tool results, and the sampling and tool
+// parameters are decoded, and BifrostInput maps them onto Bifrost's schemas.
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+
+	Tools               *[]schemas.Tool     `json:"tools,omitempty"`
+	ToolChoice          *schemas.ToolChoice `json:"tool_choice,omitempty"`
+	ParallelToolCalls   *bool               `json:"parallel_tool_calls,omitempty"`
+	Temperature         *float64            `json:"temperature,omitempty"`
+	TopP                *float64            `json:"top_p,omitempty"`
+	MaxTokens           *int                `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                `json:"max_completion_tokens,omitempty"`
+	Stop                stopSequences       `json:"stop,omitempty"`
+	PresencePenalty     *float64            `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64            `json:"frequency_penalty,omitempty"`
+	User                *string             `json:"user,omitempty"`
+
+	// Filled in by BifrostInput, and kept so pooled requests reuse them
+	messages []schemas.BifrostMessage
+	params   schemas.ModelParameters
+}
+
+// ChatMessage is one message of a chat completion request, in any role
+type ChatMessage struct {
+	Role       string                  `json:"role"`
+	Content    *schemas.MessageContent `json:"content"` // A string or an array of parts; nil for null
+	Name       *string                 `json:"name,omitempty"`
+	ToolCallID *string                 `json:"tool_call_id,omitempty"`
+	ToolCalls  *[]schemas.ToolCall     `json:"tool_calls,omitempty"`
+	Refusal    *string                 `json:"refusal,omitempty"`
+}
+
+// stopSequences is the stop parameter, a single string or an array of them
+type stopSequences []string
+
+func (s *stopSequences) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*s = nil
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = stopSequences{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = many
+	return nil
+}
+
+// BifrostInput maps the request onto a Bifrost chat completion's messages and parameters,
+// rejecting messages Bifrost can't represent. The parameters are nil when the request sets
+// none. Both belong to the request and are only valid until it is reset.
+//
+// Bifrost's message schema has no name field, so message names are accepted but not forwarded.
+// developer messages are sent as system messages, which is what OpenAI does for older models.
+func (r *ChatRequest) BifrostInput() (*[]schemas.BifrostMessage, *schemas.ModelParameters, error) {
+	r.messages = r.messages[:0]
+	for i := range r.Messages {
+		msg, err := r.Messages[i].bifrostMessage()
+		if err != nil {
+			return nil, nil, fmt.Errorf("messages.%d: %v", i, err)
+		}
+		r.messages = append(r.messages, msg)
+	}
+
+	r.params = schemas.ModelParameters{
+		Tools:             r.Tools,
+		ToolChoice:        r.ToolChoice,
+		ParallelToolCalls: r.ParallelToolCalls,
+		Temperature:       r.Temperature,
+		TopP:              r.TopP,
+		MaxTokens:         r.MaxTokens,
+		PresencePenalty:   r.PresencePenalty,
+		FrequencyPenalty:  r.FrequencyPenalty,
+		User:              r.User,
+	}
+	if r.params.MaxTokens == nil {
+		r.params.MaxTokens = r.MaxCompletionTokens
+	}
+	if len(r.Stop) > 0 {
+		stop := []string(r.Stop)
+		r.params.StopSequences = &stop
+	}
+	if !r.hasParams() {
+		return &r.messages, nil, nil
+	}
+	return &r.messages, &r.params, nil
+}
+
+// hasParams reports whether the request sets any parameter
+func (r *ChatRequest) hasParams() bool {
+	return r.Tools != nil || r.ToolChoice != nil || r.ParallelToolCalls != nil || r.Temperature != nil ||
+		r.TopP != nil || r.MaxTokens != nil || r.MaxCompletionTokens != nil || len(r.Stop) > 0 ||
+		r.PresencePenalty != nil || r.FrequencyPenalty != nil || r.User != nil
+}
+
+// Reset clears the request for reuse, keeping the capacity of its slices
+func (r *ChatRequest) Reset() {
+	clear(r.Messages)
+	clear(r.messages)
+	*r = ChatRequest{Messages: r.Messages[:0], messages: r.messages[:0]}
+}
+
+// bifrostMessage maps one message, checking the fields its role requires or forbids
+func (m *ChatMessage) bifrostMessage() (schemas.BifrostMessage, error) {
+	msg := schemas.BifrostMessage{Role: schemas.ModelChatMessageRole(m.Role)}
+	if m.Content != nil {
+		if err := checkContentParts(m.Content); err != nil {
+			return msg, err
+		}
+		msg.Content = *m.Content
+	}
+
+	switch m.Role {
+	case "system", "developer", "user":
+		if m.Role == "developer" {
+			msg.Role = schemas.ModelChatMessageRoleSystem
+		}
+		if m.Content == nil {
+			return msg, fmt.Errorf("content: required in a %s message", m.Role)
+		}
+	case "assistant":
+		if m.Content == nil && m.ToolCalls == nil && m.Refusal == nil {
+			return msg, fmt.Errorf("content: required in an assistant message without tool_calls")
+		}
+		if m.ToolCalls != nil || m.Refusal != nil {
+			msg.AssistantMessage = &schemas.AssistantMessage{ToolCalls: m.ToolCalls, Refusal: m.Refusal}
+		}
+	case "tool":
+		if m.ToolCallID == nil || *m.ToolCallID == "" {
+			return msg, fmt.Errorf("tool_call_id: required in a tool message")
+		}
+		if m.Content == nil {
+			return msg, fmt.Errorf("content: required in a tool message")
+		}
+		msg.ToolMessage = &schemas.ToolMessage{ToolCallID: m.ToolCallID}
+	default:
+		return msg, fmt.Errorf("role: must be system, developer, user, assistant or tool, got %q", m.Role)
+	}
+
+	if m.ToolCalls != nil && m.Role != "assistant" {
+		return msg, fmt.Errorf("tool_calls: only allowed in an assistant message")
+	}
+	if m.ToolCallID != nil && m.Role != "tool" {
+		return msg, fmt.Errorf("tool_call_id: only allowed in a tool message")
+	}
+	return msg, nil
+}
+
+// checkContentParts accepts the part types Bifrost forwards: text and image_url
+func checkContentParts(content *schemas.MessageContent) error {
+	if content.ContentBlocks == nil {
+		return nil
+	}
+	for i, part := range *content.ContentBlocks {
+		switch part.Type {
+		case schemas.ContentBlockTypeText:
+			if part.Text == nil {
+				return fmt.Errorf("content.%d: text part without text", i)
+			}
+		case schemas.ContentBlockTypeImage:
+			if part.ImageURL == nil || part.ImageURL.URL == "" {
+				return fmt.Errorf("content.%d: image_url part without a url", i)
+			}
+		default:
+			return fmt.Errorf("content.%d: unsupported part type %q, only text and image_url are forwarded", i, part.Type)
+		}
+	}
+	return nil
+}
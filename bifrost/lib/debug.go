@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -37,13 +38,43 @@ type ProviderMetrics struct {
 	ResponseSizeInBytes    int64         `json:"response_size_in_bytes"`
 }
 
-// TimingStats holds timing statistics
+// TimingStats holds running totals of timing statistics, so memory stays flat however long
+// the gateway runs
 type TimingStats struct {
 	mu              sync.Mutex
 	totalRequests   int
-	metrics         []RequestMetrics
-	timings         []time.Duration
-	providerMetrics []ProviderMetrics
+	metrics         RequestMetrics // Sums over metricsCount responses
+	metricsCount    int64
+	providerMetrics ProviderMetrics // Sums over providerCount responses
+	providerCount   int64
+	handlerTime     time.Duration // Sum over handlerCount requests
+	handlerCount    int64
+}
+
+// addRequestMetrics adds one response's Bifrost timings to the totals
+func (s *TimingStats) addRequestMetrics(m RequestMetrics) {
+	s.metrics.QueueWaitTime += m.QueueWaitTime
+	s.metrics.KeySelectionTime += m.KeySelectionTime
+	s.metrics.PluginPreTime += m.PluginPreTime
+	s.metrics.PluginPostTime += m.PluginPostTime
+	s.metrics.RequestCount += m.RequestCount
+	s.metrics.ErrorCount += m.ErrorCount
+	s.metricsCount++
+}
+
+// addProviderMetrics adds one response's provider timings to the totals
+func (s *TimingStats) addProviderMetrics(t ProviderMetrics) {
+	s.providerMetrics.MessageFormatting += t.MessageFormatting
+	s.providerMetrics.ParamsPreparation += t.ParamsPreparation
+	s.providerMetrics.RequestBodyPreparation += t.RequestBodyPreparation
+	s.providerMetrics.JSONMarshaling += t.JSONMarshaling
+	s.providerMetrics.RequestSetup += t.RequestSetup
+	s.providerMetrics.HTTPRequest += t.HTTPRequest
+	s.providerMetrics.ErrorHandling += t.ErrorHandling
+	s.providerMetrics.ResponseParsing += t.ResponseParsing
+	s.providerMetrics.RequestSizeInBytes += t.RequestSizeInBytes
+	s.providerMetrics.ResponseSizeInBytes += t.ResponseSizeInBytes
+	s.providerCount++
 }
 
 // ServerMetrics tracks server-level metrics
@@ -56,11 +87,19 @@ type ServerMetrics struct {
 	ErrorCount         int64
 	LastError          error
 	LastErrorTime      time.Time
+
+	// Counted from Bifrost's retry log messages, see RetryCountingLogger
+	Retries          int64 // Upstream attempts after the first
+	RetriedRequests  int64 // Requests retried at least once
+	RetriesExhausted int64 // Requests that failed after their last retry
 }
 
 var (
 	stats         = &TimingStats{}
 	serverMetrics = &ServerMetrics{}
+
+	// handlerLatencies feeds the live percentiles on /metrics
+	handlerLatencies = NewLatencyWindow(time.Minute)
 )
 
 func formatSmartDuration(ns int64) string {
@@ -86,37 +125,8 @@ func PrintStats() {
 		return
 	}
 
-	// Calculate averages for Bifrost metrics
-	var totalMetrics RequestMetrics
-	for _, m := range stats.metrics {
-		totalMetrics.QueueWaitTime += m.QueueWaitTime
-		totalMetrics.KeySelectionTime += m.KeySelectionTime
-		totalMetrics.PluginPreTime += m.PluginPreTime
-		totalMetrics.PluginPostTime += m.PluginPostTime
-		totalMetrics.RequestCount += m.RequestCount
-		totalMetrics.ErrorCount += m.ErrorCount
-	}
-
-	// Calculate averages for provider timings
-	var totalProviderMetrics ProviderMetrics
-	for _, t := range stats.providerMetrics {
-		totalProviderMetrics.MessageFormatting += t.MessageFormatting
-		totalProviderMetrics.ParamsPreparation += t.ParamsPreparation
-		totalProviderMetrics.RequestBodyPreparation += t.RequestBodyPreparation
-		totalProviderMetrics.JSONMarshaling += t.JSONMarshaling
-		totalProviderMetrics.RequestSetup += t.RequestSetup
-		totalProviderMetrics.HTTPRequest += t.HTTPRequest
-		totalProviderMetrics.ErrorHandling += t.ErrorHandling
-		totalProviderMetrics.ResponseParsing += t.ResponseParsing
-		totalProviderMetrics.RequestSizeInBytes += t.RequestSizeInBytes
-		totalProviderMetrics.ResponseSizeInBytes += t.ResponseSizeInBytes
-	}
-
-	// Calculate averages for timings
-	var totalTimings time.Duration
-	for _, t := range stats.timings {
-		totalTimings += t
-	}
+	totalMetrics := stats.metrics
+	totalProviderMetrics := stats.providerMetrics
 
 	// Print final metrics
 	serverMetrics.mu.Lock()
@@ -125,6 +135,8 @@ func PrintStats() {
 	fmt.Printf("Successful Requests: %d\n", serverMetrics.SuccessfulRequests)
 	fmt.Printf("Dropped Requests: %d\n", serverMetrics.DroppedRequests)
 	fmt.Printf("Error Count: %d\n", serverMetrics.ErrorCount)
+	fmt.Printf("Retries: %d (%d requests retried, %d failed after retrying)\n",
+		serverMetrics.Retries, serverMetrics.RetriedRequests, serverMetrics.RetriesExhausted)
 	fmt.Printf("Last Error: %s\n", serverMetrics.LastError)
 	fmt.Printf("Last Error Time: %v\n", serverMetrics.LastErrorTime)
 	serverMetrics.mu.Unlock()
@@ -133,42 +145,59 @@ func PrintStats() {
 	fmt.Printf("Total Requests: %d\n", stats.totalRequests)
 
 	fmt.Printf("\nBifrost Metrics (averages):\n")
-	// Check if we have provider timings to avoid division by zero
-	if len(stats.providerMetrics) > 0 {
-		fmt.Printf("Queue Wait Time: %s\n", formatSmartDuration(totalMetrics.QueueWaitTime.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Key Selection Time: %s\n", formatSmartDuration(totalMetrics.KeySelectionTime.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Plugin Pre Time: %s\n", formatSmartDuration(totalMetrics.PluginPreTime.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Plugin Post Time: %s\n", formatSmartDuration(totalMetrics.PluginPostTime.Nanoseconds()/int64(len(stats.providerMetrics))))
-
-		fmt.Printf("\nProvider Timings (averages):\n")
-		fmt.Printf("Message Formatting: %s\n", formatSmartDuration(totalProviderMetrics.MessageFormatting.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Params Preparation: %s\n", formatSmartDuration(totalProviderMetrics.ParamsPreparation.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Request Body Preparation: %s\n", formatSmartDuration(totalProviderMetrics.RequestBodyPreparation.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("JSON Marshaling: %s\n", formatSmartDuration(totalProviderMetrics.JSONMarshaling.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Request Setup: %s\n", formatSmartDuration(totalProviderMetrics.RequestSetup.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("HTTP Request: %s\n", formatSmartDuration(totalProviderMetrics.HTTPRequest.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Error Handling: %s\n", formatSmartDuration(totalProviderMetrics.ErrorHandling.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Response Parsing: %s\n", formatSmartDuration(totalProviderMetrics.ResponseParsing.Nanoseconds()/int64(len(stats.providerMetrics))))
-		fmt.Printf("Request Size: %.2f KB\n", float64(totalProviderMetrics.RequestSizeInBytes)/float64(len(stats.providerMetrics))/1024.0)
-		fmt.Printf("Response Size: %.2f KB\n", float64(totalProviderMetrics.ResponseSizeInBytes)/float64(len(stats.providerMetrics))/1024.0)
+	if stats.metricsCount > 0 {
+		n := stats.metricsCount
+		fmt.Printf("Queue Wait Time: %s\n", formatSmartDuration(totalMetrics.QueueWaitTime.Nanoseconds()/n))
+		fmt.Printf("Key Selection Time: %s\n", formatSmartDuration(totalMetrics.KeySelectionTime.Nanoseconds()/n))
+		fmt.Printf("Plugin Pre Time: %s\n", formatSmartDuration(totalMetrics.PluginPreTime.Nanoseconds()/n))
+		fmt.Printf("Plugin Post Time: %s\n", formatSmartDuration(totalMetrics.PluginPostTime.Nanoseconds()/n))
+	} else {
+		fmt.Println("No Bifrost timing data available")
+	}
+
+	fmt.Printf("\nProvider Timings (averages):\n")
+	if stats.providerCount > 0 {
+		n := stats.providerCount
+		fmt.Printf("Message Formatting: %s\n", formatSmartDuration(totalProviderMetrics.MessageFormatting.Nanoseconds()/n))
+		fmt.Printf("Params Preparation: %s\n", formatSmartDuration(totalProviderMetrics.ParamsPreparation.Nanoseconds()/n))
+		fmt.Printf("Request Body Preparation: %s\n", formatSmartDuration(totalProviderMetrics.RequestBodyPreparation.Nanoseconds()/n))
+		fmt.Printf("JSON Marshaling: %s\n", formatSmartDuration(totalProviderMetrics.JSONMarshaling.Nanoseconds()/n))
+		fmt.Printf("Request Setup: %s\n", formatSmartDuration(totalProviderMetrics.RequestSetup.Nanoseconds()/n))
+		fmt.Printf("HTTP Request: %s\n", formatSmartDuration(totalProviderMetrics.HTTPRequest.Nanoseconds()/n))
+		fmt.Printf("Error Handling: %s\n", formatSmartDuration(totalProviderMetrics.ErrorHandling.Nanoseconds()/n))
+		fmt.Printf("Response Parsing: %s\n", formatSmartDuration(totalProviderMetrics.ResponseParsing.Nanoseconds()/n))
+		fmt.Printf("Request Size: %.2f KB\n", float64(totalProviderMetrics.RequestSizeInBytes)/float64(n)/1024.0)
+		fmt.Printf("Response Size: %.2f KB\n", float64(totalProviderMetrics.ResponseSizeInBytes)/float64(n)/1024.0)
 	} else {
 		fmt.Println("No provider timing data available")
 	}
 
 	// Only calculate average timings if we have data
-	if len(stats.timings) > 0 {
-		avgTimings := float64(totalTimings) / float64(len(stats.timings)) / float64(time.Nanosecond)
-		fmt.Printf("\nAverage Timings: %.2f ms\n", avgTimings)
+	if stats.handlerCount > 0 {
+		fmt.Printf("\nAverage Handler Time: %s\n", formatSmartDuration(stats.handlerTime.Nanoseconds()/stats.handlerCount))
 	}
 }
 
-type ChatRequest struct {
-	Messages []schemas.BifrostMessage `json:"messages"`
-	Model    string                   `json:"model"`
-}
-
-func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
+func DebugHandler(client *bifrost.Bifrost, routes *RoutingTable) func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
+		// Report where the time went in a Server-Timing header, so the benchmark runner can
+		// break down its slowest requests
+		start := time.Now()
+		var bifrostTime time.Duration
+		var requestTimings *RequestMetrics
+		var providerTimings *ProviderMetrics
+		var bifrostResp *schemas.BifrostResponse
+		defer func() {
+			handlerTime := time.Since(start)
+			RecordTimings(handlerTime, bifrostTime, bifrostResp)
+			handlerLatencies.Record(handlerTime)
+			stats.mu.Lock()
+			stats.handlerTime += handlerTime
+			stats.handlerCount++
+			stats.mu.Unlock()
+			ctx.Response.Header.Add("Server-Timing", serverTiming(handlerTime, bifrostTime, requestTimings, providerTimings))
+		}()
+
 		// Track incoming request
 		serverMetrics.mu.Lock()
 		serverMetrics.TotalRequests++
@@ -176,15 +205,14 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 
 		// Time request parsing
 		var chatReq ChatRequest
-		if err := json.Unmarshal(ctx.PostBody(), &chatReq); err != nil {
+		if err := DecodeBody(ctx, &chatReq); err != nil {
 			serverMetrics.mu.Lock()
 			serverMetrics.ErrorCount++
 			serverMetrics.LastError = fmt.Errorf("invalid request format: %v", err)
 			serverMetrics.LastErrorTime = time.Now()
 			serverMetrics.mu.Unlock()
 
-			ctx.SetStatusCode(fasthttp.StatusBadRequest)
-			ctx.SetBodyString(fmt.Sprintf("invalid request format: %v", err))
+			WriteBodyError(ctx, err)
 			return
 		}
 
@@ -199,34 +227,56 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 			ctx.SetBodyString("Messages array is required")
 			return
 		}
+		messages, params, err := chatReq.BifrostInput()
+		if err != nil {
+			serverMetrics.mu.Lock()
+			serverMetrics.ErrorCount++
+			serverMetrics.LastError = fmt.Errorf("invalid request: %v", err)
+			serverMetrics.LastErrorTime = time.Now()
+			serverMetrics.mu.Unlock()
 
-		if strings.Contains(chatReq.Model, "/") {
-			parts := strings.Split(chatReq.Model, "/")
-			chatReq.Model = parts[1]
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(fmt.Sprintf("invalid request: %v", err))
+			return
 		}
 
+		provider, model := routes.ResolveRequest(ctx, chatReq.Model)
+
 		// Create Bifrost request
 		bifrostReq := &schemas.BifrostRequest{
-			Provider: schemas.OpenAI,
-			Model:    chatReq.Model,
+			Provider: provider,
+			Model:    model,
 			Input: schemas.RequestInput{
-				ChatCompletionInput: &chatReq.Messages,
+				ChatCompletionInput: messages,
 			},
+			Params: params,
 		}
 
+		// Report which key served the request; the lease is filled in when Bifrost selects one
+		lease := &keyLease{}
+		ctx.SetUserValue(keyLeaseKey{}, lease)
+
 		// Make Bifrost API call with timeout
+		// The goroutine writes its own variables, which are only read once it is done, since it
+		// outlives the handler on a timeout
 		done := make(chan struct{})
-		var bifrostResp *schemas.BifrostResponse
-		var bifrostErr *schemas.BifrostError
+		var callResp *schemas.BifrostResponse
+		var bifrostErr, callErr *schemas.BifrostError
 
+		bifrostStart := time.Now()
 		go func() {
-			bifrostResp, bifrostErr = client.ChatCompletionRequest(ctx, bifrostReq)
+			callResp, callErr = client.ChatCompletionRequest(ctx, bifrostReq)
 			close(done)
 		}()
 
 		select {
 		case <-done:
 			// Request completed
+			bifrostResp, bifrostErr = callResp, callErr
+			bifrostTime = time.Since(bifrostStart)
+			if key := lease.Key(); key != "" {
+				ctx.Response.Header.Set("X-Bifrost-Key", key)
+			}
 		case <-time.After(30 * time.Second):
 			// Request timed out
 			serverMetrics.mu.Lock()
@@ -270,15 +320,18 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 				jsonBytes, err := json.Marshal(metrics)
 				if err != nil {
 					fmt.Printf("Error marshaling bifrost_timings: %v\n", err)
+					stats.mu.Unlock()
 					return
 				}
 				// Unmarshal into RequestMetrics
 				var requestMetrics RequestMetrics
 				if err := json.Unmarshal(jsonBytes, &requestMetrics); err != nil {
 					fmt.Printf("Error unmarshaling bifrost_timings: %v\n", err)
+					stats.mu.Unlock()
 					return
 				}
-				stats.metrics = append(stats.metrics, requestMetrics)
+				stats.addRequestMetrics(requestMetrics)
+				requestTimings = &requestMetrics
 			}
 
 			// Process provider_metrics
@@ -287,6 +340,7 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 				jsonBytes, err := json.Marshal(metrics)
 				if err != nil {
 					fmt.Printf("Error marshaling provider_metrics: %v\n", err)
+					stats.mu.Unlock()
 					return
 				}
 
@@ -294,10 +348,12 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 				var providerMetrics ProviderMetrics
 				if err := json.Unmarshal(jsonBytes, &providerMetrics); err != nil {
 					fmt.Printf("Error unmarshaling provider_metrics: %v\n", err)
+					stats.mu.Unlock()
 					return
 				}
 
-				stats.providerMetrics = append(stats.providerMetrics, providerMetrics)
+				stats.addProviderMetrics(providerMetrics)
+				providerTimings = &providerMetrics
 			}
 		}
 
@@ -323,7 +379,7 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 		}
 
 		// Encode the response
-		if err := json.NewEncoder(ctx).Encode(bifrostResp); err != nil {
+		if err := EncodeResponse(ctx, bifrostResp); err != nil {
 			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
 			ctx.SetBodyString(fmt.Sprintf("Error encoding response: %v", err))
 			log.Printf("Error encoding response: %v", err)
@@ -331,7 +387,56 @@ func DebugHandler(client *bifrost.Bifrost) func(ctx *fasthttp.RequestCtx) {
 	}
 }
 
-func GetMetricsHandler() func(ctx *fasthttp.RequestCtx) {
+// serverTiming formats a Server-Timing header value with durations in milliseconds. The
+// Bifrost and provider breakdowns are only available when the core reports them.
+func serverTiming(handler time.Duration, bifrostTime time.Duration, request *RequestMetrics, provider *ProviderMetrics) string {
+	entries := []string{timingEntry("handler", handler)}
+	if bifrostTime > 0 {
+		entries = append(entries, timingEntry("bifrost", bifrostTime))
+	}
+	if request != nil {
+		entries = append(entries,
+			timingEntry("queue_wait", request.QueueWaitTime),
+			timingEntry("key_selection", request.KeySelectionTime),
+			timingEntry("plugin_pre", request.PluginPreTime),
+			timingEntry("plugin_post", request.PluginPostTime))
+	}
+	if provider != nil {
+		entries = append(entries,
+			timingEntry("message_formatting", provider.MessageFormatting),
+			timingEntry("json_marshaling", provider.JSONMarshaling),
+			timingEntry("request_setup", provider.RequestSetup),
+			timingEntry("http_request", provider.HTTPRequest),
+			timingEntry("response_parsing", provider.ResponseParsing))
+	}
+	return strings.Join(entries, ", ")
+}
+
+func timingEntry(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", name, float64(d)/float64(time.Millisecond))
+}
+
+// MetricsSources are the optional gateway features /metrics reports on. Features left nil are
+// disabled and reported as null.
+type MetricsSources struct {
+	Admission   *Admission
+	Pools       *ModelPools
+	Cache       *ResponseCache
+	Idempotency *IdempotencyKeys
+	VirtualKeys *VirtualKeys
+	Breaker     *CircuitBreaker
+	Validator   *RequestValidator
+	Anthropic   *AnthropicIngress
+	Keys        *KeyBalancer
+	IPLimiter   *IPLimiter
+	WorkerPool  *WorkerPool
+	Fallback    *Fallback
+	Realtime    *RealtimeProxy
+}
+
+// GetMetricsHandler serves server metrics as JSON, including the state of every enabled feature
+// in sources, e.g. admission queue state and per-model pool state
+func GetMetricsHandler(sources MetricsSources) func(ctx *fasthttp.RequestCtx) {
 	return func(ctx *fasthttp.RequestCtx) {
 		serverMetrics.mu.Lock()
 		defer serverMetrics.mu.Unlock()
@@ -341,9 +446,37 @@ func GetMetricsHandler() func(ctx *fasthttp.RequestCtx) {
 			"successful_requests": serverMetrics.SuccessfulRequests,
 			"dropped_requests":    serverMetrics.DroppedRequests,
 			"error_count":         serverMetrics.ErrorCount,
+			"retries":             serverMetrics.Retries,
+			"retried_requests":    serverMetrics.RetriedRequests,
+			"retries_exhausted":   serverMetrics.RetriesExhausted,
 			"last_error":          serverMetrics.LastError,
 			"last_error_time":     serverMetrics.LastErrorTime,
-			"current_time":        time.Now(),
+			"goroutines":          runtime.NumGoroutine(),
+			"in_flight":           sources.Admission.InFlight(),
+			"queue_depth":         sources.Admission.QueueDepth(),
+			"rejected_requests":   sources.Admission.Rejected(),
+			"model_pools":         sources.Pools.Stats(),
+			"cache":               sources.Cache.Stats(),
+			"idempotency":         sources.Idempotency.Stats(),
+			"virtual_keys":        sources.VirtualKeys.Stats(),
+			"circuit_breaker":     sources.Breaker.Stats(),
+			"validation":          sources.Validator.Stats(),
+			"anthropic":           sources.Anthropic.Stats(),
+			"keys":                sources.Keys.Stats(),
+			"ip_limits":           sources.IPLimiter.Stats(),
+			"worker_pool":         sources.WorkerPool.Stats(),
+			"fallback":            sources.Fallback.Stats(),
+			"realtime":            sources.Realtime.Stats(),
+			"body_streaming":      bodyStreaming.Stats(),
+			"minify":              responseMinifier.Stats(),
+			"warmup":              warmup,
+			"runtime":             CurrentRuntimeStats(),
+			"memory":              memoryWatch.Stats(),
+			"latency_ms": map[string]WindowPercentiles{
+				"10s": handlerLatencies.Percentiles(10 * time.Second),
+				"60s": handlerLatencies.Percentiles(time.Minute),
+			},
+			"current_time": time.Now(),
 		}
 
 		ctx.SetContentType("application/json")
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/maximhq/bifrost/core/schemas"
+)
+
+// ResponseEncoder writes a chat completion response as JSON followed by a newline
+type ResponseEncoder func(w io.Writer, resp *schemas.BifrostResponse) error
+
+// responseEncoders are the choices of -json-encoder. Encoding the response is one of the
+// largest costs per request, so they can be compared within one binary. easyjson is not
+// offered: its generated marshalers don't compile for Bifrost's response schema, and dereference
+// the embedded stream and non-stream choice pointers without checking them.
+var responseEncoders = map[string]ResponseEncoder{
+	"std": func(w io.Writer, resp *schemas.BifrostResponse) error {
+		return json.NewEncoder(w).Encode(resp)
+	},
+	"sonic": func(w io.Writer, resp *schemas.BifrostResponse) error {
+		return sonic.ConfigDefault.NewEncoder(w).Encode(resp)
+	},
+	"jsoniter": func(w io.Writer, resp *schemas.BifrostResponse) error {
+		return jsoniter.ConfigCompatibleWithStandardLibrary.NewEncoder(w).Encode(resp)
+	},
+}
+
+// responseEncoder encodes chat completion responses, set by SetJSONEncoder
+var responseEncoder = responseEncoders["std"]
+
+// JSONEncoderNames lists the -json-encoder choices
+func JSONEncoderNames() []string {
+	var names []string
+	for name := range responseEncoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SetJSONEncoder selects the encoder chat completion responses are written with
+func SetJSONEncoder(name string) error {
+	encoder, ok := responseEncoders[name]
+	if !ok {
+		return fmt.Errorf("unknown JSON encoder %q (choose %s)", name, strings.Join(JSONEncoderNames(), ", "))
+	}
+	responseEncoder = encoder
+	return nil
+}
+
+// EncodeResponse writes a chat completion response with the selected encoder, minified when
+// SetResponseMinification enabled it
+func EncodeResponse(w io.Writer, resp *schemas.BifrostResponse) error {
+	if responseMinifier != nil {
+		return responseMinifier.write(w, func(w io.Writer) error { return responseEncoder(w, resp) })
+	}
+	return responseEncoder(w, resp)
+}
@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// FallbackHeader marks a canned degraded response, with why it was served as its value
+const FallbackHeader = "X-Bifrost-Fallback"
+
+// Reasons a fallback response is served for
+const (
+	FallbackUpstreamError = "upstream_error"
+	FallbackTimeout       = "timeout"
+	FallbackCircuitOpen   = "circuit_open"
+)
+
+// defaultFallbackBody is the canned completion served without -fallback-body
+const defaultFallbackBody = `{"id":"chatcmpl-fallback","object":"chat.completion","created":0,"model":"fallback",` +
+	`"choices":[{"index":0,"message":{"role":"assistant","content":"The service is temporarily degraded. Please try again later."},"finish_reason":"stop"}],` +
+	`"usage":{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}}`
+
+// Fallback answers requests whose upstream call failed, timed out or was refused by the circuit
+// breaker with a canned degraded response instead of the error, marked with X-Bifrost-Fallback,
+// the way gateways advertising graceful degradation do. Benchmarked against an upstream injecting
+// errors, it shows the success rate clients see as the SLA floor and what serving it costs.
+// Responses below 500, rate limit refusals included, pass through untouched.
+type Fallback struct {
+	body   []byte
+	status int
+
+	requests       atomic.Int64
+	served         atomic.Int64
+	upstreamErrors atomic.Int64
+	timeouts       atomic.Int64
+	circuitOpen    atomic.Int64
+}
+
+// FallbackStats count the degraded responses, reported on /metrics
+type FallbackStats struct {
+	Requests       int64 `json:"requests"`
+	Served         int64 `json:"served"` // Failures answered with the fallback response
+	UpstreamErrors int64 `json:"upstream_errors"`
+	Timeouts       int64 `json:"timeouts"`
+	CircuitOpen    int64 `json:"circuit_open"`
+}
+
+// NewFallback serves the JSON response in bodyFile (the built-in degraded completion when empty)
+// with the given status
+func NewFallback(bodyFile string, status int) (*Fallback, error) {
+	if status < 200 || status > 599 {
+		return nil, fmt.Errorf("status must be an HTTP status, got %d", status)
+	}
+	body := []byte(defaultFallbackBody)
+	if bodyFile != "" {
+		var err error
+		if body, err = os.ReadFile(bodyFile); err != nil {
+			return nil, fmt.Errorf("failed to read fallback body: %v", err)
+		}
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("fallback body %s is not valid JSON", bodyFile)
+		}
+	}
+	return &Fallback{body: body, status: status}, nil
+}
+
+// fallbackReason classifies a failed response, "" for responses that aren't failures
+func fallbackReason(resp *fasthttp.Response) string {
+	status := resp.StatusCode()
+	switch {
+	case status == fasthttp.StatusGatewayTimeout || status == fasthttp.StatusRequestTimeout:
+		return FallbackTimeout
+	case status < 500:
+		return ""
+	}
+
+	// Only failures pay for lowercasing a copy of the body
+	body := bytes.ToLower(resp.Body())
+	switch {
+	case status == fasthttp.StatusServiceUnavailable && bytes.Contains(body, []byte("circuit_open")):
+		return FallbackCircuitOpen
+	case bytes.Contains(body, []byte("timeout")) || bytes.Contains(body, []byte("deadline exceeded")):
+		return FallbackTimeout
+	}
+	return FallbackUpstreamError
+}
+
+// Wrap replaces failed responses of next with the fallback response
+func (f *Fallback) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		f.requests.Add(1)
+		next(ctx)
+
+		reason := fallbackReason(&ctx.Response)
+		switch reason {
+		case "":
+			return
+		case FallbackTimeout:
+			f.timeouts.Add(1)
+		case FallbackCircuitOpen:
+			f.circuitOpen.Add(1)
+		default:
+			f.upstreamErrors.Add(1)
+		}
+		f.served.Add(1)
+
+		ctx.Response.Header.Del("Retry-After")
+		ctx.Response.Header.Set(FallbackHeader, reason)
+		ctx.SetStatusCode(f.status)
+		ctx.SetContentType("application/json")
+		ctx.SetBody(f.body)
+	}
+}
+
+// Stats returns the fallback counters, nil when fallback responses are disabled
+func (f *Fallback) Stats() *FallbackStats {
+	if f == nil {
+		return nil
+	}
+	return &FallbackStats{
+		Requests:       f.requests.Load(),
+		Served:         f.served.Load(),
+		UpstreamErrors: f.upstreamErrors.Load(),
+		Timeouts:       f.timeouts.Load(),
+		CircuitOpen:    f.circuitOpen.Load(),
+	}
+}
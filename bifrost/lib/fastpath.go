@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	bifrost "github.com/maximhq/bifrost/core"
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	chatRequestPool = sync.Pool{
+		New: func() interface{} {
+			return &ChatRequest{}
+		},
+	}
+	bifrostRequestPool = sync.Pool{
+		New: func() interface{} {
+			return &schemas.BifrostRequest{}
+		},
+	}
+	responseBufferPool = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+)
+
+func acquireChatRequest() *ChatRequest {
+	return chatRequestPool.Get().(*ChatRequest)
+}
+
+func releaseChatRequest(req *ChatRequest) {
+	// Zero every message so the next decode does not inherit stale fields
+	req.Reset()
+	chatRequestPool.Put(req)
+}
+
+func acquireBifrostRequest() *schemas.BifrostRequest {
+	return bifrostRequestPool.Get().(*schemas.BifrostRequest)
+}
+
+func releaseBifrostRequest(req *schemas.BifrostRequest) {
+	*req = schemas.BifrostRequest{}
+	bifrostRequestPool.Put(req)
+}
+
+// FastHandler serves chat completions using pooled request objects and sonic for JSON
+// decoding, encoding responses with -json-encoder (sonic by default). It is functionally
+// equivalent to the default handler.
+func FastHandler(client *bifrost.Bifrost, routes *RoutingTable) func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		chatReq := acquireChatRequest()
+		defer releaseChatRequest(chatReq)
+
+		if err := decodeBodySonic(ctx, chatReq); err != nil {
+			WriteBodyError(ctx, err)
+			return
+		}
+
+		if len(chatReq.Messages) == 0 {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString("Messages array is required")
+			return
+		}
+		messages, params, err := chatReq.BifrostInput()
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+
+		bifrostReq := acquireBifrostRequest()
+		defer releaseBifrostRequest(bifrostReq)
+
+		bifrostReq.Provider, bifrostReq.Model = routes.ResolveRequest(ctx, chatReq.Model)
+		bifrostReq.Input.ChatCompletionInput = messages
+		bifrostReq.Params = params
+
+		bifrostStart := time.Now()
+		resp, bifrostErr := client.ChatCompletionRequest(ctx, bifrostReq)
+		bifrostTime := time.Since(bifrostStart)
+		if bifrostErr != nil {
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(fmt.Sprintf("error: %v", bifrostErr))
+			RecordTimings(time.Since(start), bifrostTime, nil)
+			return
+		}
+
+		buf := responseBufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer responseBufferPool.Put(buf)
+
+		if err := EncodeResponse(buf, resp); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(fmt.Sprintf("Error encoding response: %v", err))
+			return
+		}
+
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType("application/json")
+		// SetBody copies the buffer, so it is safe to return it to the pool afterwards
+		ctx.SetBody(buf.Bytes())
+		RecordTimings(time.Since(start), bifrostTime, resp)
+	}
+}
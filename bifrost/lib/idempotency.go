@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// idempotencyKeyHeader names the client-chosen key a request and its retries share
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyScope is the hash of a key together with the path and credentials it was sent
+// with, so tenants sharing a key never see each other's responses
+type idempotencyScope [sha256.Size]byte
+
+// idempotentCall is a request served under a key: in flight until done is closed, then its
+// response is replayed to duplicates until it expires
+type idempotentCall struct {
+	done    chan struct{}
+	body    cacheKey // Normalized request body, which duplicates must match
+	resp    cachedResponse
+	expires time.Time
+}
+
+// IdempotencyKeys deduplicates requests carrying an Idempotency-Key header. Duplicates of a
+// request still in flight wait for it and share its single upstream call; duplicates arriving
+// after it completed get its response replayed for a TTL. Responses with 429 or 5xx statuses
+// are not kept, so retrying a failed request does call the upstream again. A key reused with a
+// different body is answered with 422, like OpenAI and Stripe do. Requests without the header
+// pass through untouched.
+type IdempotencyKeys struct {
+	ttl     time.Duration
+	maxKeys int
+
+	mu    sync.Mutex
+	calls map[idempotencyScope]*idempotentCall
+	order []idempotencyScope // Completion order, oldest first, for eviction
+
+	requests  atomic.Int64
+	misses    atomic.Int64
+	coalesced atomic.Int64
+	replayed  atomic.Int64
+	conflicts atomic.Int64
+	evictions atomic.Int64
+}
+
+// IdempotencyStats is the state of idempotency key deduplication, reported on /metrics
+type IdempotencyStats struct {
+	Keys      int   `json:"keys"`      // Keys in flight or kept for replay
+	Requests  int64 `json:"requests"`  // Requests carrying an Idempotency-Key
+	Misses    int64 `json:"misses"`    // Requests that called the upstream
+	Coalesced int64 `json:"coalesced"` // Duplicates that waited for an in-flight request with their key
+	Replayed  int64 `json:"replayed"`  // Duplicates answered with a completed request's response
+	Conflicts int64 `json:"conflicts"` // Keys reused with a different body, answered with 422
+	Evictions int64 `json:"evictions"`
+}
+
+// NewIdempotencyKeys replays completed responses for ttl, keeping at most maxKeys of them
+func NewIdempotencyKeys(ttl time.Duration, maxKeys int) *IdempotencyKeys {
+	return &IdempotencyKeys{
+		ttl:     ttl,
+		maxKeys: max(maxKeys, 1),
+		calls:   make(map[idempotencyScope]*idempotentCall),
+	}
+}
+
+// scopeOf hashes the key with the request's path and API key
+func scopeOf(ctx *fasthttp.RequestCtx, key []byte) idempotencyScope {
+	h := sha256.New()
+	for _, part := range [][]byte{ctx.Path(), []byte(credential(ctx)), key} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	var scope idempotencyScope
+	h.Sum(scope[:0])
+	return scope
+}
+
+// bodyKey identifies the request body, normalized when it is JSON
+func bodyKey(body []byte) cacheKey {
+	if key, ok := normalizedKey(body); ok {
+		return key
+	}
+	return sha256.Sum256(body)
+}
+
+// Wrap serves duplicates of requests with the same Idempotency-Key, calling next once per key
+func (k *IdempotencyKeys) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		key := ctx.Request.Header.Peek(idempotencyKeyHeader)
+		if len(key) == 0 {
+			next(ctx)
+			return
+		}
+		k.requests.Add(1)
+		scope := scopeOf(ctx, key)
+		body := bodyKey(ctx.PostBody())
+
+		k.mu.Lock()
+		if call, found := k.calls[scope]; found && (call.expires.IsZero() || time.Now().Before(call.expires)) {
+			k.mu.Unlock()
+			if call.body != body {
+				k.conflicts.Add(1)
+				writeIdempotencyConflict(ctx)
+				return
+			}
+			select {
+			case <-call.done:
+				k.replayed.Add(1)
+			default:
+				k.coalesced.Add(1)
+				<-call.done
+			}
+			writeReplayed(ctx, call.resp)
+			return
+		}
+		if _, found := k.calls[scope]; found {
+			k.forget(scope)
+		}
+		call := &idempotentCall{done: make(chan struct{}), body: body, resp: abandonedResponse}
+		k.calls[scope] = call
+		k.mu.Unlock()
+
+		// Release the duplicates even if next panics; abandonedResponse is retryable, so the key is
+		// dropped and a retry calls the upstream again
+		defer func() {
+			k.mu.Lock()
+			if retryable(call.resp.status) {
+				delete(k.calls, scope)
+			} else {
+				call.expires = time.Now().Add(k.ttl)
+				k.keep(scope)
+			}
+			k.mu.Unlock()
+			close(call.done)
+		}()
+
+		k.misses.Add(1)
+		next(ctx)
+
+		// Copy the response, since fasthttp reuses its buffers once the handler returns
+		call.resp = cachedResponse{
+			status:      ctx.Response.StatusCode(),
+			contentType: append([]byte(nil), ctx.Response.Header.ContentType()...),
+			body:        append([]byte(nil), ctx.Response.Body()...),
+		}
+	}
+}
+
+// retryable reports whether a response is a failure the client should be able to retry
+func retryable(status int) bool {
+	return status == fasthttp.StatusTooManyRequests || status >= 500
+}
+
+// keep queues a completed key for eviction, dropping expired keys and the oldest ones beyond
+// maxKeys. The caller holds k.mu.
+func (k *IdempotencyKeys) keep(scope idempotencyScope) {
+	k.order = append(k.order, scope)
+
+	now := time.Now()
+	for len(k.order) > 0 {
+		oldest := k.order[0]
+		call, found := k.calls[oldest]
+		expired := !found || call.expires.IsZero() || !now.Before(call.expires)
+		if !expired && len(k.order) <= k.maxKeys {
+			break
+		}
+		k.order = k.order[1:]
+		// A key that expired may have been claimed again by a request still in flight
+		if found && !call.expires.IsZero() {
+			delete(k.calls, oldest)
+			if !expired {
+				k.evictions.Add(1)
+			}
+		}
+	}
+}
+
+// forget drops the eviction queue entry of an expired key about to be claimed again, so the key
+// isn't counted twice once the new call is kept. Keys share one TTL and expire in completion
+// order, so the entry is near the front. The caller holds k.mu.
+func (k *IdempotencyKeys) forget(scope idempotencyScope) {
+	if i := slices.Index(k.order, scope); i >= 0 {
+		k.order = slices.Delete(k.order, i, i+1)
+	}
+}
+
+// writeReplayed answers a duplicate with the response of the request that owned its key
+func writeReplayed(ctx *fasthttp.RequestCtx, resp cachedResponse) {
+	ctx.SetStatusCode(resp.status)
+	ctx.Response.Header.SetContentTypeBytes(resp.contentType)
+	ctx.Response.Header.Set("Idempotent-Replayed", "true")
+	ctx.SetBody(resp.body)
+}
+
+// writeIdempotencyConflict rejects a key reused with a different body
+func writeIdempotencyConflict(ctx *fasthttp.RequestCtx) {
+	writeOpenAIError(ctx, fasthttp.StatusUnprocessableEntity, "idempotency_key_reused",
+		"Keys for idempotent requests can only be used with the same parameters they were first used with.")
+}
+
+// Stats returns the deduplication counters, nil when idempotency keys are disabled
+func (k *IdempotencyKeys) Stats() *IdempotencyStats {
+	if k == nil {
+		return nil
+	}
+	k.mu.Lock()
+	keys := len(k.calls)
+	k.mu.Unlock()
+
+	return &IdempotencyStats{
+		Keys:      keys,
+		Requests:  k.requests.Load(),
+		Misses:    k.misses.Load(),
+		Coalesced: k.coalesced.Load(),
+		Replayed:  k.replayed.Load(),
+		Conflicts: k.conflicts.Load(),
+		Evictions: k.evictions.Load(),
+	}
+}
@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ipSweepMin is the client count below which idle clients are never swept
+const ipSweepMin = 1024
+
+// IPLimitSettings configures per client IP limits; a zero limit is disabled
+type IPLimitSettings struct {
+	Concurrency int     // Requests a client may have in flight
+	RPS         float64 // Requests per second refilled into each client's token bucket
+	Burst       int     // Requests a client can send at once, defaults to RPS rounded up
+	RetryAfter  time.Duration
+}
+
+// ipClient is the state kept for one client IP
+type ipClient struct {
+	inFlight  int
+	available float64
+	updated   time.Time
+}
+
+// IPLimitStats are reported on /metrics under "ip_limits"
+type IPLimitStats struct {
+	Clients             int     `json:"clients"` // Client IPs currently tracked
+	InFlight            int64   `json:"in_flight"`
+	Allowed             int64   `json:"allowed"`
+	ConcurrencyRejected int64   `json:"concurrency_rejected"`
+	RateRejected        int64   `json:"rate_rejected"`
+	MeanCheckNs         float64 `json:"mean_check_ns"` // Time spent checking and updating the limits per request
+}
+
+// IPLimiter limits the requests in flight and the request rate of every client IP, the per-client
+// protection other gateways enable by default. The state of all clients sits behind one mutex,
+// and idle clients are swept as new ones arrive, so memory stays bounded under IP churn.
+type IPLimiter struct {
+	concurrency int
+	rps         float64
+	burst       float64
+	retryAfter  string
+
+	mu        sync.Mutex
+	clients   map[string]*ipClient
+	nextSweep int
+
+	inFlight            atomic.Int64
+	allowed             atomic.Int64
+	concurrencyRejected atomic.Int64
+	rateRejected        atomic.Int64
+	checkNs             atomic.Int64
+}
+
+// NewIPLimiter creates a limiter, or returns an error for negative limits
+func NewIPLimiter(settings IPLimitSettings) (*IPLimiter, error) {
+	if settings.Concurrency < 0 || settings.RPS < 0 || settings.Burst < 0 {
+		return nil, fmt.Errorf("per-IP limits must not be negative")
+	}
+	burst := float64(settings.Burst)
+	if burst == 0 {
+		burst = max(float64(int(settings.RPS+0.999)), 1)
+	}
+	return &IPLimiter{
+		concurrency: settings.Concurrency,
+		rps:         settings.RPS,
+		burst:       burst,
+		retryAfter:  strconv.Itoa(max(int(settings.RetryAfter.Round(time.Second)/time.Second), 1)),
+		clients:     make(map[string]*ipClient),
+		nextSweep:   ipSweepMin,
+	}, nil
+}
+
+// acquire admits a request from ip, or returns the error code it is rejected with
+func (l *IPLimiter) acquire(ip string, now time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, ok := l.clients[ip]
+	if !ok {
+		if len(l.clients) >= l.nextSweep {
+			l.sweep(now)
+		}
+		client = &ipClient{available: l.burst, updated: now}
+		l.clients[ip] = client
+	}
+
+	if l.concurrency > 0 && client.inFlight >= l.concurrency {
+		return "concurrency_limit_exceeded"
+	}
+	if l.rps > 0 {
+		client.available = min(l.burst, client.available+now.Sub(client.updated).Seconds()*l.rps)
+		client.updated = now
+		if client.available < 1 {
+			return "rate_limit_exceeded"
+		}
+		client.available--
+	}
+	client.inFlight++
+	return ""
+}
+
+// release ends a request admitted by acquire
+func (l *IPLimiter) release(ip string) {
+	l.mu.Lock()
+	if client, ok := l.clients[ip]; ok {
+		client.inFlight--
+	}
+	l.mu.Unlock()
+}
+
+// sweep forgets clients with nothing in flight and a full bucket, which behave exactly like new
+// ones. The caller holds l.mu.
+func (l *IPLimiter) sweep(now time.Time) {
+	for ip, client := range l.clients {
+		refilled := l.rps == 0 || client.available+now.Sub(client.updated).Seconds()*l.rps >= l.burst
+		if client.inFlight == 0 && refilled {
+			delete(l.clients, ip)
+		}
+	}
+	l.nextSweep = max(2*len(l.clients), ipSweepMin)
+}
+
+// clientIP returns the IP of the client that sent the request. Behind the HTTP/2 frontend every
+// request arrives over an in-memory connection, so the frontend's X-Forwarded-For is used.
+func clientIP(ctx *fasthttp.RequestCtx) string {
+	if name, _ := frontend.Load().(string); name != "" {
+		if ip := ctx.Request.Header.Peek("X-Forwarded-For"); len(ip) > 0 {
+			return string(ip)
+		}
+	}
+	return ctx.RemoteIP().String()
+}
+
+// Wrap rejects requests over their client IP's concurrency or rate limit with 429. Clients on a
+// unix socket have no IP and share one set of limits.
+func (l *IPLimiter) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		ip := clientIP(ctx)
+		code := l.acquire(ip, start)
+		l.checkNs.Add(int64(time.Since(start)))
+
+		switch code {
+		case "concurrency_limit_exceeded":
+			l.concurrencyRejected.Add(1)
+			ctx.Response.Header.Set("Retry-After", l.retryAfter)
+			writeOpenAIError(ctx, fasthttp.StatusTooManyRequests, code,
+				fmt.Sprintf("Client %s already has %d requests in flight", ip, l.concurrency))
+			return
+		case "rate_limit_exceeded":
+			l.rateRejected.Add(1)
+			ctx.Response.Header.Set("Retry-After", l.retryAfter)
+			writeOpenAIError(ctx, fasthttp.StatusTooManyRequests, code,
+				fmt.Sprintf("Client %s exceeded its rate limit of %g requests per second", ip, l.rps))
+			return
+		}
+
+		l.allowed.Add(1)
+		l.inFlight.Add(1)
+		defer func() {
+			release := time.Now()
+			l.release(ip)
+			l.checkNs.Add(int64(time.Since(release)))
+			l.inFlight.Add(-1)
+		}()
+		next(ctx)
+	}
+}
+
+// Stats returns the limiter's counters, nil when per-IP limits are disabled
+func (l *IPLimiter) Stats() *IPLimitStats {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	clients := len(l.clients)
+	l.mu.Unlock()
+
+	stats := &IPLimitStats{
+		Clients:             clients,
+		InFlight:            l.inFlight.Load(),
+		Allowed:             l.allowed.Load(),
+		ConcurrencyRejected: l.concurrencyRejected.Load(),
+		RateRejected:        l.rateRejected.Load(),
+	}
+	if checked := stats.Allowed + stats.ConcurrencyRejected + stats.RateRejected; checked > 0 {
+		stats.MeanCheckNs = float64(l.checkNs.Load()) / float64(checked)
+	}
+	return stats
+}
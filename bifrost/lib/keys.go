@@ -0,0 +1,282 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maximhq/bifrost/core/schemas"
+)
+
+// KeyStrategy picks which of several provider keys serves a request
+type KeyStrategy string
+
+const (
+	KeyWeighted      KeyStrategy = "weighted"        // Random, in proportion to each key's weight
+	KeyRoundRobin    KeyStrategy = "round-robin"     // Each key in turn, ignoring weights
+	KeyLeastInFlight KeyStrategy = "least-in-flight" // The key with the fewest requests in flight per unit of weight
+)
+
+// ParseKeyStrategy validates a -key-strategy value
+func ParseKeyStrategy(s string) (KeyStrategy, error) {
+	switch strategy := KeyStrategy(s); strategy {
+	case KeyWeighted, KeyRoundRobin, KeyLeastInFlight:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown key strategy %q (use weighted, round-robin or least-in-flight)", s)
+}
+
+// WeightedKey is a provider key and its share of traffic
+type WeightedKey struct {
+	Value  string
+	Weight float64
+}
+
+// ParseKeys parses a comma separated list of keys, each optionally followed by :weight,
+// e.g. "sk-a:3,sk-b". Weights default to 1.
+func ParseKeys(list string) ([]WeightedKey, error) {
+	var keys []WeightedKey
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key := WeightedKey{Value: entry, Weight: 1}
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			weight, err := strconv.ParseFloat(entry[i+1:], 64)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q: must be a positive number", maskKey(entry[:i]))
+			}
+			key = WeightedKey{Value: entry[:i], Weight: weight}
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys given")
+	}
+	return keys, nil
+}
+
+// maskKey shortens a key to something safe to print, e.g. sk-...a1b2
+func maskKey(key string) string {
+	if len(key) <= 10 {
+		return "..."
+	}
+	return key[:3] + "..." + key[len(key)-4:]
+}
+
+type keyLeaseKey struct{}
+
+// keyLease follows a request from PreHook through key selection to PostHook. The debug handler
+// creates it up front as a fasthttp user value so it can report the key that served the request.
+type keyLease struct {
+	active bool // Set by PreHook; without it the key is recorded but not counted in flight
+	key    *balancedKey
+}
+
+// Key returns the masked key that served the request, or "" if none was selected
+func (l *keyLease) Key() string {
+	if l == nil || l.key == nil {
+		return ""
+	}
+	return l.key.label
+}
+
+// balancedKey is a key and its counters. Counters are kept per key value, so they survive
+// reloads that keep the key.
+type balancedKey struct {
+	value  string
+	label  string
+	weight float64
+
+	selected atomic.Int64
+	inFlight atomic.Int64
+	errors   atomic.Int64
+}
+
+// KeyStats is the balance of requests over one key
+type KeyStats struct {
+	Key      string  `json:"key"` // Masked
+	Weight   float64 `json:"weight"`
+	Selected int64   `json:"selected"`
+	InFlight int64   `json:"in_flight"`
+	Errors   int64   `json:"errors"`
+}
+
+// KeyBalancerStats is reported on /metrics under "keys"
+type KeyBalancerStats struct {
+	Strategy     KeyStrategy `json:"strategy"`
+	Selections   int64       `json:"selections"`
+	MeanSelectNs float64     `json:"mean_select_ns"` // Time spent picking a key, on top of Bifrost's own key selection
+	Keys         []KeyStats  `json:"keys"`
+}
+
+// KeyBalancer spreads requests over a provider's keys with a KeyStrategy. The account hands
+// Bifrost only the key it picks, and the balancer also runs as a Bifrost plugin so it knows when
+// each request finishes, which least-in-flight needs.
+type KeyBalancer struct {
+	strategy KeyStrategy
+
+	mu    sync.RWMutex
+	keys  []*balancedKey
+	known map[string]*balancedKey
+
+	next       atomic.Uint64 // Round robin position, also used to break least-in-flight ties
+	selections atomic.Int64
+	selectNs   atomic.Int64
+}
+
+// NewKeyBalancer creates a balancer; an empty strategy means weighted
+func NewKeyBalancer(strategy KeyStrategy) *KeyBalancer {
+	if strategy == "" {
+		strategy = KeyWeighted
+	}
+	return &KeyBalancer{strategy: strategy, known: make(map[string]*balancedKey)}
+}
+
+// SetKeys swaps in a new key set, keeping the counters of keys that stay
+func (b *KeyBalancer) SetKeys(keys []WeightedKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.keys = b.keys[:0]
+	for _, k := range keys {
+		key, ok := b.known[k.Value]
+		if !ok {
+			key = &balancedKey{value: k.Value, label: maskKey(k.Value)}
+			b.known[k.Value] = key
+		}
+		key.weight = k.Weight
+		b.keys = append(b.keys, key)
+	}
+}
+
+// Len returns the number of keys
+func (b *KeyBalancer) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.keys)
+}
+
+// pick selects a key for the request carried by ctx and records it on the request's lease
+func (b *KeyBalancer) pick(ctx context.Context) string {
+	start := time.Now()
+	b.mu.RLock()
+	var key *balancedKey
+	switch {
+	case len(b.keys) == 0:
+		b.mu.RUnlock()
+		return ""
+	case len(b.keys) == 1:
+		key = b.keys[0]
+	case b.strategy == KeyRoundRobin:
+		key = b.keys[b.next.Add(1)%uint64(len(b.keys))]
+	case b.strategy == KeyLeastInFlight:
+		key = b.leastInFlight()
+	default:
+		key = b.weighted()
+	}
+	b.mu.RUnlock()
+
+	key.selected.Add(1)
+	if lease, ok := ctx.Value(keyLeaseKey{}).(*keyLease); ok {
+		lease.key = key
+		if lease.active {
+			key.inFlight.Add(1)
+		}
+	}
+	b.selections.Add(1)
+	b.selectNs.Add(int64(time.Since(start)))
+	return key.value
+}
+
+// weighted picks a key at random in proportion to its weight; callers hold mu
+func (b *KeyBalancer) weighted() *balancedKey {
+	var total float64
+	for _, key := range b.keys {
+		total += key.weight
+	}
+	r := rand.Float64() * total
+	for _, key := range b.keys {
+		if r < key.weight {
+			return key
+		}
+		r -= key.weight
+	}
+	return b.keys[len(b.keys)-1]
+}
+
+// leastInFlight picks the key with the fewest requests in flight relative to its weight,
+// starting the scan at a rotating position so ties don't all land on the first key; callers hold mu
+func (b *KeyBalancer) leastInFlight() *balancedKey {
+	offset := b.next.Add(1)
+	var best *balancedKey
+	var bestLoad float64
+	for i := range b.keys {
+		key := b.keys[(offset+uint64(i))%uint64(len(b.keys))]
+		load := float64(key.inFlight.Load()) / key.weight
+		if best == nil || load < bestLoad {
+			best, bestLoad = key, load
+		}
+	}
+	return best
+}
+
+func (b *KeyBalancer) GetName() string {
+	return "key-balancer"
+}
+
+func (b *KeyBalancer) PreHook(ctx *context.Context, req *schemas.BifrostRequest) (*schemas.BifrostRequest, *schemas.PluginShortCircuit, error) {
+	if lease, ok := (*ctx).Value(keyLeaseKey{}).(*keyLease); ok {
+		lease.active = true
+		return req, nil, nil
+	}
+	*ctx = context.WithValue(*ctx, keyLeaseKey{}, &keyLease{active: true})
+	return req, nil, nil
+}
+
+func (b *KeyBalancer) PostHook(ctx *context.Context, result *schemas.BifrostResponse, bifrostErr *schemas.BifrostError) (*schemas.BifrostResponse, *schemas.BifrostError, error) {
+	lease, ok := (*ctx).Value(keyLeaseKey{}).(*keyLease)
+	if !ok || lease.key == nil || !lease.active {
+		return result, bifrostErr, nil
+	}
+	lease.active = false
+	lease.key.inFlight.Add(-1)
+	if bifrostErr != nil {
+		lease.key.errors.Add(1)
+	}
+	return result, bifrostErr, nil
+}
+
+func (b *KeyBalancer) Cleanup() error {
+	return nil
+}
+
+// Stats returns the balance over every current key, or nil when there is no balancer
+func (b *KeyBalancer) Stats() *KeyBalancerStats {
+	if b == nil {
+		return nil
+	}
+	stats := &KeyBalancerStats{Strategy: b.strategy, Selections: b.selections.Load()}
+	if stats.Selections > 0 {
+		stats.MeanSelectNs = float64(b.selectNs.Load()) / float64(stats.Selections)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, key := range b.keys {
+		stats.Keys = append(stats.Keys, KeyStats{
+			Key:      key.label,
+			Weight:   key.weight,
+			Selected: key.selected.Load(),
+			InFlight: key.inFlight.Load(),
+			Errors:   key.errors.Load(),
+		})
+	}
+	return stats
+}
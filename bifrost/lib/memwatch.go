@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryWatch samples the gateway's own RSS and heap on a ticker, keeping the peaks, and raises
+// an alarm when RSS crosses a high-water mark: a warning is logged and a heap profile written,
+// so a leak can be chased from the profile taken while it was happening. The alarm re-arms once
+// RSS falls back below 90% of the mark. Without an RSS reading (off Linux) the heap in use is
+// compared instead.
+type MemoryWatch struct {
+	highWater  int64 // Bytes, 0 for no alarm
+	profileDir string
+
+	mu      sync.Mutex
+	stats   MemoryStats
+	alarmed bool
+}
+
+// MemoryStats is the gateway's own memory use, reported under memory on /metrics
+type MemoryStats struct {
+	RSS           int64     `json:"rss_bytes"` // 0 when the platform doesn't report it
+	PeakRSS       int64     `json:"peak_rss_bytes"`
+	HeapAlloc     int64     `json:"heap_alloc_bytes"`
+	PeakHeapAlloc int64     `json:"peak_heap_alloc_bytes"`
+	HeapInuse     int64     `json:"heap_inuse_bytes"`
+	HeapSys       int64     `json:"heap_sys_bytes"`
+	NumGC         uint32    `json:"num_gc"`
+	GCPauseTotal  float64   `json:"gc_pause_total_ms"`
+	SampledAt     time.Time `json:"sampled_at"`
+
+	HighWater   int64     `json:"high_water_bytes,omitempty"`
+	Alarms      int       `json:"alarms"`
+	LastAlarm   time.Time `json:"last_alarm,omitempty"`
+	LastProfile string    `json:"last_profile,omitempty"` // Heap profile written by the last alarm
+}
+
+// memoryWatch is nil unless StartMemoryWatch was called
+var memoryWatch *MemoryWatch
+
+// StartMemoryWatch samples memory every interval, alarming above highWater bytes (0 disables
+// the alarm) with heap profiles written to profileDir
+func StartMemoryWatch(interval time.Duration, highWater int64, profileDir string) (*MemoryWatch, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("sampling interval must be positive")
+	}
+	if highWater > 0 {
+		if err := os.MkdirAll(profileDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create heap profile directory: %v", err)
+		}
+	}
+	w := &MemoryWatch{highWater: highWater, profileDir: profileDir}
+	w.sample()
+	go func() {
+		for range time.Tick(interval) {
+			w.sample()
+		}
+	}()
+	memoryWatch = w
+	return w, nil
+}
+
+func (w *MemoryWatch) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	rss := readRSS()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := &w.stats
+	s.RSS = rss
+	s.PeakRSS = max(s.PeakRSS, rss)
+	s.HeapAlloc = int64(m.HeapAlloc)
+	s.PeakHeapAlloc = max(s.PeakHeapAlloc, s.HeapAlloc)
+	s.HeapInuse = int64(m.HeapInuse)
+	s.HeapSys = int64(m.HeapSys)
+	s.NumGC = m.NumGC
+	s.GCPauseTotal = float64(m.PauseTotalNs) / float64(time.Millisecond)
+	s.SampledAt = time.Now()
+
+	if w.highWater == 0 {
+		return
+	}
+	used, measure := rss, "RSS"
+	if rss == 0 {
+		used, measure = s.HeapInuse, "heap in use"
+	}
+	switch {
+	case !w.alarmed && used > w.highWater:
+		w.alarmed = true
+		s.Alarms++
+		s.LastAlarm = s.SampledAt
+		s.LastProfile = w.writeHeapProfile()
+		log.Printf("Warning: Memory high-water mark crossed: %s %d MiB above %d MiB (heap %d MiB in use), heap profile %s",
+			measure, used>>20, w.highWater>>20, s.HeapInuse>>20, s.LastProfile)
+	case w.alarmed && used < w.highWater/10*9:
+		w.alarmed = false
+	}
+}
+
+// writeHeapProfile dumps the heap profile, returning its path or "" if it could not be written
+func (w *MemoryWatch) writeHeapProfile() string {
+	path := filepath.Join(w.profileDir, fmt.Sprintf("gateway-heap-%d-%s.pprof", os.Getpid(), time.Now().Format("20060102-150405")))
+	file, err := os.Create(path)
+	if err != nil {
+		log.Printf("Warning: Could not create heap profile: %v", err)
+		return ""
+	}
+	defer file.Close()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		log.Printf("Warning: Could not write heap profile: %v", err)
+		return ""
+	}
+	return path
+}
+
+// readRSS returns the process's resident set size from /proc, 0 where it isn't available
+func readRSS() int64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * int64(os.Getpagesize())
+}
+
+// Stats returns the last sample, nil when memory isn't watched
+func (w *MemoryWatch) Stats() *MemoryStats {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.HighWater = w.highWater
+	return &stats
+}
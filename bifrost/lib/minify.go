@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Minification modes of -minify-response
+const (
+	MinifyMetadata = "metadata" // Drop Bifrost's extra_fields
+	MinifyAll      = "all"      // Also drop null, {} and [] fields everywhere
+)
+
+// ResponseMinifier strips what OpenAI clients don't need from chat completion responses after
+// they are encoded: Bifrost's provider-internal extra_fields (provider, model_params, latency,
+// raw_response) and, in MinifyAll mode, optional fields that are null or empty. The response is
+// re-tokenized to do so, which costs CPU for the bytes it saves; Stats reports both, so the
+// trade-off can be benchmarked. Key order and number literals are kept; null elements of arrays
+// are kept, since their position matters.
+type ResponseMinifier struct {
+	mode string
+
+	responses   atomic.Int64
+	bytesBefore atomic.Int64
+	bytesAfter  atomic.Int64
+	errors      atomic.Int64
+	nanos       atomic.Int64 // Spent minifying
+}
+
+// MinifyStats are the bytes saved by response minification and the time it took, on /metrics
+type MinifyStats struct {
+	Mode         string  `json:"mode"`
+	Responses    int64   `json:"responses"`
+	BytesBefore  int64   `json:"bytes_before"`
+	BytesAfter   int64   `json:"bytes_after"`
+	BytesSaved   int64   `json:"bytes_saved"`
+	SavedPercent float64 `json:"saved_percent"`
+	MeanMinifyUs float64 `json:"mean_minify_us"`
+	Errors       int64   `json:"errors"` // Responses sent unminified because they could not be parsed
+}
+
+// responseMinifier is nil unless SetResponseMinification enabled it
+var responseMinifier *ResponseMinifier
+
+// SetResponseMinification minifies every encoded chat completion in the given mode, "" to disable
+func SetResponseMinification(mode string) error {
+	switch mode {
+	case "":
+		responseMinifier = nil
+	case MinifyMetadata, MinifyAll:
+		responseMinifier = &ResponseMinifier{mode: mode}
+	default:
+		return fmt.Errorf("unknown mode %q (choose %s or %s)", mode, MinifyMetadata, MinifyAll)
+	}
+	return nil
+}
+
+// minifyBufferPool holds the buffers responses are encoded into before minification
+var minifyBufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// write encodes the response with encode and writes it to w minified
+func (m *ResponseMinifier) write(w io.Writer, encode func(io.Writer) error) error {
+	encoded := minifyBufferPool.Get().(*bytes.Buffer)
+	minified := minifyBufferPool.Get().(*bytes.Buffer)
+	encoded.Reset()
+	minified.Reset()
+	defer minifyBufferPool.Put(encoded)
+	defer minifyBufferPool.Put(minified)
+
+	if err := encode(encoded); err != nil {
+		return err
+	}
+	start := time.Now()
+	dec := json.NewDecoder(bytes.NewReader(encoded.Bytes()))
+	dec.UseNumber()
+	if _, err := m.value(dec, minified, 0, false); err != nil {
+		m.errors.Add(1)
+		_, err = w.Write(encoded.Bytes())
+		return err
+	}
+	minified.WriteByte('\n')
+	m.nanos.Add(int64(time.Since(start)))
+	m.responses.Add(1)
+	m.bytesBefore.Add(int64(encoded.Len()))
+	m.bytesAfter.Add(int64(minified.Len()))
+	_, err := w.Write(minified.Bytes())
+	return err
+}
+
+// value copies the next JSON value from dec to out, leaving out empty values when droppable,
+// and reports whether it was written
+func (m *ResponseMinifier) value(dec *json.Decoder, out *bytes.Buffer, depth int, droppable bool) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	droppable = droppable && m.mode == MinifyAll
+
+	switch t := tok.(type) {
+	case json.Delim:
+		start := out.Len()
+		out.WriteRune(rune(t))
+		members := 0
+		for dec.More() {
+			memberStart := out.Len()
+			if members > 0 {
+				out.WriteByte(',')
+			}
+			if t == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return false, err
+				}
+				if depth == 0 && key == "extra_fields" {
+					var skipped json.RawMessage
+					if err := dec.Decode(&skipped); err != nil {
+						return false, err
+					}
+					out.Truncate(memberStart)
+					continue
+				}
+				quoted, _ := json.Marshal(key)
+				out.Write(quoted)
+				out.WriteByte(':')
+			}
+			// Array elements are kept even when empty, their index is meaningful
+			written, err := m.value(dec, out, depth+1, t == '{')
+			if err != nil {
+				return false, err
+			}
+			if !written {
+				out.Truncate(memberStart)
+				continue
+			}
+			members++
+		}
+		if _, err := dec.Token(); err != nil {
+			return false, err
+		}
+		if members == 0 && droppable {
+			out.Truncate(start)
+			return false, nil
+		}
+		if t == '{' {
+			out.WriteByte('}')
+		} else {
+			out.WriteByte(']')
+		}
+	case nil:
+		if droppable {
+			return false, nil
+		}
+		out.WriteString("null")
+	case string:
+		quoted, _ := json.Marshal(t)
+		out.Write(quoted)
+	case json.Number:
+		out.WriteString(t.String())
+	case bool:
+		if t {
+			out.WriteString("true")
+		} else {
+			out.WriteString("false")
+		}
+	}
+	return true, nil
+}
+
+// Stats returns the minification counters, nil when responses aren't minified
+func (m *ResponseMinifier) Stats() *MinifyStats {
+	if m == nil {
+		return nil
+	}
+	stats := &MinifyStats{
+		Mode:        m.mode,
+		Responses:   m.responses.Load(),
+		BytesBefore: m.bytesBefore.Load(),
+		BytesAfter:  m.bytesAfter.Load(),
+		Errors:      m.errors.Load(),
+	}
+	stats.BytesSaved = stats.BytesBefore - stats.BytesAfter
+	if stats.BytesBefore > 0 {
+		stats.SavedPercent = 100 * float64(stats.BytesSaved) / float64(stats.BytesBefore)
+	}
+	if stats.Responses > 0 {
+		stats.MeanMinifyUs = float64(m.nanos.Load()) / float64(stats.Responses) / float64(time.Microsecond)
+	}
+	return stats
+}
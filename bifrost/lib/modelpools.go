@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ModelPools gives models their own concurrency limit and wait queue in front of Bifrost.
+// Bifrost has one worker pool per provider, so requests for a slow model can occupy every
+// worker and block requests for fast ones. Limiting each model's in-flight requests keeps
+// workers free for the others. This is a gate in the gateway, not a Bifrost provider setting.
+type ModelPools struct {
+	pools      map[string]*modelPool
+	routes     *RoutingTable
+	retryAfter string
+}
+
+type modelPool struct {
+	slots    chan struct{}
+	buffer   int64
+	waiting  atomic.Int64
+	rejected atomic.Int64
+}
+
+// ModelPoolStats is the state of one model's pool, reported on /metrics
+type ModelPoolStats struct {
+	Concurrency int   `json:"concurrency"`
+	InFlight    int   `json:"in_flight"`
+	Waiting     int64 `json:"waiting"`
+	Rejected    int64 `json:"rejected_requests"`
+}
+
+// NewModelPools parses a comma separated list of model=concurrency[:buffer] pools, e.g.
+// "gpt-4o=100:1000,gpt-4o-mini=500". The buffer, the number of requests allowed to wait for
+// a slot, defaults to the concurrency; requests beyond it are rejected with 429. Requested models
+// are resolved through routes first, so aliases count against their target model's pool.
+func NewModelPools(spec string, retryAfter time.Duration, routes *RoutingTable) (*ModelPools, error) {
+	seconds := max(int(retryAfter.Round(time.Second)/time.Second), 1)
+	p := &ModelPools{pools: make(map[string]*modelPool), routes: routes, retryAfter: strconv.Itoa(seconds)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, sizes, ok := strings.Cut(entry, "=")
+		if !ok || model == "" {
+			return nil, fmt.Errorf("invalid model pool %q, expected model=concurrency[:buffer]", entry)
+		}
+		concurrencyStr, bufferStr, hasBuffer := strings.Cut(sizes, ":")
+		concurrency, err := strconv.Atoi(concurrencyStr)
+		if err != nil || concurrency <= 0 {
+			return nil, fmt.Errorf("invalid concurrency for model %s: %q", model, concurrencyStr)
+		}
+		buffer := concurrency
+		if hasBuffer {
+			if buffer, err = strconv.Atoi(bufferStr); err != nil || buffer < 0 {
+				return nil, fmt.Errorf("invalid buffer size for model %s: %q", model, bufferStr)
+			}
+		}
+		p.pools[model] = &modelPool{slots: make(chan struct{}, concurrency), buffer: int64(buffer)}
+	}
+	if len(p.pools) == 0 {
+		return nil, fmt.Errorf("no model pools given")
+	}
+	return p, nil
+}
+
+// Concurrency returns the sum of every pool's concurrency
+func (p *ModelPools) Concurrency() int {
+	total := 0
+	for _, pool := range p.pools {
+		total += cap(pool.slots)
+	}
+	return total
+}
+
+// Wrap holds each request for a pooled model until its pool has a free slot. Models are
+// matched after routing, with the route picked here also serving the request; other models pass
+// through.
+func (p *ModelPools) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.Unmarshal(ctx.PostBody(), &req)
+		_, model := p.routes.Pick(ctx, req.Model)
+
+		pool, ok := p.pools[model]
+		if !ok {
+			next(ctx)
+			return
+		}
+
+		select {
+		case pool.slots <- struct{}{}:
+		default:
+			if pool.waiting.Add(1) > pool.buffer {
+				pool.waiting.Add(-1)
+				pool.rejected.Add(1)
+				ctx.Response.Header.Set("Retry-After", p.retryAfter)
+				ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+				ctx.SetContentType("application/json")
+				ctx.SetBodyString(`{"error":{"message":"model pool is full","type":"queue_full"}}`)
+				return
+			}
+			pool.slots <- struct{}{}
+			pool.waiting.Add(-1)
+		}
+		defer func() { <-pool.slots }()
+		next(ctx)
+	}
+}
+
+// Stats returns the state of every pool, nil when there are none
+func (p *ModelPools) Stats() map[string]ModelPoolStats {
+	if p == nil {
+		return nil
+	}
+	models := make([]string, 0, len(p.pools))
+	for model := range p.pools {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+
+	stats := make(map[string]ModelPoolStats, len(models))
+	for _, model := range models {
+		pool := p.pools[model]
+		stats[model] = ModelPoolStats{
+			Concurrency: cap(pool.slots),
+			InFlight:    len(pool.slots),
+			Waiting:     pool.waiting.Load(),
+			Rejected:    pool.rejected.Load(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+// ModelEntry is one model of the /v1/models listing, in OpenAI's format with the provider
+// serving it. Aliases from the routing table are listed too, owned by the gateway, with the
+// routes they spread over.
+type ModelEntry struct {
+	ID       string  `json:"id"` // provider/model, as sent in requests
+	Object   string  `json:"object"`
+	Created  int64   `json:"created"`
+	OwnedBy  string  `json:"owned_by"`
+	Provider string  `json:"provider,omitempty"`
+	Routes   []Route `json:"routes,omitempty"` // Set for aliases
+}
+
+// modelList is the /v1/models response
+type modelList struct {
+	Object string       `json:"object"`
+	Data   []ModelEntry `json:"data"`
+}
+
+// ModelsHandler serves GET /v1/models and GET /v1/models/{model} for the account's models and
+// the routing table's aliases, so OpenAI SDKs and tools that probe the model list before
+// sending traffic work against the gateway. The models are fixed, so the listing is encoded once.
+func ModelsHandler(account *BaseAccount, routes *RoutingTable) (list fasthttp.RequestHandler, get fasthttp.RequestHandler) {
+	created := time.Now().Unix()
+	var entries []ModelEntry
+	for provider, models := range account.Models() {
+		for _, model := range models {
+			entries = append(entries, ModelEntry{
+				ID:       string(provider) + "/" + model,
+				Object:   "model",
+				Created:  created,
+				OwnedBy:  string(provider),
+				Provider: string(provider),
+			})
+		}
+	}
+	if routes != nil {
+		for alias, targets := range routes.routes {
+			entries = append(entries, ModelEntry{ID: alias, Object: "model", Created: created, OwnedBy: "bifrost", Routes: targets})
+		}
+	}
+	slices.SortFunc(entries, func(a, b ModelEntry) int { return strings.Compare(a.ID, b.ID) })
+
+	body, _ := json.Marshal(modelList{Object: "list", Data: entries})
+	list = func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("application/json")
+		ctx.SetBody(body)
+	}
+
+	// Models are looked up as listed, or without the provider prefix like chat requests
+	byID := make(map[string][]byte, 2*len(entries))
+	for _, entry := range entries {
+		encoded, _ := json.Marshal(entry)
+		byID[entry.ID] = encoded
+		if _, model, ok := strings.Cut(entry.ID, "/"); ok && entry.Provider == string(schemas.OpenAI) {
+			byID[model] = encoded
+		}
+	}
+	get = func(ctx *fasthttp.RequestCtx) {
+		id, _ := ctx.UserValue("model").(string)
+		encoded, ok := byID[strings.TrimPrefix(id, "/")]
+		if !ok {
+			writeOpenAIError(ctx, fasthttp.StatusNotFound, "model_not_found", "The model '"+id+"' does not exist")
+			return
+		}
+		ctx.SetContentType("application/json")
+		ctx.SetBody(encoded)
+	}
+	return list, get
+}
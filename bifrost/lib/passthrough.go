@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/bytedance/sonic/ast"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+)
+
+// mockBodyHeaders describe the request body as the mocker received it
+var mockBodyHeaders = []string{"X-Mock-Body-Bytes", "X-Mock-Body-Sha256"}
+
+// Passthrough proxies the raw request body to the upstream with a plain fasthttp client,
+// skipping Bifrost entirely. Comparing it with the normal handler separates the cost of
+// the HTTP wrapper and fasthttp from the overhead of Bifrost core. Only the model is rewritten,
+// the way Bifrost would resolve it, so the upstream sees the same model either way.
+type Passthrough struct {
+	client        *fasthttp.Client
+	upstream      string // Chat completions URL Bifrost would call
+	authorization string
+	routes        *RoutingTable
+}
+
+// NewPassthrough creates a passthrough proxy with the same upstream, timeout, connection limit
+// and proxy settings Bifrost uses for OpenAI. An empty upstreamURL is DefaultUpstreamURL. Models
+// are resolved through routes, which may be nil, and must route to OpenAI.
+func NewPassthrough(apiKey string, upstreamURL string, proxyURL string, concurrency int, timeout time.Duration, routes *RoutingTable) *Passthrough {
+	client := &fasthttp.Client{
+		ReadTimeout:     timeout,
+		WriteTimeout:    timeout,
+		MaxConnsPerHost: concurrency,
+	}
+	if proxyURL != "" {
+		client.Dial = fasthttpproxy.FasthttpHTTPDialer(proxyURL)
+	}
+	if upstreamURL == "" {
+		upstreamURL = DefaultUpstreamURL
+	}
+	return &Passthrough{
+		client:        client,
+		upstream:      strings.TrimRight(upstreamURL, "/") + "/v1/chat/completions",
+		authorization: "Bearer " + apiKey,
+		routes:        routes,
+	}
+}
+
+// passthroughBody returns the request body, reading a streamed one into memory up to the body
+// limit, since its model may have to be rewritten before it is forwarded
+func passthroughBody(ctx *fasthttp.RequestCtx) ([]byte, error) {
+	s := bodyStreaming
+	if !s.streams(ctx) {
+		if s != nil {
+			s.buffered.Add(1)
+		}
+		return ctx.PostBody(), nil
+	}
+
+	s.streamed.Add(1)
+	body, err := io.ReadAll(&io.LimitedReader{R: ctx.RequestBodyStream(), N: int64(s.maxBody) + 1})
+	s.streamedBytes.Add(int64(len(body)))
+	if len(body) > s.maxBody {
+		s.tooLarge.Add(1)
+		return nil, errBodyTooLarge
+	}
+	return body, err
+}
+
+// rewriteModel returns the body with its model resolved through the routing table, like
+// "openai/gpt-4o-mini" to "gpt-4o-mini" or an alias to its route. Bodies whose model is already
+// resolved, or that have none, are returned as they are.
+func (p *Passthrough) rewriteModel(ctx *fasthttp.RequestCtx, body []byte) ([]byte, error) {
+	node, err := sonic.Get(body, "model")
+	if err != nil {
+		return body, nil
+	}
+	model, err := node.String()
+	if err != nil {
+		return body, nil
+	}
+	if _, resolved := p.routes.ResolveRequest(ctx, model); resolved != model {
+		root, err := sonic.Get(body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := root.Set("model", ast.NewString(resolved)); err != nil {
+			return nil, err
+		}
+		return root.MarshalJSON()
+	}
+	return body, nil
+}
+
+// Handler forwards each request body with its model resolved and copies back the upstream status
+// and body, along with the mocker's body checksum headers.
+// Request and response objects are pooled, so the fast path does not allocate per request.
+func (p *Passthrough) Handler() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		body, err := passthroughBody(ctx)
+		if err != nil {
+			WriteBodyError(ctx, err)
+			return
+		}
+		if body, err = p.rewriteModel(ctx, body); err != nil {
+			WriteBodyError(ctx, err)
+			return
+		}
+
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+
+		req.SetRequestURI(p.upstream)
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.Header.SetContentType("application/json")
+		req.Header.Set(fasthttp.HeaderAuthorization, p.authorization)
+		for _, name := range forwardedHeaders {
+			if value := ctx.Request.Header.Peek(name); len(value) > 0 {
+				req.Header.SetBytesV(name, value)
+			}
+		}
+		req.SetBodyRaw(body)
+
+		if err := p.client.Do(req, resp); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+			ctx.SetBodyString(fmt.Sprintf("error: %v", err))
+			return
+		}
+
+		ctx.SetStatusCode(resp.StatusCode())
+		ctx.Response.Header.SetContentTypeBytes(resp.Header.ContentType())
+		// The mocker's checksum of the body it received, for the runner's -verify-body
+		for _, name := range mockBodyHeaders {
+			if value := resp.Header.Peek(name); len(value) > 0 {
+				ctx.Response.Header.SetBytesV(name, value)
+			}
+		}
+		ctx.SetBody(resp.Body())
+	}
+}
@@ -0,0 +1,400 @@
+package lib
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpproxy"
+)
+
+// maxRealtimeOpenStats bounds the open connections listed on /metrics
+const maxRealtimeOpenStats = 100
+
+// maxRealtimeErrorBody bounds the body of a refused upstream handshake copied to the client
+const maxRealtimeErrorBody = 64 << 10
+
+// realtimeForwardedHeaders are the handshake headers sent on to the upstream. The client's
+// Sec-WebSocket-Key is forwarded, so the upstream's Sec-WebSocket-Accept is valid for the client.
+var realtimeForwardedHeaders = []string{
+	"Sec-WebSocket-Key", "Sec-WebSocket-Version", "Sec-WebSocket-Protocol", "Sec-WebSocket-Extensions",
+	"OpenAI-Beta", RequestIDHeader,
+}
+
+// realtimeUpgradeHeaders are the upstream's 101 headers passed back to the client
+var realtimeUpgradeHeaders = []string{"Sec-WebSocket-Accept", "Sec-WebSocket-Protocol", "Sec-WebSocket-Extensions"}
+
+// RealtimeProxy passes WebSocket connections on /v1/realtime through to the upstream's realtime
+// endpoint, like OpenAI's Realtime API or the mocker's emulation of it. The handshake is sent on
+// with the gateway's API key, and once the upstream has switched protocols frames are copied
+// both ways untouched: only their headers are parsed, to count frames and payload bytes per
+// connection, so the cost measured is the gateway's connection fan-out and forwarding, not
+// unmasking or JSON. Bifrost core has no realtime support, so frames never go through it.
+type RealtimeProxy struct {
+	address       string // Upstream host:port
+	host          string // Host header of the upstream handshake
+	path          string // Base path of the upstream plus /v1/realtime
+	tlsConfig     *tls.Config
+	dial          fasthttp.DialFunc
+	authorization string
+	timeout       time.Duration // Dial and handshake timeout
+
+	handshakes atomic.Int64
+	rejected   atomic.Int64
+	fromClient frameCounter
+	toClient   frameCounter
+
+	mu              sync.Mutex
+	nextID          int64
+	open            map[int64]*realtimeConn
+	closed          int64
+	closedLifetime  time.Duration
+	handshakeTotal  time.Duration
+	handshakeMax    time.Duration
+	handshakeCount  int64
+	failedHandshake string // Last reason a handshake failed
+}
+
+// realtimeConn is the accounting of one proxied connection
+type realtimeConn struct {
+	id         int64
+	client     string
+	opened     time.Time
+	fromClient frameCounter
+	toClient   frameCounter
+}
+
+// frameCounter counts the frames forwarded in one direction and their payload bytes
+type frameCounter struct {
+	frames atomic.Int64
+	bytes  atomic.Int64
+}
+
+func (c *frameCounter) add(length uint64) {
+	c.frames.Add(1)
+	c.bytes.Add(int64(length))
+}
+
+// RealtimeStats describe the proxied realtime connections, reported on /metrics. Frames and
+// bytes count payloads in each direction, control frames included, open connections included.
+type RealtimeStats struct {
+	Connections     int64                     `json:"connections"` // Upgraded connections, open or closed
+	Active          int64                     `json:"active"`
+	Rejected        int64                     `json:"rejected"` // Handshakes that failed or the upstream refused
+	LastRejection   string                    `json:"last_rejection,omitempty"`
+	ClientFrames    int64                     `json:"client_frames"`
+	ClientBytes     int64                     `json:"client_bytes"`
+	UpstreamFrames  int64                     `json:"upstream_frames"`
+	UpstreamBytes   int64                     `json:"upstream_bytes"`
+	HandshakeMeanMs float64                   `json:"handshake_mean_ms"` // Dial to the upstream's 101
+	HandshakeMaxMs  float64                   `json:"handshake_max_ms"`
+	LifetimeMeanS   float64                   `json:"lifetime_mean_s"` // Mean lifetime of closed connections
+	Open            []RealtimeConnectionStats `json:"open,omitempty"`  // The oldest open connections
+}
+
+// RealtimeConnectionStats describe one open connection
+type RealtimeConnectionStats struct {
+	ID             int64   `json:"id"`
+	Client         string  `json:"client"`
+	AgeS           float64 `json:"age_s"`
+	ClientFrames   int64   `json:"client_frames"`
+	ClientBytes    int64   `json:"client_bytes"`
+	UpstreamFrames int64   `json:"upstream_frames"`
+	UpstreamBytes  int64   `json:"upstream_bytes"`
+}
+
+// NewRealtimeProxy proxies to the realtime endpoint under upstreamURL, DefaultUpstreamURL when
+// empty, through proxyURL when set, giving up on a handshake after timeout
+func NewRealtimeProxy(apiKey string, upstreamURL string, proxyURL string, timeout time.Duration) (*RealtimeProxy, error) {
+	if upstreamURL == "" {
+		upstreamURL = DefaultUpstreamURL
+	}
+	u, err := url.Parse(upstreamURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL: %v", err)
+	}
+	p := &RealtimeProxy{
+		address:       u.Host,
+		host:          u.Host,
+		path:          strings.TrimRight(u.Path, "/") + "/v1/realtime",
+		authorization: "Bearer " + apiKey,
+		timeout:       timeout,
+		open:          make(map[int64]*realtimeConn),
+	}
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		p.address = net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.Scheme == "https" {
+		// WebSockets need HTTP/1.1, so it is the only protocol offered
+		p.tlsConfig = &tls.Config{ServerName: u.Hostname(), NextProtos: []string{"http/1.1"}}
+	}
+	if proxyURL != "" {
+		p.dial = fasthttpproxy.FasthttpHTTPDialerTimeout(proxyURL, timeout)
+	} else {
+		p.dial = func(addr string) (net.Conn, error) { return net.DialTimeout("tcp", addr, timeout) }
+	}
+	return p, nil
+}
+
+// Handler opens the upstream connection and completes its handshake before answering the
+// client, so a refused handshake reaches the client with the upstream's status and body
+func (p *RealtimeProxy) Handler() fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !headerHasToken(ctx.Request.Header.Peek("Connection"), "upgrade") || !headerHasToken(ctx.Request.Header.Peek("Upgrade"), "websocket") {
+			ctx.Response.Header.Set("Upgrade", "websocket")
+			ctx.SetStatusCode(fasthttp.StatusUpgradeRequired)
+			ctx.SetBodyString("Expected a WebSocket upgrade")
+			return
+		}
+
+		start := time.Now()
+		upstream, reader, resp, err := p.handshake(ctx)
+		if err != nil {
+			p.reject(err.Error())
+			ctx.SetStatusCode(fasthttp.StatusBadGateway)
+			ctx.SetBodyString(fmt.Sprintf("error: realtime upstream: %v", err))
+			return
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			defer upstream.Close()
+			p.reject(resp.Status)
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, maxRealtimeErrorBody))
+			ctx.SetStatusCode(resp.StatusCode)
+			ctx.SetContentType(resp.Header.Get("Content-Type"))
+			ctx.SetBody(body)
+			return
+		}
+		conn := p.opened(ctx.RemoteAddr().String(), time.Since(start))
+
+		// fasthttp writes this 101 to the client before handing over the connection
+		ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+		ctx.Response.Header.Set("Upgrade", "websocket")
+		ctx.Response.Header.Set("Connection", "Upgrade")
+		for _, name := range realtimeUpgradeHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				ctx.Response.Header.Set(name, value)
+			}
+		}
+		ctx.Hijack(func(client net.Conn) {
+			p.forward(conn, client, upstream, reader)
+		})
+	}
+}
+
+// handshake dials the upstream and sends it the client's handshake, returning the connection,
+// its reader and the upstream's answer
+func (p *RealtimeProxy) handshake(ctx *fasthttp.RequestCtx) (net.Conn, *bufio.Reader, *http.Response, error) {
+	conn, err := p.dial(p.address)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	if p.tlsConfig != nil {
+		conn = tls.Client(conn, p.tlsConfig)
+	}
+	conn.SetDeadline(time.Now().Add(p.timeout))
+
+	var req strings.Builder
+	req.WriteString("GET " + p.path)
+	if query := ctx.URI().QueryString(); len(query) > 0 {
+		req.WriteString("?" + string(query))
+	}
+	req.WriteString(" HTTP/1.1\r\nHost: " + p.host + "\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	req.WriteString("Authorization: " + p.authorization + "\r\n")
+	for _, name := range realtimeForwardedHeaders {
+		if value := ctx.Request.Header.Peek(name); len(value) > 0 {
+			req.WriteString(name + ": " + string(value) + "\r\n")
+		}
+	}
+	req.WriteString("\r\n")
+	if _, err := io.WriteString(conn, req.String()); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("failed to send the handshake: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("failed to read the handshake: %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, reader, resp, nil
+}
+
+// forward copies frames both ways until either side closes its connection, then closes both
+func (p *RealtimeProxy) forward(conn *realtimeConn, client net.Conn, upstream net.Conn, upstreamReader *bufio.Reader) {
+	defer p.release(conn)
+
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			client.Close()
+			upstream.Close()
+		})
+	}
+	defer closeBoth()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		copyFrames(client, upstreamReader, &conn.toClient, &p.toClient)
+		closeBoth()
+	}()
+	copyFrames(upstream, bufio.NewReader(client), &conn.fromClient, &p.fromClient)
+	closeBoth()
+	<-done
+}
+
+// copyFrames copies WebSocket frames from src to dst unchanged until src or dst fails, counting
+// frames and payload bytes. Frames are flushed once no further frame is buffered, so bursts of
+// small frames are written together.
+func copyFrames(dst io.Writer, src *bufio.Reader, conn *frameCounter, total *frameCounter) {
+	out := bufio.NewWriter(dst)
+	var header [14]byte
+	for {
+		if _, err := io.ReadFull(src, header[:2]); err != nil {
+			return
+		}
+		n := 2
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			if _, err := io.ReadFull(src, header[2:4]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(header[2:4]))
+			n = 4
+		case 127:
+			if _, err := io.ReadFull(src, header[2:10]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(header[2:10])
+			n = 10
+		}
+		// The masking key travels with the frame; the payload stays masked
+		if header[1]&0x80 != 0 {
+			if _, err := io.ReadFull(src, header[n:n+4]); err != nil {
+				return
+			}
+			n += 4
+		}
+
+		if _, err := out.Write(header[:n]); err != nil {
+			return
+		}
+		if _, err := io.CopyN(out, src, int64(length)); err != nil {
+			return
+		}
+		conn.add(length)
+		total.add(length)
+
+		if src.Buffered() == 0 {
+			if err := out.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// opened accounts an upgraded connection
+func (p *RealtimeProxy) opened(client string, handshake time.Duration) *realtimeConn {
+	p.handshakes.Add(1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	conn := &realtimeConn{id: p.nextID, client: client, opened: time.Now()}
+	p.open[conn.id] = conn
+	p.handshakeTotal += handshake
+	p.handshakeMax = max(p.handshakeMax, handshake)
+	p.handshakeCount++
+	return conn
+}
+
+// release moves a closed connection from the open ones to the lifetime totals
+func (p *RealtimeProxy) release(conn *realtimeConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.open, conn.id)
+	p.closed++
+	p.closedLifetime += time.Since(conn.opened)
+}
+
+// reject accounts a handshake that didn't upgrade
+func (p *RealtimeProxy) reject(reason string) {
+	p.rejected.Add(1)
+	p.mu.Lock()
+	p.failedHandshake = reason
+	p.mu.Unlock()
+}
+
+// headerHasToken reports whether a comma separated header value contains token, case-insensitively
+func headerHasToken(value []byte, token string) bool {
+	for _, part := range strings.Split(string(value), ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the connection and frame counters, nil when the realtime proxy is disabled
+func (p *RealtimeProxy) Stats() *RealtimeStats {
+	if p == nil {
+		return nil
+	}
+	stats := &RealtimeStats{
+		Connections:    p.handshakes.Load(),
+		Rejected:       p.rejected.Load(),
+		ClientFrames:   p.fromClient.frames.Load(),
+		ClientBytes:    p.fromClient.bytes.Load(),
+		UpstreamFrames: p.toClient.frames.Load(),
+		UpstreamBytes:  p.toClient.bytes.Load(),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats.Active = int64(len(p.open))
+	stats.LastRejection = p.failedHandshake
+	if p.handshakeCount > 0 {
+		stats.HandshakeMeanMs = float64(p.handshakeTotal) / float64(p.handshakeCount) / float64(time.Millisecond)
+		stats.HandshakeMaxMs = float64(p.handshakeMax) / float64(time.Millisecond)
+	}
+	if p.closed > 0 {
+		stats.LifetimeMeanS = p.closedLifetime.Seconds() / float64(p.closed)
+	}
+
+	now := time.Now()
+	for _, conn := range p.open {
+		stats.Open = append(stats.Open, RealtimeConnectionStats{
+			ID:             conn.id,
+			Client:         conn.client,
+			AgeS:           now.Sub(conn.opened).Seconds(),
+			ClientFrames:   conn.fromClient.frames.Load(),
+			ClientBytes:    conn.fromClient.bytes.Load(),
+			UpstreamFrames: conn.toClient.frames.Load(),
+			UpstreamBytes:  conn.toClient.bytes.Load(),
+		})
+	}
+	slices.SortFunc(stats.Open, func(a, b RealtimeConnectionStats) int { return cmp.Compare(a.ID, b.ID) })
+	if len(stats.Open) > maxRealtimeOpenStats {
+		stats.Open = stats.Open[:maxRealtimeOpenStats]
+	}
+	return stats
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"log"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RequestIDHeader is the per-request ID sent by the benchmark runner
+const RequestIDHeader = "X-Request-ID"
+
+// TraceIDHeader is the per-request trace ID the runner sends with -mocker-url, recorded by the
+// mocker to split latency into time spent before, in and after the upstream
+const TraceIDHeader = "X-Trace-Id"
+
+// forwardedHeaders are the per-request headers -passthrough sends on to the upstream. Bifrost
+// core does not forward per-request headers, so requests through it reach the mocker untraced.
+var forwardedHeaders = []string{RequestIDHeader, TraceIDHeader}
+
+// maxLoggedErrorBody caps how much of an error response body is logged
+const maxLoggedErrorBody = 200
+
+// WithRequestID echoes the request ID back in the response. With logErrors, every error
+// response is logged with its request ID, so a failure the runner reports can be found here.
+// Bifrost core does not forward per-request headers, so only -passthrough and -realtime send
+// the ID upstream.
+func WithRequestID(next fasthttp.RequestHandler, logErrors bool) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		// Copied, since the handler may reuse the request buffers
+		id := string(ctx.Request.Header.Peek(RequestIDHeader))
+		next(ctx)
+		if id == "" {
+			return
+		}
+		ctx.Response.Header.Set(RequestIDHeader, id)
+
+		if status := ctx.Response.StatusCode(); logErrors && status >= fasthttp.StatusBadRequest {
+			body := ctx.Response.Body()
+			if len(body) > maxLoggedErrorBody {
+				body = body[:maxLoggedErrorBody]
+			}
+			log.Printf("Request %s failed with HTTP %d: %s", id, status, body)
+		}
+	}
+}
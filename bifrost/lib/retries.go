@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"strings"
+
+	bifrost "github.com/maximhq/bifrost/core"
+	"github.com/maximhq/bifrost/core/schemas"
+)
+
+// RetryCountingLogger counts Bifrost's retries into the debug stats. Bifrost does not report
+// attempts per request, but it logs every retry and every request that fails after retrying.
+type RetryCountingLogger struct {
+	schemas.Logger
+}
+
+// NewRetryCountingLogger wraps the logger Bifrost would use by default
+func NewRetryCountingLogger() *RetryCountingLogger {
+	return &RetryCountingLogger{Logger: bifrost.NewDefaultLogger(schemas.LogLevelInfo)}
+}
+
+func (l *RetryCountingLogger) Info(msg string) {
+	if strings.HasPrefix(msg, "Retrying request") {
+		serverMetrics.mu.Lock()
+		serverMetrics.Retries++
+		if strings.Contains(msg, "(attempt 1/") {
+			serverMetrics.RetriedRequests++
+		}
+		serverMetrics.mu.Unlock()
+	}
+	l.Logger.Info(msg)
+}
+
+func (l *RetryCountingLogger) Warn(msg string) {
+	if strings.HasPrefix(msg, "Request failed after") {
+		serverMetrics.mu.Lock()
+		serverMetrics.RetriesExhausted++
+		serverMetrics.mu.Unlock()
+	}
+	l.Logger.Warn(msg)
+}
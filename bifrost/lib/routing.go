@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+// Route is one weighted target of a model alias
+type Route struct {
+	Provider schemas.ModelProvider `json:"provider"`
+	Model    string                `json:"model"`
+	Weight   float64               `json:"weight"`
+}
+
+// RoutingTable maps incoming model aliases (e.g. "fast", "smart") to weighted provider/model routes
+type RoutingTable struct {
+	routes map[string][]Route
+	totals map[string]float64
+}
+
+// LoadRoutingTable reads a routing table from a JSON file of the form
+// {"fast": [{"provider": "openai", "model": "gpt-4o-mini", "weight": 1}]}
+func LoadRoutingTable(path string) (*RoutingTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing table: %v", err)
+	}
+
+	var routes map[string][]Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse routing table: %v", err)
+	}
+
+	table := &RoutingTable{
+		routes: routes,
+		totals: make(map[string]float64, len(routes)),
+	}
+	for alias, targets := range routes {
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("alias %q has no routes", alias)
+		}
+		for i := range targets {
+			if targets[i].Model == "" {
+				return nil, fmt.Errorf("alias %q has a route without a model", alias)
+			}
+			if targets[i].Weight <= 0 {
+				return nil, fmt.Errorf("alias %q has a route with non-positive weight", alias)
+			}
+			if targets[i].Provider == "" {
+				targets[i].Provider = schemas.OpenAI
+			}
+			table.totals[alias] += targets[i].Weight
+		}
+	}
+
+	return table, nil
+}
+
+// Resolve returns the provider and model for an incoming model name. Aliases are routed by weight;
+// anything else falls back to OpenAI with any "provider/" prefix stripped.
+func (t *RoutingTable) Resolve(model string) (schemas.ModelProvider, string) {
+	if t != nil {
+		if targets, ok := t.routes[model]; ok {
+			pick := rand.Float64() * t.totals[model]
+			for _, target := range targets {
+				pick -= target.Weight
+				if pick < 0 {
+					return target.Provider, target.Model
+				}
+			}
+			last := targets[len(targets)-1]
+			return last.Provider, last.Model
+		}
+	}
+
+	if strings.Contains(model, "/") {
+		parts := strings.Split(model, "/")
+		model = parts[1]
+	}
+	return schemas.OpenAI, model
+}
+
+// routeKey is the request user value holding the route Pick chose for it
+type routeKey struct{}
+
+// pickedRoute is the route chosen for a request's model
+type pickedRoute struct {
+	model    string
+	provider schemas.ModelProvider
+	resolved string
+}
+
+// Pick resolves model like Resolve and remembers the route for the request, so that
+// ResolveRequest in the handler returns the same route of a weighted alias. Middleware that acts
+// on the resolved model, like model pools, picks the route this way.
+func (t *RoutingTable) Pick(ctx *fasthttp.RequestCtx, model string) (schemas.ModelProvider, string) {
+	provider, resolved := t.Resolve(model)
+	ctx.SetUserValue(routeKey{}, pickedRoute{model: model, provider: provider, resolved: resolved})
+	return provider, resolved
+}
+
+// ResolveRequest returns the route picked for the request's model by Pick, or resolves it
+func (t *RoutingTable) ResolveRequest(ctx *fasthttp.RequestCtx, model string) (schemas.ModelProvider, string) {
+	if picked, ok := ctx.UserValue(routeKey{}).(pickedRoute); ok && picked.model == model {
+		return picked.provider, picked.resolved
+	}
+	return t.Resolve(model)
+}
+
+// Providers returns every provider referenced by the routing table
+func (t *RoutingTable) Providers() []schemas.ModelProvider {
+	if t == nil {
+		return nil
+	}
+
+	seen := make(map[schemas.ModelProvider]bool)
+	var providers []schemas.ModelProvider
+	for _, targets := range t.routes {
+		for _, target := range targets {
+			if !seen[target.Provider] {
+				seen[target.Provider] = true
+				providers = append(providers, target.Provider)
+			}
+		}
+	}
+	return providers
+}
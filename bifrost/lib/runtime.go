@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// RuntimeSettings are Go runtime tuning parameters, written the way the GOMAXPROCS, GOGC and
+// GOMEMLIMIT environment variables take them. Zero values leave a setting unchanged.
+type RuntimeSettings struct {
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	GOGC       string `json:"gogc"`       // Percent, or "off"
+	GOMEMLIMIT string `json:"gomemlimit"` // Bytes with an optional B, KiB, MiB, GiB or TiB suffix, or "off"
+}
+
+// RuntimeStats are the runtime settings in effect, reported on /metrics
+type RuntimeStats struct {
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	GOGC       int    `json:"gogc"`             // -1 when the GC is off
+	GOMEMLIMIT int64  `json:"gomemlimit_bytes"` // math.MaxInt64 when there is no limit
+	NumCPU     int    `json:"num_cpu"`
+	GoVersion  string `json:"go_version"`
+	Ballast    int64  `json:"ballast_bytes"`      // Heap ballast set with -ballast
+	Frontend   string `json:"frontend,omitempty"` // Server in front of fasthttp, like the -http2 frontend
+}
+
+// gcPercent tracks GOGC, since the runtime can only report it by changing it
+var gcPercent atomic.Int64
+
+func init() {
+	percent, err := ParseGOGC(os.Getenv("GOGC"))
+	if err != nil {
+		percent = 100 // The runtime default, also used for unset or invalid GOGC
+	}
+	gcPercent.Store(int64(percent))
+}
+
+// ParseGOGC parses a GOGC value, -1 for off
+func ParseGOGC(s string) (int, error) {
+	if s == "off" {
+		return -1, nil
+	}
+	percent, err := strconv.Atoi(s)
+	if err != nil || percent < 0 {
+		return 0, fmt.Errorf("GOGC must be a non-negative percent or off, got %q", s)
+	}
+	return percent, nil
+}
+
+// ParseMemoryLimit parses a GOMEMLIMIT value, math.MaxInt64 for off
+func ParseMemoryLimit(s string) (int64, error) {
+	if s == "off" {
+		return math.MaxInt64, nil
+	}
+
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1}}
+	number, multiplier := s, int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(s, unit.suffix), unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("GOMEMLIMIT must be a byte count with an optional B, KiB, MiB, GiB or TiB suffix, or off, got %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// Validate checks the settings without applying them
+func (s RuntimeSettings) Validate() error {
+	if s.GOMAXPROCS < 0 {
+		return fmt.Errorf("GOMAXPROCS must not be negative, got %d", s.GOMAXPROCS)
+	}
+	if s.GOGC != "" {
+		if _, err := ParseGOGC(s.GOGC); err != nil {
+			return err
+		}
+	}
+	if s.GOMEMLIMIT != "" {
+		if _, err := ParseMemoryLimit(s.GOMEMLIMIT); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyRuntimeSettings changes the runtime settings of the running process. The runtime picks
+// them up without a restart, so they can be changed between benchmark runs.
+func ApplyRuntimeSettings(s RuntimeSettings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.GOMAXPROCS > 0 {
+		runtime.GOMAXPROCS(s.GOMAXPROCS)
+	}
+	if s.GOGC != "" {
+		percent, _ := ParseGOGC(s.GOGC)
+		debug.SetGCPercent(percent)
+		gcPercent.Store(int64(percent))
+	}
+	if s.GOMEMLIMIT != "" {
+		limit, _ := ParseMemoryLimit(s.GOMEMLIMIT)
+		debug.SetMemoryLimit(limit)
+	}
+	return nil
+}
+
+// CurrentRuntimeStats returns the runtime settings in effect
+func CurrentRuntimeStats() RuntimeStats {
+	return RuntimeStats{
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		GOGC:       int(gcPercent.Load()),
+		GOMEMLIMIT: debug.SetMemoryLimit(-1),
+		NumCPU:     runtime.NumCPU(),
+		GoVersion:  runtime.Version(),
+		Ballast:    BallastBytes(),
+		Frontend:   frontendName(),
+	}
+}
+
+// frontendName returns the server in front of fasthttp, empty when there is none
+func frontendName() string {
+	name, _ := frontend.Load().(string)
+	return name
+}
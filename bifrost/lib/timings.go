@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+// timingBuckets are the upper bounds (in seconds) of the stage timing histograms, from the
+// microseconds of key selection to the seconds of a slow upstream
+var timingBuckets = []float64{0.00001, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// timingStages are the stages recorded, in exposition order. handler and bifrost are measured by
+// the gateway, the others are read from the bifrost_timings and provider_metrics Bifrost reports.
+var timingStages = append([]string{"handler", "bifrost", "queue_wait", "key_selection", "plugin_pre", "plugin_post"}, providerStages...)
+
+// providerStages are the provider_metrics fields recorded, named as Bifrost reports them
+var providerStages = []string{
+	"message_formatting", "params_preparation", "request_body_preparation", "json_marshaling",
+	"request_setup", "http_request", "error_handling", "response_parsing",
+}
+
+// histogram is a lock-free Prometheus style histogram with cumulative buckets
+type histogram struct {
+	counts []atomic.Uint64 // Per bucket, not cumulative; the last one is +Inf
+	sumNs  atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(timingBuckets) && seconds > timingBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// TimingHistograms records every request's stage timings into fixed histograms, so memory stays
+// flat and recording costs a few atomic adds per stage
+type TimingHistograms struct {
+	stages map[string]*histogram
+}
+
+// timingHistograms is nil unless EnableTimingHistograms was called
+var timingHistograms *TimingHistograms
+
+// EnableTimingHistograms turns on stage timing histograms for every handler
+func EnableTimingHistograms() *TimingHistograms {
+	h := &TimingHistograms{stages: make(map[string]*histogram, len(timingStages))}
+	for _, stage := range timingStages {
+		h.stages[stage] = &histogram{counts: make([]atomic.Uint64, len(timingBuckets)+1)}
+	}
+	timingHistograms = h
+	return h
+}
+
+// observe records a stage timing, ignoring stages Bifrost did not report
+func (h *TimingHistograms) observe(stage string, d time.Duration) {
+	if d > 0 {
+		h.stages[stage].observe(d)
+	}
+}
+
+// durationField reads a duration in nanoseconds from a decoded JSON object
+func durationField(m map[string]interface{}, key string) time.Duration {
+	switch v := m[key].(type) {
+	case float64:
+		return time.Duration(v)
+	case int64:
+		return time.Duration(v)
+	case int:
+		return time.Duration(v)
+	}
+	return 0
+}
+
+// recordTimings records a request's handler and Bifrost time, plus the stage timings found in
+// the response's raw response. The raw response maps are read directly, without the JSON round
+// trip of the debug handler.
+func (h *TimingHistograms) recordTimings(handler time.Duration, bifrostTime time.Duration, resp *schemas.BifrostResponse) {
+	h.observe("handler", handler)
+	h.observe("bifrost", bifrostTime)
+	if resp == nil {
+		return
+	}
+	raw, ok := resp.ExtraFields.RawResponse.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if m, ok := raw["bifrost_timings"].(map[string]interface{}); ok {
+		h.observe("queue_wait", durationField(m, "queue_wait_time"))
+		h.observe("key_selection", durationField(m, "key_selection_time"))
+		h.observe("plugin_pre", durationField(m, "plugin_pre_time"))
+		h.observe("plugin_post", durationField(m, "plugin_post_time"))
+	}
+	if m, ok := raw["provider_metrics"].(map[string]interface{}); ok {
+		for _, stage := range providerStages {
+			h.observe(stage, durationField(m, stage))
+		}
+	}
+}
+
+// RecordTimings records a request's timings when timing histograms are enabled
+func RecordTimings(handler time.Duration, bifrostTime time.Duration, resp *schemas.BifrostResponse) {
+	if timingHistograms != nil {
+		timingHistograms.recordTimings(handler, bifrostTime, resp)
+	}
+}
+
+// Handler serves the histograms in the Prometheus text exposition format
+func (h *TimingHistograms) Handler() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("text/plain; version=0.0.4")
+		fmt.Fprintf(ctx, "# HELP bifrost_stage_duration_seconds Time spent in each stage of serving a request.\n")
+		fmt.Fprintf(ctx, "# TYPE bifrost_stage_duration_seconds histogram\n")
+		for _, stage := range timingStages {
+			s := h.stages[stage]
+			var cumulative uint64
+			for i, bound := range timingBuckets {
+				cumulative += s.counts[i].Load()
+				fmt.Fprintf(ctx, "bifrost_stage_duration_seconds_bucket{stage=%q,le=%q} %d\n",
+					stage, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+			}
+			count := cumulative + s.counts[len(timingBuckets)].Load()
+			fmt.Fprintf(ctx, "bifrost_stage_duration_seconds_bucket{stage=%q,le=\"+Inf\"} %d\n", stage, count)
+			fmt.Fprintf(ctx, "bifrost_stage_duration_seconds_sum{stage=%q} %g\n", stage, time.Duration(s.sumNs.Load()).Seconds())
+			fmt.Fprintf(ctx, "bifrost_stage_duration_seconds_count{stage=%q} %d\n", stage, count)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+type usageContextKey struct{}
+
+// usageStart is what PreHook remembers about a request until its PostHook
+type usageStart struct {
+	provider schemas.ModelProvider
+	model    string
+	start    time.Time
+}
+
+// UsageRecord is the accounting of a single request
+type UsageRecord struct {
+	Time             time.Time `json:"time"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	Status           int       `json:"status"`
+	LatencyMs        float64   `json:"latency_ms"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+}
+
+// UsageTotals accumulates over every request since start (or the last reset)
+type UsageTotals struct {
+	Requests         int64 `json:"requests"`
+	Errors           int64 `json:"errors"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// UsagePlugin is a Bifrost plugin recording model, token usage, latency and status of every
+// request. The most recent records are kept in a ring buffer and served on /usage.
+type UsagePlugin struct {
+	mu      sync.Mutex
+	records []UsageRecord
+	next    int
+	full    bool
+	totals  UsageTotals
+}
+
+// NewUsagePlugin creates a usage plugin keeping the last capacity records
+func NewUsagePlugin(capacity int) *UsagePlugin {
+	return &UsagePlugin{records: make([]UsageRecord, max(capacity, 1))}
+}
+
+func (p *UsagePlugin) GetName() string {
+	return "usage-accounting"
+}
+
+func (p *UsagePlugin) PreHook(ctx *context.Context, req *schemas.BifrostRequest) (*schemas.BifrostRequest, *schemas.PluginShortCircuit, error) {
+	*ctx = context.WithValue(*ctx, usageContextKey{}, usageStart{provider: req.Provider, model: req.Model, start: time.Now()})
+	return req, nil, nil
+}
+
+func (p *UsagePlugin) PostHook(ctx *context.Context, result *schemas.BifrostResponse, bifrostErr *schemas.BifrostError) (*schemas.BifrostResponse, *schemas.BifrostError, error) {
+	started, ok := (*ctx).Value(usageContextKey{}).(usageStart)
+	if !ok {
+		return result, bifrostErr, nil
+	}
+
+	record := UsageRecord{
+		Time:      started.start,
+		Provider:  string(started.provider),
+		Model:     started.model,
+		Status:    fasthttp.StatusOK,
+		LatencyMs: float64(time.Since(started.start)) / float64(time.Millisecond),
+	}
+	if bifrostErr != nil {
+		record.Status = fasthttp.StatusInternalServerError
+		if bifrostErr.StatusCode != nil {
+			record.Status = *bifrostErr.StatusCode
+		}
+	}
+	if result != nil && result.Usage != nil {
+		record.PromptTokens = result.Usage.PromptTokens
+		record.CompletionTokens = result.Usage.CompletionTokens
+		record.TotalTokens = result.Usage.TotalTokens
+	}
+
+	p.add(record)
+	return result, bifrostErr, nil
+}
+
+func (p *UsagePlugin) Cleanup() error {
+	return nil
+}
+
+func (p *UsagePlugin) add(record UsageRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.records[p.next] = record
+	p.next = (p.next + 1) % len(p.records)
+	if p.next == 0 {
+		p.full = true
+	}
+
+	p.totals.Requests++
+	if record.Status != fasthttp.StatusOK {
+		p.totals.Errors++
+	}
+	p.totals.PromptTokens += int64(record.PromptTokens)
+	p.totals.CompletionTokens += int64(record.CompletionTokens)
+	p.totals.TotalTokens += int64(record.TotalTokens)
+}
+
+// Handler serves the totals and buffered records, oldest first. ?reset=true clears both.
+func (p *UsagePlugin) Handler() func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		p.mu.Lock()
+		var records []UsageRecord
+		if p.full {
+			records = append(records, p.records[p.next:]...)
+		}
+		records = append(records, p.records[:p.next]...)
+		totals := p.totals
+
+		if string(ctx.QueryArgs().Peek("reset")) == "true" {
+			p.next, p.full, p.totals = 0, false, UsageTotals{}
+		}
+		p.mu.Unlock()
+
+		ctx.SetContentType("application/json")
+		json.NewEncoder(ctx).Encode(map[string]interface{}{
+			"totals":  totals,
+			"records": records,
+		})
+	}
+}
@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ValidationLimits are the size limits of strict request validation
+type ValidationLimits struct {
+	MaxBodyBytes     int // Largest request body accepted
+	MaxMessages      int // Most messages in one request
+	MaxContentLength int // Longest text of a single message or content part, in bytes
+}
+
+// ValidationStats are the validator's counters, reported on /metrics
+type ValidationStats struct {
+	Validated int64   `json:"validated"` // Requests let through
+	Rejected  int64   `json:"rejected"`  // Requests answered with 400
+	MeanUs    float64 `json:"mean_us"`   // Mean time spent validating a request
+}
+
+// chatParams are the chat completion request fields OpenAI accepts; any other field is rejected
+var chatParams = map[string]bool{
+	"model": true, "messages": true, "temperature": true, "top_p": true, "n": true, "stream": true,
+	"stream_options": true, "stop": true, "max_tokens": true, "max_completion_tokens": true,
+	"presence_penalty": true, "frequency_penalty": true, "logit_bias": true, "logprobs": true,
+	"top_logprobs": true, "user": true, "seed": true, "tools": true, "tool_choice": true,
+	"parallel_tool_calls": true, "response_format": true, "functions": true, "function_call": true,
+	"metadata": true, "store": true, "service_tier": true, "modalities": true, "audio": true,
+	"prediction": true, "reasoning_effort": true, "web_search_options": true,
+}
+
+// messageRoles are the roles a chat message may have
+var messageRoles = map[string]bool{
+	"system": true, "developer": true, "user": true, "assistant": true, "tool": true, "function": true,
+}
+
+// contentPartTypes are the types of the parts of an array message content
+var contentPartTypes = map[string]bool{
+	"text": true, "image_url": true, "input_audio": true, "file": true, "refusal": true,
+}
+
+// validationError is a request that doesn't match the chat completion schema
+type validationError struct {
+	param   string
+	message string
+}
+
+func invalid(param string, format string, args ...interface{}) *validationError {
+	return &validationError{param: param, message: fmt.Sprintf(format, args...)}
+}
+
+// RequestValidator checks chat completion requests against the OpenAI schema before they reach
+// Bifrost, answering mismatches with OpenAI style 400s
+type RequestValidator struct {
+	limits ValidationLimits
+
+	validated atomic.Int64
+	rejected  atomic.Int64
+	totalNs   atomic.Int64
+}
+
+// NewRequestValidator creates a validator enforcing the given limits
+func NewRequestValidator(limits ValidationLimits) *RequestValidator {
+	return &RequestValidator{limits: limits}
+}
+
+// validateNumber checks that an optional field is a number within [lo, hi]
+func validateNumber(fields map[string]json.RawMessage, name string, lo, hi float64) *validationError {
+	raw, ok := fields[name]
+	if !ok || string(raw) == "null" {
+		return nil
+	}
+	var v float64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return invalid(name, "Invalid type for '%s': expected a number.", name)
+	}
+	if v < lo || v > hi {
+		return invalid(name, "Invalid '%s': %g is not between %g and %g.", name, v, lo, hi)
+	}
+	return nil
+}
+
+// validateInteger checks that an optional field is an integer of at least lo
+func validateInteger(fields map[string]json.RawMessage, name string, lo int64) *validationError {
+	raw, ok := fields[name]
+	if !ok || string(raw) == "null" {
+		return nil
+	}
+	var v int64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return invalid(name, "Invalid type for '%s': expected an integer.", name)
+	}
+	if v < lo {
+		return invalid(name, "Invalid '%s': integer below minimum value %d.", name, lo)
+	}
+	return nil
+}
+
+// validateContent checks a message's content: a string, an array of content parts, or null for
+// assistant messages that call tools
+func (v *RequestValidator) validateContent(param string, raw json.RawMessage, role string, hasToolCalls bool) *validationError {
+	if len(raw) == 0 || string(raw) == "null" {
+		if role == "assistant" && hasToolCalls {
+			return nil
+		}
+		return invalid(param, "Invalid value for '%s': expected a string or an array of content parts, got null.", param)
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if len(text) > v.limits.MaxContentLength {
+			return invalid(param, "Invalid '%s': string too long. Expected a string with maximum length %d, but got a string with length %d instead.",
+				param, v.limits.MaxContentLength, len(text))
+		}
+		return nil
+	}
+
+	var parts []struct {
+		Type     string          `json:"type"`
+		Text     *string         `json:"text"`
+		ImageURL json.RawMessage `json:"image_url"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return invalid(param, "Invalid type for '%s': expected a string or an array of content parts.", param)
+	}
+	if len(parts) == 0 {
+		return invalid(param, "Invalid '%s': empty array. Expected an array with minimum length 1.", param)
+	}
+	for i, part := range parts {
+		partParam := fmt.Sprintf("%s[%d]", param, i)
+		if !contentPartTypes[part.Type] {
+			return invalid(partParam+".type", "Invalid value: '%s'. Supported values are: 'text', 'image_url', 'input_audio', 'file' and 'refusal'.", part.Type)
+		}
+		switch part.Type {
+		case "text":
+			if part.Text == nil {
+				return invalid(partParam+".text", "Missing required parameter: '%s.text'.", partParam)
+			}
+			if len(*part.Text) > v.limits.MaxContentLength {
+				return invalid(partParam+".text", "Invalid '%s.text': string too long. Expected a string with maximum length %d, but got a string with length %d instead.",
+					partParam, v.limits.MaxContentLength, len(*part.Text))
+			}
+		case "image_url":
+			var image struct {
+				URL *string `json:"url"`
+			}
+			if err := json.Unmarshal(part.ImageURL, &image); err != nil || image.URL == nil {
+				return invalid(partParam+".image_url.url", "Missing required parameter: '%s.image_url.url'.", partParam)
+			}
+		}
+	}
+	return nil
+}
+
+// validateMessages checks every message's role, content and tool fields
+func (v *RequestValidator) validateMessages(raw json.RawMessage) *validationError {
+	var messages []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return invalid("messages", "Invalid type for 'messages': expected an array of objects.")
+	}
+	if len(messages) == 0 {
+		return invalid("messages", "Invalid 'messages': empty array. Expected an array with minimum length 1, but got an empty array instead.")
+	}
+	if len(messages) > v.limits.MaxMessages {
+		return invalid("messages", "Invalid 'messages': array too long. Expected an array with maximum length %d, but got an array with length %d instead.",
+			v.limits.MaxMessages, len(messages))
+	}
+
+	for i, msg := range messages {
+		param := fmt.Sprintf("messages[%d]", i)
+		var role string
+		if err := json.Unmarshal(msg["role"], &role); err != nil || role == "" {
+			return invalid(param+".role", "Missing required parameter: '%s.role'.", param)
+		}
+		if !messageRoles[role] {
+			return invalid(param+".role", "Invalid value: '%s'. Supported values are: 'system', 'developer', 'user', 'assistant', 'tool' and 'function'.", role)
+		}
+
+		_, hasToolCalls := msg["tool_calls"]
+		_, hasFunctionCall := msg["function_call"]
+		if err := v.validateContent(param+".content", msg["content"], role, hasToolCalls || hasFunctionCall); err != nil {
+			return err
+		}
+
+		if role == "tool" {
+			var id string
+			if err := json.Unmarshal(msg["tool_call_id"], &id); err != nil || id == "" {
+				return invalid(param+".tool_call_id", "Missing required parameter: '%s.tool_call_id'.", param)
+			}
+		}
+		if hasToolCalls {
+			var calls []struct {
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function *struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			}
+			if err := json.Unmarshal(msg["tool_calls"], &calls); err != nil {
+				return invalid(param+".tool_calls", "Invalid type for '%s.tool_calls': expected an array of objects.", param)
+			}
+			for j, call := range calls {
+				if call.ID == "" || call.Type != "function" || call.Function == nil || call.Function.Name == "" {
+					return invalid(fmt.Sprintf("%s.tool_calls[%d]", param, j), "Invalid '%s.tool_calls[%d]': expected an id, type 'function' and a function name.", param, j)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateTools checks the tool definitions of a request
+func validateTools(raw json.RawMessage) *validationError {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var tools []struct {
+		Type     string `json:"type"`
+		Function *struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &tools); err != nil {
+		return invalid("tools", "Invalid type for 'tools': expected an array of objects.")
+	}
+	for i, tool := range tools {
+		if tool.Type != "function" {
+			return invalid(fmt.Sprintf("tools[%d].type", i), "Invalid value: '%s'. Supported values are: 'function'.", tool.Type)
+		}
+		if tool.Function == nil || tool.Function.Name == "" {
+			return invalid(fmt.Sprintf("tools[%d].function.name", i), "Missing required parameter: 'tools[%d].function.name'.", i)
+		}
+	}
+	return nil
+}
+
+// validate checks a request body against the chat completion schema
+func (v *RequestValidator) validate(body []byte) *validationError {
+	if len(body) > v.limits.MaxBodyBytes {
+		return invalid("", "Request body too large: %d bytes, the maximum is %d.", len(body), v.limits.MaxBodyBytes)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return invalid("", "We could not parse the JSON body of your request. The request body must be a JSON object.")
+	}
+	for name := range fields {
+		if !chatParams[name] {
+			return invalid(name, "Unrecognized request argument supplied: %s", name)
+		}
+	}
+
+	var model string
+	if err := json.Unmarshal(fields["model"], &model); err != nil || model == "" {
+		return invalid("model", "You must provide a model parameter.")
+	}
+	if err := v.validateMessages(fields["messages"]); err != nil {
+		return err
+	}
+
+	checks := []*validationError{
+		validateNumber(fields, "temperature", 0, 2),
+		validateNumber(fields, "top_p", 0, 1),
+		validateNumber(fields, "presence_penalty", -2, 2),
+		validateNumber(fields, "frequency_penalty", -2, 2),
+		validateInteger(fields, "n", 1),
+		validateInteger(fields, "max_tokens", 1),
+		validateInteger(fields, "max_completion_tokens", 1),
+		validateTools(fields["tools"]),
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := fields["stream"]; ok && string(raw) != "null" {
+		var stream bool
+		if err := json.Unmarshal(raw, &stream); err != nil {
+			return invalid("stream", "Invalid type for 'stream': expected a boolean.")
+		}
+	}
+	if raw, ok := fields["stop"]; ok && string(raw) != "null" {
+		var one string
+		var many []string
+		if json.Unmarshal(raw, &one) != nil {
+			if err := json.Unmarshal(raw, &many); err != nil {
+				return invalid("stop", "Invalid type for 'stop': expected a string or an array of strings.")
+			}
+			if len(many) > 4 {
+				return invalid("stop", "Invalid 'stop': array too long. Expected an array with maximum length 4, but got an array with length %d instead.", len(many))
+			}
+		}
+	}
+	return nil
+}
+
+// Wrap answers requests that don't match the chat completion schema with a 400 instead of calling next
+func (v *RequestValidator) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		err := v.validate(ctx.PostBody())
+		v.totalNs.Add(int64(time.Since(start)))
+
+		if err != nil {
+			v.rejected.Add(1)
+			var param interface{}
+			if err.param != "" {
+				param = err.param
+			}
+			body, _ := json.Marshal(map[string]interface{}{
+				"error": map[string]interface{}{"message": err.message, "type": "invalid_request_error", "param": param, "code": nil},
+			})
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetContentType("application/json")
+			ctx.SetBody(body)
+			return
+		}
+		v.validated.Add(1)
+		next(ctx)
+	}
+}
+
+// Stats returns the validator's counters, nil when validation is disabled
+func (v *RequestValidator) Stats() *ValidationStats {
+	if v == nil {
+		return nil
+	}
+	stats := &ValidationStats{Validated: v.validated.Load(), Rejected: v.rejected.Load()}
+	if total := stats.Validated + stats.Rejected; total > 0 {
+		stats.MeanUs = float64(v.totalNs.Load()) / float64(total) / 1000
+	}
+	return stats
+}
@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// VirtualKeyHeader is the header Bifrost reads virtual keys from; Authorization: Bearer works too
+const VirtualKeyHeader = "x-bf-vk"
+
+// VirtualKey is one key of the virtual keys file, mapping a client credential to a tenant
+type VirtualKey struct {
+	Key    string   `json:"key"`
+	Tenant string   `json:"tenant"`
+	Models []string `json:"models"` // Models the tenant may use, without provider prefix; empty allows every model
+	RPM    int      `json:"rpm"`    // Requests per minute, 0 for no limit
+	Burst  int      `json:"burst"`  // Requests the tenant can send at once, defaults to RPM
+}
+
+// tenant holds the limits and counters of one tenant, shared by all of its keys
+type tenant struct {
+	name   string
+	models map[string]bool // nil allows every model
+
+	mu        sync.Mutex
+	rpm       float64
+	burst     float64
+	available float64
+	updated   time.Time
+
+	requests    atomic.Int64
+	rateLimited atomic.Int64
+	denied      atomic.Int64
+	errors      atomic.Int64
+}
+
+// TenantStats are a tenant's counters, reported on /metrics
+type TenantStats struct {
+	Requests    int64 `json:"requests"`     // Requests let through to the gateway
+	RateLimited int64 `json:"rate_limited"` // Rejected with 429 for exceeding the tenant's RPM
+	Denied      int64 `json:"denied"`       // Rejected with 403 for a model the tenant may not use
+	Errors      int64 `json:"errors"`       // Let through but answered with a non-2xx status
+}
+
+// VirtualKeyStats are the counters of every tenant and of requests without a valid key
+type VirtualKeyStats struct {
+	Tenants      map[string]TenantStats `json:"tenants"`
+	Unauthorized int64                  `json:"unauthorized"`
+}
+
+// VirtualKeys authenticates requests by virtual key and enforces each tenant's allowed models and
+// rate limit before they reach Bifrost, the bookkeeping multi-tenant gateways do on every request
+type VirtualKeys struct {
+	keys         map[string]*tenant
+	tenants      map[string]*tenant
+	retryAfter   string
+	unauthorized atomic.Int64
+}
+
+// LoadVirtualKeys reads a JSON array of virtual keys. Keys of the same tenant share its limits;
+// the models and limits of a tenant's first key apply to all of them.
+func LoadVirtualKeys(path string, retryAfter time.Duration) (*VirtualKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read virtual keys: %v", err)
+	}
+	var keys []VirtualKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse virtual keys: %v", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no virtual keys in %s", path)
+	}
+
+	seconds := max(int(retryAfter.Round(time.Second)/time.Second), 1)
+	v := &VirtualKeys{
+		keys:       make(map[string]*tenant, len(keys)),
+		tenants:    make(map[string]*tenant),
+		retryAfter: strconv.Itoa(seconds),
+	}
+	now := time.Now()
+	for _, key := range keys {
+		if key.Key == "" || key.Tenant == "" {
+			return nil, fmt.Errorf("every virtual key needs a key and a tenant")
+		}
+		if _, exists := v.keys[key.Key]; exists {
+			return nil, fmt.Errorf("virtual key of tenant %s is listed twice", key.Tenant)
+		}
+		if key.RPM < 0 || key.Burst < 0 {
+			return nil, fmt.Errorf("tenant %s has a negative rate limit", key.Tenant)
+		}
+
+		t, ok := v.tenants[key.Tenant]
+		if !ok {
+			burst := key.Burst
+			if burst == 0 {
+				burst = key.RPM
+			}
+			t = &tenant{name: key.Tenant, rpm: float64(key.RPM), burst: float64(burst), available: float64(burst), updated: now}
+			if len(key.Models) > 0 {
+				t.models = make(map[string]bool, len(key.Models))
+				for _, model := range key.Models {
+					t.models[model] = true
+				}
+			}
+			v.tenants[key.Tenant] = t
+		}
+		v.keys[key.Key] = t
+	}
+	return v, nil
+}
+
+// allow takes a request from the tenant's token bucket, or returns false when it is empty
+func (t *tenant) allow() bool {
+	if t.rpm == 0 {
+		return true
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.available = min(t.burst, t.available+now.Sub(t.updated).Seconds()*t.rpm/60)
+	t.updated = now
+	if t.available < 1 {
+		return false
+	}
+	t.available--
+	return true
+}
+
+// credential returns the virtual key sent with a request, from x-bf-vk, a bearer token or the
+// x-api-key header Anthropic SDKs send
+func credential(ctx *fasthttp.RequestCtx) string {
+	if key := ctx.Request.Header.Peek(VirtualKeyHeader); len(key) > 0 {
+		return string(key)
+	}
+	if key := ctx.Request.Header.Peek("x-api-key"); len(key) > 0 {
+		return string(key)
+	}
+	auth := string(ctx.Request.Header.Peek("Authorization"))
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// writeOpenAIError answers a rejected request with an OpenAI style invalid_request_error
+func writeOpenAIError(ctx *fasthttp.RequestCtx, status int, code, message string) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": "invalid_request_error", "code": code},
+	})
+	ctx.SetBody(body)
+}
+
+// Wrap rejects requests without a known key (401), for models their tenant may not use (403) or
+// over their tenant's rate limit (429), and counts the rest per tenant. Models are matched as
+// sent by the client, with any provider/ prefix removed: from the JSON body, or from the model
+// query parameter of requests without a body, like realtime sessions.
+func (v *VirtualKeys) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		t, ok := v.keys[credential(ctx)]
+		if !ok {
+			v.unauthorized.Add(1)
+			writeOpenAIError(ctx, fasthttp.StatusUnauthorized, "invalid_api_key", "Invalid virtual key")
+			return
+		}
+
+		if t.models != nil {
+			var req struct {
+				Model string `json:"model"`
+			}
+			if body := ctx.PostBody(); len(body) > 0 {
+				json.Unmarshal(body, &req)
+			} else {
+				req.Model = string(ctx.QueryArgs().Peek("model"))
+			}
+			model := req.Model
+			if i := strings.Index(model, "/"); i >= 0 {
+				model = model[i+1:]
+			}
+			if !t.models[model] {
+				t.denied.Add(1)
+				writeOpenAIError(ctx, fasthttp.StatusForbidden, "model_not_allowed",
+					fmt.Sprintf("Tenant %s is not allowed to use model %s", t.name, req.Model))
+				return
+			}
+		}
+
+		if !t.allow() {
+			t.rateLimited.Add(1)
+			ctx.Response.Header.Set("Retry-After", v.retryAfter)
+			writeOpenAIError(ctx, fasthttp.StatusTooManyRequests, "rate_limit_exceeded",
+				fmt.Sprintf("Tenant %s exceeded its rate limit of %.0f requests per minute", t.name, t.rpm))
+			return
+		}
+
+		t.requests.Add(1)
+		next(ctx)
+		// A realtime session that was upgraded succeeded
+		if status := ctx.Response.StatusCode(); (status < 200 || status > 299) && status != fasthttp.StatusSwitchingProtocols {
+			t.errors.Add(1)
+		}
+	}
+}
+
+// Stats returns the counters of every tenant, nil when virtual keys are disabled
+func (v *VirtualKeys) Stats() *VirtualKeyStats {
+	if v == nil {
+		return nil
+	}
+	names := make([]string, 0, len(v.tenants))
+	for name := range v.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := &VirtualKeyStats{Tenants: make(map[string]TenantStats, len(names)), Unauthorized: v.unauthorized.Load()}
+	for _, name := range names {
+		t := v.tenants[name]
+		stats.Tenants[name] = TenantStats{
+			Requests:    t.requests.Load(),
+			RateLimited: t.rateLimited.Load(),
+			Denied:      t.denied.Load(),
+			Errors:      t.errors.Load(),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// Latencies are binned log-linearly in microseconds: exact below 32µs, then 32 bins per power
+// of two (about 3% precision) up to 2^40µs
+const (
+	windowSubBins    = 32
+	windowMagnitudes = 35
+	windowBins       = windowSubBins + windowMagnitudes*windowSubBins
+)
+
+// windowBucket is the histogram of one second
+type windowBucket struct {
+	second int64
+	total  uint64
+	counts [windowBins]uint32
+}
+
+// LatencyWindow is a sliding window of per-second latency histograms. Memory is fixed by the
+// span, so it can record for the length of a soak test.
+type LatencyWindow struct {
+	mu      sync.Mutex
+	buckets []windowBucket
+}
+
+// WindowPercentiles summarizes the latencies recorded in a window
+type WindowPercentiles struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// NewLatencyWindow creates a window able to report on up to span of history
+func NewLatencyWindow(span time.Duration) *LatencyWindow {
+	return &LatencyWindow{buckets: make([]windowBucket, max(int(span/time.Second), 1))}
+}
+
+// Record adds a latency to the current second
+func (w *LatencyWindow) Record(d time.Duration) {
+	second := time.Now().Unix()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	b := &w.buckets[second%int64(len(w.buckets))]
+	if b.second != second {
+		*b = windowBucket{second: second}
+	}
+	b.counts[windowBin(d)]++
+	b.total++
+}
+
+// Percentiles reports latency percentiles in milliseconds over the last period
+func (w *LatencyWindow) Percentiles(period time.Duration) WindowPercentiles {
+	since := time.Now().Unix() - int64(period/time.Second)
+
+	w.mu.Lock()
+	var merged [windowBins]uint64
+	var total uint64
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if b.second <= since || b.total == 0 {
+			continue
+		}
+		for bin, n := range b.counts {
+			merged[bin] += uint64(n)
+		}
+		total += b.total
+	}
+	w.mu.Unlock()
+
+	if total == 0 {
+		return WindowPercentiles{}
+	}
+	return WindowPercentiles{
+		Count: int64(total),
+		P50:   windowQuantile(&merged, total, 0.50),
+		P95:   windowQuantile(&merged, total, 0.95),
+		P99:   windowQuantile(&merged, total, 0.99),
+	}
+}
+
+func windowBin(d time.Duration) int {
+	us := uint64(max(d/time.Microsecond, 0))
+	if us < windowSubBins {
+		return int(us)
+	}
+	magnitude := bits.Len64(us) - 6 // 32-63µs is magnitude 0
+	if magnitude >= windowMagnitudes {
+		return windowBins - 1
+	}
+	return windowSubBins + magnitude*windowSubBins + int(us>>magnitude) - windowSubBins
+}
+
+// windowBinValue returns the midpoint of a bin in milliseconds
+func windowBinValue(bin int) float64 {
+	if bin < windowSubBins {
+		return float64(bin) / 1000
+	}
+	magnitude := (bin - windowSubBins) / windowSubBins
+	lower := uint64(windowSubBins+(bin-windowSubBins)%windowSubBins) << magnitude
+	upper := lower + 1<<magnitude
+	return float64(lower+upper) / 2 / 1000
+}
+
+func windowQuantile(counts *[windowBins]uint64, total uint64, q float64) float64 {
+	rank := uint64(q * float64(total))
+	var seen uint64
+	for bin, n := range counts {
+		seen += n
+		if seen > rank {
+			return windowBinValue(bin)
+		}
+	}
+	return windowBinValue(windowBins - 1)
+}
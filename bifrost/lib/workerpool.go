@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// WorkerPool runs handlers on a fixed set of long-lived goroutines fed from a bounded queue.
+// fasthttp starts a goroutine per connection and lets every request run; the pool bounds how
+// many run at once and rejects requests with 429 once its queue is full, applying back-pressure
+// before Bifrost's own queue does. The connection's goroutine waits for its request's turn.
+type WorkerPool struct {
+	workers    int
+	queue      chan *poolJob
+	retryAfter string
+
+	busy      atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+	waitedNs  atomic.Int64 // Total time completed requests spent queued
+	queuePeak atomic.Int64
+}
+
+// poolJob is one request handed to a worker; done is closed once the handler returned
+type poolJob struct {
+	ctx      *fasthttp.RequestCtx
+	next     fasthttp.RequestHandler
+	queuedAt time.Time
+	done     chan struct{}
+}
+
+// WorkerPoolStats is the state of the worker pool, reported on /metrics
+type WorkerPoolStats struct {
+	Workers         int     `json:"workers"`
+	Busy            int64   `json:"busy"`
+	QueueDepth      int     `json:"queue_depth"`
+	QueueCapacity   int     `json:"queue_capacity"`
+	QueuePeak       int64   `json:"queue_peak"` // Deepest the queue has been
+	Completed       int64   `json:"completed"`
+	Rejected        int64   `json:"rejected_requests"`
+	MeanQueueWaitMs float64 `json:"mean_queue_wait_ms"`
+}
+
+// NewWorkerPool starts workers goroutines sharing a queue of queueSize waiting requests
+func NewWorkerPool(workers, queueSize int, retryAfter time.Duration) *WorkerPool {
+	seconds := max(int(retryAfter.Round(time.Second)/time.Second), 1)
+	p := &WorkerPool{
+		workers:    workers,
+		queue:      make(chan *poolJob, queueSize),
+		retryAfter: strconv.Itoa(seconds),
+	}
+	for range workers {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	for job := range p.queue {
+		p.busy.Add(1)
+		p.waitedNs.Add(int64(time.Since(job.queuedAt)))
+		job.next(job.ctx)
+		p.busy.Add(-1)
+		p.completed.Add(1)
+		close(job.done)
+	}
+}
+
+// Wrap queues requests for the pool's workers to run next, rejecting them when the queue is full
+func (p *WorkerPool) Wrap(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		job := &poolJob{ctx: ctx, next: next, queuedAt: time.Now(), done: make(chan struct{})}
+		select {
+		case p.queue <- job:
+		default:
+			p.rejected.Add(1)
+			ctx.Response.Header.Set("Retry-After", p.retryAfter)
+			ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+			ctx.SetContentType("application/json")
+			ctx.SetBodyString(`{"error":{"message":"gateway worker pool is full","type":"queue_full"}}`)
+			return
+		}
+		for depth, peak := int64(len(p.queue)), p.queuePeak.Load(); depth > peak; peak = p.queuePeak.Load() {
+			if p.queuePeak.CompareAndSwap(peak, depth) {
+				break
+			}
+		}
+		<-job.done
+	}
+}
+
+// Stats returns the pool state, nil when handlers run unbounded
+func (p *WorkerPool) Stats() *WorkerPoolStats {
+	if p == nil {
+		return nil
+	}
+	stats := &WorkerPoolStats{
+		Workers:       p.workers,
+		Busy:          p.busy.Load(),
+		QueueDepth:    len(p.queue),
+		QueueCapacity: cap(p.queue),
+		QueuePeak:     p.queuePeak.Load(),
+		Completed:     p.completed.Load(),
+		Rejected:      p.rejected.Load(),
+	}
+	if stats.Completed > 0 {
+		stats.MeanQueueWaitMs = float64(p.waitedNs.Load()) / float64(stats.Completed) / float64(time.Millisecond)
+	}
+	return stats
+}
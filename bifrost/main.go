@@ -2,157 +2,785 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"runtime"
+	"slices"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fasthttp/router"
 	"github.com/maximhq/bifrost-gateway/lib"
 	bifrost "github.com/maximhq/bifrost/core"
 	"github.com/maximhq/bifrost/core/schemas"
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/pprofhandler"
 )
 
 var (
-	openaiKey string
-	port      string
-	proxyURL  string
-	debug     bool
-
-	concurrency     int
-	bufferSize      int
-	initialPoolSize int
+	openaiKey   string
+	openaiKeys  string
+	keyStrategy string
+	keyFromEnv  bool
+	configFile  string
+	port        string
+	listenAddr  string
+	proxyURL    string
+	upstreamURL string
+	debug       bool
+	fastPath    bool
+	jsonEncoder string
+	routesFile  string
+
+	minifyResponse string
+
+	passthrough bool
+
+	realtime bool
+
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+
+	concurrency       int
+	bufferSize        int
+	initialPoolSize   int
+	serverConcurrency int
+
+	requestTimeout      time.Duration
+	maxRetries          int
+	retryBackoffInitial time.Duration
+	retryBackoffMax     time.Duration
+
+	admissionControl bool
+	retryAfter       time.Duration
+	modelPools       string
+	virtualKeysFile  string
+	adminKey         string
+
+	breakerErrorRate   float64
+	breakerMinRequests int
+	breakerWindow      time.Duration
+	breakerOpen        time.Duration
+	breakerProbes      int
+
+	fallback       bool
+	fallbackBody   string
+	fallbackStatus int
+
+	strictValidation      bool
+	validationMaxBody     int
+	validationMaxMessages int
+	validationMaxContent  int
+
+	timingHistograms bool
+	pprof            bool
+
+	anthropicIngress bool
+
+	ipConcurrency int
+	ipRPS         float64
+	ipBurst       int
+
+	streamBodyThreshold int
+	maxRequestBody      int
+
+	workers int
+
+	workerPool      int
+	workerPoolQueue int
+
+	enableHTTP2 bool
+	h2c         bool
+
+	usage       bool
+	usageBuffer int
+
+	logErrors bool
+
+	cacheTTL        time.Duration
+	cacheMaxEntries int
+
+	idempotencyTTL     time.Duration
+	idempotencyMaxKeys int
+
+	accessLogFile   string
+	accessLogSample float64
+	accessLogBuffer int
+
+	gomaxprocs int
+	gogc       string
+	gomemlimit string
+
+	memoryInterval   time.Duration
+	memoryHighWater  string
+	memoryProfileDir string
+
+	ballastSize        string
+	prewarm            int
+	prewarmConcurrency int
+	prewarmModel       string
 )
 
 func init() {
 	flag.StringVar(&openaiKey, "openai-key", "", "OpenAI API key")
+	flag.StringVar(&openaiKeys, "openai-keys", "", "Comma separated OpenAI API keys to spread requests over, each optionally with a :weight (e.g. sk-a:3,sk-b)")
+	flag.StringVar(&keyStrategy, "key-strategy", "weighted", "How requests are spread over -openai-keys: weighted, round-robin or least-in-flight")
+	flag.StringVar(&configFile, "config", "", "JSON file overriding the key, proxy, concurrency, buffer size, timeout and retries; re-read on SIGHUP or POST /admin/reload")
 	flag.StringVar(&port, "port", "3001", "Port to run the server on")
+	flag.StringVar(&listenAddr, "listen", "", "Serve on a unix domain socket (unix:/tmp/bifrost.sock) instead of -port")
 	flag.StringVar(&proxyURL, "proxy", "", "Proxy URL (e.g., http://localhost:8080)")
+	flag.StringVar(&upstreamURL, "upstream-url", "", "Base URL OpenAI requests are sent to instead of https://api.openai.com, e.g. http://localhost:8000 for the mocker")
 	flag.BoolVar(&debug, "debug", false, "Enable debug mode")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Server certificate; enables TLS when set together with -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "Server private key")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "CA used to verify client certificates; enables mutual TLS")
+	flag.StringVar(&routesFile, "routes", "", "JSON routing table mapping model aliases to weighted provider/model routes")
+	flag.BoolVar(&fastPath, "fast-path", false, "Use pooled request objects and sonic JSON encoding in the handler")
+	flag.StringVar(&jsonEncoder, "json-encoder", "", "Encoder for chat completion responses: std, sonic or jsoniter (default std, sonic with -fast-path)")
+	flag.StringVar(&minifyResponse, "minify-response", "", "Strip chat completion responses before sending: metadata drops Bifrost's extra_fields, all also drops null and empty fields (default off)")
+	flag.BoolVar(&realtime, "realtime", false, "Proxy WebSocket connections on /v1/realtime to the upstream's realtime endpoint, copying frames through unchanged")
+	flag.BoolVar(&passthrough, "passthrough", false, "Skip Bifrost and proxy the raw request body to the upstream with a fasthttp client, to measure the wrapper baseline")
 
 	flag.IntVar(&concurrency, "concurrency", 5000, "Concurrency level")
 	flag.IntVar(&bufferSize, "buffer-size", 5000, "Buffer size")
 	flag.IntVar(&initialPoolSize, "initial-pool-size", 5000, "Initial pool size")
+	flag.IntVar(&serverConcurrency, "server-concurrency", 0, "Maximum concurrent connections served by fasthttp (0 uses the fasthttp default)")
+	flag.DurationVar(&requestTimeout, "request-timeout", 12*time.Second, "Upstream request timeout (whole seconds)")
+	flag.IntVar(&maxRetries, "max-retries", 3, "Retries of upstream requests failing with 429 or 5xx")
+	flag.DurationVar(&retryBackoffInitial, "retry-backoff-initial", 100*time.Millisecond, "Backoff before the first retry, doubled for each further retry")
+	flag.DurationVar(&retryBackoffMax, "retry-backoff-max", 5*time.Second, "Maximum backoff between retries")
+	flag.BoolVar(&enableHTTP2, "http2", false, "Accept HTTP/2 over TLS (requires -tls-cert); requests are terminated by net/http and handed to fasthttp in-process")
+	flag.BoolVar(&h2c, "h2c", false, "Accept cleartext HTTP/2 (prior knowledge) without TLS")
+	flag.BoolVar(&usage, "usage", false, "Record model, token usage, latency and status of every request and serve them on /usage")
+	flag.IntVar(&usageBuffer, "usage-buffer", 10000, "Number of recent requests kept for /usage")
+	flag.BoolVar(&logErrors, "log-errors", false, "Log every error response with the request's X-Request-ID")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 0, "Cache successful responses for identical request bodies this long, coalescing identical in-flight requests (0 disables)")
+	flag.IntVar(&cacheMaxEntries, "cache-max-entries", 10000, "Maximum cached responses; the oldest are evicted first")
+	flag.DurationVar(&idempotencyTTL, "idempotency-ttl", 0, "Share one upstream call between requests with the same Idempotency-Key header and replay its response to duplicates this long (0 disables)")
+	flag.IntVar(&idempotencyMaxKeys, "idempotency-max-keys", 10000, "Maximum completed idempotency keys kept for replay; the oldest are evicted first")
+	flag.StringVar(&accessLogFile, "access-log", "", "Write JSON access logs to this file (- for stdout)")
+	flag.Float64Var(&accessLogSample, "access-log-sample", 1, "Fraction of requests written to the access log (0-1)")
+	flag.IntVar(&accessLogBuffer, "access-log-buffer", 10000, "Access log entries buffered for the background writer before new ones are dropped")
+	flag.IntVar(&gomaxprocs, "gomaxprocs", 0, "GOMAXPROCS for the gateway (0 uses every CPU, split between -workers)")
+	flag.StringVar(&gogc, "gogc", "", "GOGC percent or off (empty keeps the GOGC environment variable or the default of 100)")
+	flag.DurationVar(&memoryInterval, "memory-interval", time.Second, "How often the gateway samples its own RSS and heap for /metrics and -memory-high-water (0 disables)")
+	flag.StringVar(&memoryHighWater, "memory-high-water", "", "RSS, e.g. 2GiB, above which a warning is logged and a heap profile written (empty for no alarm)")
+	flag.StringVar(&memoryProfileDir, "memory-profile-dir", os.TempDir(), "Directory for the heap profiles written by -memory-high-water")
+	flag.StringVar(&ballastSize, "ballast", "", "Heap ballast kept allocated to make the GC run less often, e.g. 1GiB (empty for none)")
+	flag.IntVar(&prewarm, "prewarm", 0, "Synthetic chat completions sent through the handler before listening, to fill pools and open upstream connections (0 disables)")
+	flag.IntVar(&prewarmConcurrency, "prewarm-concurrency", 32, "Warm-up requests sent at once with -prewarm")
+	flag.StringVar(&prewarmModel, "prewarm-model", "openai/gpt-4o-mini", "Model the -prewarm requests ask for")
+	flag.StringVar(&gomemlimit, "gomemlimit", "", "GOMEMLIMIT, e.g. 512MiB or off (empty keeps the GOMEMLIMIT environment variable)")
+	flag.IntVar(&workers, "workers", 1, "Number of gateway processes sharing the port via SO_REUSEPORT (each gets NumCPU/workers GOMAXPROCS)")
+	flag.IntVar(&workerPool, "worker-pool", 0, "Run handlers on this many pooled goroutines instead of one per request, rejecting requests with 429 once the queue is full (0 disables)")
+	flag.IntVar(&workerPoolQueue, "worker-pool-queue", 0, "Requests waiting for a -worker-pool goroutine before new ones are rejected (default: the pool size)")
+	flag.BoolVar(&admissionControl, "admission-control", false, "Reject requests with 429 once concurrency + buffer-size requests are in flight, instead of blocking")
+	flag.StringVar(&modelPools, "model-pools", "", "Per-model concurrency pools, as model=concurrency[:buffer] (e.g., gpt-4o=100:1000,gpt-4o-mini=500). A gate in the gateway in front of Bifrost, not a Bifrost provider setting; -routes aliases count against their target model's pool")
+	flag.StringVar(&virtualKeysFile, "virtual-keys", "", "JSON file of virtual keys mapping client credentials to tenants with allowed models and rate limits")
+	flag.StringVar(&adminKey, "admin-key", "", "Bearer token POST /admin/reload requires; with -virtual-keys the endpoint is disabled without one")
+	flag.Float64Var(&breakerErrorRate, "breaker-error-rate", 0, "Open a circuit breaker around Bifrost once this fraction of requests in the window fail with 5xx (0-1, 0 disables)")
+	flag.IntVar(&breakerMinRequests, "breaker-min-requests", 20, "Requests the breaker window needs before its error rate can open the breaker")
+	flag.DurationVar(&breakerWindow, "breaker-window", 10*time.Second, "Rolling window the breaker error rate is computed over (whole seconds)")
+	flag.DurationVar(&breakerOpen, "breaker-open", 5*time.Second, "How long the breaker answers 503 before letting probes through")
+	flag.BoolVar(&fallback, "fallback", false, "Answer chat completions whose upstream call failed, timed out or hit the open breaker with a canned degraded response marked X-Bifrost-Fallback")
+	flag.StringVar(&fallbackBody, "fallback-body", "", "JSON file with the -fallback response body (default: a built-in degraded completion)")
+	flag.IntVar(&fallbackStatus, "fallback-status", fasthttp.StatusOK, "HTTP status of -fallback responses")
+	flag.IntVar(&breakerProbes, "breaker-probes", 3, "Probe requests let through while half-open; all must succeed to close the breaker")
+	flag.BoolVar(&strictValidation, "strict-validation", false, "Validate requests against the OpenAI chat completion schema before calling Bifrost, answering mismatches with 400")
+	flag.IntVar(&validationMaxBody, "validation-max-body", 8<<20, "Largest request body in bytes accepted with -strict-validation")
+	flag.IntVar(&validationMaxMessages, "validation-max-messages", 2048, "Most messages in a request accepted with -strict-validation")
+	flag.IntVar(&validationMaxContent, "validation-max-content", 1<<20, "Longest message content in bytes accepted with -strict-validation")
+	flag.BoolVar(&pprof, "pprof", false, "Serve Go's pprof profiles on /debug/pprof/, for the runner's -profile")
+	flag.BoolVar(&timingHistograms, "timing-histograms", false, "Record handler, Bifrost and provider stage timings of every request into histograms served on /metrics/prometheus")
+	flag.BoolVar(&anthropicIngress, "anthropic", false, "Also serve the Anthropic Messages API on /v1/messages, translating requests to Bifrost chat completions")
+	flag.IntVar(&ipConcurrency, "ip-concurrency", 0, "Requests each client IP may have in flight before getting 429s (0 disables)")
+	flag.Float64Var(&ipRPS, "ip-rps", 0, "Requests per second each client IP may send, enforced with a token bucket (0 disables)")
+	flag.IntVar(&ipBurst, "ip-burst", 0, "Requests a client IP can send at once under -ip-rps (default: -ip-rps rounded up)")
+	flag.IntVar(&streamBodyThreshold, "stream-body-threshold", 0, "Decode request bodies larger than this many bytes straight from the connection instead of buffering them first (0 disables)")
+	flag.IntVar(&maxRequestBody, "max-request-body", fasthttp.DefaultMaxRequestBodySize, "Largest request body in bytes; larger requests get 413")
+	flag.DurationVar(&retryAfter, "retry-after", time.Second, "Retry-After sent with 429 responses when admission control rejects a request")
 
 	flag.Parse()
 
-	if openaiKey == "" {
-		file, err := os.Open("../.env")
-		if err != nil {
-			log.Fatalf("Error loading .env file: %v", err)
+	if requestTimeout < time.Second {
+		log.Fatalf("-request-timeout must be at least 1s")
+	}
+
+	if err := validateListenAddr(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if upstreamURL != "" {
+		if err := lib.ValidateUpstreamURL(upstreamURL); err != nil {
+			log.Fatalf("Invalid -upstream-url: %v", err)
 		}
-		defer file.Close()
+	}
 
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := scanner.Text()
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 && parts[0] == "OPENAI_API_KEY" {
-				openaiKey = parts[1]
-				break
-			}
+	if accessLogSample < 0 || accessLogSample > 1 {
+		log.Fatalf("-access-log-sample must be between 0 and 1")
+	}
+
+	if enableHTTP2 && tlsCert == "" {
+		log.Fatalf("-http2 requires -tls-cert and -tls-key; use -h2c for HTTP/2 without TLS")
+	}
+
+	// Without a certificate the server listens on plain HTTP and client certificates are never asked for
+	if tlsClientCA != "" && (tlsCert == "" || tlsKey == "") {
+		log.Fatalf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
+	if ballastSize != "" {
+		if size, err := lib.ParseMemoryLimit(ballastSize); err != nil || ballastSize == "off" || size == 0 {
+			log.Fatalf("-ballast must be a positive size, e.g. 512MiB or 1GiB")
 		}
+	}
 
-		if err := scanner.Err(); err != nil {
-			log.Fatalf("Error reading .env file: %v", err)
+	if memoryHighWater != "" {
+		if size, err := lib.ParseMemoryLimit(memoryHighWater); err != nil || memoryHighWater == "off" || size == 0 {
+			log.Fatalf("-memory-high-water must be a positive size, e.g. 2GiB")
 		}
+		if memoryInterval <= 0 {
+			log.Fatalf("-memory-high-water requires a positive -memory-interval")
+		}
+	}
+
+	if prewarm < 0 || prewarmConcurrency <= 0 {
+		log.Fatalf("-prewarm must not be negative and -prewarm-concurrency must be positive")
+	}
+
+	if streamBodyThreshold < 0 || streamBodyThreshold > maxRequestBody {
+		log.Fatalf("-stream-body-threshold must be between 0 and -max-request-body")
+	}
 
-		if openaiKey == "" {
-			log.Fatalf("OpenAI API key is required")
+	if workerPool < 0 || workerPoolQueue < 0 {
+		log.Fatalf("-worker-pool and -worker-pool-queue must not be negative")
+	}
+	if workerPoolQueue == 0 {
+		workerPoolQueue = workerPool
+	}
+
+	if _, err := lib.ParseKeyStrategy(keyStrategy); err != nil {
+		log.Fatalf("Invalid -key-strategy: %v", err)
+	}
+
+	if jsonEncoder == "" {
+		jsonEncoder = "std"
+		if fastPath {
+			jsonEncoder = "sonic"
 		}
 	}
+	if err := lib.SetJSONEncoder(jsonEncoder); err != nil {
+		log.Fatalf("Invalid -json-encoder: %v", err)
+	}
+	if err := lib.SetResponseMinification(minifyResponse); err != nil {
+		log.Fatalf("Invalid -minify-response: %v", err)
+	}
+
+	// Without -openai-key or -openai-keys the key is read from .env, again on every reload
+	keyFromEnv = openaiKey == "" && openaiKeys == ""
 }
 
-type ChatRequest struct {
-	Messages []schemas.BifrostMessage `json:"messages"`
-	Model    string                   `json:"model"`
+// readEnvKey reads OPENAI_API_KEY from the .env file in the parent directory
+func readEnvKey() (string, error) {
+	file, err := os.Open("../.env")
+	if err != nil {
+		return "", fmt.Errorf("error loading .env file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 && parts[0] == "OPENAI_API_KEY" {
+			return parts[1], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("error reading .env file: %v", err)
+	}
+	return "", nil
 }
 
 func main() {
-	// Set GOMAXPROCS to utilize all available CPU cores
-	runtime.GOMAXPROCS(runtime.NumCPU())
+	// In multi-process mode this process only supervises the workers
+	if workers > 1 && !isWorker() {
+		runWorkers(workers)
+		return
+	}
+
+	cfg, err := readConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Tune the Go runtime before any pools are allocated
+	if err := lib.ApplyRuntimeSettings(runtimeSettings(cfg)); err != nil {
+		log.Fatalf("Invalid runtime settings: %v", err)
+	}
+	if ballastSize != "" {
+		size, _ := lib.ParseMemoryLimit(ballastSize)
+		lib.AllocateBallast(size)
+	}
+	if memoryInterval > 0 {
+		var highWater int64
+		if memoryHighWater != "" {
+			highWater, _ = lib.ParseMemoryLimit(memoryHighWater)
+		}
+		if _, err := lib.StartMemoryWatch(memoryInterval, highWater, memoryProfileDir); err != nil {
+			log.Fatalf("Failed to watch memory: %v", err)
+		}
+	}
 
 	// Initialize the Bifrost client with connection pooling
-	account := lib.NewBaseAccount(openaiKey, proxyURL, concurrency, bufferSize)
-	client, err := bifrost.Init(schemas.BifrostConfig{
-		Account:         account,
-		Plugins:         []schemas.Plugin{},
-		Logger:          nil,
-		InitialPoolSize: initialPoolSize,
-	})
+	settings, err := accountSettings(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize Bifrost: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	strategy, _ := lib.ParseKeyStrategy(keyStrategy)
+	keys := lib.NewKeyBalancer(strategy)
+	account := lib.NewBaseAccount(settings, keys)
+
+	plugins := []schemas.Plugin{}
+	// Track requests in flight per key; with a single key there is nothing to balance
+	if len(settings.Keys) > 1 {
+		plugins = append(plugins, keys)
+	} else {
+		keys = nil
+	}
+	var usagePlugin *lib.UsagePlugin
+	if usage {
+		usagePlugin = lib.NewUsagePlugin(usageBuffer)
+		plugins = append(plugins, usagePlugin)
+	}
+
+	// In passthrough mode Bifrost is never initialized, so its pools don't count towards memory
+	var client *bifrost.Bifrost
+	if !passthrough {
+		client, err = bifrost.Init(schemas.BifrostConfig{
+			Account:         account,
+			Plugins:         plugins,
+			Logger:          lib.NewRetryCountingLogger(),
+			InitialPoolSize: initialPoolSize,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize Bifrost: %v", err)
+		}
+	}
+
+	var routes *lib.RoutingTable
+	if routesFile != "" {
+		routes, err = lib.LoadRoutingTable(routesFile)
+		if err != nil {
+			log.Fatalf("Failed to load routing table: %v", err)
+		}
+
+		configured, _ := account.GetConfiguredProviders()
+		for _, provider := range routes.Providers() {
+			if !slices.Contains(configured, provider) {
+				log.Fatalf("Routing table references unconfigured provider: %s", provider)
+			}
+			// The passthrough client only reaches the OpenAI upstream
+			if passthrough && provider != schemas.OpenAI {
+				log.Fatalf("-passthrough only forwards to OpenAI, but the routing table references %s", provider)
+			}
+		}
 	}
 
 	r := router.New()
 
-	if debug {
-		r.POST("/v1/chat/completions", lib.DebugHandler(client))
-		r.GET("/metrics", lib.GetMetricsHandler())
+	var handler fasthttp.RequestHandler
+	if passthrough {
+		handler = lib.NewPassthrough(settings.APIKey, settings.Network.BaseURL, settings.ProxyURL, settings.Concurrency, requestTimeout, routes).Handler()
+	} else if debug {
+		handler = lib.DebugHandler(client, routes)
+	} else if fastPath {
+		handler = lib.FastHandler(client, routes)
 	} else {
-		Handler := func(ctx *fasthttp.RequestCtx) {
-			var chatReq ChatRequest
-			if err := json.Unmarshal(ctx.PostBody(), &chatReq); err != nil {
-				ctx.SetStatusCode(fasthttp.StatusBadRequest)
-				ctx.SetBodyString(fmt.Sprintf("invalid request format: %v", err))
+		handler = func(ctx *fasthttp.RequestCtx) {
+			start := time.Now()
+			var chatReq lib.ChatRequest
+			if err := lib.DecodeBody(ctx, &chatReq); err != nil {
+				lib.WriteBodyError(ctx, err)
 				return
 			}
 
-			if strings.Contains(chatReq.Model, "/") {
-				parts := strings.Split(chatReq.Model, "/")
-				chatReq.Model = parts[1]
+			if len(chatReq.Messages) == 0 {
+				ctx.SetStatusCode(fasthttp.StatusBadRequest)
+				ctx.SetBodyString("Messages array is required")
+				return
+			}
+			messages, params, err := chatReq.BifrostInput()
+			if err != nil {
+				ctx.SetStatusCode(fasthttp.StatusBadRequest)
+				ctx.SetBodyString(fmt.Sprintf("invalid request: %v", err))
+				return
 			}
 
+			provider, model := routes.ResolveRequest(ctx, chatReq.Model)
+
 			bifrostReq := &schemas.BifrostRequest{
-				Provider: schemas.OpenAI,
-				Model:    chatReq.Model,
+				Provider: provider,
+				Model:    model,
 				Input: schemas.RequestInput{
-					ChatCompletionInput: &chatReq.Messages,
+					ChatCompletionInput: messages,
 				},
+				Params: params,
 			}
 
-			if len(chatReq.Messages) == 0 {
-				ctx.SetStatusCode(fasthttp.StatusBadRequest)
-				ctx.SetBodyString("Messages array is required")
-				return
-			}
-
-			resp, err := client.ChatCompletionRequest(ctx, bifrostReq)
-			if err != nil {
+			bifrostStart := time.Now()
+			resp, bifrostErr := client.ChatCompletionRequest(ctx, bifrostReq)
+			bifrostTime := time.Since(bifrostStart)
+			if bifrostErr != nil {
 				ctx.SetStatusCode(fasthttp.StatusInternalServerError)
-				ctx.SetBodyString(fmt.Sprintf("error: %v", err))
+				ctx.SetBodyString(fmt.Sprintf("error: %v", bifrostErr))
+				lib.RecordTimings(time.Since(start), bifrostTime, nil)
 				return
 			}
 
 			ctx.SetStatusCode(fasthttp.StatusOK)
 			ctx.SetContentType("application/json")
-			json.NewEncoder(ctx).Encode(resp)
+			lib.EncodeResponse(ctx, resp)
+			lib.RecordTimings(time.Since(start), bifrostTime, resp)
+		}
+	}
+
+	// Anthropic SDK clients share the chat route's breaker, admission, pools and tenants
+	var anthropic *lib.AnthropicIngress
+	var messagesHandler fasthttp.RequestHandler
+	if anthropicIngress {
+		if passthrough {
+			log.Fatalf("-anthropic cannot be combined with -passthrough")
+		}
+		anthropic = lib.NewAnthropicIngress(client, routes)
+		messagesHandler = anthropic.Handler()
+	}
+
+	// Realtime connections bypass Bifrost and the chat middleware: frames are copied as they are
+	var realtimeProxy *lib.RealtimeProxy
+	if realtime {
+		realtimeProxy, err = lib.NewRealtimeProxy(settings.APIKey, settings.Network.BaseURL, settings.ProxyURL, requestTimeout)
+		if err != nil {
+			log.Fatalf("Failed to set up the realtime proxy: %v", err)
+		}
+		if enableHTTP2 || h2c {
+			log.Printf("Warning: WebSocket upgrades need HTTP/1.1, so /v1/realtime only works for clients that don't negotiate HTTP/2")
+		}
+	}
+
+	// Warm up the bare handler, since middleware like virtual keys or the breaker would reject
+	// or count synthetic requests
+	if prewarm > 0 {
+		body, _ := json.Marshal(map[string]interface{}{
+			"model":    prewarmModel,
+			"messages": []map[string]string{{"role": "user", "content": "Warm-up request"}},
+		})
+		warm, err := lib.Prewarm(handler, prewarm, prewarmConcurrency, body)
+		if err != nil {
+			log.Fatalf("Warm-up failed: %v", err)
+		}
+		fmt.Printf("Warmed up with %d requests in %.0fms (%d errors): first %.2fms, mean %.2fms, last %.2fms\n",
+			warm.Requests, warm.DurationMs, warm.Errors, warm.FirstMs, warm.MeanMs, warm.LastMs)
+	}
+
+	// Stop calling Bifrost while the upstream is failing, innermost so it only sees Bifrost's outcomes
+	var breaker *lib.CircuitBreaker
+	if breakerErrorRate > 0 {
+		breaker, err = lib.NewCircuitBreaker(lib.BreakerSettings{
+			ErrorRate:   breakerErrorRate,
+			MinRequests: breakerMinRequests,
+			Window:      breakerWindow,
+			OpenFor:     breakerOpen,
+			Probes:      breakerProbes,
+		})
+		if err != nil {
+			log.Fatalf("Invalid circuit breaker: %v", err)
+		}
+		handler = breaker.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = breaker.Wrap(messagesHandler)
+		}
+	}
+
+	// Shed load with 429s once Bifrost's workers and queue are saturated
+	var admission *lib.Admission
+	if admissionControl {
+		admission = lib.NewAdmission(concurrency, bufferSize, retryAfter)
+		handler = admission.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = admission.Wrap(messagesHandler)
+		}
+	}
+
+	// Keep slow models from occupying every Bifrost worker
+	var pools *lib.ModelPools
+	if modelPools != "" {
+		pools, err = lib.NewModelPools(modelPools, retryAfter, routes)
+		if err != nil {
+			log.Fatalf("Invalid -model-pools: %v", err)
+		}
+		if pools.Concurrency() > concurrency {
+			log.Printf("Warning: model pools allow %d concurrent requests but Bifrost has %d workers, so pooled models can still block each other", pools.Concurrency(), concurrency)
+		}
+		handler = pools.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = pools.Wrap(messagesHandler)
+		}
+	}
+
+	// Cache hits and coalesced requests skip admission control and model pools entirely
+	var cache *lib.ResponseCache
+	if cacheTTL > 0 {
+		cache = lib.NewResponseCache(cacheTTL, cacheMaxEntries)
+		handler = cache.Wrap(handler)
+	}
+
+	// Duplicates of a request with the same Idempotency-Key share its response, like cache hits
+	var idempotency *lib.IdempotencyKeys
+	if idempotencyTTL > 0 {
+		idempotency = lib.NewIdempotencyKeys(idempotencyTTL, idempotencyMaxKeys)
+		handler = idempotency.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = idempotency.Wrap(messagesHandler)
+		}
+	}
+
+	// Degrade failures to the canned response outside the cache and idempotency keys, which must
+	// not keep it, and inside validation, whose rejections are the client's fault
+	var fallbackResponses *lib.Fallback
+	if fallback {
+		fallbackResponses, err = lib.NewFallback(fallbackBody, fallbackStatus)
+		if err != nil {
+			log.Fatalf("Invalid fallback response: %v", err)
+		}
+		handler = fallbackResponses.Wrap(handler)
+	}
+
+	// Reject malformed requests before they are cached, queued or sent to Bifrost
+	var validator *lib.RequestValidator
+	if strictValidation {
+		validator = lib.NewRequestValidator(lib.ValidationLimits{
+			MaxBodyBytes:     validationMaxBody,
+			MaxMessages:      validationMaxMessages,
+			MaxContentLength: validationMaxContent,
+		})
+		handler = validator.Wrap(handler)
+	}
+
+	// Authenticate tenants before anything is served, cached responses included
+	var virtualKeys *lib.VirtualKeys
+	if virtualKeysFile != "" {
+		virtualKeys, err = lib.LoadVirtualKeys(virtualKeysFile, retryAfter)
+		if err != nil {
+			log.Fatalf("Failed to load virtual keys: %v", err)
+		}
+		handler = virtualKeys.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = virtualKeys.Wrap(messagesHandler)
+		}
+	}
+
+	// Limit each client IP before any other work is done for its requests
+	var ipLimiter *lib.IPLimiter
+	if ipConcurrency > 0 || ipRPS > 0 {
+		ipLimiter, err = lib.NewIPLimiter(lib.IPLimitSettings{
+			Concurrency: ipConcurrency,
+			RPS:         ipRPS,
+			Burst:       ipBurst,
+			RetryAfter:  retryAfter,
+		})
+		if err != nil {
+			log.Fatalf("Invalid per-IP limits: %v", err)
+		}
+		handler = ipLimiter.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = ipLimiter.Wrap(messagesHandler)
 		}
+	}
+
+	// Bound the goroutines running handlers, queueing and then rejecting the requests beyond them
+	var pool *lib.WorkerPool
+	if workerPool > 0 {
+		pool = lib.NewWorkerPool(workerPool, workerPoolQueue, retryAfter)
+		handler = pool.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = pool.Wrap(messagesHandler)
+		}
+	}
+
+	// With body streaming fasthttp no longer enforces the body limit, so it is checked here,
+	// reading chunked bodies up to the limit when middleware inspects them
+	if streamBodyThreshold > 0 {
+		inspected := cache != nil || idempotency != nil || validator != nil || pools != nil || virtualKeys != nil
+		streaming, err := lib.EnableBodyStreaming(streamBodyThreshold, maxRequestBody, inspected)
+		if err != nil {
+			log.Fatalf("Invalid body streaming settings: %v", err)
+		}
+		handler = streaming.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = streaming.Wrap(messagesHandler)
+		}
+	}
+
+	// Echo the runner's request ID, outermost so rejected requests are logged too
+	handler = lib.WithRequestID(handler, logErrors)
+	if messagesHandler != nil {
+		messagesHandler = lib.WithRequestID(messagesHandler, logErrors)
+	}
+
+	var accessLog *lib.AccessLog
+	if accessLogFile != "" {
+		out := os.Stdout
+		if accessLogFile != "-" {
+			out, err = os.OpenFile(workerLogFile(accessLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				log.Fatalf("Failed to open access log: %v", err)
+			}
+			defer out.Close()
+		}
+		// Workers share stdout, but each writes its own access log file
+		accessLog = lib.NewAccessLog(out, accessLogSample, accessLogBuffer, isWorker() && out == os.Stdout)
+		handler = accessLog.Wrap(handler)
+		if messagesHandler != nil {
+			messagesHandler = accessLog.Wrap(messagesHandler)
+		}
+	}
+
+	// Define HTTP handlers
+	r.POST("/v1/chat/completions", handler)
+	if messagesHandler != nil {
+		r.POST("/v1/messages", messagesHandler)
+	}
+	if realtimeProxy != nil {
+		// Sessions are admitted like chat requests: by tenant, then by client IP
+		realtimeHandler := realtimeProxy.Handler()
+		if virtualKeys != nil {
+			realtimeHandler = virtualKeys.Wrap(realtimeHandler)
+		}
+		if ipLimiter != nil {
+			realtimeHandler = ipLimiter.Wrap(realtimeHandler)
+		}
+		r.GET("/v1/realtime", lib.WithRequestID(realtimeHandler, logErrors))
+	}
+	// OpenAI SDKs and load tools list the models before sending traffic
+	listModels, getModel := lib.ModelsHandler(account, routes)
+	r.GET("/v1/models", listModels)
+	r.GET("/v1/models/{model:*}", getModel)
+	r.GET("/metrics", lib.GetMetricsHandler(lib.MetricsSources{
+		Admission:   admission,
+		Pools:       pools,
+		Cache:       cache,
+		Idempotency: idempotency,
+		VirtualKeys: virtualKeys,
+		Breaker:     breaker,
+		Validator:   validator,
+		Anthropic:   anthropic,
+		Keys:        keys,
+		IPLimiter:   ipLimiter,
+		WorkerPool:  pool,
+		Fallback:    fallbackResponses,
+		Realtime:    realtimeProxy,
+	}))
+	if usagePlugin != nil {
+		r.GET("/usage", usagePlugin.Handler())
+	}
+	if pprof {
+		r.GET("/debug/pprof/{profile:*}", pprofhandler.PprofHandler)
+	}
+	if timingHistograms {
+		r.GET("/metrics/prometheus", lib.EnableTimingHistograms().Handler())
+	}
 
-		// Define HTTP handlers
-		r.POST("/v1/chat/completions", Handler)
+	// Rotate keys or change provider settings mid-run without resetting the server. Once clients
+	// need a credential, so does reloading over HTTP.
+	reloads := &reloader{account: account, client: client}
+	if virtualKeys != nil && adminKey == "" {
+		log.Printf("Warning: /admin/reload is disabled because -virtual-keys is set without -admin-key; reload with SIGHUP")
+	} else {
+		r.POST("/admin/reload", reloads.Handler(adminKey))
 	}
+	go reloads.watchSIGHUP()
 
 	// Configure server for high throughput
 	server := &fasthttp.Server{
 		Handler:               r.Handler,
 		NoDefaultServerHeader: true,
 		TCPKeepalive:          true,
-		Concurrency:           0, // unlimited concurrent connections
+		Concurrency:           serverConcurrency, // 0 means the fasthttp default (256k)
+		MaxRequestBodySize:    maxRequestBody,
+		// fasthttp then reads at most 8KB of each body up front and leaves the rest to the handler
+		StreamRequestBody: streamBodyThreshold > 0,
+	}
+
+	if tlsClientCA != "" {
+		server.TLSConfig, err = lib.MutualTLSConfig(tlsClientCA)
+		if err != nil {
+			log.Fatalf("Failed to configure mutual TLS: %v", err)
+		}
 	}
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	var frontend *lib.HTTP2Frontend
+	if enableHTTP2 || h2c {
+		frontend = lib.NewHTTP2Frontend(server, h2c)
+	}
+
 	// Start server in a goroutine
 	go func() {
+		if frontend != nil {
+			serveHTTP2(frontend)
+			return
+		}
+
+		if listenAddr != "" {
+			socket := unixSocketPath()
+			ln := unixListener(socket)
+			fmt.Printf("Bifrost API server starting on unix socket %s...\n", socket)
+			var serveErr error
+			if tlsCert != "" {
+				serveErr = server.ServeTLS(ln, tlsCert, tlsKey)
+			} else {
+				serveErr = server.Serve(ln)
+			}
+			if serveErr != nil {
+				log.Fatalf("Server error: %v", serveErr)
+			}
+			return
+		}
+
+		if isWorker() {
+			ln := workerListener()
+			fmt.Printf("Bifrost worker %s (pid %d) serving on port %s\n", os.Getenv(workerEnv), os.Getpid(), port)
+			var serveErr error
+			if tlsCert != "" {
+				serveErr = server.ServeTLS(ln, tlsCert, tlsKey)
+			} else {
+				serveErr = server.Serve(ln)
+			}
+			if serveErr != nil {
+				log.Fatalf("Server error: %v", serveErr)
+			}
+			return
+		}
+
+		if tlsCert != "" {
+			fmt.Printf("Bifrost API server starting on port %s with TLS...\n", port)
+			if err := server.ListenAndServeTLS(":"+port, tlsCert, tlsKey); err != nil {
+				log.Fatalf("Server error: %v", err)
+			}
+			return
+		}
+
 		fmt.Printf("Bifrost API server starting on port %s...\n", port)
 		if err := server.ListenAndServe(":" + port); err != nil {
 			log.Fatalf("Server error: %v", err)
@@ -163,13 +791,24 @@ func main() {
 	<-sigChan
 	fmt.Println("\nShutting down server...")
 
-	client.Cleanup()
+	if client != nil {
+		client.Cleanup()
+	}
 
-	// Shutdown server gracefully
+	// Shutdown server gracefully, the HTTP/2 frontend first so it stops handing requests over
+	if frontend != nil {
+		if err := frontend.Shutdown(context.Background()); err != nil {
+			log.Printf("Error during HTTP/2 frontend shutdown: %v", err)
+		}
+	}
 	if err := server.Shutdown(); err != nil {
 		log.Printf("Error during server shutdown: %v", err)
 	}
 
+	if accessLog != nil {
+		accessLog.Close()
+	}
+
 	if debug {
 		// Print statistics
 		lib.PrintStats()
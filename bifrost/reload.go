@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/maximhq/bifrost-gateway/lib"
+	bifrost "github.com/maximhq/bifrost/core"
+	"github.com/maximhq/bifrost/core/schemas"
+	"github.com/valyala/fasthttp"
+)
+
+// reloadConfig is the part of the gateway configuration -config can change without a restart.
+// Fields left out keep the value of their command line flag.
+type reloadConfig struct {
+	OpenAIKey      string  `json:"openai_key"`
+	OpenAIKeys     string  `json:"openai_keys"` // Same format as -openai-keys
+	Proxy          *string `json:"proxy"`
+	UpstreamURL    *string `json:"upstream_url"`
+	Concurrency    int     `json:"concurrency"`
+	BufferSize     int     `json:"buffer_size"`
+	RequestTimeout string  `json:"request_timeout"` // e.g. "30s"
+	MaxRetries     *int    `json:"max_retries"`
+
+	// Go runtime settings, applied without restarting, e.g. to switch GOGC between runs
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	GOGC       string `json:"gogc"`
+	GOMEMLIMIT string `json:"gomemlimit"`
+}
+
+// readConfig reads the -config file, or returns an empty configuration without one
+func readConfig() (reloadConfig, error) {
+	var cfg reloadConfig
+	if configFile == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return cfg, nil
+}
+
+// runtimeSettings builds the Go runtime settings from the flags and the -config file. Without
+// either, GOMAXPROCS uses every CPU, split evenly between -workers.
+func runtimeSettings(cfg reloadConfig) lib.RuntimeSettings {
+	settings := lib.RuntimeSettings{GOMAXPROCS: gomaxprocs, GOGC: gogc, GOMEMLIMIT: gomemlimit}
+	if settings.GOMAXPROCS == 0 {
+		settings.GOMAXPROCS = runtime.NumCPU()
+		if isWorker() {
+			settings.GOMAXPROCS = max(runtime.NumCPU()/workers, 1)
+		}
+	}
+	if cfg.GOMAXPROCS != 0 {
+		settings.GOMAXPROCS = cfg.GOMAXPROCS
+	}
+	if cfg.GOGC != "" {
+		settings.GOGC = cfg.GOGC
+	}
+	if cfg.GOMEMLIMIT != "" {
+		settings.GOMEMLIMIT = cfg.GOMEMLIMIT
+	}
+	return settings
+}
+
+// accountSettings builds the account settings from the flags, .env and the -config file
+func accountSettings(cfg reloadConfig) (lib.AccountSettings, error) {
+	settings := lib.AccountSettings{
+		APIKey:      openaiKey,
+		ProxyURL:    proxyURL,
+		Concurrency: concurrency,
+		BufferSize:  bufferSize,
+		Network: schemas.NetworkConfig{
+			BaseURL:                        upstreamURL,
+			DefaultRequestTimeoutInSeconds: int(requestTimeout / time.Second),
+			MaxRetries:                     maxRetries,
+			RetryBackoffInitial:            retryBackoffInitial,
+			RetryBackoffMax:                retryBackoffMax,
+		},
+	}
+
+	var err error
+	switch {
+	case cfg.OpenAIKeys != "":
+		if settings.Keys, err = lib.ParseKeys(cfg.OpenAIKeys); err != nil {
+			return settings, fmt.Errorf("invalid openai_keys: %v", err)
+		}
+	case cfg.OpenAIKey != "":
+		settings.APIKey = cfg.OpenAIKey
+	case openaiKeys != "":
+		if settings.Keys, err = lib.ParseKeys(openaiKeys); err != nil {
+			return settings, fmt.Errorf("invalid -openai-keys: %v", err)
+		}
+	case keyFromEnv:
+		key, err := readEnvKey()
+		if err != nil {
+			return settings, err
+		}
+		settings.APIKey = key
+	}
+	if len(settings.Keys) > 0 {
+		settings.APIKey = settings.Keys[0].Value
+	} else if settings.APIKey != "" {
+		settings.Keys = []lib.WeightedKey{{Value: settings.APIKey, Weight: 1}}
+	}
+	if settings.APIKey == "" {
+		return settings, fmt.Errorf("OpenAI API key is required")
+	}
+
+	if cfg.Proxy != nil {
+		settings.ProxyURL = *cfg.Proxy
+	}
+	if cfg.UpstreamURL != nil {
+		if *cfg.UpstreamURL != "" {
+			if err := lib.ValidateUpstreamURL(*cfg.UpstreamURL); err != nil {
+				return settings, fmt.Errorf("invalid upstream_url: %v", err)
+			}
+		}
+		settings.Network.BaseURL = *cfg.UpstreamURL
+	}
+	if cfg.Concurrency != 0 {
+		settings.Concurrency = cfg.Concurrency
+	}
+	if cfg.BufferSize != 0 {
+		settings.BufferSize = cfg.BufferSize
+	}
+	if settings.Concurrency < 1 || settings.BufferSize < 1 {
+		return settings, fmt.Errorf("concurrency and buffer size must be positive")
+	}
+	if cfg.RequestTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.RequestTimeout)
+		if err != nil || timeout < time.Second {
+			return settings, fmt.Errorf("request_timeout must be a duration of at least 1s, got %q", cfg.RequestTimeout)
+		}
+		settings.Network.DefaultRequestTimeoutInSeconds = int(timeout / time.Second)
+	}
+	if cfg.MaxRetries != nil {
+		settings.Network.MaxRetries = *cfg.MaxRetries
+	}
+	return settings, nil
+}
+
+// reloader rebuilds the account from its configuration on SIGHUP or POST /admin/reload
+type reloader struct {
+	mu      sync.Mutex
+	account *lib.BaseAccount
+	client  *bifrost.Bifrost
+}
+
+// reload applies the current configuration. Runtime settings and a new key take effect
+// immediately; other changes rebuild the OpenAI provider, which drains its workers and starts a
+// new connection pool.
+func (r *reloader) reload() (providerRebuilt bool, err error) {
+	if r.client == nil {
+		return false, fmt.Errorf("reloading is not supported with -passthrough")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := readConfig()
+	if err != nil {
+		return false, err
+	}
+	settings, err := accountSettings(cfg)
+	if err != nil {
+		return false, err
+	}
+	if err := lib.ApplyRuntimeSettings(runtimeSettings(cfg)); err != nil {
+		return false, err
+	}
+	if !r.account.Update(settings) {
+		return false, nil
+	}
+	if err := r.client.UpdateProviderConcurrency(schemas.OpenAI); err != nil {
+		return true, fmt.Errorf("failed to rebuild the OpenAI provider: %v", err)
+	}
+	return true, nil
+}
+
+// Handler reloads the configuration and reports whether the provider was rebuilt. Requests must
+// carry adminKey as a bearer token unless it is empty. A worker hands the reload to the
+// supervisor, which forwards it to every worker as SIGHUP, since the request reached only one.
+func (r *reloader) Handler(adminKey string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("application/json")
+		if adminKey != "" && !hasBearer(ctx, adminKey) {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			json.NewEncoder(ctx).Encode(map[string]interface{}{"error": "invalid admin key"})
+			return
+		}
+
+		if isWorker() {
+			if err := syscall.Kill(os.Getppid(), syscall.SIGHUP); err != nil {
+				log.Printf("Reload failed: %v", err)
+				ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+				json.NewEncoder(ctx).Encode(map[string]interface{}{"error": fmt.Sprintf("failed to signal the supervisor: %v", err)})
+				return
+			}
+			log.Printf("Reload requested, forwarded to all %d workers", workers)
+			// Each worker logs its own result; none is known yet
+			ctx.SetStatusCode(fasthttp.StatusAccepted)
+			json.NewEncoder(ctx).Encode(map[string]interface{}{"reloading": true, "workers": workers})
+			return
+		}
+
+		rebuilt, err := r.reload()
+		if err != nil {
+			log.Printf("Reload failed: %v", err)
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			json.NewEncoder(ctx).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		log.Printf("Configuration reloaded (provider rebuilt: %t)", rebuilt)
+		json.NewEncoder(ctx).Encode(map[string]interface{}{"reloaded": true, "provider_rebuilt": rebuilt})
+	}
+}
+
+// hasBearer reports whether the request carries key as its bearer token
+func hasBearer(ctx *fasthttp.RequestCtx, key string) bool {
+	token, ok := strings.CutPrefix(string(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
+
+// watchSIGHUP reloads the configuration every time the process receives SIGHUP
+func (r *reloader) watchSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		rebuilt, err := r.reload()
+		if err != nil {
+			log.Printf("Reload failed: %v", err)
+			continue
+		}
+		log.Printf("Configuration reloaded on SIGHUP (provider rebuilt: %t)", rebuilt)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// unixListenPrefix marks -listen addresses that are unix domain socket paths
+const unixListenPrefix = "unix:"
+
+// unixSocketPath returns the socket path given with -listen, or "" to listen on -port
+func unixSocketPath() string {
+	return strings.TrimPrefix(listenAddr, unixListenPrefix)
+}
+
+// unixListener listens on a unix domain socket, replacing a socket left behind by a previous run.
+// The socket is removed again when the listener is closed on shutdown.
+func unixListener(path string) net.Listener {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			log.Fatalf("Refusing to replace %s: not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			log.Fatalf("Failed to remove stale socket: %v", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	// Let load generators running as other users connect
+	if err := os.Chmod(path, 0666); err != nil {
+		log.Printf("Warning: Could not make socket world writable: %v", err)
+	}
+	return ln
+}
+
+// validateListenAddr checks the -listen flag against the options that need a TCP port
+func validateListenAddr() error {
+	if listenAddr == "" {
+		return nil
+	}
+	if !strings.HasPrefix(listenAddr, unixListenPrefix) || unixSocketPath() == "" {
+		return fmt.Errorf("-listen only supports unix:/path/to.sock, use -port for TCP")
+	}
+	if workers > 1 {
+		return fmt.Errorf("-listen unix: cannot be combined with -workers, which share a TCP port")
+	}
+	return nil
+}
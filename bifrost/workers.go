@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/valyala/fasthttp/reuseport"
+)
+
+// workerEnv is set on worker processes so they serve requests instead of spawning more workers
+const workerEnv = "BIFROST_WORKER_ID"
+
+// isWorker reports whether this process was started by runWorkers
+func isWorker() bool {
+	return os.Getenv(workerEnv) != ""
+}
+
+// workerLogFile gives each worker its own copy of a log file, with its PID before the
+// extension (access.log becomes access.1234.log), so workers never interleave their lines
+func workerLogFile(path string) string {
+	if !isWorker() {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), os.Getpid(), ext)
+}
+
+// runWorkers re-executes the gateway n times with the same arguments. Every worker binds the
+// port with SO_REUSEPORT, so the kernel spreads incoming connections across processes.
+func runWorkers(n int) {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to locate gateway executable: %v", err)
+	}
+
+	workers := make([]*exec.Cmd, 0, n)
+	for i := 0; i < n; i++ {
+		cmd := exec.Command(executable, os.Args[1:]...)
+		cmd.Env = append(os.Environ(), workerEnv+"="+strconv.Itoa(i))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			stopWorkers(workers)
+			log.Fatalf("Failed to start worker %d: %v", i, err)
+		}
+		workers = append(workers, cmd)
+	}
+	fmt.Printf("Started %d gateway workers sharing port %s\n", n, port)
+
+	var wg sync.WaitGroup
+	for i, cmd := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := cmd.Wait(); err != nil {
+				log.Printf("Worker %d (pid %d) exited: %v", i, cmd.Process.Pid, err)
+			}
+		}()
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		stopWorkers(workers)
+	}()
+
+	// Every worker holds its own account, so reloads are forwarded to all of them
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			for _, cmd := range workers {
+				cmd.Process.Signal(syscall.SIGHUP)
+			}
+		}
+	}()
+
+	wg.Wait()
+}
+
+// stopWorkers asks every worker to shut down gracefully
+func stopWorkers(workers []*exec.Cmd) {
+	for _, cmd := range workers {
+		cmd.Process.Signal(syscall.SIGTERM)
+	}
+}
+
+// listenNetwork is the network the port is bound on, the one fasthttp's ListenAndServe uses in
+// single-process mode, so -workers and -http2 listen on the same addresses as a single process
+const listenNetwork = "tcp4"
+
+// workerListener binds the port with SO_REUSEPORT
+func workerListener() net.Listener {
+	ln, err := reuseport.Listen(listenNetwork, ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen with SO_REUSEPORT: %v", err)
+	}
+	return ln
+}
@@ -2,4 +2,7 @@ module mocker
 
 go 1.24.1
 
-require github.com/maximhq/bifrost/core v1.0.7
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/maximhq/bifrost/core v1.0.7
+)
@@ -1,121 +1,277 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/maximhq/bifrost/core/schemas"
+	"mocker/mock"
 )
 
-type OpenAIResponse struct {
-	ID                string                          `json:"id"`                 // Unique identifier for the completion
-	Object            string                          `json:"object"`             // Type of completion (text.completion or chat.completion)
-	Choices           []schemas.BifrostResponseChoice `json:"choices"`            // Array of completion choices
-	Model             string                          `json:"model"`              // Model used for the completion
-	Created           int                             `json:"created"`            // Unix timestamp of completion creation
-	ServiceTier       *string                         `json:"service_tier"`       // Service tier used for the request
-	SystemFingerprint *string                         `json:"system_fingerprint"` // System fingerprint for the request
-	Usage             schemas.LLMUsage                `json:"usage"`              // Token usage statistics
-}
-
-// OpenAIError represents the error response structure from the OpenAI API.
-// It includes detailed error information and event tracking.
-type OpenAIError struct {
-	EventID string `json:"event_id"` // Unique identifier for the error event
-	Type    string `json:"type"`     // Type of error
-	Error   struct {
-		Type    string      `json:"type"`     // Error type
-		Code    string      `json:"code"`     // Error code
-		Message string      `json:"message"`  // Error message
-		Param   interface{} `json:"param"`    // Parameter that caused the error
-		EventID string      `json:"event_id"` // Event ID for tracking
-	} `json:"error"`
-}
-
 var (
 	port       int
+	instances  int
 	latency    int
+	jitter     int
+	errorRate  float64
 	bigPayload bool
+
+	truncateRate  float64
+	truncateDelay int
+
+	latencyPer1kTokens  float64
+	compress            string
+	embeddingDimensions int
+
+	realtimeDeltas    int
+	realtimeEventRate float64
+
+	promptCostPer1k     float64
+	completionCostPer1k float64
+
+	logErrors    bool
+	bodyChecksum bool
+
+	fixturesDir     string
+	captureDir      string
+	captureUpstream string
+
+	rateLimitRPM   int
+	rateLimitTPM   int
+	rateLimitBurst int
+
+	seed       int64
+	recordFile string
+	replayFile string
+
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+
+	enableHTTP2          bool
+	h2c                  bool
+	maxConcurrentStreams int
+
+	slowStartDuration time.Duration
+	slowStartLatency  int
+	slowStartCurve    string
+	slowStartIdle     time.Duration
+
+	outages    string
+	outageMode string
 )
 
 func init() {
 	flag.IntVar(&port, "port", 8000, "Port for the mock server to listen on")
+	flag.IntVar(&instances, "instances", 1, "Number of independent mock servers to start on consecutive ports from -port, to benchmark gateways balancing over several upstreams")
 	flag.IntVar(&latency, "latency", 0, "Latency in milliseconds to simulate")
+	flag.IntVar(&jitter, "jitter", 0, "Random extra latency in milliseconds, uniform in [0, jitter)")
+	flag.Float64Var(&errorRate, "error-rate", 0, "Fraction of requests answered with a 500 error (0-1)")
+	flag.Float64Var(&truncateRate, "truncate-rate", 0, "Fraction of successful responses cut off partway through the body by dropping the connection (0-1)")
+	flag.IntVar(&truncateDelay, "truncate-delay", 0, "Random wait in milliseconds, uniform in [0, truncate-delay), between the partial body and the dropped connection")
 	flag.BoolVar(&bigPayload, "big-payload", false, "Use big payload")
+	flag.StringVar(&compress, "compress", "", "Serve completions compressed with this Content-Encoding (gzip or br)")
+	flag.IntVar(&embeddingDimensions, "embedding-dimensions", mock.DefaultEmbeddingDimensions, "Vector size of /v1/embeddings responses for requests that don't set dimensions, e.g. 3072 for text-embedding-3-large")
+	flag.IntVar(&realtimeDeltas, "realtime-deltas", mock.DefaultRealtimeDeltas, "Text or audio delta events streamed per /v1/realtime response")
+	flag.Float64Var(&realtimeEventRate, "realtime-event-rate", 50, "Delta events per second within a /v1/realtime response (0 sends them back to back)")
+	flag.Float64Var(&latencyPer1kTokens, "latency-per-1k-tokens", 0, "Extra latency in milliseconds per 1000 prompt tokens, estimated from the request body size")
+	flag.Float64Var(&promptCostPer1k, "prompt-cost-per-1k", 0.00015, "Simulated USD cost per 1000 prompt tokens, reported on /admin/usage")
+	flag.Float64Var(&completionCostPer1k, "completion-cost-per-1k", 0.0006, "Simulated USD cost per 1000 completion tokens, reported on /admin/usage")
+	flag.BoolVar(&logErrors, "log-errors", false, "Log every injected error with the request's X-Request-ID")
+	flag.BoolVar(&bodyChecksum, "body-checksum", false, "Answer with X-Mock-Body-Bytes and X-Mock-Body-Sha256 headers describing the received request body")
+	flag.StringVar(&fixturesDir, "fixtures", "", "Directory of JSON fixtures with canned chat completions, picked per requested model by weight")
+	flag.StringVar(&captureDir, "capture", "", "Proxy chat completions to -capture-upstream and save every successful response as a fixture in this directory")
+	flag.StringVar(&captureUpstream, "capture-upstream", "https://api.openai.com", "OpenAI compatible API proxied to with -capture")
+	flag.IntVar(&rateLimitRPM, "rate-limit-rpm", 0, "Requests per minute allowed per API key before answering 429 like OpenAI (0 disables)")
+	flag.IntVar(&rateLimitTPM, "rate-limit-tpm", 0, "Tokens per minute allowed per API key before answering 429 like OpenAI (0 disables)")
+	flag.IntVar(&rateLimitBurst, "rate-limit-burst", 0, "Requests a key can send at once before -rate-limit-rpm paces it (0 allows a whole minute's requests)")
+	flag.Int64Var(&seed, "seed", 0, "Seed for random token counts, jitter and errors so runs are reproducible (0 picks a random seed)")
+	flag.StringVar(&recordFile, "record", "", "Record the sequence of response timings, statuses and token counts to this JSONL file")
+	flag.StringVar(&replayFile, "replay", "", "Replay a sequence recorded with -record instead of drawing random responses")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Server certificate; enables TLS when set together with -tls-key")
+	flag.StringVar(&tlsKey, "tls-key", "", "Server private key")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "CA used to verify client certificates; enables mutual TLS")
+	flag.BoolVar(&enableHTTP2, "http2", true, "Offer HTTP/2 over TLS (negotiated with ALPN)")
+	flag.BoolVar(&h2c, "h2c", false, "Accept HTTP/2 without TLS (prior knowledge) on the plain port")
+	flag.IntVar(&maxConcurrentStreams, "max-concurrent-streams", 250, "Maximum concurrent HTTP/2 streams per connection")
+	flag.DurationVar(&slowStartDuration, "slow-start", 0, "Simulate a cold upstream whose extra latency decays to zero over this much traffic, e.g. 30s (0 disables)")
+	flag.IntVar(&slowStartLatency, "slow-start-latency", 1000, "Extra latency in milliseconds of the first request of a slow start")
+	flag.StringVar(&slowStartCurve, "slow-start-curve", "linear", "How the slow start latency decays: linear, exp or step")
+	flag.StringVar(&outages, "outage", "", "Comma separated outage windows as duration@start from when the mocker started, e.g. 30s@60s")
+	flag.StringVar(&outageMode, "outage-mode", "503", "How the upstream fails during outages: 503 answers with 503s, refuse drops connections and refuses new ones")
+	flag.DurationVar(&slowStartIdle, "slow-start-idle", 0, "Start cold again after this long without requests (0 stays warm once warmed up)")
 }
 
-// StrPtr creates a pointer to a string value.
-func StrPtr(s string) *string {
-	return &s
-}
+func main() {
+	flag.Parse()
 
-func mockOpenAIHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-		return
+	outageWindows, err := mock.ParseOutages(outages)
+	if err != nil {
+		log.Fatalf("Invalid -outage: %v", err)
 	}
 
-	// Simulate latency
-	if latency > 0 {
-		time.Sleep(time.Duration(latency) * time.Millisecond)
-	}
+	opts := mock.Options{
+		Latency:            time.Duration(latency) * time.Millisecond,
+		Jitter:             time.Duration(jitter) * time.Millisecond,
+		ErrorRate:          errorRate,
+		TruncateRate:       truncateRate,
+		TruncateDelay:      time.Duration(truncateDelay) * time.Millisecond,
+		BigPayload:         bigPayload,
+		LatencyPer1kTokens: time.Duration(latencyPer1kTokens * float64(time.Millisecond)),
+		Compress:           compress,
+
+		EmbeddingDimensions: embeddingDimensions,
+
+		RealtimeDeltas:    realtimeDeltas,
+		RealtimeEventRate: realtimeEventRate,
+
+		PromptCostPer1k:     promptCostPer1k,
+		CompletionCostPer1k: completionCostPer1k,
+
+		LogErrors:    logErrors,
+		BodyChecksum: bodyChecksum,
+
+		FixturesDir:     fixturesDir,
+		CaptureDir:      captureDir,
+		CaptureUpstream: captureUpstream,
+
+		RateLimitRPM:   rateLimitRPM,
+		RateLimitTPM:   rateLimitTPM,
+		RateLimitBurst: rateLimitBurst,
 
-	mockContent := "This is a mocked response from the OpenAI mocker server."
-	if bigPayload {
-		// Repeat content to generate approximately 10KB response
-		// Each repetition is ~55 chars, so ~182 repetitions ≈ 10KB
-		mockContent = strings.Repeat(mockContent, 182)
+		Seed:       seed,
+		RecordFile: recordFile,
+		ReplayFile: replayFile,
+
+		SlowStart:        slowStartDuration,
+		SlowStartLatency: time.Duration(slowStartLatency) * time.Millisecond,
+		SlowStartCurve:   slowStartCurve,
+		SlowStartIdle:    slowStartIdle,
+
+		Outages:    outageWindows,
+		OutageMode: outageMode,
 	}
 
-	// Create a mock response
-	mockChoiceMessage := schemas.BifrostResponseChoiceMessage{
-		Role:    schemas.ModelChatMessageRole("assistant"),
-		Content: StrPtr(mockContent),
+	if instances < 1 {
+		log.Fatalf("-instances must be at least 1")
 	}
-	mockChoice := schemas.BifrostResponseChoice{
-		Index:        0,
-		Message:      mockChoiceMessage,
-		FinishReason: StrPtr("stop"),
+	// Without a certificate the server listens on plain HTTP and client certificates are never asked for
+	if tlsClientCA != "" && (tlsCert == "" || tlsKey == "") {
+		log.Fatalf("-tls-client-ca requires -tls-cert and -tls-key")
 	}
-
-	randomInputTokens := rand.Intn(1000)
-	randomOutputTokens := rand.Intn(1000)
-
-	mockResp := OpenAIResponse{
-		ID:      "cmpl-mock12345",
-		Object:  "chat.completion",
-		Created: int(time.Now().Unix()),
-		Model:   "gpt-3.5-turbo-mock",
-		Choices: []schemas.BifrostResponseChoice{mockChoice},
-		Usage: schemas.LLMUsage{
-			PromptTokens:     randomInputTokens,
-			CompletionTokens: randomOutputTokens,
-			TotalTokens:      randomInputTokens + randomOutputTokens,
-		},
+	if recordFile != "" {
+		go closeOnSignal()
+	}
+	if instances == 1 {
+		serve(port, opts)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(mockResp); err != nil {
-		log.Printf("Error encoding mock response: %v", err)
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	// Every instance gets its own handler, so plans, rate limits and metrics are per instance
+	log.Printf("Starting %d instances on ports %d-%d", instances, port, port+instances-1)
+	for i := range instances {
+		instanceOpts := opts
+		instanceOpts.Instance = strconv.Itoa(port + i)
+		if seed != 0 {
+			instanceOpts.Seed = seed + int64(i)
+		}
+		if recordFile != "" {
+			instanceOpts.RecordFile = instanceFile(recordFile, port+i)
+		}
+		if i == instances-1 {
+			serve(port+i, instanceOpts)
+		} else {
+			go serve(port+i, instanceOpts)
+		}
 	}
 }
 
-func main() {
-	flag.Parse()
+// instanceFile inserts an instance's port before the extension, e.g. plans.8001.jsonl
+func instanceFile(path string, port int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), port, ext)
+}
+
+// handlers are the handlers of every instance, closed by closeOnSignal
+var (
+	handlersMu sync.Mutex
+	handlers   []*mock.Handler
+)
+
+// closeOnSignal closes every handler on SIGINT or SIGTERM, so their -record files are flushed
+// before the process exits
+func closeOnSignal() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	handlersMu.Lock()
+	for _, handler := range handlers {
+		if err := handler.Close(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	os.Exit(0)
+}
 
-	http.HandleFunc("/v1/chat/completions", mockOpenAIHandler)
+// serve runs one mock server on port until it fails, which ends the process
+func serve(port int, opts mock.Options) {
+	handler, err := mock.NewHandler(opts)
+	if err != nil {
+		log.Fatalf("Failed to set up the mocker: %v", err)
+	}
+	handlersMu.Lock()
+	handlers = append(handlers, handler)
+	handlersMu.Unlock()
 
 	addr := fmt.Sprintf(":%d", port)
-	log.Printf("Mock OpenAI server starting on port %d with latency %dms...\n", port, latency)
-	if err := http.ListenAndServe(addr, nil); err != nil {
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(enableHTTP2)
+	protocols.SetUnencryptedHTTP2(h2c)
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		Protocols: protocols,
+		HTTP2:     &http.HTTP2Config{MaxConcurrentStreams: maxConcurrentStreams},
+	}
+
+	// Refuse mode outages close and reopen the listening socket
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	ln = handler.WrapListener(ln)
+
+	if tlsCert != "" {
+		if tlsClientCA != "" {
+			tlsConfig, err := mutualTLSConfig(tlsClientCA)
+			if err != nil {
+				log.Fatalf("Failed to configure mutual TLS: %v", err)
+			}
+			server.TLSConfig = tlsConfig
+		}
+
+		log.Printf("Mock OpenAI server starting on port %d with TLS (http2=%t) and latency %dms...\n", port, enableHTTP2, latency)
+		if err := server.ServeTLS(ln, tlsCert, tlsKey); err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		return
+	}
+
+	log.Printf("Mock OpenAI server starting on port %d (h2c=%t) with latency %dms...\n", port, h2c, latency)
+	if err := server.Serve(ln); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,126 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// captureIDPattern matches the completion and tool call IDs of a response, which are replaced so
+// captured fixtures carry no identifiers of the real account
+var captureIDPattern = regexp.MustCompile(`"id"(\s*):(\s*)"(chatcmpl-|call_)[^"]*"`)
+
+// captureProxy forwards chat completions to a real OpenAI compatible API and saves every successful
+// response as a fixture, so later runs serve the exact bytes with -fixtures
+type captureProxy struct {
+	upstream string // Base URL, e.g. https://api.openai.com
+	dir      string
+	apiKey   string // Replaces the request's credential when set
+	client   *http.Client
+	captured atomic.Int64
+}
+
+// newCaptureProxy creates the capture directory. The OPENAI_API_KEY environment variable, when
+// set, is sent upstream instead of the credential the gateway sent to the mocker.
+func newCaptureProxy(upstream string, dir string) (*captureProxy, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %v", err)
+	}
+	return &captureProxy{
+		upstream: strings.TrimSuffix(upstream, "/"),
+		dir:      dir,
+		apiKey:   os.Getenv("OPENAI_API_KEY"),
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// anonymizeResponse replaces the IDs in a response body, keeping its field order and formatting
+func anonymizeResponse(body []byte, n int64) []byte {
+	calls := 0
+	return captureIDPattern.ReplaceAllFunc(body, func(match []byte) []byte {
+		sub := captureIDPattern.FindSubmatch(match)
+		if string(sub[3]) == "chatcmpl-" {
+			return []byte(fmt.Sprintf(`"id"%s:%s"chatcmpl-capture-%d"`, sub[1], sub[2], n))
+		}
+		calls++
+		return []byte(fmt.Sprintf(`"id"%s:%s"call_capture_%d_%d"`, sub[1], sub[2], n, calls))
+	})
+}
+
+// save writes a response as a fixture for the requested model, named after the model and capture number
+func (p *captureProxy) save(model string, body []byte) error {
+	n := p.captured.Add(1)
+	model = model[strings.LastIndex(model, "/")+1:]
+	if model == "" {
+		model = anyModel
+	}
+
+	if !json.Valid(body) {
+		return fmt.Errorf("response is not valid JSON")
+	}
+	fixture, err := json.MarshalIndent(Fixture{Model: model, Weight: 1, Body: string(anonymizeResponse(body, n))}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode fixture: %v", err)
+	}
+	name := strings.NewReplacer("*", "any", string(filepath.Separator), "_").Replace(model)
+	path := filepath.Join(p.dir, fmt.Sprintf("%s-capture-%d.json", name, n))
+	if err := os.WriteFile(path, append(fixture, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write fixture: %v", err)
+	}
+	log.Printf("Captured %s response to %s", model, path)
+	return nil
+}
+
+// Handler proxies a request and returns the upstream response unchanged. Only successful,
+// non-streaming responses are captured, since fixtures are served as a single JSON body.
+func (p *captureProxy) Handler(w http.ResponseWriter, r *http.Request) {
+	body := readBody(r)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, p.upstream+r.URL.Path, bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to create upstream request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", r.Header.Get("Authorization"))
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("upstream request failed: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read upstream response: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+
+	var streamed struct {
+		Stream bool `json:"stream"`
+	}
+	json.Unmarshal(body, &streamed)
+	if resp.StatusCode != http.StatusOK || streamed.Stream {
+		return
+	}
+	if err := p.save(requestedModel(body), respBody); err != nil {
+		log.Printf("Warning: Could not capture response: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package mock
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressors pool the gzip and brotli writers, which are expensive to allocate per response
+var compressors = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} { return gzip.NewWriter(io.Discard) }},
+	"br":   {New: func() interface{} { return brotli.NewWriter(io.Discard) }},
+}
+
+// compressingWriter is an io.WriteCloser that can be pointed at a new response
+type compressingWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressedResponse writes the body through the encoder
+type compressedResponse struct {
+	http.ResponseWriter
+	encoder compressingWriter
+}
+
+func (w *compressedResponse) WriteHeader(status int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressedResponse) Write(b []byte) (int, error) {
+	return w.encoder.Write(b)
+}
+
+// FlushError sends what the encoder has buffered, for http.ResponseController
+func (w *compressedResponse) FlushError() error {
+	if err := w.encoder.Flush(); err != nil {
+		return err
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// validateCompression checks the Content-Encoding completions are served with
+func validateCompression(encoding string) error {
+	if encoding == "" {
+		return nil
+	}
+	if _, ok := compressors[encoding]; !ok {
+		return fmt.Errorf("unsupported encoding %q (use gzip or br)", encoding)
+	}
+	return nil
+}
+
+// withCompression serves every response with the given Content-Encoding, whatever the client
+// accepts, so gateways have to decompress or forward it. An empty encoding disables it.
+func withCompression(encoding string, next http.HandlerFunc) http.HandlerFunc {
+	if encoding == "" {
+		return next
+	}
+	pool := compressors[encoding]
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoder := pool.Get().(compressingWriter)
+		encoder.Reset(w)
+		defer pool.Put(encoder)
+
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+		next(&compressedResponse{ResponseWriter: w, encoder: encoder}, r)
+		encoder.Close()
+	}
+}
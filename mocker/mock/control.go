@@ -0,0 +1,36 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Request headers that override a request's planned response, so a client can mix latencies or
+// inject failures into chosen requests deterministically
+const (
+	latencyHeader = "X-Mock-Latency-Ms"
+	statusHeader  = "X-Mock-Status"
+)
+
+// applyControlHeaders overrides the plan's latency and status with the request's X-Mock-Latency-Ms
+// and X-Mock-Status headers. The latency replaces the planned one, jitter included; per-token
+// latency and slow start are still added on top.
+func applyControlHeaders(r *http.Request, plan *ResponsePlan) error {
+	if value := r.Header.Get(latencyHeader); value != "" {
+		ms, err := strconv.ParseFloat(value, 64)
+		if err != nil || ms < 0 || ms > float64(time.Hour/time.Millisecond) {
+			return fmt.Errorf("invalid %s %q: must be between 0 and 3600000", latencyHeader, value)
+		}
+		plan.LatencyMs = ms
+	}
+	if value := r.Header.Get(statusHeader); value != "" {
+		status, err := strconv.Atoi(value)
+		if err != nil || status < 200 || status > 599 {
+			return fmt.Errorf("invalid %s %q: must be an HTTP status between 200 and 599", statusHeader, value)
+		}
+		plan.Status = status
+	}
+	return nil
+}
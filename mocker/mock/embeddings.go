@@ -0,0 +1,165 @@
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/http"
+)
+
+// DefaultEmbeddingDimensions is the vector size of text-embedding-3-small and ada-002
+const DefaultEmbeddingDimensions = 1536
+
+// maxEmbeddingDimensions bounds the dimensions a request can ask for
+const maxEmbeddingDimensions = 65536
+
+// embeddingRequest is an OpenAI embeddings request. input is a string, an array of strings, an
+// array of token IDs or an array of token ID arrays.
+type embeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	Dimensions     int             `json:"dimensions"`
+	EncodingFormat string          `json:"encoding_format"` // float (default) or base64
+
+	inputs []string // One per embedding; token arrays are kept as their JSON
+	tokens int
+}
+
+// embeddingResponse is the list of embeddings answering a request
+type embeddingResponse struct {
+	Object string           `json:"object"`
+	Data   []embeddingEntry `json:"data"`
+	Model  string           `json:"model"`
+	Usage  struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+type embeddingEntry struct {
+	Object    string `json:"object"`
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"` // []float32, or a base64 string of little-endian float32s
+}
+
+// parse decodes the request, counting one embedding per input and its prompt tokens
+func (req *embeddingRequest) parse(body []byte) error {
+	if err := json.Unmarshal(body, req); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	if req.Dimensions < 0 || req.Dimensions > maxEmbeddingDimensions {
+		return fmt.Errorf("dimensions must be between 1 and %d", maxEmbeddingDimensions)
+	}
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		return fmt.Errorf("encoding_format must be float or base64, got %q", req.EncodingFormat)
+	}
+
+	var one string
+	var many []string
+	var tokens []int
+	var tokenLists [][]int
+	switch {
+	case json.Unmarshal(req.Input, &one) == nil:
+		req.inputs, req.tokens = []string{one}, textTokens(one)
+	case json.Unmarshal(req.Input, &many) == nil && len(many) > 0:
+		req.inputs = many
+		for _, text := range many {
+			req.tokens += textTokens(text)
+		}
+	case json.Unmarshal(req.Input, &tokens) == nil && len(tokens) > 0:
+		req.inputs, req.tokens = []string{string(req.Input)}, len(tokens)
+	case json.Unmarshal(req.Input, &tokenLists) == nil && len(tokenLists) > 0:
+		for _, list := range tokenLists {
+			encoded, _ := json.Marshal(list)
+			req.inputs = append(req.inputs, string(encoded))
+			req.tokens += len(list)
+		}
+	default:
+		return fmt.Errorf("input must be a string, an array of strings or an array of token arrays")
+	}
+	return nil
+}
+
+// textTokens estimates the tokens of an input text, at least one
+func textTokens(text string) int {
+	return max(len(text)/bytesPerToken, 1)
+}
+
+// embeddings answers an embeddings request with a unit vector per input, of the requested
+// dimensions or -embedding-dimensions. Vectors are derived from the input text, so the same
+// input always gets the same embedding, like a real model.
+func (h *Handler) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req embeddingRequest
+	h.answer(w, r, apiEndpoint{
+		prepare: func(body []byte, plan *ResponsePlan) error {
+			if err := req.parse(body); err != nil {
+				return err
+			}
+			plan.PromptTokens, plan.CompletionTokens = req.tokens, 0
+			return nil
+		},
+		encode: func(plan ResponsePlan) ([]byte, error) {
+			dimensions := req.Dimensions
+			if dimensions == 0 {
+				dimensions = h.embeddingDimensions()
+			}
+			resp := embeddingResponse{Object: "list", Model: req.Model, Data: make([]embeddingEntry, len(req.inputs))}
+			if resp.Model == "" {
+				resp.Model = "text-embedding-3-small-mock"
+			}
+			for i, input := range req.inputs {
+				vector := embeddingVector(input, dimensions)
+				resp.Data[i] = embeddingEntry{Object: "embedding", Index: i, Embedding: vector}
+				if req.EncodingFormat == "base64" {
+					resp.Data[i].Embedding = base64Embedding(vector)
+				}
+			}
+			resp.Usage.PromptTokens = plan.PromptTokens
+			resp.Usage.TotalTokens = plan.PromptTokens
+			return encodeJSON(resp)
+		},
+	})
+}
+
+// embeddingDimensions returns the vector size of requests that don't ask for one
+func (h *Handler) embeddingDimensions() int {
+	if h.opts.EmbeddingDimensions > 0 {
+		return h.opts.EmbeddingDimensions
+	}
+	return DefaultEmbeddingDimensions
+}
+
+// embeddingVector returns a normally distributed unit vector seeded by the input, whose values
+// have the full float32 precision of real embeddings
+func embeddingVector(input string, dimensions int) []float32 {
+	hash := fnv.New64a()
+	hash.Write([]byte(input))
+	rng := rand.New(rand.NewPCG(hash.Sum64(), uint64(dimensions)))
+
+	values := make([]float64, dimensions)
+	var norm float64
+	for i := range values {
+		values[i] = rng.NormFloat64()
+		norm += values[i] * values[i]
+	}
+	norm = math.Sqrt(norm)
+
+	vector := make([]float32, dimensions)
+	for i, v := range values {
+		vector[i] = float32(v / norm)
+	}
+	return vector
+}
+
+// base64Embedding encodes a vector as OpenAI does for encoding_format base64
+func base64Embedding(vector []float32) string {
+	raw := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(raw[4*i:], math.Float32bits(v))
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,134 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// anyModel is the fixture model that answers requests for models without fixtures of their own
+const anyModel = "*"
+
+// Fixture is a canned chat completion served for requests to a model
+type Fixture struct {
+	Model    string          `json:"model"`              // Requested model it answers, "*" for any other model; defaults to the file name
+	Weight   int             `json:"weight"`             // Relative frequency among the model's fixtures, default 1
+	Response json.RawMessage `json:"response,omitempty"` // Response body, served as is apart from whitespace
+	Body     string          `json:"body,omitempty"`     // Exact response bytes served verbatim, as captured with -capture; overrides response
+}
+
+// catalogEntry is a loaded fixture with its cumulative weight and token counts
+type catalogEntry struct {
+	body             []byte
+	cumulative       int
+	promptTokens     int
+	completionTokens int
+	hasUsage         bool
+}
+
+// fixtureCatalog holds the fixtures of every model, in load order
+type fixtureCatalog struct {
+	models map[string][]catalogEntry
+}
+
+// loadFixtures reads every *.json file in dir as a Fixture
+func loadFixtures(dir string) (*fixtureCatalog, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fixtures: %v", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.json fixtures in %s", dir)
+	}
+
+	c := &fixtureCatalog{models: make(map[string][]catalogEntry)}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixture: %v", err)
+		}
+		var f Fixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to parse fixture %s: %v", path, err)
+		}
+		if f.Body != "" {
+			f.Response = json.RawMessage(f.Body)
+		}
+		if len(f.Response) == 0 {
+			return nil, fmt.Errorf("fixture %s has no response", path)
+		}
+		if f.Model == "" {
+			f.Model = strings.TrimSuffix(filepath.Base(path), ".json")
+		}
+		if f.Weight < 0 {
+			return nil, fmt.Errorf("fixture %s has a negative weight", path)
+		}
+		if f.Weight == 0 {
+			f.Weight = 1
+		}
+
+		var usage struct {
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal(f.Response, &usage); err != nil {
+			return nil, fmt.Errorf("fixture %s response is not a JSON object: %v", path, err)
+		}
+
+		// Served compact, like a real API, whatever the fixture's formatting, unless captured verbatim
+		body := []byte(f.Body)
+		if f.Body == "" {
+			var compact bytes.Buffer
+			json.Compact(&compact, f.Response)
+			compact.WriteByte('\n')
+			body = compact.Bytes()
+		}
+
+		entries := c.models[f.Model]
+		entry := catalogEntry{body: body, cumulative: f.Weight}
+		if len(entries) > 0 {
+			entry.cumulative += entries[len(entries)-1].cumulative
+		}
+		if usage.Usage != nil {
+			entry.hasUsage = true
+			entry.promptTokens = usage.Usage.PromptTokens
+			entry.completionTokens = usage.Usage.CompletionTokens
+		}
+		c.models[f.Model] = append(entries, entry)
+	}
+
+	log.Printf("Loaded %d fixtures for %d models from %s", len(paths), len(c.models), dir)
+	return c, nil
+}
+
+// Pick returns the fixture for a requested model, or false to serve the built-in response.
+// A provider prefix such as openai/ is ignored. The choice among a model's fixtures is derived
+// from the plan's sequence number, so seeded and replayed runs serve the same fixtures.
+func (c *fixtureCatalog) Pick(model string, seq int64) (catalogEntry, bool) {
+	entries, ok := c.models[model]
+	if !ok {
+		entries, ok = c.models[model[strings.LastIndex(model, "/")+1:]]
+	}
+	if !ok {
+		entries, ok = c.models[anyModel]
+	}
+	if !ok {
+		return catalogEntry{}, false
+	}
+
+	// Spread consecutive sequence numbers over the weights with a multiplicative hash
+	total := entries[len(entries)-1].cumulative
+	n := int((uint64(seq) * 0x9E3779B97F4A7C15 >> 33) % uint64(total))
+	for _, e := range entries {
+		if n < e.cumulative {
+			return e, true
+		}
+	}
+	return entries[len(entries)-1], true
+}
@@ -0,0 +1,163 @@
+package mock
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds (in seconds) of the simulated latency histogram
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// mockerMetrics holds the request accounting exposed on /metrics in Prometheus text format
+type mockerMetrics struct {
+	requests      atomic.Int64
+	http2Requests atomic.Int64
+	inFlight      atomic.Int64
+	bytesServed   atomic.Int64
+	truncated     atomic.Int64
+
+	realtimeSessions       atomic.Int64
+	realtimeActive         atomic.Int64
+	realtimeEventsSent     atomic.Int64
+	realtimeEventsReceived atomic.Int64
+
+	mu           sync.Mutex
+	bucketCounts []uint64
+	latencySum   float64
+	latencyCount uint64
+}
+
+func newMockerMetrics() *mockerMetrics {
+	return &mockerMetrics{bucketCounts: make([]uint64, len(latencyBuckets))}
+}
+
+// observeSimulatedLatency records an artificial delay added to a response
+func (m *mockerMetrics) observeSimulatedLatency(d time.Duration) {
+	seconds := d.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.bucketCounts[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// countingWriter counts the bytes written to the response body
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the server's writer, to flush truncated responses
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// wrap wraps a handler with request, in-flight and bytes served accounting
+func (m *mockerMetrics) wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.requests.Add(1)
+		if r.ProtoMajor == 2 {
+			m.http2Requests.Add(1)
+		}
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		cw := &countingWriter{ResponseWriter: w}
+		next(cw, r)
+		m.bytesServed.Add(cw.written)
+	}
+}
+
+// serveMetrics serves the mocker metrics in the Prometheus text exposition format
+func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := h.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintf(w, "# HELP mocker_requests_total Total requests received.\n")
+	fmt.Fprintf(w, "# TYPE mocker_requests_total counter\n")
+	fmt.Fprintf(w, "mocker_requests_total %d\n", metrics.requests.Load())
+
+	fmt.Fprintf(w, "# HELP mocker_http2_requests_total Requests received over HTTP/2.\n")
+	fmt.Fprintf(w, "# TYPE mocker_http2_requests_total counter\n")
+	fmt.Fprintf(w, "mocker_http2_requests_total %d\n", metrics.http2Requests.Load())
+
+	fmt.Fprintf(w, "# HELP mocker_requests_in_flight Requests currently being served.\n")
+	fmt.Fprintf(w, "# TYPE mocker_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "mocker_requests_in_flight %d\n", metrics.inFlight.Load())
+
+	fmt.Fprintf(w, "# HELP mocker_bytes_served_total Total response body bytes served.\n")
+	fmt.Fprintf(w, "# TYPE mocker_bytes_served_total counter\n")
+	fmt.Fprintf(w, "mocker_bytes_served_total %d\n", metrics.bytesServed.Load())
+
+	if h.opts.TruncateRate > 0 || h.opts.ReplayFile != "" {
+		fmt.Fprintf(w, "# HELP mocker_truncated_responses_total Responses dropped partway through the body.\n")
+		fmt.Fprintf(w, "# TYPE mocker_truncated_responses_total counter\n")
+		fmt.Fprintf(w, "mocker_truncated_responses_total %d\n", metrics.truncated.Load())
+	}
+
+	if sessions := metrics.realtimeSessions.Load(); sessions > 0 {
+		fmt.Fprintf(w, "# HELP mocker_realtime_sessions_total WebSocket sessions opened on /v1/realtime.\n")
+		fmt.Fprintf(w, "# TYPE mocker_realtime_sessions_total counter\n")
+		fmt.Fprintf(w, "mocker_realtime_sessions_total %d\n", sessions)
+
+		fmt.Fprintf(w, "# HELP mocker_realtime_sessions_active Realtime sessions currently open.\n")
+		fmt.Fprintf(w, "# TYPE mocker_realtime_sessions_active gauge\n")
+		fmt.Fprintf(w, "mocker_realtime_sessions_active %d\n", metrics.realtimeActive.Load())
+
+		fmt.Fprintf(w, "# HELP mocker_realtime_events_sent_total Server events sent on realtime sessions.\n")
+		fmt.Fprintf(w, "# TYPE mocker_realtime_events_sent_total counter\n")
+		fmt.Fprintf(w, "mocker_realtime_events_sent_total %d\n", metrics.realtimeEventsSent.Load())
+
+		fmt.Fprintf(w, "# HELP mocker_realtime_events_received_total Client events received on realtime sessions.\n")
+		fmt.Fprintf(w, "# TYPE mocker_realtime_events_received_total counter\n")
+		fmt.Fprintf(w, "mocker_realtime_events_received_total %d\n", metrics.realtimeEventsReceived.Load())
+	}
+
+	if h.coldStart != nil {
+		fmt.Fprintf(w, "# HELP mocker_slow_start_latency_seconds Extra latency a request arriving now gets from the slow start.\n")
+		fmt.Fprintf(w, "# TYPE mocker_slow_start_latency_seconds gauge\n")
+		fmt.Fprintf(w, "mocker_slow_start_latency_seconds %g\n", h.coldStart.Current(time.Now()).Seconds())
+	}
+
+	if h.outages != nil {
+		active := 0
+		if _, down := h.outages.active(time.Now()); down {
+			active = 1
+		}
+		fmt.Fprintf(w, "# HELP mocker_outage_active Whether a scheduled outage is in progress.\n")
+		fmt.Fprintf(w, "# TYPE mocker_outage_active gauge\n")
+		fmt.Fprintf(w, "mocker_outage_active %d\n", active)
+
+		fmt.Fprintf(w, "# HELP mocker_outage_rejected_total Requests answered with a 503 or dropped during outages.\n")
+		fmt.Fprintf(w, "# TYPE mocker_outage_rejected_total counter\n")
+		fmt.Fprintf(w, "mocker_outage_rejected_total %d\n", h.outages.rejected.Load())
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP mocker_simulated_latency_seconds Artificial latency added to responses.\n")
+	fmt.Fprintf(w, "# TYPE mocker_simulated_latency_seconds histogram\n")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "mocker_simulated_latency_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(bound, 'g', -1, 64), metrics.bucketCounts[i])
+	}
+	fmt.Fprintf(w, "mocker_simulated_latency_seconds_bucket{le=\"+Inf\"} %d\n", metrics.latencyCount)
+	fmt.Fprintf(w, "mocker_simulated_latency_seconds_sum %g\n", metrics.latencySum)
+	fmt.Fprintf(w, "mocker_simulated_latency_seconds_count %d\n", metrics.latencyCount)
+}
@@ -0,0 +1,511 @@
+// Package mock is an in-process mock of the OpenAI chat completions, embeddings and realtime
+// APIs with controllable latency, errors, rate limits and fixtures. It is the handler behind the mocker
+// command, and Go tests can start it with httptest instead of running the binary:
+//
+//	srv, err := mock.NewServer(mock.Options{Latency: 50 * time.Millisecond, ErrorRate: 0.01})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//	// Point the client under test at srv.URL + "/v1/chat/completions"
+package mock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maximhq/bifrost/core/schemas"
+)
+
+type OpenAIResponse struct {
+	ID                string                          `json:"id"`                 // Unique identifier for the completion
+	Object            string                          `json:"object"`             // Type of completion (text.completion or chat.completion)
+	Choices           []schemas.BifrostResponseChoice `json:"choices"`            // Array of completion choices
+	Model             string                          `json:"model"`              // Model used for the completion
+	Created           int                             `json:"created"`            // Unix timestamp of completion creation
+	ServiceTier       *string                         `json:"service_tier"`       // Service tier used for the request
+	SystemFingerprint *string                         `json:"system_fingerprint"` // System fingerprint for the request
+	Usage             schemas.LLMUsage                `json:"usage"`              // Token usage statistics
+}
+
+// OpenAIError represents the error response structure from the OpenAI API.
+// It includes detailed error information and event tracking.
+type OpenAIError struct {
+	EventID string `json:"event_id"` // Unique identifier for the error event
+	Type    string `json:"type"`     // Type of error
+	Error   struct {
+		Type    string      `json:"type"`     // Error type
+		Code    string      `json:"code"`     // Error code
+		Message string      `json:"message"`  // Error message
+		Param   interface{} `json:"param"`    // Parameter that caused the error
+		EventID string      `json:"event_id"` // Event ID for tracking
+	} `json:"error"`
+}
+
+// instanceHeader names the instance that served a response in -instances mode
+const instanceHeader = "X-Mock-Instance"
+
+// defaultCaptureUpstream is proxied to when capturing without an explicit upstream
+const defaultCaptureUpstream = "https://api.openai.com"
+
+// Options configures a mock server. The zero value answers every request at once with a short
+// completion and random token counts.
+type Options struct {
+	Latency            time.Duration // Delay of every response
+	Jitter             time.Duration // Random extra latency, uniform in [0, Jitter)
+	ErrorRate          float64       // Fraction of requests answered with a 500 error (0-1)
+	TruncateRate       float64       // Fraction of successful responses dropped partway through the body (0-1)
+	TruncateDelay      time.Duration // Random wait, uniform in [0, TruncateDelay), before a truncated response is dropped
+	BigPayload         bool          // Serve about 10KB completions instead of one sentence
+	LatencyPer1kTokens time.Duration // Extra latency per 1000 prompt tokens, estimated from the request body size
+	Compress           string        // Content-Encoding of every completion (gzip or br), empty for none
+
+	// Vector size of /v1/embeddings responses for requests without dimensions, 0 for 1536
+	EmbeddingDimensions int
+
+	RealtimeDeltas    int     // Deltas streamed per /v1/realtime response, 0 for 20
+	RealtimeEventRate float64 // Deltas per second within a realtime response, 0 sends them back to back
+
+	PromptCostPer1k     float64 // Simulated USD cost per 1000 prompt tokens, reported on /admin/usage
+	CompletionCostPer1k float64 // Simulated USD cost per 1000 completion tokens
+
+	LogErrors bool // Log every injected error with the request's X-Request-ID
+
+	// Answer with the size and SHA-256 of the received request body, so a client can check that
+	// the gateway forwarded its body intact
+	BodyChecksum bool
+
+	FixturesDir     string // Directory of JSON fixtures with canned completions, picked per requested model by weight
+	CaptureDir      string // Proxy to CaptureUpstream and save every successful response as a fixture here
+	CaptureUpstream string // OpenAI compatible API proxied to when capturing, default https://api.openai.com
+
+	RateLimitRPM   int // Requests per minute allowed per API key before answering 429 like OpenAI, 0 for no limit
+	RateLimitTPM   int // Tokens per minute allowed per API key, 0 for no limit
+	RateLimitBurst int // Requests a key can send at once, 0 allows a whole minute's requests
+
+	Seed       int64  // Seed for token counts, jitter and errors, 0 picks a random seed
+	RecordFile string // Record the sequence of response plans to this JSONL file
+	ReplayFile string // Replay a sequence recorded with RecordFile instead of drawing random responses
+
+	SlowStart        time.Duration // Traffic until a cold upstream is warm, 0 disables the slow start
+	SlowStartLatency time.Duration // Extra latency of the first request of a slow start
+	SlowStartCurve   string        // How the extra latency decays: linear (default), exp or step
+	SlowStartIdle    time.Duration // Start cold again after this long without requests, 0 stays warm
+
+	// Name echoed in the X-Mock-Instance header of every response, to tell the instances of a
+	// cluster apart; empty for none
+	Instance string
+
+	Outages    []Outage // Windows, from when the handler was created, in which the upstream is down
+	OutageMode string   // 503 (default) answers outage requests with 503s, refuse drops their connections
+}
+
+// Handler serves the mock API: /v1/chat/completions, /v1/embeddings, /v1/realtime, /metrics,
+// /traces and /admin/usage
+type Handler struct {
+	opts Options
+	mux  *http.ServeMux
+
+	plans     *planner
+	catalog   *fixtureCatalog
+	limiter   *rateLimiter
+	coldStart *slowStart
+	outages   *outageSchedule
+	metrics   *mockerMetrics
+	traces    *traceStore
+	usage     *usageLedger
+}
+
+// NewHandler validates the options and loads fixtures and replayed plans
+func NewHandler(opts Options) (*Handler, error) {
+	if err := validateCompression(opts.Compress); err != nil {
+		return nil, fmt.Errorf("invalid compression: %v", err)
+	}
+	if opts.RateLimitRPM < 0 || opts.RateLimitTPM < 0 {
+		return nil, fmt.Errorf("invalid rate limits: requests and tokens per minute must not be negative")
+	}
+	if opts.EmbeddingDimensions < 0 || opts.EmbeddingDimensions > maxEmbeddingDimensions {
+		return nil, fmt.Errorf("invalid embedding dimensions: must be between 1 and %d", maxEmbeddingDimensions)
+	}
+	if opts.RealtimeDeltas < 0 || opts.RealtimeEventRate < 0 {
+		return nil, fmt.Errorf("invalid realtime settings: deltas and event rate must not be negative")
+	}
+	if opts.TruncateRate < 0 || opts.TruncateRate > 1 || opts.TruncateDelay < 0 {
+		return nil, fmt.Errorf("invalid truncation: the rate must be between 0 and 1 and the delay not negative")
+	}
+
+	h := &Handler{
+		opts:    opts,
+		mux:     http.NewServeMux(),
+		metrics: newMockerMetrics(),
+		traces:  &traceStore{},
+		usage:   newUsageLedger(opts.PromptCostPer1k, opts.CompletionCostPer1k),
+	}
+
+	var err error
+	if opts.FixturesDir != "" {
+		if h.catalog, err = loadFixtures(opts.FixturesDir); err != nil {
+			return nil, fmt.Errorf("failed to load fixtures: %v", err)
+		}
+	}
+	if opts.RateLimitRPM > 0 || opts.RateLimitTPM > 0 {
+		h.limiter = newRateLimiter(opts.RateLimitRPM, opts.RateLimitTPM, opts.RateLimitBurst)
+	}
+	if opts.SlowStart > 0 {
+		curve := opts.SlowStartCurve
+		if curve == "" {
+			curve = "linear"
+		}
+		if h.coldStart, err = newSlowStart(opts.SlowStartLatency, opts.SlowStart, curve, opts.SlowStartIdle); err != nil {
+			return nil, fmt.Errorf("invalid slow start: %v", err)
+		}
+	}
+	if len(opts.Outages) > 0 {
+		if h.outages, err = newOutageSchedule(opts.Outages, opts.OutageMode, time.Now()); err != nil {
+			return nil, fmt.Errorf("invalid outages: %v", err)
+		}
+	}
+	if h.plans, err = newPlanner(opts); err != nil {
+		return nil, fmt.Errorf("failed to set up response plans: %v", err)
+	}
+
+	if opts.CaptureDir != "" {
+		upstream := opts.CaptureUpstream
+		if upstream == "" {
+			upstream = defaultCaptureUpstream
+		}
+		capture, err := newCaptureProxy(upstream, opts.CaptureDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up capture: %v", err)
+		}
+		log.Printf("Capturing responses from %s to %s", upstream, opts.CaptureDir)
+		h.mux.HandleFunc("/v1/chat/completions", h.metrics.wrap(capture.Handler))
+	} else {
+		h.mux.HandleFunc("/v1/chat/completions", h.metrics.wrap(withCompression(opts.Compress, h.chatCompletions)))
+	}
+	h.mux.HandleFunc("/v1/embeddings", h.metrics.wrap(withCompression(opts.Compress, h.embeddings)))
+	// Realtime sessions are counted apart from requests: a connection lasts for many responses
+	h.mux.HandleFunc("/v1/realtime", h.realtime)
+	h.mux.HandleFunc("/metrics", h.serveMetrics)
+	h.mux.Handle("/traces", h.traces)
+	h.mux.Handle("/admin/usage", h.usage)
+	return h, nil
+}
+
+// ServeHTTP routes a request to the mock API endpoint for its path
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Instance != "" {
+		w.Header().Set(instanceHeader, h.opts.Instance)
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// Close flushes and closes the -record file of response plans
+func (h *Handler) Close() error {
+	return h.plans.Close()
+}
+
+// Requests returns how many chat completion and embeddings requests were received
+func (h *Handler) Requests() int64 {
+	return h.metrics.requests.Load()
+}
+
+// Usage returns the requests, tokens and cost accounted over every API key
+func (h *Handler) Usage() KeyUsage {
+	_, total := h.usage.snapshot(false)
+	return total
+}
+
+// Server is a mock API listening on a local loopback address, closed with Close
+type Server struct {
+	*httptest.Server
+	*Handler
+}
+
+// NewServer starts a mock API over plain HTTP, like httptest.NewServer
+func NewServer(opts Options) (*Server, error) {
+	h, err := NewHandler(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{Server: httptest.NewServer(h), Handler: h}, nil
+}
+
+// NewTLSServer starts a mock API over HTTPS, like httptest.NewTLSServer. Use the embedded
+// server's Client, which trusts its certificate.
+func NewTLSServer(opts Options) (*Server, error) {
+	h, err := NewHandler(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{Server: httptest.NewTLSServer(h), Handler: h}, nil
+}
+
+// Close shuts the server down, then closes the handler's recording
+func (s *Server) Close() {
+	s.Server.Close()
+	if err := s.Handler.Close(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// bytesPerToken is the rough size of an English token, used to estimate prompt tokens from the body
+const bytesPerToken = 4
+
+// readBody reads the request body, for responses that depend on it
+func readBody(r *http.Request) []byte {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Warning: Could not read request body: %v", err)
+	}
+	return body
+}
+
+// Headers describing the request body as the mocker received it
+const (
+	bodyBytesHeader  = "X-Mock-Body-Bytes"
+	bodySHA256Header = "X-Mock-Body-Sha256"
+)
+
+// setBodyChecksum echoes the received body's size and hex SHA-256
+func setBodyChecksum(w http.ResponseWriter, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set(bodyBytesHeader, strconv.Itoa(len(body)))
+	w.Header().Set(bodySHA256Header, hex.EncodeToString(sum[:]))
+}
+
+// requestedModel returns the model named in a chat completion request body
+func requestedModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	json.Unmarshal(body, &req)
+	return req.Model
+}
+
+// StrPtr creates a pointer to a string value.
+func StrPtr(s string) *string {
+	return &s
+}
+
+// apiEndpoint is what sets one mocked API apart. Planning, outages, rate limits, latency,
+// injected errors, truncation and usage accounting are shared by every endpoint.
+type apiEndpoint struct {
+	fixtures bool // Fixtures answer the endpoint's requests for their models
+	// prepare parses the request body and sets the plan's token counts from it, nil keeps the
+	// planned counts without reading the body. Its error is answered with a 400.
+	prepare func(body []byte, plan *ResponsePlan) error
+	encode  func(plan ResponsePlan) ([]byte, error) // Encodes a successful response
+}
+
+// chatCompletions answers a chat completion as planned: after the planned latency, with an
+// injected error, a rate limit refusal, a fixture or the built-in completion
+func (h *Handler) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	h.answer(w, r, apiEndpoint{fixtures: true, encode: h.completion})
+}
+
+// answer serves a request to an API endpoint as planned
+func (h *Handler) answer(w http.ResponseWriter, r *http.Request, api apiEndpoint) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	receivedAt := time.Now()
+	defer h.traces.record(r, receivedAt)
+
+	// Echo the request ID so a response can be matched to this request end to end
+	requestID := r.Header.Get(requestIDHeader)
+	if requestID != "" {
+		w.Header().Set(requestIDHeader, requestID)
+	}
+
+	// Outages take no plan, so a replayed sequence lines up with the requests actually served
+	if h.outages != nil {
+		if outage, down := h.outages.active(receivedAt); down {
+			if h.opts.LogErrors {
+				log.Printf("Outage rejected request %s", requestID)
+			}
+			h.outages.reject(w, outage, receivedAt)
+			return
+		}
+	}
+
+	plan := h.plans.Next()
+	defer func() { h.plans.Record(plan) }()
+	if err := applyControlHeaders(r, &plan); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The body is only read when the response depends on it
+	var body []byte
+	if h.opts.LatencyPer1kTokens > 0 || h.catalog != nil || h.limiter != nil || h.opts.BodyChecksum || api.prepare != nil {
+		body = readBody(r)
+	}
+	if h.opts.BodyChecksum {
+		setBodyChecksum(w, body)
+	}
+	if api.prepare != nil {
+		if err := api.prepare(body, &plan); err != nil {
+			writeInvalidRequest(w, err)
+			return
+		}
+	} else if h.opts.LatencyPer1kTokens > 0 {
+		plan.PromptTokens = len(body) / bytesPerToken
+	}
+
+	// Bigger prompts take longer upstream: add the per-token cost on top of the planned latency
+	delay := plan.Latency()
+	if h.opts.LatencyPer1kTokens > 0 {
+		delay += time.Duration(float64(plan.PromptTokens) / 1000 * float64(h.opts.LatencyPer1kTokens))
+	}
+	// A cold upstream is slower until it has warmed up
+	if h.coldStart != nil {
+		delay += h.coldStart.Delay(receivedAt)
+	}
+
+	// A fixture's own usage is what the gateway sees, so it is what gets accounted
+	fixture, hasFixture := catalogEntry{}, false
+	if h.catalog != nil && api.fixtures {
+		fixture, hasFixture = h.catalog.Pick(requestedModel(body), plan.Seq)
+		if hasFixture && fixture.hasUsage {
+			plan.PromptTokens, plan.CompletionTokens = fixture.promptTokens, fixture.completionTokens
+		}
+	}
+
+	// Rate limits are checked on arrival: refused requests are answered at once and never billed
+	if h.limiter != nil {
+		decision := h.limiter.Take(apiKey(r), plan.PromptTokens+plan.CompletionTokens)
+		setRateLimitHeaders(w, decision)
+		if !decision.allowed {
+			plan.Status = http.StatusTooManyRequests
+			h.usage.record(r, plan)
+			if h.opts.LogErrors {
+				log.Printf("Rate limited request %s on %s", requestID, decision.exceeded)
+			}
+			writeRateLimited(w, decision, requestedModel(body), plan.PromptTokens+plan.CompletionTokens)
+			return
+		}
+	}
+
+	// Simulate latency
+	if delay > 0 {
+		time.Sleep(delay)
+		h.metrics.observeSimulatedLatency(delay)
+	}
+	h.usage.record(r, plan)
+
+	if plan.Status != http.StatusOK {
+		if h.opts.LogErrors {
+			log.Printf("Injected HTTP %d for request %s", plan.Status, requestID)
+		}
+		writeMockError(w, plan.Status)
+		return
+	}
+
+	if hasFixture {
+		if plan.TruncateAt > 0 {
+			h.writeTruncated(w, fixture.body, plan, requestID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(fixture.body)
+		return
+	}
+
+	encoded, err := api.encode(plan)
+	if err != nil {
+		log.Printf("Error encoding mock response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	if plan.TruncateAt > 0 {
+		h.writeTruncated(w, encoded, plan, requestID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// completion encodes the built-in chat completion with the plan's token counts
+func (h *Handler) completion(plan ResponsePlan) ([]byte, error) {
+	mockContent := "This is a mocked response from the OpenAI mocker server."
+	if h.opts.BigPayload {
+		// Repeat content to generate approximately 10KB response
+		// Each repetition is ~55 chars, so ~182 repetitions ≈ 10KB
+		mockContent = strings.Repeat(mockContent, 182)
+	}
+
+	// Create a mock response
+	mockChoiceMessage := schemas.BifrostResponseChoiceMessage{
+		Role:    schemas.ModelChatMessageRole("assistant"),
+		Content: StrPtr(mockContent),
+	}
+	mockChoice := schemas.BifrostResponseChoice{
+		Index:        0,
+		Message:      mockChoiceMessage,
+		FinishReason: StrPtr("stop"),
+	}
+
+	inputTokens := plan.PromptTokens
+	outputTokens := plan.CompletionTokens
+
+	mockResp := OpenAIResponse{
+		ID:      "cmpl-mock12345",
+		Object:  "chat.completion",
+		Created: int(time.Now().Unix()),
+		Model:   "gpt-3.5-turbo-mock",
+		Choices: []schemas.BifrostResponseChoice{mockChoice},
+		Usage: schemas.LLMUsage{
+			PromptTokens:     inputTokens,
+			CompletionTokens: outputTokens,
+			TotalTokens:      inputTokens + outputTokens,
+		},
+	}
+	return encodeJSON(mockResp)
+}
+
+// encodeJSON encodes a response body like json.Encoder does, with a trailing newline
+func encodeJSON(v any) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(encoded, '\n'), nil
+}
+
+// writeMockError answers with an OpenAI style error
+func writeMockError(w http.ResponseWriter, status int) {
+	var mockErr OpenAIError
+	mockErr.Type = "error"
+	mockErr.Error.Type = "server_error"
+	mockErr.Error.Code = "mock_injected_error"
+	mockErr.Error.Message = "The mocker injected this error."
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(mockErr)
+}
+
+// writeInvalidRequest answers a request the mocked API can't parse with an OpenAI style 400
+func writeInvalidRequest(w http.ResponseWriter, err error) {
+	var mockErr OpenAIError
+	mockErr.Type = "error"
+	mockErr.Error.Type = "invalid_request_error"
+	mockErr.Error.Code = "invalid_request"
+	mockErr.Error.Message = err.Error()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(mockErr)
+}
@@ -0,0 +1,197 @@
+package mock
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outage is a window in which the upstream is down, measured from when the mocker started
+type Outage struct {
+	Start    time.Duration
+	Duration time.Duration
+}
+
+// ParseOutages parses a comma separated schedule of duration@start windows, e.g. 30s@60s for a
+// 30 second outage starting a minute after the mocker started
+func ParseOutages(s string) ([]Outage, error) {
+	var outages []Outage
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		length, start, ok := strings.Cut(part, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid outage %q: use duration@start, e.g. 30s@60s", part)
+		}
+		var outage Outage
+		var err error
+		if outage.Duration, err = time.ParseDuration(length); err != nil || outage.Duration <= 0 {
+			return nil, fmt.Errorf("invalid outage %q: the duration must be positive", part)
+		}
+		if outage.Start, err = time.ParseDuration(start); err != nil || outage.Start < 0 {
+			return nil, fmt.Errorf("invalid outage %q: the start must not be negative", part)
+		}
+		outages = append(outages, outage)
+	}
+	return outages, nil
+}
+
+// outageSchedule answers requests during scheduled outages: with 503s, or in refuse mode by
+// dropping the request's connection while its listener stops accepting new ones
+type outageSchedule struct {
+	outages []Outage
+	refuse  bool
+	started time.Time
+
+	rejected atomic.Int64
+}
+
+// newOutageSchedule validates the outage mode, 503 or refuse
+func newOutageSchedule(outages []Outage, mode string, started time.Time) (*outageSchedule, error) {
+	switch mode {
+	case "", "503", "refuse":
+	default:
+		return nil, fmt.Errorf("unknown outage mode %q (use 503 or refuse)", mode)
+	}
+	return &outageSchedule{outages: outages, refuse: mode == "refuse", started: started}, nil
+}
+
+// active returns the outage the upstream is in at now, if any
+func (s *outageSchedule) active(now time.Time) (Outage, bool) {
+	elapsed := now.Sub(s.started)
+	for _, outage := range s.outages {
+		if elapsed >= outage.Start && elapsed < outage.Start+outage.Duration {
+			return outage, true
+		}
+	}
+	return Outage{}, false
+}
+
+// reject answers a request that arrived during an outage. In refuse mode the handler aborts,
+// which makes net/http close the connection without a response, like a crashed upstream.
+func (s *outageSchedule) reject(w http.ResponseWriter, outage Outage, now time.Time) {
+	s.rejected.Add(1)
+	if s.refuse {
+		panic(http.ErrAbortHandler)
+	}
+	remaining := outage.Start + outage.Duration - now.Sub(s.started)
+	w.Header().Set("Retry-After", fmt.Sprint(max(int(remaining.Seconds()+0.999), 1)))
+	writeMockError(w, http.StatusServiceUnavailable)
+}
+
+// WrapListener stops accepting connections during refuse mode outages: the listening socket is
+// closed at the start of each outage, so new connections are refused, and reopened at its end.
+// Without refuse mode the listener is returned as is.
+func (h *Handler) WrapListener(ln net.Listener) net.Listener {
+	if h.outages == nil || !h.outages.refuse {
+		return ln
+	}
+	l := &outageListener{Listener: ln, addr: ln.Addr().String(), reopened: make(chan struct{})}
+	go l.run(h.outages)
+	return l
+}
+
+// outageListener swaps its listening socket out for the length of every outage
+type outageListener struct {
+	net.Listener
+	addr string
+
+	mu       sync.Mutex
+	down     bool
+	reopened chan struct{} // Closed when the socket is listening again after an outage
+	closed   bool
+	err      error // Why the socket couldn't be reopened, returned by Accept from then on
+}
+
+// run closes and reopens the socket on schedule
+func (l *outageListener) run(s *outageSchedule) {
+	for _, outage := range s.outages {
+		time.Sleep(time.Until(s.started.Add(outage.Start)))
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		l.down = true
+		l.Listener.Close()
+		l.mu.Unlock()
+		log.Printf("Outage: refusing connections for %s", outage.Duration)
+
+		time.Sleep(time.Until(s.started.Add(outage.Start + outage.Duration)))
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		ln, err := net.Listen("tcp", l.addr)
+		if err != nil {
+			// Accept returns the error, so Serve stops with it
+			l.err = fmt.Errorf("failed to listen again on %s after the outage: %v", l.addr, err)
+			close(l.reopened)
+			l.mu.Unlock()
+			log.Printf("Outage: %v", l.err)
+			return
+		}
+		l.Listener, l.down = ln, false
+		close(l.reopened)
+		l.reopened = make(chan struct{})
+		l.mu.Unlock()
+		log.Printf("Outage over: accepting connections again")
+	}
+}
+
+// Accept waits out outages instead of failing, so the server keeps serving afterwards
+func (l *outageListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		ln, reopened := l.Listener, l.reopened
+		l.mu.Unlock()
+
+		conn, err := ln.Accept()
+		if err == nil {
+			return conn, nil
+		}
+
+		// The socket failed because an outage closed it, unless the whole listener was closed
+		l.mu.Lock()
+		swapped := l.down || l.Listener != ln
+		closed := l.closed
+		l.mu.Unlock()
+		if closed || !swapped {
+			return nil, err
+		}
+		<-reopened
+
+		l.mu.Lock()
+		failed := l.err
+		l.mu.Unlock()
+		if failed != nil {
+			return nil, failed
+		}
+	}
+}
+
+// Close stops the listener for good, waking an Accept waiting out an outage
+func (l *outageListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	if l.down {
+		// A failed reopen has already closed reopened
+		if l.err == nil {
+			close(l.reopened)
+		}
+		return nil
+	}
+	return l.Listener.Close()
+}
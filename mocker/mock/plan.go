@@ -0,0 +1,233 @@
+package mock
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ResponsePlan is everything random about one response. Plans are drawn in arrival order, so a
+// seeded or replayed sequence gives the nth request the same response in every run.
+type ResponsePlan struct {
+	Seq              int64   `json:"seq"`
+	LatencyMs        float64 `json:"latency_ms"`
+	Status           int     `json:"status"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+
+	// Fraction of the body sent before the connection is dropped, 0 for a complete response
+	TruncateAt      float64 `json:"truncate_at,omitempty"`
+	TruncateDelayMs float64 `json:"truncate_delay_ms,omitempty"` // Wait between the partial body and the drop
+}
+
+// randSource is what plans are drawn from
+type randSource interface {
+	Intn(n int) int
+	Float64() float64
+}
+
+// globalRand is math/rand's shared source, safe for concurrent use without a lock
+type globalRand struct{}
+
+func (globalRand) Intn(n int) int   { return rand.Intn(n) }
+func (globalRand) Float64() float64 { return rand.Float64() }
+
+// planner draws response plans from a random source, or from a replayed recording. Only a
+// seeded source is drawn from under a lock, so that plans follow arrival order; unseeded and
+// replayed plans are drawn without one.
+type planner struct {
+	latency   time.Duration
+	jitter    time.Duration
+	errorRate float64
+
+	truncateRate  float64
+	truncateDelay time.Duration
+
+	mu       sync.Mutex // Held while drawing from a seeded source
+	seeded   bool
+	rng      randSource
+	seq      atomic.Int64
+	replay   []ResponsePlan
+	recorder *planRecorder
+}
+
+// newPlanner creates the planner for the given options. A seed of 0 draws from math/rand's
+// shared source, so those runs can't be reproduced without -record.
+func newPlanner(opts Options) (*planner, error) {
+	p := &planner{
+		latency:       opts.Latency,
+		jitter:        opts.Jitter,
+		errorRate:     opts.ErrorRate,
+		truncateRate:  opts.TruncateRate,
+		truncateDelay: opts.TruncateDelay,
+		seeded:        opts.Seed != 0,
+		rng:           globalRand{},
+	}
+
+	switch {
+	case opts.ReplayFile != "":
+		replay, err := loadPlans(opts.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		p.replay = replay
+		log.Printf("Replaying %d recorded responses from %s", len(replay), opts.ReplayFile)
+	case p.seeded:
+		p.rng = rand.New(rand.NewSource(opts.Seed))
+		log.Printf("Using seed %d", opts.Seed)
+	}
+
+	if opts.RecordFile != "" {
+		recorder, err := newPlanRecorder(opts.RecordFile)
+		if err != nil {
+			return nil, err
+		}
+		p.recorder = recorder
+	}
+	return p, nil
+}
+
+// Next returns the plan for the next request to arrive
+func (p *planner) Next() ResponsePlan {
+	if len(p.replay) > 0 {
+		seq := p.seq.Add(1) - 1
+		plan := p.replay[seq%int64(len(p.replay))]
+		plan.Seq = seq
+		return plan
+	}
+	if p.seeded {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+	}
+
+	plan := ResponsePlan{
+		LatencyMs:        durationMs(p.latency),
+		Status:           http.StatusOK,
+		PromptTokens:     p.rng.Intn(1000),
+		CompletionTokens: p.rng.Intn(1000),
+	}
+	if p.jitter > 0 {
+		plan.LatencyMs += p.rng.Float64() * durationMs(p.jitter)
+	}
+	if p.errorRate > 0 && p.rng.Float64() < p.errorRate {
+		plan.Status = http.StatusInternalServerError
+	}
+	if plan.Status == http.StatusOK && p.truncateRate > 0 && p.rng.Float64() < p.truncateRate {
+		plan.TruncateAt = minTruncateAt + p.rng.Float64()*(maxTruncateAt-minTruncateAt)
+		plan.TruncateDelayMs = p.rng.Float64() * durationMs(p.truncateDelay)
+	}
+	plan.Seq = p.seq.Add(1) - 1
+	return plan
+}
+
+// Record adds the plan a request was answered with to the recording, if there is one. Plans
+// are recorded as served, after the body, fixtures and control headers changed them.
+func (p *planner) Record(plan ResponsePlan) {
+	if p.recorder != nil {
+		p.recorder.record(plan)
+	}
+}
+
+// Close flushes and closes the recording
+func (p *planner) Close() error {
+	if p.recorder == nil {
+		return nil
+	}
+	return p.recorder.close()
+}
+
+// planRecorder writes plans as JSON lines through a buffer. Requests finish out of order, so
+// the lines are too; loadPlans sorts them by Seq.
+type planRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+func newPlanRecorder(path string) (*planRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record file: %v", err)
+	}
+	buf := bufio.NewWriter(file)
+	return &planRecorder{file: file, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (r *planRecorder) record(plan ResponsePlan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	if err := r.enc.Encode(plan); err != nil {
+		log.Printf("Warning: Could not record response plan: %v", err)
+	}
+}
+
+func (r *planRecorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.buf.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to write record file: %v", err)
+	}
+	return nil
+}
+
+// durationMs converts a duration to fractional milliseconds, the unit plans are recorded in
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Latency returns the planned delay
+func (plan ResponsePlan) Latency() time.Duration {
+	return time.Duration(plan.LatencyMs * float64(time.Millisecond))
+}
+
+// TruncateDelay returns the planned wait before a truncated response is dropped
+func (plan ResponsePlan) TruncateDelay() time.Duration {
+	return time.Duration(plan.TruncateDelayMs * float64(time.Millisecond))
+}
+
+func loadPlans(path string) ([]ResponsePlan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %v", err)
+	}
+	defer file.Close()
+
+	var replay []ResponsePlan
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var plan ResponsePlan
+		if err := json.Unmarshal(scanner.Bytes(), &plan); err != nil {
+			return nil, fmt.Errorf("failed to parse replay file: %v", err)
+		}
+		replay = append(replay, plan)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read replay file: %v", err)
+	}
+	if len(replay) == 0 {
+		return nil, fmt.Errorf("replay file %s is empty", path)
+	}
+	slices.SortStableFunc(replay, func(a, b ResponsePlan) int { return cmp.Compare(a.Seq, b.Seq) })
+	return replay, nil
+}
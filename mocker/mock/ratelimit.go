@@ -0,0 +1,178 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket holds up to capacity units, refilled continuously at perSecond
+type tokenBucket struct {
+	capacity  float64
+	perSecond float64
+	available float64
+	updated   time.Time
+}
+
+// refill adds what accumulated since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	b.available = min(b.capacity, b.available+now.Sub(b.updated).Seconds()*b.perSecond)
+	b.updated = now
+}
+
+// wait is how long until n units are available
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.available >= n {
+		return 0
+	}
+	return time.Duration((n - b.available) / b.perSecond * float64(time.Second))
+}
+
+// reset is how long until the bucket is full again, what OpenAI reports in x-ratelimit-reset-*
+func (b *tokenBucket) reset() time.Duration {
+	return time.Duration((b.capacity - b.available) / b.perSecond * float64(time.Second))
+}
+
+// keyLimits are the request and token buckets of one API key
+type keyLimits struct {
+	requests tokenBucket
+	tokens   tokenBucket
+}
+
+// rateDecision is the outcome of a rate limit check, with the values of the x-ratelimit headers
+type rateDecision struct {
+	allowed           bool
+	limitRequests     int
+	limitTokens       int
+	remainingRequests int
+	remainingTokens   int
+	resetRequests     time.Duration
+	resetTokens       time.Duration
+	retryAfter        time.Duration // Only set when the request was refused
+	exceeded          string        // "requests" or "tokens", the limit that refused the request
+	used              int           // Usage of the exceeded limit
+}
+
+// rateLimiter applies OpenAI style per-key limits on requests and tokens per minute, with
+// token buckets that allow bursts up to the bucket size
+type rateLimiter struct {
+	rpm   int
+	tpm   int
+	burst int
+
+	mu   sync.Mutex
+	keys map[string]*keyLimits
+}
+
+// newRateLimiter limits every key to rpm requests and tpm tokens per minute, 0 for no limit.
+// A burst of 0 lets a key spend a whole minute's requests at once, like OpenAI.
+func newRateLimiter(rpm, tpm, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = rpm
+	}
+	return &rateLimiter{rpm: rpm, tpm: tpm, burst: burst, keys: make(map[string]*keyLimits)}
+}
+
+// Take charges a request for the given tokens to a key, or refuses it when either limit is exhausted.
+// Refused requests cost nothing, so clients that back off as told get through.
+func (l *rateLimiter) Take(key string, tokens int) rateDecision {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k, ok := l.keys[key]
+	if !ok {
+		k = &keyLimits{
+			requests: tokenBucket{capacity: float64(l.burst), perSecond: float64(l.rpm) / 60, available: float64(l.burst), updated: now},
+			tokens:   tokenBucket{capacity: float64(l.tpm), perSecond: float64(l.tpm) / 60, available: float64(l.tpm), updated: now},
+		}
+		l.keys[key] = k
+	}
+
+	d := rateDecision{allowed: true, limitRequests: l.rpm, limitTokens: l.tpm}
+	if l.rpm > 0 {
+		k.requests.refill(now)
+		if wait := k.requests.wait(1); wait > 0 {
+			d.allowed, d.retryAfter, d.exceeded = false, wait, "requests"
+			d.used = l.burst - int(k.requests.available)
+		}
+	}
+	if l.tpm > 0 && d.allowed {
+		k.tokens.refill(now)
+		if wait := k.tokens.wait(float64(tokens)); wait > 0 {
+			d.allowed, d.retryAfter, d.exceeded = false, wait, "tokens"
+			d.used = l.tpm - int(k.tokens.available)
+		}
+	}
+
+	if d.allowed {
+		if l.rpm > 0 {
+			k.requests.available--
+		}
+		if l.tpm > 0 {
+			k.tokens.available -= float64(tokens)
+		}
+	}
+	d.remainingRequests, d.resetRequests = int(k.requests.available), k.requests.reset()
+	d.remainingTokens, d.resetTokens = int(max(k.tokens.available, 0)), k.tokens.reset()
+	return d
+}
+
+// formatReset formats a duration the way OpenAI does in x-ratelimit-reset-*, e.g. 20ms, 1.5s or 6m0s
+func formatReset(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// setRateLimitHeaders adds the x-ratelimit headers OpenAI sends with every response
+func setRateLimitHeaders(w http.ResponseWriter, d rateDecision) {
+	h := w.Header()
+	if d.limitRequests > 0 {
+		h.Set("x-ratelimit-limit-requests", strconv.Itoa(d.limitRequests))
+		h.Set("x-ratelimit-remaining-requests", strconv.Itoa(d.remainingRequests))
+		h.Set("x-ratelimit-reset-requests", formatReset(d.resetRequests))
+	}
+	if d.limitTokens > 0 {
+		h.Set("x-ratelimit-limit-tokens", strconv.Itoa(d.limitTokens))
+		h.Set("x-ratelimit-remaining-tokens", strconv.Itoa(d.remainingTokens))
+		h.Set("x-ratelimit-reset-tokens", formatReset(d.resetTokens))
+	}
+}
+
+// rateLimitError is the body of an OpenAI 429 response
+type rateLimitError struct {
+	Error struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Param   interface{} `json:"param"`
+		Code    string      `json:"code"`
+	} `json:"error"`
+}
+
+// writeRateLimited answers a refused request like OpenAI, with retry-after headers for clients that honor them
+func writeRateLimited(w http.ResponseWriter, d rateDecision, model string, tokens int) {
+	if model == "" {
+		model = "gpt-4o-mini"
+	}
+	limitName, limit, requested := "requests per min (RPM)", d.limitRequests, 1
+	if d.exceeded == "tokens" {
+		limitName, limit, requested = "tokens per min (TPM)", d.limitTokens, tokens
+	}
+
+	var body rateLimitError
+	body.Error.Message = fmt.Sprintf("Rate limit reached for %s in organization org-mock on %s: Limit %d, Used %d, Requested %d. "+
+		"Please try again in %s. Visit https://platform.openai.com/account/rate-limits to learn more.",
+		model, limitName, limit, d.used, requested, formatReset(d.retryAfter))
+	body.Error.Type = d.exceeded
+	body.Error.Code = "rate_limit_exceeded"
+
+	w.Header().Set("retry-after", strconv.Itoa(int(math.Ceil(d.retryAfter.Seconds()))))
+	w.Header().Set("retry-after-ms", strconv.FormatInt(d.retryAfter.Milliseconds(), 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(body)
+}
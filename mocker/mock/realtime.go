@@ -0,0 +1,406 @@
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the realtime emulation
+const (
+	DefaultRealtimeDeltas = 20
+	defaultRealtimeModel  = "gpt-4o-realtime-preview"
+
+	// realtimeAudioDeltaBytes is the audio in one response.audio.delta: 100ms of 24kHz mono PCM16
+	realtimeAudioDeltaBytes = 4800
+)
+
+// realtimeWords are streamed one per response.text.delta, over and over
+var realtimeWords = strings.Fields("This is a mocked response from the OpenAI mocker server.")
+
+// realtimeAudioDelta is the base64 silence every response.audio.delta carries
+var realtimeAudioDelta = base64.StdEncoding.EncodeToString(make([]byte, realtimeAudioDeltaBytes))
+
+// realtimeEvent is a server event other than a delta; event_id and type are added when it is sent
+type realtimeEvent map[string]any
+
+// realtimeDelta is a response.text.delta or response.audio.delta, the bulk of a session's events
+type realtimeDelta struct {
+	EventID      string `json:"event_id"`
+	Type         string `json:"type"`
+	ResponseID   string `json:"response_id"`
+	ItemID       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+	Delta        string `json:"delta"`
+}
+
+// realtimeClientEvent holds the fields of client events the emulation looks at
+type realtimeClientEvent struct {
+	Type    string         `json:"type"`
+	EventID string         `json:"event_id"`
+	Item    map[string]any `json:"item"`
+	Session *struct {
+		Modalities []string `json:"modalities"`
+	} `json:"session"`
+	Response *struct {
+		Modalities []string `json:"modalities"`
+	} `json:"response"`
+}
+
+// realtimeSession is one /v1/realtime connection. Responses stream from their own goroutine,
+// so the session keeps reading client events, such as response.cancel, while one is in progress.
+type realtimeSession struct {
+	h     *Handler
+	ws    *wsConn
+	r     *http.Request
+	id    string
+	model string
+	ids   atomic.Int64 // Numbers event, item and response IDs
+
+	mu         sync.Mutex
+	modalities []string
+	cancel     chan struct{} // Closed to cancel the response in progress, nil when there is none
+	responses  sync.WaitGroup
+}
+
+// realtime emulates the OpenAI Realtime API over a WebSocket: session.created on connect,
+// session.update, conversation items, the input audio buffer, and responses streamed as text or
+// audio deltas at the configured event rate after the planned latency. Every response.create
+// takes a plan, so latency, injected errors, slow start and usage apply per response.
+func (h *Handler) realtime(w http.ResponseWriter, r *http.Request) {
+	if h.outages != nil {
+		if outage, down := h.outages.active(time.Now()); down {
+			h.outages.reject(w, outage, time.Now())
+			return
+		}
+	}
+
+	ws, err := upgradeWebSocket(w, r, "realtime")
+	if err != nil {
+		if h.opts.LogErrors {
+			log.Printf("Rejected realtime connection: %v", err)
+		}
+		return
+	}
+	number := h.metrics.realtimeSessions.Add(1)
+	h.metrics.realtimeActive.Add(1)
+	defer h.metrics.realtimeActive.Add(-1)
+
+	model := r.URL.Query().Get("model")
+	if model == "" {
+		model = defaultRealtimeModel
+	}
+	s := &realtimeSession{
+		h:          h,
+		ws:         ws,
+		r:          r,
+		id:         fmt.Sprintf("sess_mock%d", number),
+		model:      model,
+		modalities: []string{"text"},
+	}
+	s.run()
+}
+
+// run answers client events until the connection closes, then waits for the response in progress
+func (s *realtimeSession) run() {
+	defer func() {
+		s.cancelResponse()
+		s.responses.Wait()
+		s.ws.Close(wsCloseNormal, "")
+	}()
+
+	if s.send("session.created", realtimeEvent{"session": s.session()}) != nil {
+		return
+	}
+	for {
+		message, err := s.ws.ReadMessage()
+		if err != nil {
+			if err != errWebSocketClosed && s.h.opts.LogErrors {
+				log.Printf("Realtime session %s ended: %v", s.id, err)
+			}
+			return
+		}
+		s.h.metrics.realtimeEventsReceived.Add(1)
+		if s.handle(message) != nil {
+			return
+		}
+	}
+}
+
+// handle answers one client event; the error is the connection's
+func (s *realtimeSession) handle(message []byte) error {
+	var event realtimeClientEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return s.sendError("invalid_request_error", "invalid_json", "The event is not valid JSON", "")
+	}
+
+	switch event.Type {
+	case "session.update":
+		if event.Session != nil && event.Session.Modalities != nil {
+			for _, modality := range event.Session.Modalities {
+				if modality != "text" && modality != "audio" {
+					return s.sendError("invalid_request_error", "invalid_value", fmt.Sprintf("Invalid modality %q", modality), event.EventID)
+				}
+			}
+			s.mu.Lock()
+			s.modalities = event.Session.Modalities
+			s.mu.Unlock()
+		}
+		return s.send("session.updated", realtimeEvent{"session": s.session()})
+	case "conversation.item.create":
+		item := event.Item
+		if item == nil {
+			return s.sendError("invalid_request_error", "missing_required_parameter", "Missing required parameter: 'item'", event.EventID)
+		}
+		if _, ok := item["id"]; !ok {
+			item["id"] = s.newID("item")
+		}
+		item["object"] = "realtime.item"
+		item["status"] = "completed"
+		return s.send("conversation.item.created", realtimeEvent{"previous_item_id": nil, "item": item})
+	case "input_audio_buffer.append":
+		// The real API doesn't acknowledge appended audio either
+		return nil
+	case "input_audio_buffer.commit":
+		return s.send("input_audio_buffer.committed", realtimeEvent{"previous_item_id": nil, "item_id": s.newID("item")})
+	case "input_audio_buffer.clear":
+		return s.send("input_audio_buffer.cleared", realtimeEvent{})
+	case "response.create":
+		s.mu.Lock()
+		if s.cancel != nil {
+			s.mu.Unlock()
+			return s.sendError("invalid_request_error", "conversation_already_has_active_response", "Conversation already has an active response", event.EventID)
+		}
+		modalities := s.modalities
+		if event.Response != nil && event.Response.Modalities != nil {
+			modalities = event.Response.Modalities
+		}
+		cancel := make(chan struct{})
+		s.cancel = cancel
+		s.responses.Add(1)
+		s.mu.Unlock()
+		go s.respond(cancel, slices.Contains(modalities, "audio"))
+		return nil
+	case "response.cancel":
+		if !s.cancelResponse() {
+			return s.sendError("invalid_request_error", "response_cancel_not_active", "Cancellation failed: no active response found", event.EventID)
+		}
+		return nil
+	default:
+		return s.sendError("invalid_request_error", "unknown_event", fmt.Sprintf("Invalid value: '%s'", event.Type), event.EventID)
+	}
+}
+
+// cancelResponse cancels the response in progress, reporting whether there was one
+func (s *realtimeSession) cancelResponse() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel == nil {
+		return false
+	}
+	close(s.cancel)
+	s.cancel = nil
+	return true
+}
+
+// respond streams one response: response.created at once, then after the planned latency the
+// output item, the deltas paced at the event rate, and response.done. An injected error fails
+// the response instead, like an upstream server error does.
+func (s *realtimeSession) respond(cancel <-chan struct{}, audio bool) {
+	defer s.responses.Done()
+	defer func() {
+		s.mu.Lock()
+		if s.cancel == cancel {
+			s.cancel = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	h := s.h
+	plan := h.plans.Next()
+	responseID, itemID := s.newID("resp"), s.newID("item")
+	response := realtimeEvent{"id": responseID, "object": "realtime.response", "status": "in_progress", "status_details": nil, "output": []any{}, "usage": nil}
+	if s.send("response.created", realtimeEvent{"response": response}) != nil {
+		return
+	}
+
+	// The planned latency is the time to the first delta
+	delay := plan.Latency()
+	if h.coldStart != nil {
+		delay += h.coldStart.Delay(time.Now())
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-cancel:
+			s.finish(response, "cancelled", realtimeEvent{"type": "cancelled", "reason": "client_cancelled"}, nil)
+			return
+		}
+		h.metrics.observeSimulatedLatency(delay)
+	}
+	h.usage.record(s.r, plan)
+
+	if plan.Status != http.StatusOK {
+		if h.opts.LogErrors {
+			log.Printf("Injected a failed response in realtime session %s", s.id)
+		}
+		s.finish(response, "failed", realtimeEvent{"type": "failed", "error": realtimeEvent{
+			"type": "server_error", "code": "mock_injected_error", "message": "The mocker injected this error.",
+		}}, nil)
+		return
+	}
+
+	part, deltaType, doneType := realtimeEvent{"type": "text", "text": ""}, "response.text.delta", "response.text.done"
+	if audio {
+		part, deltaType, doneType = realtimeEvent{"type": "audio", "transcript": ""}, "response.audio.delta", "response.audio.done"
+	}
+	item := realtimeEvent{"id": itemID, "object": "realtime.item", "type": "message", "status": "in_progress", "role": "assistant", "content": []any{}}
+	position := realtimeEvent{"response_id": responseID, "item_id": itemID, "output_index": 0, "content_index": 0}
+	if s.send("response.output_item.added", realtimeEvent{"response_id": responseID, "output_index": 0, "item": item}) != nil {
+		return
+	}
+	if s.send("response.content_part.added", withFields(position, realtimeEvent{"part": part})) != nil {
+		return
+	}
+
+	deltas := h.opts.RealtimeDeltas
+	if deltas == 0 {
+		deltas = DefaultRealtimeDeltas
+	}
+	var tick <-chan time.Time
+	if h.opts.RealtimeEventRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / h.opts.RealtimeEventRate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var text strings.Builder
+	for i := range deltas {
+		if i > 0 && tick != nil {
+			select {
+			case <-tick:
+			case <-cancel:
+				s.finish(response, "cancelled", realtimeEvent{"type": "cancelled", "reason": "client_cancelled"}, nil)
+				return
+			}
+		}
+		delta := realtimeAudioDelta
+		if !audio {
+			delta = realtimeWords[i%len(realtimeWords)]
+			if i < deltas-1 {
+				delta += " "
+			}
+			text.WriteString(delta)
+		}
+		if s.sendDelta(realtimeDelta{Type: deltaType, ResponseID: responseID, ItemID: itemID, Delta: delta}) != nil {
+			return
+		}
+	}
+
+	if audio {
+		if s.send(doneType, position) != nil {
+			return
+		}
+	} else {
+		part = realtimeEvent{"type": "text", "text": text.String()}
+		if s.send(doneType, withFields(position, realtimeEvent{"text": text.String()})) != nil {
+			return
+		}
+	}
+	if s.send("response.content_part.done", withFields(position, realtimeEvent{"part": part})) != nil {
+		return
+	}
+	item["status"] = "completed"
+	item["content"] = []any{part}
+	if s.send("response.output_item.done", realtimeEvent{"response_id": responseID, "output_index": 0, "item": item}) != nil {
+		return
+	}
+	response["output"] = []any{item}
+	s.finish(response, "completed", nil, &plan)
+}
+
+// finish ends a response with response.done in the given status, with usage when it completed
+func (s *realtimeSession) finish(response realtimeEvent, status string, details realtimeEvent, plan *ResponsePlan) {
+	response["status"] = status
+	if details != nil {
+		response["status_details"] = details
+	}
+	if plan != nil {
+		response["usage"] = realtimeEvent{
+			"total_tokens":  plan.PromptTokens + plan.CompletionTokens,
+			"input_tokens":  plan.PromptTokens,
+			"output_tokens": plan.CompletionTokens,
+		}
+	}
+	s.send("response.done", realtimeEvent{"response": response})
+}
+
+// withFields returns a copy of base with fields added
+func withFields(base realtimeEvent, fields realtimeEvent) realtimeEvent {
+	event := make(realtimeEvent, len(base)+len(fields))
+	for k, v := range base {
+		event[k] = v
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+	return event
+}
+
+// session describes the session as session.created and session.updated report it
+func (s *realtimeSession) session() realtimeEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return realtimeEvent{"id": s.id, "object": "realtime.session", "model": s.model, "modalities": s.modalities}
+}
+
+// newID returns a new ID with the given prefix, unique within the session
+func (s *realtimeSession) newID(prefix string) string {
+	return fmt.Sprintf("%s_mock%d", prefix, s.ids.Add(1))
+}
+
+// send writes a server event, numbering it
+func (s *realtimeSession) send(eventType string, event realtimeEvent) error {
+	event["event_id"] = s.newID("event")
+	event["type"] = eventType
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.write(encoded)
+}
+
+// sendDelta writes a delta without going through a map, since deltas are most of the events
+func (s *realtimeSession) sendDelta(delta realtimeDelta) error {
+	delta.EventID = s.newID("event")
+	encoded, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+	return s.write(encoded)
+}
+
+// sendError answers a client event the emulation can't handle with an error event
+func (s *realtimeSession) sendError(errorType string, code string, message string, clientEventID string) error {
+	details := realtimeEvent{"type": errorType, "code": code, "message": message, "param": nil, "event_id": nil}
+	if clientEventID != "" {
+		details["event_id"] = clientEventID
+	}
+	return s.send("error", realtimeEvent{"error": details})
+}
+
+func (s *realtimeSession) write(encoded []byte) error {
+	if err := s.ws.WriteText(encoded); err != nil {
+		return err
+	}
+	s.h.metrics.realtimeEventsSent.Add(1)
+	s.h.metrics.bytesServed.Add(int64(len(encoded)))
+	return nil
+}
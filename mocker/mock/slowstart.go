@@ -0,0 +1,79 @@
+package mock
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// slowStart simulates a cold upstream: responses get extra latency that decays to nothing over
+// the first seconds of traffic, like caches warming up or autoscaling adding capacity
+type slowStart struct {
+	extra    time.Duration // Extra latency of the first request
+	duration time.Duration // Time until the upstream is warm
+	curve    string        // linear, exp or step
+	idle     time.Duration // Idle time after which the upstream is cold again, 0 to stay warm
+
+	mu       sync.Mutex
+	started  time.Time // First request of the current cold start, zero before any traffic
+	lastSeen time.Time
+}
+
+// newSlowStart validates the slow start settings
+func newSlowStart(extra time.Duration, duration time.Duration, curve string, idle time.Duration) (*slowStart, error) {
+	switch curve {
+	case "linear", "exp", "step":
+	default:
+		return nil, fmt.Errorf("unknown curve %q (use linear, exp or step)", curve)
+	}
+	if extra < 0 || duration <= 0 || idle < 0 {
+		return nil, fmt.Errorf("latency and idle must not be negative and the duration must be positive")
+	}
+	return &slowStart{extra: extra, duration: duration, curve: curve, idle: idle}, nil
+}
+
+// Delay returns the extra latency of a request arriving now. The clock starts with the first
+// request, and again with the first request after an idle period.
+func (s *slowStart) Delay(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started.IsZero() || (s.idle > 0 && now.Sub(s.lastSeen) >= s.idle) {
+		if !s.started.IsZero() {
+			log.Printf("Idle for %s, starting cold again", now.Sub(s.lastSeen).Round(time.Millisecond))
+		}
+		s.started = now
+	}
+	s.lastSeen = now
+	return s.extraAt(now.Sub(s.started))
+}
+
+// Current returns the extra latency a request arriving now would get, without starting the clock
+func (s *slowStart) Current(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started.IsZero() || (s.idle > 0 && now.Sub(s.lastSeen) >= s.idle) {
+		return s.extra
+	}
+	return s.extraAt(now.Sub(s.started))
+}
+
+// extraAt is the extra latency after the given time of traffic. The exp curve falls to 1/e
+// every fifth of the duration, step stays at the full extra latency until the upstream is warm.
+func (s *slowStart) extraAt(elapsed time.Duration) time.Duration {
+	if elapsed >= s.duration {
+		return 0
+	}
+	progress := float64(elapsed) / float64(s.duration)
+	switch s.curve {
+	case "exp":
+		return time.Duration(float64(s.extra) * math.Exp(-5*progress))
+	case "step":
+		return s.extra
+	default:
+		return time.Duration(float64(s.extra) * (1 - progress))
+	}
+}
@@ -0,0 +1,79 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// traceHeader is the per-request trace ID header sent by the benchmark runner
+const traceHeader = "X-Trace-Id"
+
+// requestIDHeader is the per-request ID sent by the benchmark runner, echoed back in responses
+const requestIDHeader = "X-Request-ID"
+
+// TraceRecord captures when a traced request was received and answered
+type TraceRecord struct {
+	TraceID     string    `json:"trace_id"`
+	ReceivedAt  time.Time `json:"received_at"`
+	RespondedAt time.Time `json:"responded_at"`
+}
+
+// maxTraceRecords bounds the traces kept between resets, about 60MB. Once full, the oldest
+// are overwritten, so a long run without ?reset=true keeps only its most recent requests.
+const maxTraceRecords = 500_000
+
+// traceStore holds the traces recorded since the last reset, in a ring buffer
+type traceStore struct {
+	mu      sync.Mutex
+	records []TraceRecord
+	next    int   // Where the next record goes once records is full
+	dropped int64 // Records overwritten since the last reset
+}
+
+// record stores the timings of a traced request. Requests without a trace ID are ignored.
+func (t *traceStore) record(r *http.Request, receivedAt time.Time) {
+	id := r.Header.Get(traceHeader)
+	if id == "" {
+		return
+	}
+
+	record := TraceRecord{
+		TraceID:     id,
+		ReceivedAt:  receivedAt,
+		RespondedAt: time.Now(),
+	}
+
+	t.mu.Lock()
+	if len(t.records) < maxTraceRecords {
+		t.records = append(t.records, record)
+	} else {
+		t.records[t.next] = record
+		t.next = (t.next + 1) % maxTraceRecords
+		t.dropped++
+	}
+	t.mu.Unlock()
+}
+
+// ServeHTTP returns the recorded traces as a JSON array, oldest first. Pass reset=true to
+// clear them. X-Traces-Dropped counts the records overwritten since the last reset.
+func (t *traceStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.Lock()
+	// Copied in order, since the ring keeps being overwritten after the lock is released
+	records := make([]TraceRecord, 0, len(t.records))
+	records = append(records, t.records[t.next:]...)
+	records = append(records, t.records[:t.next]...)
+	dropped := t.dropped
+	if r.URL.Query().Get("reset") == "true" {
+		t.records, t.next, t.dropped = nil, 0, 0
+	}
+	t.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Traces-Dropped", strconv.FormatInt(dropped, 10))
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		http.Error(w, "Failed to encode traces", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,40 @@
+package mock
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Truncated responses cut the body somewhere in this range of its length, so the JSON is
+// always cut off mid-document
+const (
+	minTruncateAt = 0.1
+	maxTruncateAt = 0.9
+)
+
+// writeTruncated answers a 200 that dies mid-response: the headers announce the full body, part
+// of it is sent and flushed, and after the planned delay the handler aborts. net/http then closes
+// the connection (or resets the HTTP/2 stream) without finishing the body, like an upstream that
+// crashed while answering.
+func (h *Handler) writeTruncated(w http.ResponseWriter, body []byte, plan ResponsePlan, requestID string) {
+	sent := int(float64(len(body)) * plan.TruncateAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body[:sent])
+	if err := http.NewResponseController(w).Flush(); err != nil {
+		log.Printf("Warning: Could not flush truncated response: %v", err)
+	}
+
+	if delay := plan.TruncateDelay(); delay > 0 {
+		time.Sleep(delay)
+	}
+	h.metrics.truncated.Add(1)
+	if h.opts.LogErrors {
+		log.Printf("Truncated response for request %s after %d of %d bytes", requestID, sent, len(body))
+	}
+	panic(http.ErrAbortHandler)
+}
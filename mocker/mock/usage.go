@@ -0,0 +1,107 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyUsage is the request, token and cost accounting for one Authorization header
+type KeyUsage struct {
+	Key              string  `json:"key"` // Masked API key, "none" when the header was missing
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"` // Requests answered with an injected error
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+// usageLedger accounts requests, tokens and simulated cost per API key
+type usageLedger struct {
+	promptCostPer1k     float64
+	completionCostPer1k float64
+
+	mu   sync.Mutex
+	keys map[string]*KeyUsage
+}
+
+func newUsageLedger(promptCostPer1k float64, completionCostPer1k float64) *usageLedger {
+	return &usageLedger{promptCostPer1k: promptCostPer1k, completionCostPer1k: completionCostPer1k, keys: make(map[string]*KeyUsage)}
+}
+
+// maskAPIKey hides all but the prefix and the last 4 characters of a key
+func maskAPIKey(key string) string {
+	if key == "" {
+		return "none"
+	}
+	if len(key) <= 10 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:3] + "..." + key[len(key)-4:]
+}
+
+// apiKey returns the key a request was sent with, "" when it has none
+func apiKey(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// record accounts one request against its Authorization header. Tokens are only billed on success.
+func (l *usageLedger) record(r *http.Request, plan ResponsePlan) {
+	key := apiKey(r)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u, ok := l.keys[key]
+	if !ok {
+		u = &KeyUsage{Key: maskAPIKey(key)}
+		l.keys[key] = u
+	}
+	u.Requests++
+	if plan.Status != http.StatusOK {
+		u.Errors++
+		return
+	}
+	u.PromptTokens += int64(plan.PromptTokens)
+	u.CompletionTokens += int64(plan.CompletionTokens)
+	u.TotalTokens += int64(plan.PromptTokens + plan.CompletionTokens)
+	u.CostUSD += float64(plan.PromptTokens)/1000*l.promptCostPer1k + float64(plan.CompletionTokens)/1000*l.completionCostPer1k
+}
+
+// snapshot returns the usage per API key, sorted by masked key, and the total, optionally clearing it
+func (l *usageLedger) snapshot(reset bool) ([]KeyUsage, KeyUsage) {
+	l.mu.Lock()
+	keys := make([]KeyUsage, 0, len(l.keys))
+	for _, u := range l.keys {
+		keys = append(keys, *u)
+	}
+	if reset {
+		l.keys = make(map[string]*KeyUsage)
+	}
+	l.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	total := KeyUsage{Key: "total"}
+	for _, u := range keys {
+		total.Requests += u.Requests
+		total.Errors += u.Errors
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.TotalTokens += u.TotalTokens
+		total.CostUSD += u.CostUSD
+	}
+	return keys, total
+}
+
+// ServeHTTP returns the usage per API key and the total as JSON. Pass reset=true to clear it.
+func (l *usageLedger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keys, total := l.snapshot(r.URL.Query().Get("reset") == "true")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys, "total": total}); err != nil {
+		http.Error(w, "Failed to encode usage", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,276 @@
+package mock
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is appended to the client's key to compute Sec-WebSocket-Accept (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage bounds a client message; input_audio_buffer.append carries base64 audio,
+// so it is generous
+const maxWebSocketMessage = 16 << 20
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Close codes sent by the mocker
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+)
+
+// errWebSocketClosed is returned by ReadMessage once the client closed the connection
+var errWebSocketClosed = errors.New("websocket closed by the client")
+
+// wsConn is the server side of a WebSocket connection, just enough of RFC 6455 to emulate the
+// realtime API: text and binary messages, fragmentation, pings and the closing handshake.
+// Extensions such as permessage-deflate are never negotiated.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	mu     sync.Mutex // Serializes writes, which come from the reader and the response streams
+	bw     *bufio.Writer
+	closed bool
+}
+
+// upgradeWebSocket answers a WebSocket handshake with 101 Switching Protocols, taking over the
+// connection. Headers already set on w, like X-Mock-Instance, are sent with the 101. protocol
+// is echoed in Sec-WebSocket-Protocol when the client offered it. A request that isn't a
+// handshake gets a 400 or 426 and an error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, protocol string) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Only GET method is allowed", http.StatusMethodNotAllowed)
+		return nil, fmt.Errorf("method %s", r.Method)
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("websocket version %q", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("invalid key %q", key)
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 connections can't be taken over
+		http.Error(w, "WebSocket upgrades need HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return nil, fmt.Errorf("failed to take over the connection: %v", err)
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	var handshake strings.Builder
+	handshake.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	handshake.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n")
+	if protocol != "" && headerHasToken(r.Header, "Sec-WebSocket-Protocol", protocol) {
+		handshake.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	for name, values := range w.Header() {
+		for _, value := range values {
+			handshake.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	handshake.WriteString("\r\n")
+
+	ws := &wsConn{conn: conn, br: rw.Reader, bw: rw.Writer}
+	if _, err := ws.bw.WriteString(handshake.String()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write the handshake: %v", err)
+	}
+	if err := ws.bw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write the handshake: %v", err)
+	}
+	return ws, nil
+}
+
+// headerHasToken reports whether a comma separated header contains token, case-insensitively
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message, answering pings on the way. It returns
+// errWebSocketClosed after the client's close frame has been answered.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		final, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.Close(wsCloseNormal, "")
+			return nil, errWebSocketClosed
+		case wsText, wsBinary:
+			if fragmented {
+				c.Close(wsCloseProtocolError, "expected a continuation frame")
+				return nil, fmt.Errorf("new message inside a fragmented one")
+			}
+		case wsContinuation:
+			if !fragmented {
+				c.Close(wsCloseProtocolError, "unexpected continuation frame")
+				return nil, fmt.Errorf("continuation frame without a message")
+			}
+		default:
+			c.Close(wsCloseProtocolError, "unknown opcode")
+			return nil, fmt.Errorf("unknown opcode %#x", opcode)
+		}
+
+		if len(message)+len(payload) > maxWebSocketMessage {
+			c.Close(wsCloseTooBig, "message too big")
+			return nil, fmt.Errorf("message over %d bytes", maxWebSocketMessage)
+		}
+		message = append(message, payload...)
+		if final {
+			return message, nil
+		}
+		fragmented = true
+	}
+}
+
+// readFrame reads and unmasks one frame. Clients must mask every frame.
+func (c *wsConn) readFrame() (final bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	final = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		c.Close(wsCloseProtocolError, "no extensions were negotiated")
+		return false, 0, nil, fmt.Errorf("reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		c.Close(wsCloseProtocolError, "client frames must be masked")
+		return false, 0, nil, fmt.Errorf("unmasked client frame")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.br, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.br, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxWebSocketMessage {
+		c.Close(wsCloseTooBig, "message too big")
+		return false, 0, nil, fmt.Errorf("frame of %d bytes", length)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return final, opcode, payload, nil
+}
+
+// WriteText sends one unfragmented text message
+func (c *wsConn) WriteText(message []byte) error {
+	return c.writeFrame(wsText, message)
+}
+
+// writeFrame sends one unmasked frame, as servers do
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	var header [10]byte
+	header[0] = 0x80 | opcode
+	n := 2
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		n = 10
+	}
+	if _, err := c.bw.Write(header[:n]); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// Close sends a close frame with the given code and reason, then closes the connection
+func (c *wsConn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	c.writeFrame(wsClose, payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -0,0 +1,154 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// ArrivalTrace replays the request arrival times of real traffic instead of a constant rate,
+// keeping its bursts and lulls. It is a vegeta pacer: request n is sent at the n-th arrival's
+// offset from the first, divided by the speed-up, and the trace loops when the attack outlasts it.
+type ArrivalTrace struct {
+	Path  string
+	Speed float64 // Replay speed-up, 2 sends the trace twice as fast
+
+	offsets []time.Duration // Scaled offsets from the first arrival, ascending
+	span    time.Duration   // One pass, including a mean gap before the trace starts over
+}
+
+// LoadArrivalTrace reads one arrival per line: an RFC 3339 timestamp or a number of seconds
+// (Unix or relative), in the first comma or whitespace separated column. Blank lines, # comments
+// and a header line are skipped, and arrivals are sorted.
+func LoadArrivalTrace(path string, speed float64) (*ArrivalTrace, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("speed must be positive")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open arrival trace: %v", err)
+	}
+	defer file.Close()
+
+	var arrivals []float64 // Seconds
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		field := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })[0]
+		seconds, err := parseArrival(field)
+		if err != nil {
+			if len(arrivals) == 0 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("arrival trace line %d: %v", line, err)
+		}
+		arrivals = append(arrivals, seconds)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read arrival trace: %v", err)
+	}
+	if len(arrivals) < 2 {
+		return nil, fmt.Errorf("arrival trace %s needs at least 2 arrivals", path)
+	}
+	slices.Sort(arrivals)
+	if arrivals[len(arrivals)-1] == arrivals[0] {
+		return nil, fmt.Errorf("arrival trace %s spans no time", path)
+	}
+
+	t := &ArrivalTrace{Path: path, Speed: speed, offsets: make([]time.Duration, len(arrivals))}
+	for i, a := range arrivals {
+		t.offsets[i] = time.Duration((a - arrivals[0]) / speed * float64(time.Second))
+	}
+	last := t.offsets[len(t.offsets)-1]
+	t.span = last + last/time.Duration(len(t.offsets)-1)
+	return t, nil
+}
+
+// parseArrival reads an arrival as seconds
+func parseArrival(field string) (float64, error) {
+	if ts, err := time.Parse(time.RFC3339Nano, field); err == nil {
+		return float64(ts.UnixNano()) / float64(time.Second), nil
+	}
+	seconds, err := strconv.ParseFloat(field, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, fmt.Errorf("%q is neither an RFC 3339 timestamp nor a number of seconds", field)
+	}
+	return seconds, nil
+}
+
+// Offset is when request seq is due, from the start of the attack
+func (t *ArrivalTrace) Offset(seq uint64) time.Duration {
+	n := uint64(len(t.offsets))
+	return time.Duration(seq/n)*t.span + t.offsets[seq%n]
+}
+
+// Pace implements vegeta.Pacer, waiting for the next arrival
+func (t *ArrivalTrace) Pace(elapsed time.Duration, hits uint64) (time.Duration, bool) {
+	return max(t.Offset(hits)-elapsed, 0), false
+}
+
+// Rate implements vegeta.Pacer with the trace's rate over the second around elapsed
+func (t *ArrivalTrace) Rate(elapsed time.Duration) float64 {
+	at := elapsed % t.span
+	from, _ := slices.BinarySearch(t.offsets, at-time.Second/2)
+	to, _ := slices.BinarySearch(t.offsets, at+time.Second/2)
+	return float64(to - from)
+}
+
+// MeanRate is the trace's average requests per second at its speed
+func (t *ArrivalTrace) MeanRate() float64 {
+	return float64(len(t.offsets)) / t.span.Seconds()
+}
+
+// offeredRate is the trace's average requests per second over an attack of duration, which
+// differs from the mean rate when the attack ends partway through a pass
+func (t *ArrivalTrace) offeredRate(duration time.Duration) float64 {
+	passes := duration / t.span
+	tail, _ := slices.BinarySearch(t.offsets, duration%t.span)
+	return float64(int(passes)*len(t.offsets)+tail) / duration.Seconds()
+}
+
+// peakRate is the most arrivals in any one second of the trace
+func (t *ArrivalTrace) peakRate() int {
+	peak, from := 0, 0
+	for to, offset := range t.offsets {
+		for t.offsets[from] <= offset-time.Second {
+			from++
+		}
+		peak = max(peak, to-from+1)
+	}
+	return peak
+}
+
+// arrivalTraceSummary describes the trace for the results, replayed for duration seconds; nil
+// without a trace
+func arrivalTraceSummary(t *ArrivalTrace, duration int) *resultfile.ArrivalTrace {
+	if t == nil {
+		return nil
+	}
+	return &resultfile.ArrivalTrace{
+		File:     t.Path,
+		Arrivals: len(t.offsets),
+		SpanSec:  t.span.Seconds(),
+		Speed:    t.Speed,
+		MeanRPS:  t.MeanRate(),
+		PeakRPS:  t.peakRate(),
+		Passes:   float64(duration) / t.span.Seconds(),
+	}
+}
+
+// String describes the trace for the run's summary
+func (t *ArrivalTrace) String() string {
+	return fmt.Sprintf("%s (%s arrivals over %s, mean %s/s, peak %s/s, speed %sx)", t.Path, report.Int(int64(len(t.offsets))),
+		t.span.Round(time.Millisecond), report.Float(t.MeanRate(), 1), report.Int(int64(t.peakRate())), report.Float(t.Speed, 2))
+}
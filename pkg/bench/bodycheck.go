@@ -0,0 +1,119 @@
+package bench
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"bifrost-benchmarks/resultfile"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Headers the mocker's -body-checksum answers with, describing the request body it received
+const (
+	mockBodyBytesHeader  = "X-Mock-Body-Bytes"
+	mockBodySHA256Header = "X-Mock-Body-Sha256"
+)
+
+// bodyCheckHeader is set on responses by the runner's own transport with the outcome of the
+// body check, so it reaches the result whichever engine sent the request
+const bodyCheckHeader = "X-Bench-Body-Check"
+
+// Outcomes of comparing a sent body with what the upstream received
+const (
+	bodyIntact     = "intact"
+	bodyMismatch   = "mismatch"   // Different bytes, and not fewer of them
+	bodyTruncated  = "truncated"  // The upstream received fewer bytes than were sent
+	bodyUnverified = "unverified" // The response carried no checksum
+)
+
+// sentBody is the size and SHA-256 of a request body as sent
+type sentBody struct {
+	size int
+	sum  [sha256.Size]byte
+}
+
+func newSentBody(body []byte) sentBody {
+	return sentBody{size: len(body), sum: sha256.Sum256(body)}
+}
+
+// requestBody hashes the body of a request without consuming it
+func requestBody(req *http.Request) (sentBody, error) {
+	if req.Body == nil || req.GetBody == nil {
+		return newSentBody(nil), nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return sentBody{}, err
+	}
+	defer body.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, body); err != nil {
+		return sentBody{}, err
+	}
+	return newSentBody(buf.Bytes()), nil
+}
+
+// check compares the body with the checksum headers of the response. Only gateways that
+// forward request bodies and upstream response headers verbatim can be checked: a gateway
+// that re-encodes the body, as Bifrost does, changes its checksum without corrupting it.
+func (b sentBody) check(header func(string) string) string {
+	sum := header(mockBodySHA256Header)
+	if sum == "" {
+		return bodyUnverified
+	}
+	if sum == hex.EncodeToString(b.sum[:]) {
+		return bodyIntact
+	}
+	if size, err := strconv.Atoi(header(mockBodyBytesHeader)); err == nil && size < b.size {
+		return bodyTruncated
+	}
+	return bodyMismatch
+}
+
+// bodyChecks counts the outcomes of -verify-body over a run
+type bodyChecks struct {
+	intact, mismatched, truncated, unverified int
+}
+
+// Add counts a response's body check, returning why it failed or "" when it didn't
+func (c *bodyChecks) Add(res *vegeta.Result) string {
+	if res.Error != "" || res.Code == 0 {
+		return ""
+	}
+	switch res.Headers.Get(bodyCheckHeader) {
+	case bodyIntact:
+		c.intact++
+	case bodyMismatch:
+		c.mismatched++
+		return "request body corrupted upstream"
+	case bodyTruncated:
+		c.truncated++
+		return "request body truncated upstream"
+	default:
+		c.unverified++
+	}
+	return ""
+}
+
+// Summary returns the counts, nil without -verify-body
+func (c *bodyChecks) Summary() *resultfile.BodyChecks {
+	if c == nil {
+		return nil
+	}
+	return &resultfile.BodyChecks{Intact: c.intact, Mismatched: c.mismatched, Truncated: c.truncated, Unverified: c.unverified}
+}
+
+// String summarizes the checks, e.g. "998 intact, 2 truncated"
+func (c *bodyChecks) String() string {
+	s := fmt.Sprintf("%s intact, %s mismatched, %s truncated", report.Int(int64(c.intact)), report.Int(int64(c.mismatched)), report.Int(int64(c.truncated)))
+	if c.unverified > 0 {
+		s += fmt.Sprintf(", %s without a checksum", report.Int(int64(c.unverified)))
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"fmt"
+	"slices"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// bodySizes totals the body bytes a provider was sent and answered with, and summarizes the
+// sizes of its successful responses. Gateways that add metadata to every response show up as
+// bigger bodies for the same upstream. Failed responses are left out of the percentiles, so a
+// few short error bodies don't pull them down.
+func bodySizes(samples []RequestSample) *resultfile.BodySizes {
+	if len(samples) == 0 {
+		return nil
+	}
+	sizes := &resultfile.BodySizes{}
+	var ok []uint64
+	for _, s := range samples {
+		sizes.BytesIn += s.BytesIn
+		sizes.BytesOut += s.BytesOut
+		if s.Code == 200 {
+			ok = append(ok, s.BytesIn)
+		}
+	}
+	if len(ok) == 0 {
+		return sizes
+	}
+	slices.Sort(ok)
+	var total uint64
+	for _, n := range ok {
+		total += n
+	}
+	percentile := func(p float64) uint64 { return ok[min(int(p*float64(len(ok))), len(ok)-1)] }
+	sizes.Responses = len(ok)
+	sizes.Response = &resultfile.SizeSummary{
+		MinBytes:  ok[0],
+		MeanBytes: float64(total) / float64(len(ok)),
+		P50Bytes:  percentile(0.5),
+		P90Bytes:  percentile(0.9),
+		P99Bytes:  percentile(0.99),
+		MaxBytes:  ok[len(ok)-1],
+	}
+	return sizes
+}
+
+// formatBytes prints a byte count in B, KB or MB (1024 based)
+func formatBytes(n float64) string {
+	switch {
+	case n >= 1024*1024:
+		return report.Float(n/(1024*1024), 2) + " MB"
+	case n >= 1024:
+		return report.Float(n/1024, 2) + " KB"
+	default:
+		return report.Float(n, 0) + " B"
+	}
+}
+
+// printBodySizes prints the successful response sizes and the bytes sent and received
+func printBodySizes(s *resultfile.BodySizes) {
+	if s == nil {
+		return
+	}
+	totals := fmt.Sprintf("%s received, %s sent", formatBytes(float64(s.BytesIn)), formatBytes(float64(s.BytesOut)))
+	if s.Response == nil {
+		fmt.Printf("  Body Sizes: %s\n", totals)
+		return
+	}
+	r := s.Response
+	fmt.Printf("  Response Body Sizes: mean %s, P50 %s, P99 %s, max %s (%s)\n", formatBytes(r.MeanBytes),
+		formatBytes(float64(r.P50Bytes)), formatBytes(float64(r.P99Bytes)), formatBytes(float64(r.MaxBytes)), totals)
+}
@@ -0,0 +1,124 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// BurnPoint is the error budget burn over the rolling window ending at one second of the run
+type BurnPoint struct {
+	AtSec       float64 // End of the window, in seconds since the attack started
+	OfferedRPS  float64 // Requests sent per second in the window
+	SuccessRate float64 // Percent of the window's requests that succeeded
+	BurnRate    float64 // Error rate as a multiple of the error budget, 1 spends it exactly
+}
+
+// burnRates computes the burn rate of every rolling window of the run, one per second. Windows
+// are evaluated once they are full, or at the end of runs shorter than a window. Requests count
+// against the second they were sent in, and only "ok" outcomes are successes.
+func burnRates(samples []RequestSample, start time.Time, window time.Duration, sloSuccess float64) []BurnPoint {
+	if len(samples) == 0 {
+		return nil
+	}
+	type bucket struct{ sent, ok int }
+	var buckets []bucket
+	for _, s := range samples {
+		second := max(int(s.SentAt.Sub(start)/time.Second), 0)
+		for len(buckets) <= second {
+			buckets = append(buckets, bucket{})
+		}
+		buckets[second].sent++
+		if s.Outcome == "ok" {
+			buckets[second].ok++
+		}
+	}
+
+	budget := 1 - sloSuccess/100
+	w := max(int(window/time.Second), 1)
+	var points []BurnPoint
+	sent, ok := 0, 0
+	for end := range buckets {
+		sent += buckets[end].sent
+		ok += buckets[end].ok
+		if end >= w {
+			sent -= buckets[end-w].sent
+			ok -= buckets[end-w].ok
+		}
+		if (end+1 < w && end < len(buckets)-1) || sent == 0 {
+			continue
+		}
+		success := float64(ok) / float64(sent)
+		points = append(points, BurnPoint{
+			AtSec:       float64(end + 1),
+			OfferedRPS:  float64(sent) / float64(min(end+1, w)),
+			SuccessRate: 100 * success,
+			BurnRate:    (1 - success) / budget,
+		})
+	}
+	return points
+}
+
+// breakingPoint returns the first window that burned the error budget faster than threshold
+func breakingPoint(points []BurnPoint, threshold float64) *BurnPoint {
+	for i, p := range points {
+		if p.BurnRate > threshold {
+			return &points[i]
+		}
+	}
+	return nil
+}
+
+// errorBudget summarizes a run's burn against the SLO for the results file
+func errorBudget(points []BurnPoint, opts Scenario) *resultfile.ErrorBudget {
+	budget := &resultfile.ErrorBudget{
+		SLOSuccess:    opts.SLOSuccess,
+		WindowSec:     opts.SLOWindow.Seconds(),
+		BurnThreshold: opts.BurnThreshold,
+	}
+	for _, p := range points {
+		budget.MaxBurnRate = math.Max(budget.MaxBurnRate, p.BurnRate)
+	}
+	if p := breakingPoint(points, opts.BurnThreshold); p != nil {
+		budget.BreakingPoint = &resultfile.BreakingPoint{
+			AtSec:       p.AtSec,
+			OfferedRPS:  p.OfferedRPS,
+			SuccessRate: p.SuccessRate,
+			BurnRate:    p.BurnRate,
+		}
+	}
+	return budget
+}
+
+// formatBreakingPoint describes when a provider first breached its SLO, or that it held
+func formatBreakingPoint(budget *resultfile.ErrorBudget) string {
+	if p := budget.BreakingPoint; p != nil {
+		return fmt.Sprintf("%ss into the run at %s req/s offered (success %s%%, burn %sx, max burn %sx)",
+			report.Float(p.AtSec, 0), report.Float(p.OfferedRPS, 1), report.Float(p.SuccessRate, 2),
+			report.Float(p.BurnRate, 2), report.Float(budget.MaxBurnRate, 2))
+	}
+	return fmt.Sprintf("none, %s%% success SLO held (max burn %sx)", report.Float(budget.SLOSuccess, 2), report.Float(budget.MaxBurnRate, 2))
+}
+
+// burnSeries returns a provider's burn rate against seconds since the attack started
+func burnSeries(result Result) chartSeries {
+	s := chartSeries{Name: result.ProviderName}
+	for _, p := range result.Burn {
+		s.X = append(s.X, p.AtSec)
+		s.Values = append(s.Values, p.BurnRate)
+	}
+	return s
+}
+
+// thresholdSeries is a flat line at the burn rate that counts as a breach, spanning the given series
+func thresholdSeries(threshold float64, series []chartSeries) chartSeries {
+	var end float64
+	for _, s := range series {
+		if len(s.X) > 0 {
+			end = math.Max(end, s.X[len(s.X)-1])
+		}
+	}
+	return chartSeries{Name: fmt.Sprintf("breach (%gx)", threshold), X: []float64{0, end}, Values: []float64{threshold, threshold}}
+}
@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RunCerts implements the `certs` command, generating a test CA plus server and client
+// certificates for running the runner, gateway and mocker with mutual TLS
+func RunCerts(args []string) error {
+	fs := flag.NewFlagSet("certs", flag.ExitOnError)
+	dir := fs.String("dir", "certs", "Directory to write the certificates to")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "Comma separated hostnames and IPs for the server certificate")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "Certificate validity period")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+
+	caCert, caKey, err := generateCert("bifrost-benchmarks test CA", nil, nil, nil, *validFor)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA: %v", err)
+	}
+	if err := writeCertPair(*dir, "ca", caCert, caKey); err != nil {
+		return fmt.Errorf("failed to write CA: %v", err)
+	}
+
+	serverCert, serverKey, err := generateCert("bifrost-benchmarks server", splitList(*hosts), caCert, caKey, *validFor)
+	if err != nil {
+		return fmt.Errorf("failed to generate server certificate: %v", err)
+	}
+	if err := writeCertPair(*dir, "server", serverCert, serverKey); err != nil {
+		return fmt.Errorf("failed to write server certificate: %v", err)
+	}
+
+	clientCert, clientKey, err := generateCert("bifrost-benchmarks client", nil, caCert, caKey, *validFor)
+	if err != nil {
+		return fmt.Errorf("failed to generate client certificate: %v", err)
+	}
+	if err := writeCertPair(*dir, "client", clientCert, clientKey); err != nil {
+		return fmt.Errorf("failed to write client certificate: %v", err)
+	}
+
+	fmt.Printf("Test certificates written to %s (ca, server, client)\n", *dir)
+	return nil
+}
+
+// generateCert creates a certificate signed by the given parent, or a self-signed CA when parent is nil.
+// Leaf certificates are valid for both server and client authentication.
+func generateCert(commonName string, hosts []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, validFor time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"bifrost-benchmarks"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.ExtKeyUsage = nil
+	} else {
+		signer, signerKey = parent, parentKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// writeCertPair writes <name>.pem and <name>-key.pem to dir
+func writeCertPair(dir string, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0644); err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600)
+}
+
+// LoadClientTLSConfig builds the runner's TLS config: trust caFile and, when certFile/keyFile
+// are given, present a client certificate for mutual TLS
+func LoadClientTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	cfg := &tls.Config{RootCAs: pool}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
@@ -0,0 +1,335 @@
+package bench
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// composeLabelPrefix marks the labels that make a docker-compose service a benchmarked gateway
+const composeLabelPrefix = "benchmark."
+
+// composeFile is the part of a docker-compose file provider discovery reads. Services stay a
+// node so providers keep the order they are declared in.
+type composeFile struct {
+	Name     string    `yaml:"name"`
+	Services yaml.Node `yaml:"services"`
+}
+
+// composeService is the part of a service definition provider discovery reads
+type composeService struct {
+	ContainerName string        `yaml:"container_name"`
+	Ports         []composePort `yaml:"ports"`
+	Labels        composeLabels `yaml:"labels"`
+}
+
+// composeProjectInvalid matches what Compose drops from a directory name to make a project name
+var composeProjectInvalid = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// composePort is one published port, from the short ("8080:80") or the long syntax, interpolated
+type composePort struct {
+	Published string // Host port, "" when Docker picks one
+	Target    string // Container port
+	Protocol  string // tcp or udp
+}
+
+func (p *composePort) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Target    string `yaml:"target"`
+			Published string `yaml:"published"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return err
+		}
+		var err error
+		*p = composePort{Protocol: long.Protocol}
+		if p.Published, err = interpolateCompose(long.Published); err != nil {
+			return err
+		}
+		p.Target, err = interpolateCompose(long.Target)
+		return err
+	}
+
+	// [HOST_IP:][PUBLISHED:]TARGET[/PROTOCOL]; the host IP may be a bracketed IPv6 address
+	var short string
+	if err := node.Decode(&short); err != nil {
+		return err
+	}
+	short, err := interpolateCompose(short)
+	if err != nil {
+		return err
+	}
+	spec, protocol, _ := strings.Cut(short, "/")
+	*p = composePort{Protocol: protocol}
+	i := strings.LastIndex(spec, ":")
+	p.Target = spec[i+1:]
+	if i >= 0 {
+		rest := spec[:i]
+		p.Published = rest[strings.LastIndex(rest, ":")+1:]
+	}
+	return nil
+}
+
+// composeLabels are a service's labels, from a mapping or a list of key=value
+type composeLabels map[string]string
+
+func (l *composeLabels) UnmarshalYAML(node *yaml.Node) error {
+	labels := make(composeLabels)
+	if node.Kind == yaml.SequenceNode {
+		var list []string
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+		for _, entry := range list {
+			key, value, _ := strings.Cut(entry, "=")
+			labels[key] = value
+		}
+	} else if err := node.Decode((*map[string]string)(&labels)); err != nil {
+		return err
+	}
+	*l = labels
+	return nil
+}
+
+// loadComposeProviders reads the gateways to benchmark from a docker-compose file. Every
+// service with a benchmark.* label is one, reached on the host port it publishes and monitored
+// through its container, unless benchmark.enable is false:
+//
+//	benchmark.name            provider name, default the service name
+//	benchmark.port            container port to benchmark, when the service publishes several
+//	benchmark.route           chat completions path, like path in the providers config
+//	benchmark.route.<route>   path or URL of another route, like routes
+//	benchmark.header.<name>   header sent with every request, ${VAR} read from the environment
+//	benchmark.auth            bearer:<VAR> or header:<name>:<VAR>
+//	benchmark.rate, .duration per-provider load
+//	benchmark.monitor         process, or docker:<container> for another container
+//	benchmark.pprof           pprof URL, or a path on the published port
+//	benchmark.metrics         JSON metrics URL with goroutine counts, or a path on the published port
+//
+// Values are interpolated like Compose does, so ${PORT:-3001} works in ports and labels, from
+// the environment and the .env file next to the compose file.
+func loadComposeProviders(path string, host string) ([]ProviderConfig, error) {
+	if err := loadComposeEnv(path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %v", err)
+	}
+	var file composeFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %v", err)
+	}
+	if file.Services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("compose file %s has no services", path)
+	}
+	project, err := composeProject(file.Name, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []ProviderConfig
+	for i := 0; i+1 < len(file.Services.Content); i += 2 {
+		name := file.Services.Content[i].Value
+		var service composeService
+		if err := file.Services.Content[i+1].Decode(&service); err != nil {
+			return nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		config, ok, err := service.provider(name, project, host)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := config.validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no service in %s has %s labels", path, composeLabelPrefix+"*")
+	}
+	return configs, nil
+}
+
+// loadComposeEnv adds the variables of the .env file in the compose file's directory, which
+// Compose interpolates with, without overriding the ones already set
+func loadComposeEnv(path string) error {
+	envFile := filepath.Join(filepath.Dir(path), ".env")
+	if _, err := os.Stat(envFile); os.IsNotExist(err) {
+		return nil
+	}
+	if err := godotenv.Load(envFile); err != nil {
+		return fmt.Errorf("failed to load %s: %v", envFile, err)
+	}
+	return nil
+}
+
+// composeProject returns the Compose project name, which container names start with: the
+// file's name, COMPOSE_PROJECT_NAME or the directory holding the file
+func composeProject(name string, path string) (string, error) {
+	if name != "" {
+		return interpolateCompose(name)
+	}
+	if name := os.Getenv("COMPOSE_PROJECT_NAME"); name != "" {
+		return name, nil
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the compose project: %v", err)
+	}
+	return composeProjectInvalid.ReplaceAllString(strings.ToLower(filepath.Base(dir)), ""), nil
+}
+
+// provider turns a service into a provider config, reporting false for services without
+// benchmark labels
+func (s composeService) provider(name string, project string, host string) (ProviderConfig, bool, error) {
+	labels := make(map[string]string)
+	for key, value := range s.Labels {
+		if !strings.HasPrefix(key, composeLabelPrefix) {
+			continue
+		}
+		expanded, err := interpolateCompose(value)
+		if err != nil {
+			return ProviderConfig{}, false, fmt.Errorf("label %s: %v", key, err)
+		}
+		labels[strings.TrimPrefix(key, composeLabelPrefix)] = expanded
+	}
+	if len(labels) == 0 || labels["enable"] == "false" {
+		return ProviderConfig{}, false, nil
+	}
+
+	config := ProviderConfig{Name: name, Path: labels["route"], Pprof: labels["pprof"], MetricsURL: labels["metrics"]}
+	if label, ok := labels["name"]; ok {
+		config.Name = label
+	}
+
+	port, err := s.publishedPort(labels["port"])
+	if err != nil {
+		return config, false, err
+	}
+	config.Port = port
+	if strings.HasPrefix(config.Pprof, "/") {
+		config.Pprof = localURL(host, port, config.Pprof)
+	}
+	if strings.HasPrefix(config.MetricsURL, "/") {
+		config.MetricsURL = localURL(host, port, config.MetricsURL)
+	}
+
+	container := s.ContainerName
+	if container == "" {
+		container = fmt.Sprintf("%s-%s-1", project, name)
+	} else if container, err = interpolateCompose(container); err != nil {
+		return config, false, fmt.Errorf("container_name: %v", err)
+	}
+	config.Monitor = dockerMonitorPrefix + container
+	if monitor, ok := labels["monitor"]; ok {
+		config.Monitor = monitor
+	}
+
+	for key, value := range labels {
+		switch {
+		case strings.HasPrefix(key, "route."):
+			if config.Routes == nil {
+				config.Routes = make(map[string]string)
+			}
+			config.Routes[strings.TrimPrefix(key, "route.")] = value
+		case strings.HasPrefix(key, "header."):
+			if config.Headers == nil {
+				config.Headers = make(map[string]string)
+			}
+			config.Headers[strings.TrimPrefix(key, "header.")] = value
+		}
+	}
+
+	if auth, ok := labels["auth"]; ok {
+		scheme, rest, _ := strings.Cut(auth, ":")
+		config.Auth = &AuthConfig{Scheme: scheme, Env: rest}
+		if scheme == "header" {
+			config.Auth.Header, config.Auth.Env, _ = strings.Cut(rest, ":")
+		}
+	}
+	for _, load := range []struct {
+		label string
+		dst   *int
+	}{{"rate", &config.Rate}, {"duration", &config.Duration}} {
+		value, ok := labels[load.label]
+		if !ok {
+			continue
+		}
+		if *load.dst, err = strconv.Atoi(value); err != nil {
+			return config, false, fmt.Errorf("label %s%s must be a whole number, got %q", composeLabelPrefix, load.label, value)
+		}
+	}
+	return config, true, nil
+}
+
+// publishedPort returns the host port the service publishes for the container port target,
+// or its first published TCP port when target is empty
+func (s composeService) publishedPort(target string) (string, error) {
+	for _, port := range s.Ports {
+		if (port.Protocol != "" && port.Protocol != "tcp") || (target != "" && port.Target != target) {
+			continue
+		}
+		if port.Published == "" {
+			return "", fmt.Errorf("container port %s is published on a port Docker picks, publish it on a fixed host port", port.Target)
+		}
+		if strings.Contains(port.Published, "-") {
+			return "", fmt.Errorf("port range %s can't be benchmarked, publish one port per gateway", port.Published)
+		}
+		return port.Published, nil
+	}
+	if target != "" {
+		return "", fmt.Errorf("container port %s is not published", target)
+	}
+	return "", fmt.Errorf("publishes no TCP port")
+}
+
+// interpolateCompose expands variables the way Compose does: $VAR, ${VAR}, ${VAR:-default},
+// ${VAR-default}, ${VAR:?error}, ${VAR?error}, and $$ for a literal $
+func interpolateCompose(s string) (string, error) {
+	var failed error
+	expanded := os.Expand(s, func(expr string) string {
+		if expr == "$" {
+			return "$"
+		}
+		name, fallback, op := expr, "", ""
+		if i := strings.IndexAny(expr, ":-?"); i >= 0 {
+			name, op = expr[:i], expr[i:]
+			if strings.HasPrefix(op, ":") && len(op) > 1 {
+				op, fallback = op[:2], op[2:]
+			} else {
+				op, fallback = op[:1], op[1:]
+			}
+		}
+		value, set := os.LookupEnv(name)
+		switch op {
+		case ":-":
+			if value == "" {
+				return fallback
+			}
+		case "-":
+			if !set {
+				return fallback
+			}
+		case ":?", "?":
+			if !set || (op == ":?" && value == "") {
+				if fallback == "" {
+					fallback = "not set"
+				}
+				failed = fmt.Errorf("%s: %s", name, fallback)
+			}
+		}
+		return value
+	})
+	return expanded, failed
+}
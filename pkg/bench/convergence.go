@@ -0,0 +1,84 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// minTailSamples is the number of observations we want above a percentile before trusting it
+const minTailSamples = 10
+
+// PercentileEstimate holds a latency percentile together with its 95% confidence interval
+type PercentileEstimate struct {
+	Quantile float64
+	Value    time.Duration
+	Low      time.Duration
+	High     time.Duration
+	Samples  int
+}
+
+// Converged reports whether enough samples landed beyond the percentile for it to be meaningful
+func (e PercentileEstimate) Converged() bool {
+	return float64(e.Samples)*(1-e.Quantile) >= minTailSamples
+}
+
+// sortedLatencies returns a sorted copy of the given latencies
+func sortedLatencies(latencies []time.Duration) []time.Duration {
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// estimatePercentile computes the q-th percentile of sorted latencies with a distribution-free
+// 95% confidence interval. The number of samples below the true percentile is Binomial(n, q),
+// so with the normal approximation to the binomial the interval bounds are the order statistics
+// at n*q ± 1.96*sqrt(n*q*(1-q)).
+func estimatePercentile(sorted []time.Duration, q float64) PercentileEstimate {
+	n := len(sorted)
+	est := PercentileEstimate{Quantile: q, Samples: n}
+	if n == 0 {
+		return est
+	}
+
+	rank := func(r float64) time.Duration {
+		i := int(math.Ceil(r)) - 1
+		if i < 0 {
+			i = 0
+		}
+		if i > n-1 {
+			i = n - 1
+		}
+		return sorted[i]
+	}
+
+	center := float64(n) * q
+	spread := 1.96 * math.Sqrt(float64(n)*q*(1-q))
+
+	est.Value = rank(center)
+	est.Low = rank(center - spread)
+	est.High = rank(center + spread + 1)
+	return est
+}
+
+// convergenceWarnings returns a warning for every estimate that lacks enough tail samples
+func convergenceWarnings(estimates ...PercentileEstimate) []string {
+	var warnings []string
+	for _, e := range estimates {
+		if e.Converged() {
+			continue
+		}
+		needed := int(math.Ceil(minTailSamples/(1-e.Quantile) - 1e-9))
+		warnings = append(warnings, fmt.Sprintf("%s is based on %d samples, at least %d are needed for a reliable estimate",
+			percentileLabel(e.Quantile), e.Samples, needed))
+	}
+	return warnings
+}
+
+// percentileLabel formats a quantile as e.g. P99 or P99.9
+func percentileLabel(q float64) string {
+	return "P" + strconv.FormatFloat(math.Round(q*1000)/10, 'f', -1, 64)
+}
@@ -0,0 +1,108 @@
+package bench
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// CostConfig is the cost section of a provider config: what it costs to run the gateway. Raw
+// throughput comparisons ignore that one gateway may need a bigger instance, or four times the
+// memory, to reach it; pricing the run turns them into requests per dollar.
+type CostConfig struct {
+	HourlyUSD       float64 `json:"hourly_usd"`         // Infrastructure per hour, e.g. the instance price
+	PerRequestUSD   float64 `json:"per_request_usd"`    // Per request sent, e.g. a managed gateway's pricing
+	MemoryGBHourUSD float64 `json:"memory_gb_hour_usd"` // Per GB-hour of the gateway's measured peak memory
+}
+
+// Validate checks that the costs are non-negative and at least one is set
+func (c *CostConfig) Validate() error {
+	if c.HourlyUSD < 0 || c.PerRequestUSD < 0 || c.MemoryGBHourUSD < 0 {
+		return fmt.Errorf("costs must not be negative")
+	}
+	if c.HourlyUSD == 0 && c.PerRequestUSD == 0 && c.MemoryGBHourUSD == 0 {
+		return fmt.Errorf("set hourly_usd, per_request_usd or memory_gb_hour_usd")
+	}
+	return nil
+}
+
+// pricePerformance prices a run at its measured rates, nil for providers without a cost. The
+// score is the successful requests per dollar, 0 when the P99 misses targetP99 (0 for no target),
+// since throughput bought at a latency the SLA doesn't allow is worth nothing.
+func pricePerformance(cost *CostConfig, metrics *vegeta.Metrics, memStats []ServerMemStat, duration int, targetP99 time.Duration) *resultfile.PricePerformance {
+	if cost == nil {
+		return nil
+	}
+	var peakRSS uint64
+	for _, stat := range memStats {
+		peakRSS = max(peakRSS, stat.RSS)
+	}
+	if cost.MemoryGBHourUSD > 0 && peakRSS == 0 {
+		log.Printf("Warning: No server memory was sampled, memory_gb_hour_usd is not charged")
+	}
+	hourly := cost.HourlyUSD + cost.MemoryGBHourUSD*float64(peakRSS)/(1<<30) + cost.PerRequestUSD*metrics.Rate*3600
+
+	p := &resultfile.PricePerformance{
+		HourlyUSD:   hourly,
+		RunCostUSD:  hourly * float64(duration) / 3600,
+		MeetsTarget: targetP99 == 0 || metrics.Latencies.P99 <= targetP99,
+	}
+	if targetP99 > 0 {
+		p.TargetP99Ms = float64(targetP99) / float64(time.Millisecond)
+	}
+	if hourly > 0 {
+		p.RequestsPerDollar = metrics.Throughput * 3600 / hourly
+	}
+	if p.MeetsTarget {
+		p.Score = p.RequestsPerDollar
+	}
+	return p
+}
+
+// formatPricePerformance describes a provider's price-performance for its summary
+func formatPricePerformance(p *resultfile.PricePerformance) string {
+	desc := fmt.Sprintf("%s requests/$ at $%s/h ($%s for the run)", report.Float(p.RequestsPerDollar, 0), report.Float(p.HourlyUSD, 4), report.Float(p.RunCostUSD, 6))
+	switch {
+	case p.TargetP99Ms == 0:
+	case p.MeetsTarget:
+		desc += fmt.Sprintf(", P99 within %s", report.Duration(msDuration(p.TargetP99Ms)))
+	default:
+		desc += fmt.Sprintf(", score 0: P99 above %s", report.Duration(msDuration(p.TargetP99Ms)))
+	}
+	return desc
+}
+
+// PrintPriceRanking ranks the providers that have a cost by price-performance score, for runs
+// comparing more than one
+func PrintPriceRanking(results []Result) {
+	var priced []Result
+	for _, res := range results {
+		if res.PricePerformance != nil {
+			priced = append(priced, res)
+		}
+	}
+	if len(priced) < 2 {
+		return
+	}
+	slices.SortStableFunc(priced, func(a, b Result) int { return cmp.Compare(b.PricePerformance.Score, a.PricePerformance.Score) })
+
+	fmt.Println("Price-Performance Ranking (successful requests per dollar):")
+	best := priced[0].PricePerformance.Score
+	for i, res := range priced {
+		p := res.PricePerformance
+		line := fmt.Sprintf("  %d. %s: %s", i+1, res.ProviderName, report.Float(p.Score, 0))
+		if !p.MeetsTarget {
+			line += fmt.Sprintf(" (P99 above %s)", report.Duration(msDuration(p.TargetP99Ms)))
+		} else if i > 0 && p.Score > 0 {
+			line += fmt.Sprintf(" (%sx the cost of %s)", report.Float(best/p.Score, 2), priced[0].ProviderName)
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dockerMonitorPrefix selects Docker API sampling in -monitor and the providers config monitor field
+const dockerMonitorPrefix = "docker:"
+
+// defaultDockerSocket is where the Docker daemon listens unless DOCKER_HOST names another unix socket
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// ParseMonitor validates a monitor setting and returns the container it names, or "" to
+// monitor the process listening on the provider's port
+func ParseMonitor(monitor string) (container string, err error) {
+	switch {
+	case monitor == "" || monitor == "process":
+		return "", nil
+	case strings.HasPrefix(monitor, dockerMonitorPrefix):
+		container = strings.TrimPrefix(monitor, dockerMonitorPrefix)
+		if container == "" {
+			return "", fmt.Errorf("%q names no container", monitor)
+		}
+		return container, nil
+	}
+	return "", fmt.Errorf("unknown monitor %q (use process or docker:<container>)", monitor)
+}
+
+// dockerStats is the part of the Docker container stats response the runner uses
+type dockerStats struct {
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage uint64 `json:"total_usage"`
+		} `json:"cpu_usage"`
+		SystemUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs  int    `json:"online_cpus"`
+	} `json:"cpu_stats"`
+}
+
+// workingSet returns memory usage without the reclaimable page cache, like docker stats shows
+func (s dockerStats) workingSet() uint64 {
+	inactive, ok := s.MemoryStats.Stats["inactive_file"] // cgroup v2
+	if !ok {
+		inactive = s.MemoryStats.Stats["total_inactive_file"] // cgroup v1
+	}
+	if inactive > s.MemoryStats.Usage {
+		return s.MemoryStats.Usage
+	}
+	return s.MemoryStats.Usage - inactive
+}
+
+// dockerMonitor samples a container's memory and CPU through the Docker API, for targets whose
+// process lives in another pid namespace and can't be found by port
+type dockerMonitor struct {
+	client    *http.Client
+	container string
+
+	mu          sync.Mutex
+	first, last dockerStats
+	samples     int
+}
+
+// newDockerMonitor connects to the Docker daemon's unix socket
+func newDockerMonitor(container string) *dockerMonitor {
+	socket := defaultDockerSocket
+	if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, unixScheme) {
+		socket = unixSocketPath(host)
+	}
+	return &dockerMonitor{
+		client:    &http.Client{Transport: &http.Transport{DialContext: unixDialer(socket)}, Timeout: 5 * time.Second},
+		container: container,
+	}
+}
+
+// Stats fetches a single stats sample without waiting for Docker's own CPU delta
+func (m *dockerMonitor) Stats() (dockerStats, error) {
+	var stats dockerStats
+	resp, err := m.client.Get("http://docker/containers/" + url.PathEscape(m.container) + "/stats?stream=false&one-shot=true")
+	if err != nil {
+		return stats, fmt.Errorf("failed to query docker stats: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return stats, fmt.Errorf("docker stats for container %s returned HTTP %d", m.container, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return stats, fmt.Errorf("failed to parse docker stats: %v", err)
+	}
+	return stats, nil
+}
+
+// Run samples the container every 100ms until stop is closed, appending memory to stats
+func (m *dockerMonitor) Run(stop <-chan struct{}, stats *[]ServerMemStat, mutex *sync.Mutex) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sample, err := m.Stats()
+			if err != nil {
+				continue
+			}
+
+			memStat := ServerMemStat{
+				Timestamp: time.Now(),
+				RSS:       sample.workingSet(),
+			}
+			if sample.MemoryStats.Limit > 0 {
+				memStat.MemPercent = 100 * float64(memStat.RSS) / float64(sample.MemoryStats.Limit)
+			}
+
+			mutex.Lock()
+			*stats = append(*stats, memStat)
+			mutex.Unlock()
+
+			m.mu.Lock()
+			if m.samples == 0 {
+				m.first = sample
+			}
+			m.last = sample
+			m.samples++
+			m.mu.Unlock()
+		}
+	}
+}
+
+// CPUPercent returns the container's average CPU usage between the first and last sample,
+// where 100% is one core, computed the same way as docker stats
+func (m *dockerMonitor) CPUPercent() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.samples < 2 {
+		return 0
+	}
+	cpuDelta := float64(m.last.CPUStats.CPUUsage.TotalUsage) - float64(m.first.CPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(m.last.CPUStats.SystemUsage) - float64(m.first.CPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * float64(max(m.last.CPUStats.OnlineCPUs, 1)) * 100
+}
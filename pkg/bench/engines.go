@@ -0,0 +1,326 @@
+package bench
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// DefaultEngine is vegeta's attacker, which every feature of the runner supports
+const DefaultEngine = "vegeta"
+
+// Engine sends one provider's load and reports every request as a vegeta result, so the rest of
+// the runner doesn't care which generator produced it. *vegeta.Attacker is an Engine.
+type Engine interface {
+	Attack(tr vegeta.Targeter, rate vegeta.Pacer, du time.Duration, name string) <-chan *vegeta.Result
+	Stop() bool
+}
+
+// EngineFactory builds the engine a target is attacked with. client carries the runner's
+// transport (TLS, unix sockets, HTTP/3 and request IDs); engines that send requests themselves
+// may ignore it. arg is the text after the colon in -engine name:arg.
+type EngineFactory func(client *http.Client, arg string) (Engine, error)
+
+var (
+	enginesMu sync.RWMutex
+	engines   = map[string]EngineFactory{
+		DefaultEngine: func(client *http.Client, _ string) (Engine, error) {
+			return vegeta.NewAttacker(vegeta.Client(client)), nil
+		},
+		"k6":     newK6Engine,
+		"native": newNativeEngine,
+	}
+)
+
+// RegisterEngine makes a load engine selectable by name with -engine or Scenario.Engine. Call it
+// from an init function; it panics if the name is taken.
+func RegisterEngine(name string, factory EngineFactory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	if name == "" || strings.Contains(name, ":") {
+		panic(fmt.Sprintf("bench: invalid engine name %q", name))
+	}
+	if _, ok := engines[name]; ok {
+		panic(fmt.Sprintf("bench: engine %q registered twice", name))
+	}
+	engines[name] = factory
+}
+
+// EngineNames lists the registered engines
+func EngineNames() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	return engineNames()
+}
+
+// engineNames lists the registered engines; callers hold enginesMu
+func engineNames() []string {
+	var names []string
+	for name := range engines {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LookupEngine validates a name[:arg] engine setting; "" is the default engine
+func LookupEngine(spec string) (factory EngineFactory, arg string, err error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	if name == "" {
+		name = DefaultEngine
+	}
+
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	factory, ok := engines[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown engine %q (registered: %s)", name, strings.Join(engineNames(), ", "))
+	}
+	return factory, arg, nil
+}
+
+// newEngine builds the engine spec names
+func newEngine(spec string, client *http.Client) (Engine, error) {
+	factory, arg, err := LookupEngine(spec)
+	if err != nil {
+		return nil, err
+	}
+	engine, err := factory(client, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create engine %q: %v", spec, err)
+	}
+	return engine, nil
+}
+
+// nonDefaultEngine names the engine spec selects, "" for vegeta
+func nonDefaultEngine(spec string) string {
+	name, _, _ := strings.Cut(spec, ":")
+	if name == DefaultEngine {
+		return ""
+	}
+	return name
+}
+
+// k6Bodies is how many request bodies are drawn from the targeter up front for k6, which
+// can't call back into the runner for every request
+const k6Bodies = 1000
+
+// k6Script runs a constant arrival rate scenario, sending one of the pre-drawn bodies at random
+const k6Script = `import http from 'k6/http';
+import { SharedArray } from 'k6/data';
+
+const bodies = new SharedArray('bodies', () => JSON.parse(open(__ENV.BENCH_BODIES)));
+const params = { headers: JSON.parse(__ENV.BENCH_HEADERS), timeout: '240s' };
+
+export const options = {
+  discardResponseBodies: true,
+  scenarios: {
+    load: {
+      executor: 'constant-arrival-rate',
+      rate: Number(__ENV.BENCH_RATE),
+      timeUnit: '1s',
+      duration: __ENV.BENCH_DURATION,
+      preAllocatedVUs: Number(__ENV.BENCH_VUS),
+      maxVUs: Number(__ENV.BENCH_MAX_VUS),
+    },
+  },
+};
+
+export default function () {
+  http.request(__ENV.BENCH_METHOD, __ENV.BENCH_URL, bodies[Math.floor(Math.random() * bodies.length)], params);
+}
+`
+
+// k6Engine runs the attack in an external k6 process and reads its per-request samples back from
+// k6's JSON output once it exits. k6 sends with its own HTTP client, so the runner's transport
+// settings, request IDs and traces don't apply, and response bodies and headers are not kept
+// (no -validate or Server-Timing breakdowns).
+type k6Engine struct {
+	binary string
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+// newK6Engine finds the k6 binary, the one named by arg or k6 on the PATH
+func newK6Engine(_ *http.Client, arg string) (Engine, error) {
+	binary := arg
+	if binary == "" {
+		binary = "k6"
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("k6 not found, install it or pass its path as -engine k6:/path/to/k6: %v", err)
+	}
+	return &k6Engine{binary: path}, nil
+}
+
+// Attack runs k6 at the pacer's rate. Only constant rates are supported, as with -rate.
+func (e *k6Engine) Attack(tr vegeta.Targeter, pacer vegeta.Pacer, du time.Duration, name string) <-chan *vegeta.Result {
+	results := make(chan *vegeta.Result)
+	go func() {
+		defer close(results)
+		if err := e.run(tr, pacer, du, results); err != nil {
+			log.Printf("Warning: k6 attack for %s failed: %v", name, err)
+		}
+	}()
+	return results
+}
+
+func (e *k6Engine) run(tr vegeta.Targeter, pacer vegeta.Pacer, du time.Duration, results chan<- *vegeta.Result) error {
+	rate, ok := pacer.(vegeta.Rate)
+	if !ok || rate.Per != time.Second {
+		return fmt.Errorf("k6 only supports a constant rate per second")
+	}
+
+	dir, err := os.MkdirTemp("", "bench-k6-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Draw the bodies k6 picks from, and the method, URL and headers they are sent with
+	var first vegeta.Target
+	bodies := make([]string, 0, k6Bodies)
+	for i := 0; i < k6Bodies; i++ {
+		var tgt vegeta.Target
+		if err := tr(&tgt); err != nil {
+			return fmt.Errorf("failed to build request: %v", err)
+		}
+		if i == 0 {
+			first = tgt
+		}
+		bodies = append(bodies, string(tgt.Body))
+	}
+	headers := map[string]string{}
+	for key := range first.Header {
+		headers[key] = first.Header.Get(key)
+	}
+
+	script, bodyFile, outFile := dir+"/script.js", dir+"/bodies.json", dir+"/samples.json"
+	if err := os.WriteFile(script, []byte(k6Script), 0o644); err != nil {
+		return err
+	}
+	bodyJSON, _ := json.Marshal(bodies)
+	if err := os.WriteFile(bodyFile, bodyJSON, 0o644); err != nil {
+		return err
+	}
+	headerJSON, _ := json.Marshal(headers)
+
+	// Enough VUs for a second of requests up front, and room for slow responses
+	vus := max(rate.Freq, 1)
+	env := map[string]string{
+		"BENCH_BODIES":   bodyFile,
+		"BENCH_HEADERS":  string(headerJSON),
+		"BENCH_RATE":     strconv.Itoa(rate.Freq),
+		"BENCH_DURATION": fmt.Sprintf("%ds", int(du.Seconds())),
+		"BENCH_VUS":      strconv.Itoa(vus),
+		"BENCH_MAX_VUS":  strconv.Itoa(vus * 10),
+		"BENCH_METHOD":   first.Method,
+		"BENCH_URL":      first.URL,
+	}
+	args := []string{"run", "--quiet", "--no-summary", "--out", "json=" + outFile}
+	for key, value := range env {
+		args = append(args, "-e", key+"="+value)
+	}
+	args = append(args, script)
+
+	cmd := exec.Command(e.binary, args...)
+	cmd.Stderr = os.Stderr
+	e.mu.Lock()
+	e.cmd = cmd
+	e.mu.Unlock()
+	runErr := cmd.Run()
+
+	samples, err := readK6Samples(outFile)
+	if err != nil {
+		return err
+	}
+	for _, res := range samples {
+		results <- res
+	}
+	if runErr != nil && len(samples) == 0 {
+		return runErr
+	}
+	return nil
+}
+
+// Stop ends the k6 process; the samples it wrote so far are still reported
+func (e *k6Engine) Stop() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cmd == nil || e.cmd.Process == nil {
+		return false
+	}
+	return e.cmd.Process.Signal(os.Interrupt) == nil
+}
+
+// k6Point is one line of k6's JSON output
+type k6Point struct {
+	Type   string `json:"type"`
+	Metric string `json:"metric"`
+	Data   struct {
+		Time  time.Time         `json:"time"`
+		Value float64           `json:"value"` // Milliseconds for http_req_duration
+		Tags  map[string]string `json:"tags"`
+	} `json:"data"`
+}
+
+// readK6Samples turns k6's http_req_duration points into results ordered by send time. k6
+// timestamps a sample when the request completes. Seq only numbers the results in that order:
+// k6 doesn't say when it meant to send each request, so the runner neither corrects k6 latencies
+// for coordinated omission nor reports late requests.
+func readK6Samples(path string) ([]*vegeta.Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read k6 output: %v", err)
+	}
+	defer file.Close()
+
+	var results []*vegeta.Result
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var point k6Point
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil || point.Type != "Point" || point.Metric != "http_req_duration" {
+			continue
+		}
+		latency := time.Duration(point.Data.Value * float64(time.Millisecond))
+		res := &vegeta.Result{
+			Timestamp: point.Data.Time.Add(-latency),
+			Latency:   latency,
+			Method:    point.Data.Tags["method"],
+			URL:       point.Data.Tags["url"],
+		}
+		code, _ := strconv.Atoi(point.Data.Tags["status"])
+		res.Code = uint16(code)
+		switch {
+		case point.Data.Tags["error"] != "":
+			res.Error = point.Data.Tags["error"]
+		case res.Code < 200 || res.Code >= 400:
+			res.Error = fmt.Sprintf("%d %s", res.Code, http.StatusText(code))
+		}
+		results = append(results, res)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read k6 output: %v", err)
+	}
+
+	slices.SortFunc(results, func(a, b *vegeta.Result) int { return a.Timestamp.Compare(b.Timestamp) })
+	for i, res := range results {
+		res.Seq = uint64(i)
+	}
+	return results, nil
+}
@@ -0,0 +1,214 @@
+package bench
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// exportSchemaVersion is bumped whenever a column of the exported dataset changes meaning
+const exportSchemaVersion = 1
+
+// publicFlags are the run flags that describe the scenario and are safe to publish.
+// Everything else (paths, URLs, extra gateway arguments) may identify private infrastructure.
+var publicFlags = []string{
+	"rate", "duration", "cooldown", "scenario", "model", "model-mix", "big-payload", "suffix", "validate", "soak", "tag",
+}
+
+// RequestSample is the outcome of a single request, kept for raw data exports
+type RequestSample struct {
+	Seq     uint64
+	SentAt  time.Time
+	Latency time.Duration
+	Code    uint16
+	Outcome string // ok, http_error, invalid, client_timeout, server_timeout or error
+	Error   string
+
+	BytesIn  uint64 // Response body bytes, after decoding
+	BytesOut uint64 // Request body bytes
+}
+
+// newRequestSample records a vegeta result along with how the runner classified it
+func newRequestSample(res *vegeta.Result, kind timeoutKind, invalid bool) RequestSample {
+	outcome := "ok"
+	switch {
+	case kind == clientTimeout:
+		outcome = "client_timeout"
+	case kind == serverTimeout:
+		outcome = "server_timeout"
+	case res.Error != "" && res.Code == 0:
+		outcome = "error"
+	case res.Code != 200:
+		outcome = "http_error"
+	case invalid:
+		outcome = "invalid"
+	}
+
+	return RequestSample{
+		Seq:     res.Seq,
+		SentAt:  res.Timestamp,
+		Latency: res.Latency,
+		Code:    res.Code,
+		Outcome: outcome,
+		Error:   res.Error,
+
+		BytesIn:  res.BytesIn,
+		BytesOut: res.BytesOut,
+	}
+}
+
+// RunExport implements the `export` command, writing the per-request samples of a recorded run as CSV
+func RunExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbPath := fs.String("db", "results.db", "SQLite results database")
+	runID := fs.Int64("run", 0, "Run to export (0 for the latest run)")
+	output := fs.String("output", "dataset.csv", "Output CSV file; run metadata is written next to it as <output>.meta.json")
+	public := fs.Bool("public", false, "Strip error messages and non-scenario flags so the dataset can be published")
+	fs.Parse(args)
+
+	db, err := openResultsDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *runID == 0 {
+		if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM runs`).Scan(runID); err != nil {
+			return fmt.Errorf("failed to find the latest run: %v", err)
+		}
+		if *runID == 0 {
+			return fmt.Errorf("no runs recorded in %s", *dbPath)
+		}
+	}
+
+	var startedAt, scenario, flagsJSON string
+	err = db.QueryRow(`SELECT started_at, scenario, flags FROM runs WHERE id = ?`, *runID).Scan(&startedAt, &scenario, &flagsJSON)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("run %d not found in %s", *runID, *dbPath)
+	} else if err != nil {
+		return fmt.Errorf("failed to read run %d: %v", *runID, err)
+	}
+
+	var flags map[string]string
+	if err := json.Unmarshal([]byte(flagsJSON), &flags); err != nil {
+		return fmt.Errorf("failed to parse flags of run %d: %v", *runID, err)
+	}
+	if *public {
+		flags = filterFlags(flags, publicFlags)
+	}
+
+	if err := writeExportMeta(*output+".meta.json", *runID, startedAt, scenario, flags, *public); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT s.provider, p.target_rate, p.duration_sec, s.seq, s.sent_at, s.latency_ns, s.status_code, s.outcome, s.error
+		FROM request_samples s JOIN provider_results p ON p.run_id = s.run_id AND p.provider = s.provider
+		WHERE s.run_id = ? ORDER BY s.provider, s.seq`, *runID)
+	if err != nil {
+		return fmt.Errorf("failed to query request samples: %v", err)
+	}
+	defer rows.Close()
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := []string{"schema_version", "run_id", "scenario", "started_at", "provider", "target_rate", "duration_sec",
+		"model", "big_payload", "seq", "sent_at", "latency_ms", "status_code", "outcome"}
+	if !*public {
+		header = append(header, "error")
+	}
+	writer.Write(header)
+
+	count := 0
+	for rows.Next() {
+		var provider, sentAt, outcome, errMsg string
+		var rate, durationSec int
+		var seq, latencyNs int64
+		var code int
+		if err := rows.Scan(&provider, &rate, &durationSec, &seq, &sentAt, &latencyNs, &code, &outcome, &errMsg); err != nil {
+			return fmt.Errorf("failed to read request samples: %v", err)
+		}
+
+		record := []string{
+			strconv.Itoa(exportSchemaVersion),
+			strconv.FormatInt(*runID, 10),
+			scenario,
+			startedAt,
+			provider,
+			strconv.Itoa(rate),
+			strconv.Itoa(durationSec),
+			flags["model"],
+			flags["big-payload"],
+			strconv.FormatInt(seq, 10),
+			sentAt,
+			strconv.FormatFloat(float64(latencyNs)/float64(time.Millisecond), 'f', 3, 64),
+			strconv.Itoa(code),
+			outcome,
+		}
+		if !*public {
+			record = append(record, errMsg)
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("failed to write export: %v", err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read request samples: %v", err)
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write export: %v", err)
+	}
+
+	if count == 0 {
+		log.Printf("Warning: Run %d has no request samples (recorded before samples were stored?)", *runID)
+	}
+	fmt.Printf("Exported %d requests of run %d to %s\n", count, *runID, *output)
+	return nil
+}
+
+// writeExportMeta writes the run level metadata that accompanies an exported dataset
+func writeExportMeta(path string, runID int64, startedAt string, scenario string, flags map[string]string, public bool) error {
+	meta := struct {
+		SchemaVersion int               `json:"schema_version"`
+		RunID         int64             `json:"run_id"`
+		StartedAt     string            `json:"started_at"`
+		Scenario      string            `json:"scenario"`
+		Public        bool              `json:"public"`
+		Flags         map[string]string `json:"flags"`
+	}{exportSchemaVersion, runID, startedAt, scenario, public, flags}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal export metadata: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write export metadata: %v", err)
+	}
+	return nil
+}
+
+// filterFlags keeps only the named flags
+func filterFlags(flags map[string]string, keep []string) map[string]string {
+	filtered := make(map[string]string, len(keep))
+	for _, name := range keep {
+		if value, ok := flags[name]; ok {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,123 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportFormat controls how numbers and durations are printed in human-facing reports.
+// Machine output (results files, snapshots, databases) is never localized.
+type reportFormat struct {
+	Units  string // Latency unit: ns, us, ms, s, or auto to pick per value
+	Locale string // Number formatting: none, en, de, fr
+}
+
+// report is the format used by every printed summary
+var report = reportFormat{Units: "ms", Locale: "none"}
+
+// localeSeparators maps a locale to its thousands and decimal separators
+var localeSeparators = map[string][2]string{
+	"none": {"", "."},
+	"en":   {",", "."},
+	"de":   {".", ","},
+	"fr":   {" ", ","},
+}
+
+// newReportFormat validates the given units and locale
+func newReportFormat(units string, locale string) (reportFormat, error) {
+	switch units {
+	case "ns", "us", "ms", "s", "auto":
+	default:
+		return reportFormat{}, fmt.Errorf("unknown units %q (use ns, us, ms, s or auto)", units)
+	}
+	if _, ok := localeSeparators[locale]; !ok {
+		return reportFormat{}, fmt.Errorf("unknown locale %q (use none, en, de or fr)", locale)
+	}
+	return reportFormat{Units: units, Locale: locale}, nil
+}
+
+// SetReportFormat sets the latency unit and number locale of printed reports
+func SetReportFormat(units string, locale string) error {
+	f, err := newReportFormat(units, locale)
+	if err != nil {
+		return err
+	}
+	report = f
+	return nil
+}
+
+// Duration formats a latency in the configured unit so columns can be compared at a glance
+func (f reportFormat) Duration(d time.Duration) string {
+	units := f.Units
+	if units == "auto" {
+		switch {
+		case d >= time.Second:
+			units = "s"
+		case d >= time.Millisecond:
+			units = "ms"
+		case d >= time.Microsecond:
+			units = "us"
+		default:
+			units = "ns"
+		}
+	}
+
+	switch units {
+	case "ns":
+		return f.Int(int64(d)) + " ns"
+	case "us":
+		return f.Float(float64(d)/float64(time.Microsecond), 2) + " µs"
+	case "s":
+		return f.Float(d.Seconds(), 3) + " s"
+	default:
+		return f.Float(float64(d)/float64(time.Millisecond), 2) + " ms"
+	}
+}
+
+// Float formats a number with the given decimals using the configured locale
+func (f reportFormat) Float(v float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	intPart, fracPart, _ := strings.Cut(s, ".")
+
+	sep := localeSeparators[f.Locale]
+	out := groupThousands(intPart, sep[0])
+	if fracPart != "" {
+		out += sep[1] + fracPart
+	}
+	if v < 0 {
+		out = "-" + out
+	}
+	return out
+}
+
+// Int formats an integer using the configured locale
+func (f reportFormat) Int(v int64) string {
+	return f.Float(float64(v), 0)
+}
+
+func groupThousands(digits string, sep string) string {
+	if sep == "" || len(digits) <= 3 {
+		return digits
+	}
+
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(sep)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+// formatTimestamp formats a time for machine output as an ISO8601 (RFC3339) UTC timestamp
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,255 @@
+package bench
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const resultsSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at TEXT NOT NULL,
+	scenario   TEXT NOT NULL,
+	flags      TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS provider_results (
+	run_id                INTEGER NOT NULL REFERENCES runs(id),
+	provider              TEXT NOT NULL,
+	target_rate           INTEGER NOT NULL,
+	duration_sec          INTEGER NOT NULL,
+	requests              INTEGER NOT NULL,
+	success_rate          REAL NOT NULL,
+	mean_latency_ms       REAL NOT NULL,
+	p50_latency_ms        REAL NOT NULL,
+	p99_latency_ms        REAL NOT NULL,
+	p999_latency_ms       REAL NOT NULL,
+	max_latency_ms        REAL NOT NULL,
+	throughput_rps        REAL NOT NULL,
+	server_peak_memory_mb REAL NOT NULL,
+	server_avg_memory_mb  REAL NOT NULL,
+	summary               TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_provider_results_provider_rate ON provider_results(provider, target_rate);
+CREATE TABLE IF NOT EXISTS memory_samples (
+	run_id      INTEGER NOT NULL REFERENCES runs(id),
+	provider    TEXT NOT NULL,
+	timestamp   TEXT NOT NULL,
+	rss_bytes   INTEGER NOT NULL,
+	vms_bytes   INTEGER NOT NULL,
+	mem_percent REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS request_samples (
+	run_id      INTEGER NOT NULL REFERENCES runs(id),
+	provider    TEXT NOT NULL,
+	seq         INTEGER NOT NULL,
+	sent_at     TEXT NOT NULL,
+	latency_ns  INTEGER NOT NULL,
+	status_code INTEGER NOT NULL,
+	outcome     TEXT NOT NULL,
+	error       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_request_samples_run ON request_samples(run_id, provider);
+`
+
+// openResultsDB opens (and if needed creates) the SQLite results database
+func openResultsDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open results database: %v", err)
+	}
+	if _, err := db.Exec(resultsSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create results schema: %v", err)
+	}
+	return db, nil
+}
+
+// currentFlags returns the value of every command line flag, for recording alongside a run
+func currentFlags() map[string]string {
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// SaveResultsDB appends a run and all of its provider results to the database
+func SaveResultsDB(results []Result, dbPath string, scenario string) error {
+	db, err := openResultsDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	flagsJSON, err := json.Marshal(currentFlags())
+	if err != nil {
+		return fmt.Errorf("failed to marshal flags: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start results transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	run, err := tx.Exec(`INSERT INTO runs (started_at, scenario, flags) VALUES (?, ?, ?)`,
+		formatTimestamp(time.Now()), scenario, string(flagsJSON))
+	if err != nil {
+		return fmt.Errorf("failed to record run: %v", err)
+	}
+	runID, err := run.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to record run: %v", err)
+	}
+
+	for _, res := range results {
+		provider := strings.ToLower(res.ProviderName)
+		summary := serializeResult(res)
+		summaryJSON, err := json.Marshal(summary)
+		if err != nil {
+			return fmt.Errorf("failed to marshal results: %v", err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO provider_results (run_id, provider, target_rate, duration_sec, requests, success_rate,
+			mean_latency_ms, p50_latency_ms, p99_latency_ms, p999_latency_ms, max_latency_ms, throughput_rps,
+			server_peak_memory_mb, server_avg_memory_mb, summary) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			runID, provider, res.TargetRate, res.DurationSec, summary.Requests, summary.SuccessRate,
+			summary.MeanLatencyMs, summary.P50LatencyMs, summary.P99LatencyMs, summary.P999LatencyMs, summary.MaxLatencyMs,
+			summary.ThroughputRPS, summary.ServerPeakMemoryMB, summary.ServerAvgMemoryMB, string(summaryJSON))
+		if err != nil {
+			return fmt.Errorf("failed to record results for %s: %v", res.ProviderName, err)
+		}
+
+		for _, stat := range res.ServerMemoryStats {
+			_, err = tx.Exec(`INSERT INTO memory_samples (run_id, provider, timestamp, rss_bytes, vms_bytes, mem_percent)
+				VALUES (?, ?, ?, ?, ?, ?)`,
+				runID, provider, stat.Timestamp.UTC().Format(time.RFC3339Nano), stat.RSS, stat.VMS, stat.MemPercent)
+			if err != nil {
+				return fmt.Errorf("failed to record memory samples for %s: %v", res.ProviderName, err)
+			}
+		}
+
+		sampleStmt, err := tx.Prepare(`INSERT INTO request_samples (run_id, provider, seq, sent_at, latency_ns, status_code, outcome, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return fmt.Errorf("failed to record request samples for %s: %v", res.ProviderName, err)
+		}
+		for _, sample := range res.Samples {
+			_, err = sampleStmt.Exec(runID, provider, sample.Seq, sample.SentAt.UTC().Format(time.RFC3339Nano),
+				int64(sample.Latency), sample.Code, sample.Outcome, sample.Error)
+			if err != nil {
+				sampleStmt.Close()
+				return fmt.Errorf("failed to record request samples for %s: %v", res.ProviderName, err)
+			}
+		}
+		sampleStmt.Close()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit results: %v", err)
+	}
+
+	fmt.Printf("Results saved to %s (run %d)\n", dbPath, runID)
+	return nil
+}
+
+// tagFilterSQL returns a WHERE clause extension keeping provider results p with every tag, which
+// are stored in the summary JSON; tag names are restricted to characters safe in a JSON path
+func tagFilterSQL(tags Tags) (string, []interface{}) {
+	var clause strings.Builder
+	var args []interface{}
+	for name, value := range tags {
+		clause.WriteString(` AND json_extract(p.summary, '$.tags."' || ? || '"') = ?`)
+		args = append(args, name, value)
+	}
+	return clause.String(), args
+}
+
+// RunHistory implements the `history` command, printing how a provider performed over time at a given rate
+func RunHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	dbPath := fs.String("db", "results.db", "SQLite results database")
+	provider := fs.String("provider", "bifrost", "Provider to show history for")
+	rate := fs.Int("rate", 0, "Only show runs at this target rate (0 for all rates)")
+	scenario := fs.String("scenario", "", "Only show runs of this scenario")
+	limit := fs.Int("limit", 50, "Maximum number of runs to show")
+	var tags Tags
+	fs.Var(&tags, "tag", "Only show runs with this name=value tag (repeatable)")
+	fs.Parse(args)
+
+	db, err := openResultsDB(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tagFilter, tagArgs := tagFilterSQL(tags)
+	queryArgs := append([]interface{}{strings.ToLower(*provider), *rate, *rate, *scenario, *scenario}, tagArgs...)
+	rows, err := db.Query(`SELECT r.id, r.started_at, r.scenario, p.target_rate, p.requests, p.success_rate,
+			p.p50_latency_ms, p.p99_latency_ms, p.throughput_rps, p.server_peak_memory_mb, p.summary
+		FROM provider_results p JOIN runs r ON r.id = p.run_id
+		WHERE p.provider = ? AND (? = 0 OR p.target_rate = ?) AND (? = '' OR r.scenario = ?)`+tagFilter+`
+		ORDER BY r.started_at DESC, r.id DESC LIMIT ?`,
+		append(queryArgs, *limit)...)
+	if err != nil {
+		return fmt.Errorf("failed to query history: %v", err)
+	}
+	defer rows.Close()
+
+	type historyRow struct {
+		runID                         int64
+		startedAt, scenario           string
+		rate                          int
+		requests                      uint64
+		success, p50, p99, rps, memMB float64
+		tags                          Tags
+	}
+
+	var history []historyRow
+	for rows.Next() {
+		var h historyRow
+		var summaryJSON string
+		if err := rows.Scan(&h.runID, &h.startedAt, &h.scenario, &h.rate, &h.requests, &h.success,
+			&h.p50, &h.p99, &h.rps, &h.memMB, &summaryJSON); err != nil {
+			return fmt.Errorf("failed to read history: %v", err)
+		}
+		var summary resultfile.ProviderResult
+		if err := json.Unmarshal([]byte(summaryJSON), &summary); err == nil {
+			h.tags = summary.Tags
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read history: %v", err)
+	}
+
+	if len(history) == 0 {
+		fmt.Printf("No runs recorded for %s\n", *provider)
+		return nil
+	}
+
+	fmt.Printf("History for %s:\n", *provider)
+	fmt.Printf("%-6s %-26s %-12s %-6s %-9s %-9s %-10s %-10s %-12s %-10s %s\n",
+		"run", "started", "scenario", "rate", "requests", "success%", "p50 (ms)", "p99 (ms)", "throughput", "peak MB", "tags")
+	// Print oldest first so trends read top to bottom
+	for i := len(history) - 1; i >= 0; i-- {
+		h := history[i]
+		fmt.Printf("%-6d %-26s %-12s %-6d %-9d %-9.2f %-10.2f %-10.2f %-12.2f %-10.2f %s\n",
+			h.runID, h.startedAt, h.scenario, h.rate, h.requests, h.success, h.p50, h.p99, h.rps, h.memMB, h.tags)
+	}
+
+	if len(history) > 1 {
+		first, last := history[len(history)-1], history[0]
+		fmt.Printf("\nTrend over %d runs: P99 %+.1f%%, throughput %+.1f%%, peak memory %+.1f%%\n", len(history),
+			percentChange(first.p99, last.p99), percentChange(first.rps, last.rps), percentChange(first.memMB, last.memMB))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package bench
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+	psnet "github.com/shirou/gopsutil/net"
+)
+
+// ProtocolHTTP3 selects HTTP/3 over QUIC in -http3 and the providers config protocol field
+const ProtocolHTTP3 = "h3"
+
+// ParseProtocol validates a provider's protocol against its endpoint. The default, "", speaks
+// HTTP/1.1 (or HTTP/2 for h2c and TLS targets that negotiate it) over TCP.
+func ParseProtocol(protocol string, endpoint string, socket string) error {
+	switch protocol {
+	case "", "h1":
+		return nil
+	case ProtocolHTTP3:
+		if socket != "" {
+			return fmt.Errorf("HTTP/3 runs over UDP and can't use a unix socket")
+		}
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme != "https" {
+			return fmt.Errorf("HTTP/3 requires an https endpoint, got %s", endpoint)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %q (use h1 or h3)", protocol)
+}
+
+// newHTTP3Transport creates a QUIC client transport. Requests to a host share one QUIC
+// connection, multiplexed over streams up to the server's stream limit.
+func newHTTP3Transport(tlsConfig *tls.Config) *http3.Transport {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return &http3.Transport{TLSClientConfig: tlsConfig.Clone()}
+}
+
+// protocolCounter counts responses by the protocol they arrived over, e.g. HTTP/1.1 or HTTP/3.0
+type protocolCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newProtocolCounter() *protocolCounter {
+	return &protocolCounter{counts: make(map[string]int64)}
+}
+
+// Add counts one response
+func (c *protocolCounter) Add(proto string) {
+	c.mu.Lock()
+	c.counts[proto]++
+	c.mu.Unlock()
+}
+
+// addN counts n responses at once, for engines that count per worker
+func (c *protocolCounter) addN(proto string, n int64) {
+	c.mu.Lock()
+	c.counts[proto] += n
+	c.mu.Unlock()
+}
+
+// Counts returns the responses received per protocol
+func (c *protocolCounter) Counts() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make(map[string]int64, len(c.counts))
+	for proto, n := range c.counts {
+		counts[proto] = n
+	}
+	return counts
+}
+
+// String lists the protocols most used first, e.g. "HTTP/3.0 (998), HTTP/1.1 (2)"
+func (c *protocolCounter) String() string {
+	counts := c.Counts()
+	protos := make([]string, 0, len(counts))
+	for proto := range counts {
+		protos = append(protos, proto)
+	}
+	sort.Slice(protos, func(i, j int) bool { return counts[protos[i]] > counts[protos[j]] })
+
+	parts := make([]string, len(protos))
+	for i, proto := range protos {
+		parts[i] = fmt.Sprintf("%s (%s)", proto, report.Int(counts[proto]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// getProcessByUDPPort finds the processes bound to a UDP port, where QUIC servers listen
+func getProcessByUDPPort(port string) (*processGroup, error) {
+	portNum, err := strconv.ParseUint(port, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port number: %v", err)
+	}
+
+	conns, err := psnet.Connections("udp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connections: %v", err)
+	}
+
+	bound := func(conn psnet.ConnectionStat) bool {
+		return conn.Laddr.Port == uint32(portNum) && conn.Raddr.Port == 0
+	}
+	var pids []int32
+	for _, conn := range conns {
+		if bound(conn) && conn.Pid > 0 {
+			pids = append(pids, conn.Pid)
+			pids = append(pids, listeningRelatives(conn.Pid, "udp", bound)...)
+		}
+	}
+	return newProcessGroup(pids, "UDP port "+port)
+}
@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// ClientSettings tune the HTTP client one target is attacked with. Tuning them per provider keeps
+// a client-side limit, like too few idle connections, from passing for a gateway difference.
+type ClientSettings struct {
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	Timeout             time.Duration // Whole request, including reading the response body
+	DisableCompression  bool
+}
+
+// DefaultClientSettings are used for targets that don't set their own
+var DefaultClientSettings = ClientSettings{
+	MaxIdleConnsPerHost: 100000,
+	IdleConnTimeout:     10 * time.Second,
+	Timeout:             240 * time.Second,
+}
+
+// ClientConfig is the client section of a provider config; unset fields keep the defaults
+type ClientConfig struct {
+	MaxIdleConnsPerHost *int   `json:"max_idle_conns_per_host"`
+	IdleConnTimeout     string `json:"idle_conn_timeout"` // Go duration, e.g. 90s
+	Timeout             string `json:"timeout"`           // Go duration, e.g. 30s
+	DisableCompression  bool   `json:"disable_compression"`
+}
+
+// Settings resolves the config against the defaults; a nil config is the defaults
+func (c *ClientConfig) Settings() (ClientSettings, error) {
+	settings := DefaultClientSettings
+	if c == nil {
+		return settings, nil
+	}
+	if c.MaxIdleConnsPerHost != nil {
+		if *c.MaxIdleConnsPerHost < 0 {
+			return settings, fmt.Errorf("max_idle_conns_per_host must not be negative")
+		}
+		settings.MaxIdleConnsPerHost = *c.MaxIdleConnsPerHost
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{{"idle_conn_timeout", c.IdleConnTimeout, &settings.IdleConnTimeout}, {"timeout", c.Timeout, &settings.Timeout}} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return settings, fmt.Errorf("%s must be a positive duration, e.g. 30s", d.name)
+		}
+		*d.dst = parsed
+	}
+	settings.DisableCompression = c.DisableCompression
+	return settings, nil
+}
+
+// clientSettings returns the target's client settings, the defaults when it sets none
+func (p Target) clientSettings() ClientSettings {
+	if p.Client == nil {
+		return DefaultClientSettings
+	}
+	return *p.Client
+}
+
+// Summary returns the settings as saved with the result
+func (s ClientSettings) Summary() *resultfile.HTTPClient {
+	return &resultfile.HTTPClient{
+		MaxIdleConnsPerHost: s.MaxIdleConnsPerHost,
+		IdleConnTimeoutMs:   float64(s.IdleConnTimeout) / float64(time.Millisecond),
+		TimeoutMs:           float64(s.Timeout) / float64(time.Millisecond),
+		DisableCompression:  s.DisableCompression,
+	}
+}
+
+// String describes the settings, e.g. "100,000 idle conns per host, idle timeout 10s, timeout 4m0s"
+func (s ClientSettings) String() string {
+	desc := fmt.Sprintf("%s idle conns per host, idle timeout %s, timeout %s", report.Int(int64(s.MaxIdleConnsPerHost)), s.IdleConnTimeout, s.Timeout)
+	if s.DisableCompression {
+		desc += ", compression off"
+	}
+	return desc
+}
@@ -0,0 +1,107 @@
+package bench
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	leakSampleInterval = time.Second
+	leakWindows        = 5 // Runs are split into this many windows whose averages must keep rising
+	leakMinSamples     = 10
+)
+
+// leakSample is one reading of a target's open file descriptors and goroutines
+type leakSample struct {
+	Timestamp  time.Time
+	NumFDs     int32 // -1 when unavailable
+	Goroutines int   // -1 when the target does not expose it
+}
+
+// leakMonitor samples FDs and goroutines once a second while a target is attacked
+type leakMonitor struct {
+	proc       *processGroup
+	metricsURL string
+
+	mu      sync.Mutex
+	samples []leakSample
+}
+
+func newLeakMonitor(proc *processGroup, metricsURL string) *leakMonitor {
+	return &leakMonitor{proc: proc, metricsURL: metricsURL}
+}
+
+// Run samples until stop is closed
+func (m *leakMonitor) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(leakSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sample := leakSample{Timestamp: time.Now(), NumFDs: -1, Goroutines: fetchGoroutines(m.metricsURL)}
+			if fds, err := m.proc.NumFDs(); err == nil {
+				sample.NumFDs = fds
+			}
+
+			m.mu.Lock()
+			m.samples = append(m.samples, sample)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// Warnings reports FD or goroutine counts that grew steadily over the run
+func (m *leakMonitor) Warnings() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var fds, goroutines []float64
+	for _, s := range m.samples {
+		if s.NumFDs >= 0 {
+			fds = append(fds, float64(s.NumFDs))
+		}
+		if s.Goroutines >= 0 {
+			goroutines = append(goroutines, float64(s.Goroutines))
+		}
+	}
+
+	var warnings []string
+	if first, last, ok := monotonicGrowth(fds); ok {
+		warnings = append(warnings, fmt.Sprintf("open file descriptors grew steadily from %.0f to %.0f, possible fd leak", first, last))
+	}
+	if first, last, ok := monotonicGrowth(goroutines); ok {
+		warnings = append(warnings, fmt.Sprintf("goroutines grew steadily from %.0f to %.0f, possible goroutine leak", first, last))
+	}
+	return warnings
+}
+
+// monotonicGrowth reports whether values keep rising across the run. Samples are averaged in
+// windows to smooth out load spikes; every window must be higher than the one before, and the
+// last one meaningfully higher than the first.
+func monotonicGrowth(values []float64) (first float64, last float64, grew bool) {
+	if len(values) < leakMinSamples {
+		return 0, 0, false
+	}
+
+	means := make([]float64, leakWindows)
+	for w := range means {
+		window := values[w*len(values)/leakWindows : (w+1)*len(values)/leakWindows]
+		for _, v := range window {
+			means[w] += v
+		}
+		means[w] /= float64(len(window))
+	}
+
+	for w := 1; w < len(means); w++ {
+		if means[w] <= means[w-1] {
+			return 0, 0, false
+		}
+	}
+
+	first, last = means[0], means[len(means)-1]
+	return first, last, last-first >= 10 && percentChange(first, last) >= 10
+}
@@ -0,0 +1,167 @@
+package bench
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+const (
+	liveWindowSeconds = 10 // Seconds of latencies used for the rolling percentiles
+	liveSparkWidth    = 40 // RSS samples shown in the sparkline
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// liveDashboard redraws a small status panel in the terminal every second during an attack
+type liveDashboard struct {
+	provider string
+	proc     *processGroup
+	started  time.Time
+
+	mu      sync.Mutex
+	current liveSecond
+	window  []liveSecond // Most recent completed seconds, oldest first
+	rss     []float64    // Server RSS in MB, one sample per second
+
+	stop  chan struct{}
+	done  chan struct{}
+	lines int // Lines drawn by the previous render, to redraw in place
+}
+
+// liveSecond holds the results that completed within one second
+type liveSecond struct {
+	requests  int
+	errors    int
+	latencies []time.Duration
+}
+
+func newLiveDashboard(provider string, proc *processGroup) *liveDashboard {
+	return &liveDashboard{
+		provider: provider,
+		proc:     proc,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins redrawing the dashboard every second until Stop is called
+func (d *liveDashboard) Start() {
+	d.started = time.Now()
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.tick()
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the dashboard, leaving the last frame on screen
+func (d *liveDashboard) Stop() {
+	close(d.stop)
+	<-d.done
+	fmt.Println()
+}
+
+// Add records a completed request
+func (d *liveDashboard) Add(res *vegeta.Result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.current.requests++
+	if res.Error != "" || res.Code < 200 || res.Code >= 400 {
+		d.current.errors++
+	}
+	d.current.latencies = append(d.current.latencies, res.Latency)
+}
+
+// tick closes the current second, samples server memory and redraws
+func (d *liveDashboard) tick() {
+	d.mu.Lock()
+	last := d.current
+	d.current = liveSecond{}
+	d.window = append(d.window, last)
+	if len(d.window) > liveWindowSeconds {
+		d.window = d.window[1:]
+	}
+	var latencies []time.Duration
+	for _, s := range d.window {
+		latencies = append(latencies, s.latencies...)
+	}
+	d.mu.Unlock()
+
+	if d.proc != nil {
+		if memInfo, err := d.proc.MemoryInfo(); err == nil {
+			d.rss = append(d.rss, float64(memInfo.RSS)/(1024*1024))
+			if len(d.rss) > liveSparkWidth {
+				d.rss = d.rss[1:]
+			}
+		}
+	}
+
+	slices.Sort(latencies)
+	errorRate := 0.0
+	if last.requests > 0 {
+		errorRate = 100.0 * float64(last.errors) / float64(last.requests)
+	}
+
+	lines := []string{
+		fmt.Sprintf("  %s live [%s]", d.provider, time.Since(d.started).Round(time.Second)),
+		fmt.Sprintf("    RPS: %s   Errors: %s%%", report.Int(int64(last.requests)), report.Float(errorRate, 2)),
+		fmt.Sprintf("    P50: %s   P99: %s   (last %ds)", report.Duration(liveQuantile(latencies, 0.50)),
+			report.Duration(liveQuantile(latencies, 0.99)), len(d.window)),
+	}
+	if len(d.rss) > 0 {
+		lines = append(lines, fmt.Sprintf("    RSS: %s %s MB", sparkline(d.rss), report.Float(d.rss[len(d.rss)-1], 2)))
+	} else {
+		lines = append(lines, "    RSS: unavailable")
+	}
+	d.render(lines)
+}
+
+// render replaces the previous frame with the given lines
+func (d *liveDashboard) render(lines []string) {
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", d.lines)
+	}
+	for _, line := range lines {
+		b.WriteString("\033[2K")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	fmt.Print(b.String())
+	d.lines = len(lines)
+}
+
+// liveQuantile returns the q-quantile of sorted latencies, or 0 when there are none
+func liveQuantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// sparkline draws values as block characters scaled between their minimum and maximum
+func sparkline(values []float64) string {
+	lo, hi := slices.Min(values), slices.Max(values)
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,200 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// MatrixSpec lists the values of every dimension of a scenario matrix. Dimensions left out
+// take their value from the command line flags, or every provider for providers.
+type MatrixSpec struct {
+	Rates     []int    `json:"rates"`
+	Durations []int    `json:"durations"` // Seconds
+	Payloads  []string `json:"payloads"`  // small, big or a prompt size such as 10KB
+	Providers []string `json:"providers"`
+}
+
+// MatrixCell is one combination of the matrix
+type MatrixCell struct {
+	Rate     int    `json:"rate"`
+	Duration int    `json:"duration"`
+	Payload  string `json:"payload"`
+	Provider string `json:"provider"`
+}
+
+// Key identifies the combination in the matrix results file
+func (c MatrixCell) Key() string {
+	return fmt.Sprintf("rate=%d,duration=%d,payload=%s,provider=%s", c.Rate, c.Duration, c.Payload, c.Provider)
+}
+
+// MatrixResult is one combination with its benchmark summary
+type MatrixResult struct {
+	MatrixCell
+	Result resultfile.ProviderResult `json:"result"`
+}
+
+// LoadMatrixSpec reads a matrix from a JSON file, filling dimensions it leaves out
+func LoadMatrixSpec(path string, opts Scenario, bigPayload bool, providers []Target, route Route) (MatrixSpec, error) {
+	var spec MatrixSpec
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return spec, fmt.Errorf("failed to read matrix: %v", err)
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return spec, fmt.Errorf("failed to parse matrix: %v", err)
+	}
+
+	if len(spec.Payloads) > 0 && route.Name != ChatRoute {
+		return spec, fmt.Errorf("payloads only apply to the chat route")
+	}
+
+	if len(spec.Rates) == 0 {
+		spec.Rates = []int{opts.Rate}
+	}
+	if len(spec.Durations) == 0 {
+		spec.Durations = []int{opts.Duration}
+	}
+	if len(spec.Payloads) == 0 {
+		spec.Payloads = []string{"small"}
+		if bigPayload {
+			spec.Payloads = []string{"big"}
+		}
+	}
+	if len(spec.Providers) == 0 {
+		spec.Providers = TargetNames(providers)
+	}
+
+	for _, rate := range spec.Rates {
+		if rate <= 0 {
+			return spec, fmt.Errorf("rates must be positive, got %d", rate)
+		}
+	}
+	for _, duration := range spec.Durations {
+		if duration <= 0 {
+			return spec, fmt.Errorf("durations must be positive, got %d", duration)
+		}
+	}
+	for _, payload := range spec.Payloads {
+		if payload != "small" && payload != "big" {
+			if _, err := ParseByteSize(payload); err != nil {
+				return spec, fmt.Errorf("payload %q is not small, big, huge or a size: %v", payload, err)
+			}
+		}
+	}
+	for _, name := range spec.Providers {
+		if findProvider(providers, name) == nil {
+			return spec, fmt.Errorf("provider %q not found. Available providers: %v", name, TargetNames(providers))
+		}
+	}
+	return spec, nil
+}
+
+// findProvider returns the provider with the given name, ignoring case
+func findProvider(providers []Target, name string) *Target {
+	for i := range providers {
+		if strings.EqualFold(providers[i].Name, name) {
+			return &providers[i]
+		}
+	}
+	return nil
+}
+
+// Cells expands the matrix into every combination, with providers varying fastest so each
+// provider sees the same conditions back to back
+func (spec MatrixSpec) Cells() []MatrixCell {
+	var cells []MatrixCell
+	for _, rate := range spec.Rates {
+		for _, duration := range spec.Durations {
+			for _, payload := range spec.Payloads {
+				for _, provider := range spec.Providers {
+					cells = append(cells, MatrixCell{Rate: rate, Duration: duration, Payload: payload, Provider: strings.ToLower(provider)})
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// matrixPayload builds the chat completion payload for a payload dimension value
+func matrixPayload(payload string, model string) []byte {
+	switch payload {
+	case "small":
+		return ChatPayload(false, model)
+	case "big":
+		return ChatPayload(true, model)
+	}
+	size, _ := ParseByteSize(payload)
+	return SizedPayload(model, size)
+}
+
+// RunMatrix runs every combination of the matrix and writes the results keyed by combination
+func RunMatrix(providers []Target, spec MatrixSpec, model string, route Route, opts Scenario, outputFile string) {
+	cells := spec.Cells()
+	fmt.Printf("Scenario matrix: %d rates x %d durations x %d payloads x %d providers = %d runs\n",
+		len(spec.Rates), len(spec.Durations), len(spec.Payloads), len(spec.Providers), len(cells))
+
+	results := make([]MatrixResult, 0, len(cells))
+	for i, cell := range cells {
+		fmt.Printf("\nMatrix run %d/%d: %s\n", i+1, len(cells), cell.Key())
+
+		provider := *findProvider(providers, cell.Provider)
+		provider.Rate, provider.Duration = cell.Rate, cell.Duration
+		if route.Name == ChatRoute {
+			provider.Payload = matrixPayload(cell.Payload, model)
+		}
+
+		for _, res := range runBenchmarks(context.Background(), []Target{provider}, opts) {
+			results = append(results, MatrixResult{MatrixCell: cell, Result: serializeResult(res)})
+		}
+
+		// Save after every run so an interrupted matrix keeps what it finished
+		saveMatrix(spec, results, outputFile)
+
+		if i < len(cells)-1 && opts.Cooldown > 0 {
+			fmt.Printf("Cooling down for %d seconds...\n", opts.Cooldown)
+			time.Sleep(time.Duration(opts.Cooldown) * time.Second)
+		}
+	}
+
+	printMatrixTable(results)
+	fmt.Printf("Matrix results saved to %s\n", outputFile)
+}
+
+func printMatrixTable(results []MatrixResult) {
+	fmt.Printf("\nScenario Matrix:\n")
+	fmt.Printf("%-6s %-8s %-8s %-12s | %-14s %-10s %-14s %-14s %-12s\n",
+		"rate", "duration", "payload", "provider", "throughput/s", "success%", "p50", "p99", "peak mem MB")
+	for _, r := range results {
+		fmt.Printf("%-6d %-8d %-8s %-12s | %-14s %-10s %-14s %-14s %-12s\n", r.Rate, r.Duration, r.Payload, r.Provider,
+			report.Float(r.Result.ThroughputRPS, 2), report.Float(r.Result.SuccessRate, 2),
+			report.Duration(msDuration(r.Result.P50LatencyMs)), report.Duration(msDuration(r.Result.P99LatencyMs)),
+			report.Float(r.Result.ServerPeakMemoryMB, 2))
+	}
+}
+
+// saveMatrix writes the matrix dimensions and the results of every finished combination
+func saveMatrix(spec MatrixSpec, results []MatrixResult, outputFile string) {
+	byKey := make(map[string]MatrixResult, len(results))
+	for _, r := range results {
+		byKey[r.Key()] = r
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Dimensions MatrixSpec              `json:"dimensions"`
+		Results    map[string]MatrixResult `json:"results"`
+	}{spec, byKey}, "", "  ")
+	if err != nil {
+		log.Printf("Warning: Could not encode matrix results: %v", err)
+		return
+	}
+	if err := os.WriteFile(outputFile, data, 0644); err != nil {
+		log.Printf("Warning: Could not write matrix results: %v", err)
+	}
+}
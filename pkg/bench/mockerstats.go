@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fetchMockerRequests reads mocker_requests_total from the mocker's Prometheus metrics endpoint
+func fetchMockerRequests(mockerURL string, tlsConfig *tls.Config) (int64, error) {
+	client := http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := client.Get(strings.TrimRight(mockerURL, "/") + "/metrics")
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch mocker metrics: %v", err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "mocker_requests_total ")
+		if !ok {
+			continue
+		}
+		return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read mocker metrics: %v", err)
+	}
+	return 0, fmt.Errorf("mocker_requests_total not found in mocker metrics")
+}
+
+// requestAmplification is the number of upstream requests per request offered to the gateway,
+// where values above 1 indicate retries
+func requestAmplification(upstreamRequests int64, offered uint64) float64 {
+	if offered == 0 {
+		return 0
+	}
+	return float64(upstreamRequests) / float64(offered)
+}
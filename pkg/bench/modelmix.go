@@ -0,0 +1,56 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// weightedModel is one entry of a model mix
+type weightedModel struct {
+	Model  string
+	Weight float64
+}
+
+// ModelMix picks the model of each request by weight, e.g. to send realistic alias traffic
+type ModelMix []weightedModel
+
+// ParseModelMix parses a comma separated list of model=weight pairs (e.g. "fast=3,smart=1").
+// A missing weight defaults to 1.
+func ParseModelMix(s string) (ModelMix, error) {
+	var mix ModelMix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		model, weightStr, hasWeight := strings.Cut(part, "=")
+		weight := 1.0
+		if hasWeight {
+			w, err := strconv.ParseFloat(weightStr, 64)
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight for model %q: %q", model, weightStr)
+			}
+			weight = w
+		}
+		mix = append(mix, weightedModel{Model: model, Weight: weight})
+	}
+	return mix, nil
+}
+
+// Pick returns a model chosen at random according to the mix weights
+func (m ModelMix) Pick() string {
+	var total float64
+	for _, w := range m {
+		total += w.Weight
+	}
+	pick := rand.Float64() * total
+	for _, w := range m {
+		pick -= w.Weight
+		if pick < 0 {
+			return w.Model
+		}
+	}
+	return m[len(m)-1].Model
+}
@@ -0,0 +1,258 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+	"github.com/valyala/fasthttp"
+)
+
+// nativeBodies is how many requests are drawn from the targeter and serialized before the attack
+const nativeBodies = 1000
+
+// nativeEngine is an open-loop generator on fasthttp for rates vegeta can't reach. Requests are
+// drawn from the targeter and serialized up front, then sent from reused fasthttp requests, so
+// sending allocates little besides the result. Each worker, one per core by default, schedules
+// every workers-th request on its own client and connection pool, handing requests to sender
+// goroutines that are added whenever all of its senders are busy. Like vegeta, request seq is
+// scheduled seq+1 intervals after the attack starts and timestamped when it is actually sent.
+type nativeEngine struct {
+	workers    int
+	dial       fasthttp.DialFunc // nil for TCP
+	transport  *http.Transport
+	timeout    time.Duration // The runner client's, as fasthttp's read and write timeouts
+	runID      string
+	trace      bool
+	verifyBody bool
+	protocols  *protocolCounter
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// nativeRequest is a pre-serialized request, its headers in a template and its body as is
+type nativeRequest struct {
+	header fasthttp.RequestHeader
+	body   []byte
+	sent   sentBody // The body's checksum, for -verify-body
+}
+
+// newNativeEngine takes TLS, unix sockets and request IDs from the runner's client. arg is the
+// number of workers, the number of cores if empty.
+func newNativeEngine(client *http.Client, arg string) (Engine, error) {
+	workers := runtime.GOMAXPROCS(0)
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid worker count %q: use -engine native:N with N > 0", arg)
+		}
+		workers = n
+	}
+
+	traced, ok := client.Transport.(*traceTransport)
+	if !ok {
+		return nil, fmt.Errorf("the native engine needs the runner's HTTP client")
+	}
+	transport, ok := traced.next.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("the native engine only speaks HTTP/1.1")
+	}
+
+	e := &nativeEngine{
+		workers:    workers,
+		transport:  transport,
+		timeout:    client.Timeout,
+		runID:      traced.runID,
+		trace:      traced.trace,
+		verifyBody: traced.verifyBody,
+		protocols:  traced.protocols,
+		stop:       make(chan struct{}),
+	}
+	if dial := transport.DialContext; dial != nil {
+		e.dial = func(addr string) (net.Conn, error) { return dial(context.Background(), "tcp", addr) }
+	}
+	return e, nil
+}
+
+// Attack sends requests at the pacer's rate until du has passed. Only constant rates are
+// supported, as with -rate.
+func (e *nativeEngine) Attack(tr vegeta.Targeter, pacer vegeta.Pacer, du time.Duration, name string) <-chan *vegeta.Result {
+	// The runner expects the schedule to start now, so serializing requests counts as lag
+	began := time.Now()
+	results := make(chan *vegeta.Result, 1024)
+	rate, ok := pacer.(vegeta.Rate)
+	if !ok || rate.Freq <= 0 {
+		log.Printf("Warning: native attack for %s failed: the native engine only supports a constant rate", name)
+		close(results)
+		return results
+	}
+	requests, err := nativeRequests(tr)
+	if err != nil {
+		log.Printf("Warning: native attack for %s failed: %v", name, err)
+		close(results)
+		return results
+	}
+
+	interval := rate.Per / time.Duration(rate.Freq)
+	hits := uint64(du / interval)
+
+	var wg sync.WaitGroup
+	for w := 0; w < e.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.work(uint64(w), requests, began, interval, hits, name, results)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Stop ends the attack: no more requests are sent, those in flight still report their results
+func (e *nativeEngine) Stop() bool {
+	stopped := false
+	e.stopOnce.Do(func() {
+		close(e.stop)
+		stopped = true
+	})
+	return stopped
+}
+
+// nativeRequests draws and serializes the requests the attack cycles through
+func nativeRequests(tr vegeta.Targeter) ([]*nativeRequest, error) {
+	requests := make([]*nativeRequest, 0, nativeBodies)
+	for i := 0; i < nativeBodies; i++ {
+		var tgt vegeta.Target
+		if err := tr(&tgt); err != nil {
+			return nil, fmt.Errorf("failed to build request: %v", err)
+		}
+		req := &nativeRequest{body: tgt.Body, sent: newSentBody(tgt.Body)}
+		req.header.SetMethod(tgt.Method)
+		req.header.SetRequestURI(tgt.URL)
+		for key, values := range tgt.Header {
+			for _, value := range values {
+				req.header.Add(key, value)
+			}
+		}
+		requests = append(requests, req)
+	}
+	return requests, nil
+}
+
+// work schedules requests worker, worker+workers, ... on its own client
+func (e *nativeEngine) work(worker uint64, requests []*nativeRequest, began time.Time, interval time.Duration, hits uint64, name string, results chan<- *vegeta.Result) {
+	client := &fasthttp.Client{
+		Dial:                          e.dial,
+		TLSConfig:                     e.transport.TLSClientConfig,
+		MaxConnsPerHost:               100000,
+		MaxIdleConnDuration:           e.transport.IdleConnTimeout,
+		ReadTimeout:                   e.timeout,
+		WriteTimeout:                  e.timeout,
+		NoDefaultUserAgentHeader:      true,
+		DisableHeaderNamesNormalizing: true,
+		DisablePathNormalizing:        true,
+	}
+
+	ticks := make(chan uint64)
+	var senders sync.WaitGroup
+	defer func() {
+		close(ticks)
+		senders.Wait()
+	}()
+
+	step := uint64(e.workers)
+	for seq := worker; seq < hits; seq += step {
+		timer := time.NewTimer(time.Until(began.Add(time.Duration(seq+1) * interval)))
+		select {
+		case <-e.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		select {
+		case ticks <- seq:
+		default:
+			// Every sender is waiting on a response, so add one rather than send late
+			senders.Add(1)
+			go func() {
+				defer senders.Done()
+				e.send(client, requests, ticks, name, results)
+			}()
+			ticks <- seq
+		}
+	}
+}
+
+// send sends the requests scheduled on ticks, reusing one fasthttp request and response
+func (e *nativeEngine) send(client *fasthttp.Client, requests []*nativeRequest, ticks <-chan uint64, name string, results chan<- *vegeta.Result) {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	var responses int64
+	defer func() {
+		if responses > 0 && e.protocols != nil {
+			e.protocols.addN("HTTP/1.1", responses)
+		}
+	}()
+
+	for seq := range ticks {
+		tmpl := requests[seq%uint64(len(requests))]
+		tmpl.header.CopyTo(&req.Header)
+		req.SetRequestURIBytes(tmpl.header.RequestURI())
+		req.SetBodyRaw(tmpl.body)
+		id := traceID(e.runID, name, seq)
+		req.Header.Set(requestIDHeader, id)
+		if e.trace {
+			req.Header.Set(traceHeader, id)
+		}
+
+		res := &vegeta.Result{
+			Attack:   name,
+			Seq:      seq,
+			Method:   string(tmpl.header.Method()),
+			URL:      string(tmpl.header.RequestURI()),
+			BytesOut: uint64(len(tmpl.body)),
+		}
+		res.Timestamp = time.Now()
+		err := client.Do(req, resp)
+		res.Latency = time.Since(res.Timestamp)
+		if err != nil {
+			res.Error = err.Error()
+			results <- res
+			continue
+		}
+		responses++
+
+		res.Code = uint16(resp.StatusCode())
+		res.Body = append([]byte(nil), resp.Body()...)
+		res.BytesIn = uint64(len(res.Body))
+		if res.Code < 200 || res.Code >= 400 {
+			res.Error = fmt.Sprintf("%d %s", res.Code, http.StatusText(int(res.Code))) // As net/http's Status
+		}
+		// Server-Timing and the body check are the only response headers the runner reads
+		if timing := resp.Header.Peek("Server-Timing"); len(timing) > 0 {
+			res.Headers = http.Header{"Server-Timing": {string(timing)}}
+		}
+		if e.verifyBody {
+			if res.Headers == nil {
+				res.Headers = http.Header{}
+			}
+			res.Headers.Set(bodyCheckHeader, tmpl.sent.check(func(key string) string { return string(resp.Header.Peek(key)) }))
+		}
+		results <- res
+	}
+}
@@ -0,0 +1,201 @@
+package bench
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promptFiller is repeated to pad prompts up to the requested size
+const promptFiller = "Please provide a comprehensive analysis of proxy gateways for AI, including architecture, " +
+	"load balancing, request routing, caching, rate limiting and their trade-offs. "
+
+// PayloadSizePoint is one provider's results at one prompt size
+type PayloadSizePoint struct {
+	Provider      string
+	SizeBytes     int
+	ThroughputRPS float64
+	SuccessRate   float64
+	MeanLatencyMs float64
+	P50LatencyMs  float64
+	P99LatencyMs  float64
+	PeakMemoryMB  float64
+}
+
+// HugePayloadSize is the prompt size named "huge", large enough to exceed fasthttp's default 4MB
+// body limit and exercise a gateway's request body streaming
+const HugePayloadSize = 8 * 1024 * 1024
+
+// ParseByteSize parses sizes such as 512, 10KB or 1MB (binary units), or huge for HugePayloadSize
+func ParseByteSize(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "HUGE" {
+		return HugePayloadSize, nil
+	}
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "MB"):
+		multiplier, s = 1024*1024, strings.TrimSuffix(s, "MB")
+	case strings.HasSuffix(s, "KB"):
+		multiplier, s = 1024, strings.TrimSuffix(s, "KB")
+	case strings.HasSuffix(s, "B"):
+		s = strings.TrimSuffix(s, "B")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// ParseByteSizes parses a comma separated list of sizes
+func ParseByteSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		size, err := ParseByteSize(part)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return sizes, nil
+}
+
+// formatByteSize prints a size the way it is usually written on the command line
+func formatByteSize(n int) string {
+	switch {
+	case n >= 1024*1024 && n%(1024*1024) == 0:
+		return fmt.Sprintf("%dMB", n/(1024*1024))
+	case n >= 1024 && n%1024 == 0:
+		return fmt.Sprintf("%dKB", n/1024)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// SizedPayload builds a chat completion payload whose prompt is size bytes long
+func SizedPayload(model string, size int) []byte {
+	header := "This is a benchmark request #{request_index} at #{timestamp}. "
+	prompt := header
+	if len(prompt) < size {
+		prompt += strings.Repeat(promptFiller, (size-len(prompt))/len(promptFiller)+1)
+	}
+	prompt = prompt[:max(size, len(header))]
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"messages": []map[string]string{
+			{"role": "user", "content": prompt},
+		},
+		"model": "openai/" + model,
+	})
+	return payload
+}
+
+// RunPayloadSweep runs the same scenario against every provider at each prompt size and
+// prints a size vs latency/throughput table per provider
+func RunPayloadSweep(providers []Target, sizes []int, model string, opts Scenario, outputFile string) []PayloadSizePoint {
+	var points []PayloadSizePoint
+	for i, size := range sizes {
+		fmt.Printf("\nPayload size %s (%d/%d)\n", formatByteSize(size), i+1, len(sizes))
+
+		payload := SizedPayload(model, size)
+		for j := range providers {
+			providers[j].Payload = payload
+		}
+
+		for _, res := range runBenchmarks(context.Background(), providers, opts) {
+			var peakMem uint64
+			for _, stat := range res.ServerMemoryStats {
+				peakMem = max(peakMem, stat.RSS)
+			}
+			points = append(points, PayloadSizePoint{
+				Provider:      strings.ToLower(res.ProviderName),
+				SizeBytes:     size,
+				ThroughputRPS: res.Metrics.Throughput,
+				SuccessRate:   100.0 * res.Metrics.Success,
+				MeanLatencyMs: float64(res.Metrics.Latencies.Mean) / float64(time.Millisecond),
+				P50LatencyMs:  float64(res.Metrics.Latencies.P50) / float64(time.Millisecond),
+				P99LatencyMs:  float64(res.Metrics.Latencies.P99) / float64(time.Millisecond),
+				PeakMemoryMB:  float64(peakMem) / (1024 * 1024),
+			})
+		}
+
+		if i < len(sizes)-1 && opts.Cooldown > 0 {
+			fmt.Printf("Cooling down for %d seconds...\n", opts.Cooldown)
+			time.Sleep(time.Duration(opts.Cooldown) * time.Second)
+		}
+	}
+
+	printPayloadSweepTable(providers, points)
+	if outputFile != "" {
+		savePayloadSweep(points, outputFile)
+	}
+	return points
+}
+
+func printPayloadSweepTable(providers []Target, points []PayloadSizePoint) {
+	for _, p := range providers {
+		name := strings.ToLower(p.Name)
+		fmt.Printf("\nPayload Size Table for %s:\n", p.Name)
+		fmt.Printf("%-8s | %-14s %-10s %-14s %-14s %-14s %-12s\n",
+			"size", "throughput/s", "success%", "mean", "p50", "p99", "peak mem MB")
+		for _, point := range points {
+			if point.Provider != name {
+				continue
+			}
+			fmt.Printf("%-8s | %-14s %-10s %-14s %-14s %-14s %-12s\n", formatByteSize(point.SizeBytes),
+				report.Float(point.ThroughputRPS, 2), report.Float(point.SuccessRate, 2),
+				report.Duration(msDuration(point.MeanLatencyMs)), report.Duration(msDuration(point.P50LatencyMs)),
+				report.Duration(msDuration(point.P99LatencyMs)), report.Float(point.PeakMemoryMB, 2))
+		}
+	}
+}
+
+func savePayloadSweep(points []PayloadSizePoint, outputFile string) {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		log.Printf("Warning: Could not create payload sweep file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"provider", "size_bytes", "throughput_rps", "success_rate", "mean_latency_ms", "p50_latency_ms",
+		"p99_latency_ms", "peak_memory_mb"})
+	for _, p := range points {
+		w.Write([]string{
+			p.Provider,
+			strconv.Itoa(p.SizeBytes),
+			strconv.FormatFloat(p.ThroughputRPS, 'f', 2, 64),
+			strconv.FormatFloat(p.SuccessRate, 'f', 2, 64),
+			strconv.FormatFloat(p.MeanLatencyMs, 'f', 3, 64),
+			strconv.FormatFloat(p.P50LatencyMs, 'f', 3, 64),
+			strconv.FormatFloat(p.P99LatencyMs, 'f', 3, 64),
+			strconv.FormatFloat(p.PeakMemoryMB, 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Warning: Could not write payload sweep file: %v", err)
+		return
+	}
+
+	fmt.Printf("Payload sweep results saved to %s\n", outputFile)
+}
+
+// msDuration converts milliseconds back to a duration for report formatting
+func msDuration(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
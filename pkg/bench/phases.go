@@ -0,0 +1,180 @@
+package bench
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// phaseSampler traces a sample of requests with httptrace, timing DNS, connect, TLS, the wait
+// for the first response byte and the body read. Sampled requests go through the same
+// connection pool as the rest, so connection setup shows up only as often as the pool really
+// dials: a gateway that is slow to answer looks different from one whose connections are slow
+// to set up. Only net/http's HTTP/1.1 and HTTP/2 transports report these phases.
+type phaseSampler struct {
+	rate  float64
+	every uint64 // Every n-th request is traced
+
+	mu                            sync.Mutex
+	sampled, newConnections       int
+	dns, connect, tls, ttfb, body []time.Duration
+}
+
+// newPhaseSampler traces a fraction rate of requests, nil when rate is 0
+func newPhaseSampler(rate float64) *phaseSampler {
+	if rate <= 0 {
+		return nil
+	}
+	return &phaseSampler{rate: rate, every: uint64(max(math.Round(1/rate), 1))}
+}
+
+// phaseRecord collects one traced request's timestamps
+type phaseRecord struct {
+	sampler                    *phaseSampler
+	reused                     bool
+	dnsStart, dnsDone          time.Time
+	connectStart, connectDone  time.Time
+	tlsStart, tlsDone          time.Time
+	wrote, firstByte, bodyDone time.Time
+	once                       sync.Once
+}
+
+// Trace returns req with a client trace attached if seq is sampled, and the record it fills in
+func (s *phaseSampler) Trace(req *http.Request, seq uint64) (*http.Request, *phaseRecord) {
+	if s == nil || seq%s.every != 0 {
+		return req, nil
+	}
+	r := &phaseRecord{sampler: s}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { r.dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { r.dnsDone = time.Now() },
+		ConnectStart: func(string, string) {
+			// Dialing several addresses counts from the first attempt
+			if r.connectStart.IsZero() {
+				r.connectStart = time.Now()
+			}
+		},
+		ConnectDone:          func(string, string, error) { r.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { r.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { r.tlsDone = time.Now() },
+		GotConn:              func(info httptrace.GotConnInfo) { r.reused = info.Reused },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { r.wrote = time.Now() },
+		GotFirstResponseByte: func() { r.firstByte = time.Now() },
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), r
+}
+
+// Wrap times the read of the response body, adding the record once it is read or closed
+func (r *phaseRecord) Wrap(resp *http.Response) {
+	resp.Body = &phaseBody{ReadCloser: resp.Body, record: r}
+}
+
+// done adds the record to the sampler; the body is over
+func (r *phaseRecord) done() {
+	r.once.Do(func() {
+		r.bodyDone = time.Now()
+		r.sampler.add(r)
+	})
+}
+
+// phaseBody marks the end of the body read on EOF or close
+type phaseBody struct {
+	io.ReadCloser
+	record *phaseRecord
+}
+
+func (b *phaseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.record.done()
+	}
+	return n, err
+}
+
+func (b *phaseBody) Close() error {
+	b.record.done()
+	return b.ReadCloser.Close()
+}
+
+func (s *phaseSampler) add(r *phaseRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sampled++
+	if !r.reused {
+		s.newConnections++
+	}
+	if !r.dnsStart.IsZero() && !r.dnsDone.IsZero() {
+		s.dns = append(s.dns, r.dnsDone.Sub(r.dnsStart))
+	}
+	if !r.connectStart.IsZero() && !r.connectDone.IsZero() {
+		s.connect = append(s.connect, r.connectDone.Sub(r.connectStart))
+	}
+	if !r.tlsStart.IsZero() && !r.tlsDone.IsZero() {
+		s.tls = append(s.tls, r.tlsDone.Sub(r.tlsStart))
+	}
+	if !r.wrote.IsZero() && !r.firstByte.IsZero() {
+		s.ttfb = append(s.ttfb, r.firstByte.Sub(r.wrote))
+		s.body = append(s.body, r.bodyDone.Sub(r.firstByte))
+	}
+}
+
+// Summary returns the phase latencies, nil without sampling
+func (s *phaseSampler) Summary() *resultfile.ConnectionPhases {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &resultfile.ConnectionPhases{
+		SampleRate:     s.rate,
+		Sampled:        s.sampled,
+		NewConnections: s.newConnections,
+		DNS:            phaseLatency(s.dns),
+		Connect:        phaseLatency(s.connect),
+		TLS:            phaseLatency(s.tls),
+		TTFB:           phaseLatency(s.ttfb),
+		BodyRead:       phaseLatency(s.body),
+	}
+}
+
+// phaseLatency summarizes one phase, nil if no sampled request went through it
+func phaseLatency(durations []time.Duration) *resultfile.PhaseLatency {
+	if len(durations) == 0 {
+		return nil
+	}
+	summary := summarizeLatencies(durations)
+	return &resultfile.PhaseLatency{
+		Count:  len(durations),
+		MeanMs: float64(summary.Mean) / float64(time.Millisecond),
+		P50Ms:  float64(summary.P50) / float64(time.Millisecond),
+		P99Ms:  float64(summary.P99) / float64(time.Millisecond),
+		MaxMs:  float64(summary.Max) / float64(time.Millisecond),
+	}
+}
+
+// printConnectionPhases prints the mean and P99 of every phase a sampled request went through
+func printConnectionPhases(p *resultfile.ConnectionPhases) {
+	if p.Sampled == 0 {
+		fmt.Println("  Connection Phases: no request was sampled")
+		return
+	}
+	fmt.Printf("  Connection Phases: %s sampled requests, %s on new connections\n", report.Int(int64(p.Sampled)), report.Int(int64(p.NewConnections)))
+	ms := func(v float64) string { return report.Duration(msDuration(v)) }
+	for _, phase := range []struct {
+		name    string
+		latency *resultfile.PhaseLatency
+	}{{"DNS", p.DNS}, {"Connect", p.Connect}, {"TLS Handshake", p.TLS}, {"TTFB", p.TTFB}, {"Body Read", p.BodyRead}} {
+		if phase.latency == nil {
+			continue
+		}
+		fmt.Printf("    %s: mean %s, P99 %s (%s requests)\n", phase.name, ms(phase.latency.MeanMs), ms(phase.latency.P99Ms), report.Int(int64(phase.latency.Count)))
+	}
+}
@@ -0,0 +1,196 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+const (
+	portSampleInterval = time.Second
+	// Warn once TIME_WAIT and ESTABLISHED sockets use this percent of the ephemeral port range
+	portUsageWarnPercent = 80
+	// Dial error Linux reports when no ephemeral port is left for a destination
+	portExhaustedError = "cannot assign requested address"
+)
+
+// portSample is one reading of the load host's TCP socket counts
+type portSample struct {
+	TimeWait    int
+	Established int
+}
+
+// portMonitor samples the load generator host's TIME_WAIT and ESTABLISHED socket counts during
+// an attack. Every new connection to a target takes an ephemeral port that stays in TIME_WAIT
+// for a minute after it closes, so at high rates without keep-alive the range runs out and
+// requests fail with errors the gateway never saw.
+type portMonitor struct {
+	ephemeralPorts int
+
+	mu        sync.Mutex
+	samples   []portSample
+	exhausted int // Requests that failed because no ephemeral port was free
+}
+
+// newPortMonitor returns nil when the host's socket counts can't be read, e.g. off Linux
+func newPortMonitor() *portMonitor {
+	if _, err := readPortSample(); err != nil {
+		return nil
+	}
+	ports, err := ephemeralPortRange()
+	if err != nil {
+		return nil
+	}
+	return &portMonitor{ephemeralPorts: ports}
+}
+
+// Run samples until stop is closed
+func (m *portMonitor) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(portSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sample, err := readPortSample()
+			if err != nil {
+				continue
+			}
+			m.mu.Lock()
+			m.samples = append(m.samples, sample)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// Observe counts requests that failed for lack of an ephemeral port
+func (m *portMonitor) Observe(errMsg string) {
+	if errMsg == "" || !strings.Contains(errMsg, portExhaustedError) {
+		return
+	}
+	m.mu.Lock()
+	m.exhausted++
+	m.mu.Unlock()
+}
+
+// Usage summarizes the run's socket counts, nil for a nil monitor
+func (m *portMonitor) Usage() *resultfile.SocketUsage {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	usage := &resultfile.SocketUsage{EphemeralPorts: m.ephemeralPorts, PortErrors: m.exhausted}
+	for _, s := range m.samples {
+		usage.PeakTimeWait = max(usage.PeakTimeWait, s.TimeWait)
+		usage.PeakEstablished = max(usage.PeakEstablished, s.Established)
+		if m.ephemeralPorts > 0 {
+			used := 100 * float64(s.TimeWait+s.Established) / float64(m.ephemeralPorts)
+			usage.PeakPortUsage = max(usage.PeakPortUsage, used)
+		}
+	}
+	return usage
+}
+
+// portWarnings reports ephemeral port exhaustion, or a run that came close to it
+func portWarnings(usage *resultfile.SocketUsage) []string {
+	if usage == nil {
+		return nil
+	}
+	var warnings []string
+	if usage.PortErrors > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d requests failed because the load host ran out of ephemeral ports; these failures say nothing about the target", usage.PortErrors))
+	}
+	if usage.PeakPortUsage >= portUsageWarnPercent {
+		warnings = append(warnings, fmt.Sprintf("load host sockets peaked at %d TIME_WAIT and %d ESTABLISHED, %.0f%% of its %d ephemeral ports; enable keep-alive, widen net.ipv4.ip_local_port_range or lower the rate",
+			usage.PeakTimeWait, usage.PeakEstablished, usage.PeakPortUsage, usage.EphemeralPorts))
+	}
+	return warnings
+}
+
+// readPortSample reads the host-wide TIME_WAIT count from /proc/net/sockstat and the
+// ESTABLISHED (and CLOSE_WAIT) count from /proc/net/snmp, both far cheaper than listing sockets
+func readPortSample() (portSample, error) {
+	var sample portSample
+
+	timeWait, err := procField("/proc/net/sockstat", "TCP:", "tw")
+	if err != nil {
+		return sample, err
+	}
+	established, err := procField("/proc/net/snmp", "Tcp:", "CurrEstab")
+	if err != nil {
+		return sample, err
+	}
+	sample.TimeWait, sample.Established = timeWait, established
+	return sample, nil
+}
+
+// procField finds a named counter on the lines starting with prefix. /proc/net/sockstat pairs
+// names and values on one line, /proc/net/snmp puts a header line before the values line.
+func procField(path string, prefix string, name string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var header []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != prefix {
+			continue
+		}
+		fields = fields[1:]
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == name {
+				if value, err := strconv.Atoi(fields[i+1]); err == nil {
+					return value, nil
+				}
+			}
+		}
+		if header == nil {
+			header = fields
+			continue
+		}
+		for i, field := range header {
+			if field == name && i < len(fields) {
+				return strconv.Atoi(fields[i])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s not found in %s", name, path)
+}
+
+// ephemeralPortRange returns the number of local ports available for outgoing connections
+func ephemeralPortRange() (int, error) {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("unexpected ip_local_port_range %q", strings.TrimSpace(string(data)))
+	}
+	low, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, err
+	}
+	high, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, err
+	}
+	return high - low + 1, nil
+}
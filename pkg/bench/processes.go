@@ -0,0 +1,184 @@
+package bench
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// processGroup is every process serving a target. Gateways run with several workers, like
+// Bifrost's -workers, have one listener per process, so their memory, CPU and descriptors are
+// the sums over the group. Processes that exit during the run are left out of the sums.
+type processGroup struct {
+	procs []*process.Process
+}
+
+// newProcessGroup opens the processes with the given PIDs, skipping duplicates and those
+// that are gone. what describes where they were found, for the log.
+func newProcessGroup(pids []int32, what string) (*processGroup, error) {
+	group := &processGroup{}
+	seen := make(map[int32]bool)
+	for _, pid := range pids {
+		if pid <= 0 || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		cmdline, _ := p.Cmdline()
+		fmt.Printf("Found process on %s: PID=%d, Cmdline=%s\n", what, pid, cmdline)
+		group.procs = append(group.procs, p)
+	}
+	if len(group.procs) == 0 {
+		return nil, fmt.Errorf("no process found listening on %s", what)
+	}
+	if len(group.procs) > 1 {
+		fmt.Printf("Monitoring the %d processes on %s together\n", len(group.procs), what)
+	}
+	return group, nil
+}
+
+// each calls sample for every process, failing only when it fails for all of them
+func (g *processGroup) each(sample func(p *process.Process) error) error {
+	var firstErr error
+	ok := false
+	for _, p := range g.procs {
+		if err := sample(p); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		ok = true
+	}
+	if !ok {
+		return firstErr
+	}
+	return nil
+}
+
+// MemoryInfo sums the RSS and VMS of the group
+func (g *processGroup) MemoryInfo() (*process.MemoryInfoStat, error) {
+	total := &process.MemoryInfoStat{}
+	err := g.each(func(p *process.Process) error {
+		memInfo, err := p.MemoryInfo()
+		if err == nil {
+			total.RSS += memInfo.RSS
+			total.VMS += memInfo.VMS
+		}
+		return err
+	})
+	return total, err
+}
+
+// MemoryPercent sums the share of system memory used by the group
+func (g *processGroup) MemoryPercent() (float32, error) {
+	var total float32
+	err := g.each(func(p *process.Process) error {
+		percent, err := p.MemoryPercent()
+		total += percent
+		return err
+	})
+	return total, err
+}
+
+// NumFDs sums the open file descriptors of the group
+func (g *processGroup) NumFDs() (int32, error) {
+	var total int32
+	err := g.each(func(p *process.Process) error {
+		fds, err := p.NumFDs()
+		total += fds
+		return err
+	})
+	return total, err
+}
+
+// NumThreads sums the threads of the group
+func (g *processGroup) NumThreads() (int32, error) {
+	var total int32
+	err := g.each(func(p *process.Process) error {
+		threads, err := p.NumThreads()
+		total += threads
+		return err
+	})
+	return total, err
+}
+
+// Percent returns the group's CPU usage over interval, where 100 is one core
+func (g *processGroup) Percent(interval time.Duration) (float64, error) {
+	before, err := g.cpuSeconds()
+	if err != nil {
+		return 0, err
+	}
+	started := time.Now()
+	time.Sleep(interval)
+	after, err := g.cpuSeconds()
+	if err != nil {
+		return 0, err
+	}
+	return 100 * max(after-before, 0) / time.Since(started).Seconds(), nil
+}
+
+// cpuSeconds sums the user and system CPU time of the group
+func (g *processGroup) cpuSeconds() (float64, error) {
+	var total float64
+	err := g.each(func(p *process.Process) error {
+		times, err := p.Times()
+		if err == nil {
+			total += times.User + times.System
+		}
+		return err
+	})
+	return total, err
+}
+
+// listeningRelatives returns the relatives of pid (its parent, siblings and children) with a
+// connection of the given kind that matches. gopsutil reports identical listeners once, so of
+// workers sharing a port with SO_REUSEPORT it finds only one. They are usually started by one
+// supervisor, like Bifrost's -workers, nginx or gunicorn.
+func listeningRelatives(pid int32, kind string, match func(psnet.ConnectionStat) bool) []int32 {
+	self, err := process.NewProcess(pid)
+	if err != nil {
+		return nil
+	}
+	parent, err := self.Ppid()
+	if err != nil {
+		return nil
+	}
+	pids, err := process.Pids()
+	if err != nil {
+		return nil
+	}
+
+	var relatives []int32
+	for _, candidate := range pids {
+		if candidate == pid {
+			continue
+		}
+		// Everything descends from init, so it doesn't make processes related
+		related := candidate == parent && parent > 1
+		if !related {
+			p, err := process.NewProcess(candidate)
+			if err != nil {
+				continue
+			}
+			ppid, err := p.Ppid()
+			if err != nil {
+				continue
+			}
+			related = ppid == pid || (ppid == parent && parent > 1)
+		}
+		if !related {
+			continue
+		}
+		if conns, err := psnet.ConnectionsPidWithoutUids(kind, candidate); err == nil && slices.ContainsFunc(conns, match) {
+			relatives = append(relatives, candidate)
+		}
+	}
+	return relatives
+}
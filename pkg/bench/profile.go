@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// DefaultProfileSeconds is how long the CPU profile of -profile samples
+const DefaultProfileSeconds = 30
+
+// profileCapture fetches a CPU and a heap profile from a target's pprof endpoint while it is
+// under load. The CPU profile is centered on the middle of the attack, and the heap profile is
+// taken right after it, so both show the steady state rather than warm-up or drain.
+type profileCapture struct {
+	done    chan struct{}
+	result  *resultfile.Profiles
+	errs    []error
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// startProfileCapture schedules the capture for an attack of duration starting now. The files
+// are written to dir as <provider>-<runID>-cpu.pprof and -heap.pprof.
+func startProfileCapture(pprofURL string, provider string, runID string, dir string, seconds int, duration time.Duration) *profileCapture {
+	c := &profileCapture{done: make(chan struct{}), stopped: make(chan struct{})}
+
+	// Attacks shorter than the profile get profiled for their middle half
+	length := min(time.Duration(seconds)*time.Second, duration/2)
+	length = max(length.Truncate(time.Second), time.Second)
+	delay := max((duration-length)/2, 0)
+	base := strings.TrimSuffix(pprofURL, "/")
+	prefix := filepath.Join(dir, fmt.Sprintf("%s-%s", strings.ToLower(provider), runID))
+
+	go func() {
+		defer close(c.done)
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-c.stopped:
+			return
+		case <-timer.C:
+		}
+
+		profiles := &resultfile.Profiles{CPUSeconds: int(length.Seconds())}
+		cpuURL := fmt.Sprintf("%s/profile?seconds=%d", base, profiles.CPUSeconds)
+		if err := fetchProfile(cpuURL, prefix+"-cpu.pprof", length+30*time.Second); err != nil {
+			c.errs = append(c.errs, fmt.Errorf("CPU profile: %v", err))
+		} else {
+			profiles.CPU = prefix + "-cpu.pprof"
+		}
+		if err := fetchProfile(base+"/heap", prefix+"-heap.pprof", 30*time.Second); err != nil {
+			c.errs = append(c.errs, fmt.Errorf("heap profile: %v", err))
+		} else {
+			profiles.Heap = prefix + "-heap.pprof"
+		}
+		if profiles.CPU != "" || profiles.Heap != "" {
+			c.result = profiles
+		}
+	}()
+	return c
+}
+
+// Wait returns the saved profiles once the capture is over, cancelling it if it hasn't started.
+// An attack that ended early, e.g. by timing out, may not have been profiled at all.
+func (c *profileCapture) Wait() (*resultfile.Profiles, []error) {
+	c.once.Do(func() { close(c.stopped) })
+	<-c.done
+	return c.result, c.errs
+}
+
+// formatProfiles lists the saved profiles, e.g. "CPU (30s) profiles/bifrost-x-cpu.pprof, heap ..."
+func formatProfiles(p *resultfile.Profiles) string {
+	var parts []string
+	if p.CPU != "" {
+		parts = append(parts, fmt.Sprintf("CPU (%ds) %s", p.CPUSeconds, p.CPU))
+	}
+	if p.Heap != "" {
+		parts = append(parts, "heap "+p.Heap)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// fetchProfile downloads one pprof profile to path
+func fetchProfile(url string, path string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
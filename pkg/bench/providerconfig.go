@@ -0,0 +1,203 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// bifrostVirtualKey is sent to every built-in provider so Bifrost virtual key lookups are exercised
+const bifrostVirtualKey = "f452b625-a65e-4dfd-b48d-0ee3ba0e8d46"
+
+// ProviderConfig declares how to reach a gateway, so new gateways can be added without code changes
+type ProviderConfig struct {
+	Name    string                 `json:"name"`
+	PortEnv string                 `json:"port_env"` // Environment variable holding the local port
+	Port    string                 `json:"port"`     // Local port, for providers whose port is known up front
+	URL     string                 `json:"url"`      // Full endpoint URL, overrides the default localhost chat completions URL, or unix:///path/to.sock
+	Path    string                 `json:"path"`     // Endpoint path on localhost, defaults to /{suffix}/chat/completions
+	Routes  map[string]string      `json:"routes"`   // Path or full URL per route name, for routes other gateways serve elsewhere
+	Headers map[string]string      `json:"headers"`  // ${VAR} references are expanded from the environment
+	Auth    *AuthConfig            `json:"auth"`
+	Body    map[string]interface{} `json:"body"` // Fields set on every request body, e.g. {"metadata": {...}}
+
+	// Per-provider load, overriding -rate and -duration for gateways that can't sustain the global rate
+	Rate     int `json:"rate"`
+	Duration int `json:"duration"` // Seconds
+
+	Monitor  string `json:"monitor"`  // process (default) or docker:<container> for gateways running in Docker
+	Protocol string `json:"protocol"` // h1 (default) or h3 for HTTP/3 over QUIC, which needs an https url
+	Pprof    string `json:"pprof"`    // pprof base URL of Go gateways, e.g. http://localhost:${BIFROST_PORT}/debug/pprof, for -profile
+
+	// JSON metrics endpoint with goroutines and runtime fields, like the Bifrost gateway's /metrics.
+	// Goroutines aren't sampled for providers without one.
+	MetricsURL string `json:"metrics_url"`
+
+	Client *ClientConfig `json:"client"` // HTTP client tuning for this provider, DefaultClientSettings if unset
+	Cost   *CostConfig   `json:"cost"`   // What running the gateway costs, for price-performance scoring
+}
+
+// AuthConfig describes how a gateway expects its credential
+type AuthConfig struct {
+	Scheme string `json:"scheme"` // bearer (Authorization: Bearer) or header (raw value in Header)
+	Header string `json:"header"`
+	Env    string `json:"env"` // Environment variable holding the credential
+}
+
+// defaultProviderConfigs are the gateways benchmarked when no -providers-config is given
+var defaultProviderConfigs = []ProviderConfig{
+	{Name: "Bifrost", PortEnv: "BIFROST_PORT", Headers: map[string]string{"x-bf-vk": bifrostVirtualKey}, Pprof: "http://localhost:${BIFROST_PORT}/debug/pprof", MetricsURL: "http://localhost:${BIFROST_PORT}/metrics"},
+	{Name: "Litellm", PortEnv: "LITELLM_PORT", Headers: map[string]string{"x-bf-vk": bifrostVirtualKey}},
+	{Name: "Helicone", PortEnv: "HELICONE_PORT", Headers: map[string]string{"x-bf-vk": bifrostVirtualKey}},
+}
+
+// loadProviderConfigs reads provider definitions from a JSON file, or returns the defaults when path is empty
+func loadProviderConfigs(path string) ([]ProviderConfig, error) {
+	if path == "" {
+		return defaultProviderConfigs, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read providers config: %v", err)
+	}
+
+	var configs []ProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse providers config: %v", err)
+	}
+
+	for i, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("provider %d has no name", i)
+		}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+// validate checks a named provider's fields
+func (c ProviderConfig) validate() error {
+	if c.URL == "" && c.PortEnv == "" && c.Port == "" {
+		return fmt.Errorf("provider %s needs a url, port or port_env", c.Name)
+	}
+	if c.Rate < 0 || c.Duration < 0 {
+		return fmt.Errorf("provider %s: rate and duration must not be negative", c.Name)
+	}
+	if _, err := c.Client.Settings(); err != nil {
+		return fmt.Errorf("provider %s: client: %v", c.Name, err)
+	}
+	if c.Cost != nil {
+		if err := c.Cost.Validate(); err != nil {
+			return fmt.Errorf("provider %s: cost: %v", c.Name, err)
+		}
+	}
+	if _, err := ParseMonitor(c.Monitor); err != nil {
+		return fmt.Errorf("provider %s: %v", c.Name, err)
+	}
+	if c.Auth != nil {
+		switch c.Auth.Scheme {
+		case "bearer":
+		case "header":
+			if c.Auth.Header == "" {
+				return fmt.Errorf("provider %s: header auth needs a header name", c.Name)
+			}
+		default:
+			return fmt.Errorf("provider %s: unknown auth scheme %q (use bearer or header)", c.Name, c.Auth.Scheme)
+		}
+		if c.Auth.Env == "" {
+			return fmt.Errorf("provider %s: auth needs an env variable", c.Name)
+		}
+	}
+	return nil
+}
+
+// localPort returns the provider's local port, from port_env or port, "" when it has neither
+func (c ProviderConfig) localPort() string {
+	if c.PortEnv != "" {
+		return os.Getenv(c.PortEnv)
+	}
+	return c.Port
+}
+
+// Socket returns the unix domain socket the provider is served on, or "" for network providers
+func (c ProviderConfig) Socket() string {
+	return unixSocketPath(os.ExpandEnv(c.URL))
+}
+
+// Endpoint returns this provider's URL for a route. The url and path fields apply to chat
+// completions; other routes use the provider's routes entry or the route's default path.
+// Providers on a unix socket get a localhost URL, the connection itself goes to the socket.
+func (c ProviderConfig) Endpoint(route Route, suffix string, host string) (string, error) {
+	path := route.PathFor(suffix)
+	if override, ok := c.Routes[route.Name]; ok {
+		override = os.ExpandEnv(strings.ReplaceAll(override, "{suffix}", suffix))
+		if strings.HasPrefix(override, "http://") || strings.HasPrefix(override, "https://") {
+			return override, nil
+		}
+		path = override
+	} else if route.Name == ChatRoute {
+		if c.URL != "" && c.Socket() == "" {
+			return os.ExpandEnv(c.URL), nil
+		}
+		if c.Path != "" {
+			path = c.Path
+		}
+	}
+
+	if c.Socket() != "" {
+		return "http://localhost" + path, nil
+	}
+	if c.PortEnv == "" && c.Port == "" {
+		return "", fmt.Errorf("no url for route %s, add it to the provider's routes", route.Name)
+	}
+	return localURL(host, c.localPort(), path), nil
+}
+
+// RequestHeaders resolves the static headers and credential sent with every request
+func (c ProviderConfig) RequestHeaders() (http.Header, error) {
+	header := http.Header{"Content-Type": []string{"application/json"}}
+
+	for name, value := range c.Headers {
+		expanded, err := expandEnvStrict(value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+		header.Set(name, expanded)
+	}
+
+	if c.Auth != nil {
+		credential := os.Getenv(c.Auth.Env)
+		if credential == "" {
+			return nil, fmt.Errorf("%s is not set", c.Auth.Env)
+		}
+		switch c.Auth.Scheme {
+		case "bearer":
+			header.Set("Authorization", "Bearer "+credential)
+		case "header":
+			header.Set(c.Auth.Header, credential)
+		}
+	}
+
+	return header, nil
+}
+
+// expandEnvStrict expands ${VAR} references and fails on variables that are not set
+func expandEnvStrict(s string) (string, error) {
+	var missing []string
+	expanded := os.Expand(s, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// RawResult is one line of a -raw-output export: a single request as the load engine saw it
+type RawResult struct {
+	RunID     string  `json:"run_id"`
+	Provider  string  `json:"provider"`
+	Seq       uint64  `json:"seq"`
+	Timestamp string  `json:"timestamp"` // When the request was sent, RFC 3339 with nanoseconds
+	Code      uint16  `json:"code"`
+	LatencyMs float64 `json:"latency_ms"` // From the scheduled send time with -correct-omission
+	BytesIn   uint64  `json:"bytes_in"`
+	BytesOut  uint64  `json:"bytes_out"`
+	Outcome   string  `json:"outcome"` // ok, http_error, invalid, client_timeout, server_timeout or error
+	Error     string  `json:"error,omitempty"`
+}
+
+// RawResults streams every request's result to a JSONL file as it arrives, gzip-compressed when
+// the path ends in .gz, so custom statistics can be computed later without rerunning. It is
+// shared by every provider and run of an invocation; lines carry the run ID and provider.
+type RawResults struct {
+	file *os.File
+	buf  *bufio.Writer
+	gz   *gzip.Writer // nil for plain JSONL
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	written int64
+	err     error // First write error, after which lines are dropped
+	closed  bool
+}
+
+// CreateRawResults creates the export file, truncating any previous one
+func CreateRawResults(path string) (*RawResults, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create raw output file: %v", err)
+	}
+	r := &RawResults{file: file, buf: bufio.NewWriterSize(file, 256<<10)}
+	var out io.Writer = r.buf
+	if strings.HasSuffix(path, ".gz") {
+		r.gz = gzip.NewWriter(r.buf)
+		out = r.gz
+	}
+	r.encoder = json.NewEncoder(out)
+	return r, nil
+}
+
+// Add writes one result, classified by outcome like the request samples
+func (r *RawResults) Add(runID, provider string, res *vegeta.Result, outcome string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.closed {
+		return
+	}
+
+	err := r.encoder.Encode(RawResult{
+		RunID:     runID,
+		Provider:  strings.ToLower(provider),
+		Seq:       res.Seq,
+		Timestamp: res.Timestamp.UTC().Format(time.RFC3339Nano),
+		Code:      res.Code,
+		LatencyMs: float64(res.Latency) / float64(time.Millisecond),
+		BytesIn:   res.BytesIn,
+		BytesOut:  res.BytesOut,
+		Outcome:   outcome,
+		Error:     res.Error,
+	})
+	if err != nil {
+		r.err = err
+		log.Printf("Warning: Could not write raw results, dropping the rest: %v", err)
+		return
+	}
+	r.written++
+}
+
+// Close flushes the export and closes the file, returning the first error writing it. Closing
+// again does nothing.
+func (r *RawResults) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	err := r.err
+	if r.gz != nil {
+		if closeErr := r.gz.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if flushErr := r.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write raw output file: %v", err)
+	}
+	fmt.Printf("Wrote %s raw results to %s\n", report.Int(r.written), r.file.Name())
+	return nil
+}
@@ -0,0 +1,230 @@
+package bench
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// repeatMetric is a metric summarized across the runs of -repeat
+type repeatMetric struct {
+	Key        string // Key in the results file and CSV
+	Label      string
+	IsDuration bool // Milliseconds, printed with the report units
+	HigherBest bool
+	value      func(res Result) float64
+}
+
+var repeatMetrics = []repeatMetric{
+	{Key: "throughput_rps", Label: "throughput/s", HigherBest: true, value: func(res Result) float64 { return res.Metrics.Throughput }},
+	{Key: "success_rate", Label: "success%", HigherBest: true, value: func(res Result) float64 { return 100.0 * res.Metrics.Success }},
+	{Key: "mean_latency_ms", Label: "mean", IsDuration: true, value: func(res Result) float64 { return durationMs(res.Metrics.Latencies.Mean) }},
+	{Key: "p50_latency_ms", Label: "p50", IsDuration: true, value: func(res Result) float64 { return durationMs(res.Metrics.Latencies.P50) }},
+	{Key: "p99_latency_ms", Label: "p99", IsDuration: true, value: func(res Result) float64 { return durationMs(res.Metrics.Latencies.P99) }},
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// tCritical95 are the two-sided 95% quantiles of Student's t distribution for 1 to 30 degrees of freedom
+var tCritical95 = []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+
+// tCritical returns the two-sided 95% t quantile, rounding fractional degrees of freedom down
+func tCritical(df float64) float64 {
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if i := int(df); i <= len(tCritical95) {
+		return tCritical95[i-1]
+	}
+	return 1.96
+}
+
+// sampleStats returns the mean and sample standard deviation of values
+func sampleStats(values []float64) (mean, stddev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if len(values) < 2 {
+		return mean, 0
+	}
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)-1))
+}
+
+// summarizeRepeats computes the mean, standard deviation and 95% confidence interval of the mean
+func summarizeRepeats(values []float64) resultfile.MetricStats {
+	mean, stddev := sampleStats(values)
+	stats := resultfile.MetricStats{Mean: mean, StdDev: stddev, CILow: mean, CIHigh: mean}
+	if len(values) > 1 {
+		half := tCritical(float64(len(values)-1)) * stddev / math.Sqrt(float64(len(values)))
+		stats.CILow, stats.CIHigh = mean-half, mean+half
+	}
+	return stats
+}
+
+// RepeatComparison is a Welch's t-test of one metric between two providers
+type RepeatComparison struct {
+	A, B        string
+	Metric      repeatMetric
+	Change      float64 // Percent change of B's mean from A's
+	T           float64
+	Significant bool // The difference is unlikely to be noise at 95% confidence
+}
+
+// welchTest tests whether two samples have different means without assuming equal variances
+func welchTest(a, b []float64) (t float64, significant bool) {
+	meanA, sdA := sampleStats(a)
+	meanB, sdB := sampleStats(b)
+	varA, varB := sdA*sdA/float64(len(a)), sdB*sdB/float64(len(b))
+	if varA+varB == 0 {
+		return 0, meanA != meanB
+	}
+	t = (meanB - meanA) / math.Sqrt(varA+varB)
+	df := (varA + varB) * (varA + varB) / (varA*varA/float64(len(a)-1) + varB*varB/float64(len(b)-1))
+	return t, math.Abs(t) > tCritical(df)
+}
+
+// RunRepeats runs every provider n times, cooling down between rounds, and prints each metric's
+// spread and which provider differences are within noise. Each returned round holds the results
+// of one run of every provider; the last round carries the repeat statistics.
+func RunRepeats(providers []Target, n int, opts Scenario, outputFile string) [][]Result {
+	rounds := make([][]Result, 0, n)
+	for i := 0; i < n; i++ {
+		fmt.Printf("\nRepeat %d/%d\n", i+1, n)
+		rounds = append(rounds, runBenchmarks(context.Background(), providers, opts))
+
+		if i < n-1 && opts.Cooldown > 0 {
+			fmt.Printf("Cooling down for %d seconds...\n", opts.Cooldown)
+			time.Sleep(time.Duration(opts.Cooldown) * time.Second)
+		}
+	}
+
+	// values[provider][metric] holds one value per round
+	names := make([]string, 0, len(providers))
+	values := make(map[string]map[string][]float64)
+	for _, round := range rounds {
+		for _, res := range round {
+			name := res.ProviderName
+			if values[name] == nil {
+				names = append(names, name)
+				values[name] = make(map[string][]float64)
+			}
+			for _, m := range repeatMetrics {
+				values[name][m.Key] = append(values[name][m.Key], m.value(res))
+			}
+		}
+	}
+
+	last := rounds[len(rounds)-1]
+	for i, res := range last {
+		summary := &resultfile.RepeatSummary{Runs: n, Metrics: make(map[string]resultfile.MetricStats)}
+		for _, m := range repeatMetrics {
+			summary.Metrics[m.Key] = summarizeRepeats(values[res.ProviderName][m.Key])
+		}
+		last[i].Repeat = summary
+	}
+
+	var comparisons []RepeatComparison
+	for i, a := range names {
+		for _, b := range names[i+1:] {
+			for _, m := range repeatMetrics {
+				meanA, _ := sampleStats(values[a][m.Key])
+				meanB, _ := sampleStats(values[b][m.Key])
+				t, significant := welchTest(values[a][m.Key], values[b][m.Key])
+				comparisons = append(comparisons, RepeatComparison{A: a, B: b, Metric: m,
+					Change: percentChange(meanA, meanB), T: t, Significant: significant})
+			}
+		}
+	}
+
+	printRepeatSummary(last, comparisons)
+	if outputFile != "" {
+		if err := saveRepeats(last, comparisons, outputFile); err != nil {
+			log.Printf("Warning: %v", err)
+		} else {
+			fmt.Printf("\nRepeat statistics saved to %s\n", outputFile)
+		}
+	}
+	return rounds
+}
+
+// formatRepeatValue formats a metric value, durations in the report units
+func formatRepeatValue(m repeatMetric, v float64) string {
+	if m.IsDuration {
+		return report.Duration(msDuration(v))
+	}
+	return report.Float(v, 2)
+}
+
+func printRepeatSummary(results []Result, comparisons []RepeatComparison) {
+	for _, res := range results {
+		fmt.Printf("\n%s across %d runs (mean ± stddev, 95%% CI of the mean):\n", res.ProviderName, res.Repeat.Runs)
+		for _, m := range repeatMetrics {
+			s := res.Repeat.Metrics[m.Key]
+			fmt.Printf("  %-14s %s ± %s (%s - %s)\n", m.Label, formatRepeatValue(m, s.Mean), formatRepeatValue(m, s.StdDev),
+				formatRepeatValue(m, s.CILow), formatRepeatValue(m, s.CIHigh))
+		}
+	}
+
+	if len(comparisons) == 0 {
+		return
+	}
+	fmt.Printf("\nProvider differences (Welch's t-test, 95%% confidence):\n")
+	for _, c := range comparisons {
+		verdict := "within noise"
+		if c.Significant {
+			better := c.A
+			if (c.Change > 0) == c.Metric.HigherBest {
+				better = c.B
+			}
+			verdict = "significant, " + better + " is better"
+		}
+		fmt.Printf("  %-14s %s vs %s: %+.1f%% (t=%.2f) %s\n", c.Metric.Label, c.A, c.B, c.Change, c.T, verdict)
+	}
+}
+
+// saveRepeats writes every provider's repeat statistics and the pairwise comparisons as CSV
+func saveRepeats(results []Result, comparisons []RepeatComparison, outputFile string) error {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create repeat file: %v", err)
+	}
+	defer file.Close()
+
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	w := csv.NewWriter(file)
+	w.Write([]string{"provider", "metric", "runs", "mean", "stddev", "ci_low", "ci_high"})
+	for _, res := range results {
+		for _, m := range repeatMetrics {
+			s := res.Repeat.Metrics[m.Key]
+			w.Write([]string{strings.ToLower(res.ProviderName), m.Key, strconv.Itoa(res.Repeat.Runs),
+				format(s.Mean), format(s.StdDev), format(s.CILow), format(s.CIHigh)})
+		}
+	}
+
+	w.Write(nil)
+	w.Write([]string{"provider_a", "provider_b", "metric", "change_percent", "t", "significant"})
+	for _, c := range comparisons {
+		w.Write([]string{strings.ToLower(c.A), strings.ToLower(c.B), c.Metric.Key, format(c.Change), format(c.T),
+			strconv.FormatBool(c.Significant)})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,102 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ChatRoute is the default route; prompt size, payload sweeps and -validate only apply to it
+const ChatRoute = "chat"
+
+// Route is an API endpoint benchmarked across gateways, e.g. chat completions or embeddings
+type Route struct {
+	Name    string                 `json:"name"`
+	Method  string                 `json:"method"`  // Defaults to POST
+	Path    string                 `json:"path"`    // Path template, {suffix} is replaced with -suffix
+	Payload map[string]interface{} `json:"payload"` // Request body, #{request_index} and #{timestamp} are filled in per request
+}
+
+// builtinRoutes are the OpenAI compatible endpoints most gateways expose, plus the Anthropic Messages API
+func builtinRoutes() []Route {
+	return []Route{
+		{Name: ChatRoute, Method: http.MethodPost, Path: "/{suffix}/chat/completions"},
+		{Name: "embeddings", Method: http.MethodPost, Path: "/{suffix}/embeddings", Payload: map[string]interface{}{
+			"model": "openai/text-embedding-3-small",
+			"input": "This is a benchmark request #{request_index} at #{timestamp}. How are you?",
+		}},
+		{Name: "moderations", Method: http.MethodPost, Path: "/{suffix}/moderations", Payload: map[string]interface{}{
+			"model": "openai/omni-moderation-latest",
+			"input": "This is a benchmark request #{request_index} at #{timestamp}. How are you?",
+		}},
+		{Name: "rerank", Method: http.MethodPost, Path: "/{suffix}/rerank", Payload: map[string]interface{}{
+			"model": "cohere/rerank-english-v3.0",
+			"query": "What is an AI gateway? (request #{request_index} at #{timestamp})",
+			"documents": []string{
+				"An AI gateway proxies requests to model providers.",
+				"A CDN caches static content close to users.",
+				"A load balancer spreads traffic across servers.",
+			},
+		}},
+		{Name: "messages", Method: http.MethodPost, Path: "/{suffix}/messages", Payload: map[string]interface{}{
+			"model":      "openai/gpt-4o-mini",
+			"max_tokens": 256,
+			"messages": []map[string]interface{}{
+				{"role": "user", "content": "This is a benchmark request #{request_index} at #{timestamp}. How are you?"},
+			},
+		}},
+	}
+}
+
+// FindRoute looks up a route by name among the built-in routes and those in configPath,
+// which replace built-in routes of the same name
+func FindRoute(name string, configPath string) (Route, error) {
+	routes := builtinRoutes()
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return Route{}, fmt.Errorf("failed to read routes config: %v", err)
+		}
+		var custom []Route
+		if err := json.Unmarshal(data, &custom); err != nil {
+			return Route{}, fmt.Errorf("failed to parse routes config: %v", err)
+		}
+		routes = append(custom, routes...)
+	}
+
+	var names []string
+	for _, r := range routes {
+		if !strings.EqualFold(r.Name, name) {
+			names = append(names, r.Name)
+			continue
+		}
+		if r.Path == "" {
+			return Route{}, fmt.Errorf("route %s has no path", r.Name)
+		}
+		if r.Method == "" {
+			r.Method = http.MethodPost
+		}
+		r.Method = strings.ToUpper(r.Method)
+		if r.Name != ChatRoute && r.Payload == nil && r.Method != http.MethodGet {
+			return Route{}, fmt.Errorf("route %s has no payload", r.Name)
+		}
+		return r, nil
+	}
+	return Route{}, fmt.Errorf("unknown route %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// PathFor returns the route path with the URL suffix filled in
+func (r Route) PathFor(suffix string) string {
+	return strings.ReplaceAll(r.Path, "{suffix}", suffix)
+}
+
+// Body returns the route's payload template, nil for bodiless requests
+func (r Route) Body() []byte {
+	if r.Payload == nil {
+		return nil
+	}
+	body, _ := json.Marshal(r.Payload)
+	return body
+}
@@ -0,0 +1,1127 @@
+// Package bench is the benchmark harness behind the bifrost-benchmarks command. It attacks
+// OpenAI compatible endpoints with vegeta while sampling the target's memory, CPU and leaks,
+// so integration tests in other repos can drive the same runs programmatically:
+//
+//	targets := []bench.Target{{Name: "Bifrost", Method: "POST", Endpoint: "http://localhost:3001/v1/chat/completions",
+//		Payload: bench.ChatPayload(false, "gpt-4o-mini"), Port: "3001"}}
+//	results := bench.NewRunner(bench.Scenario{Rate: 100, Duration: 10}).Run(ctx, targets)
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/shirou/gopsutil/net"
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// Target is an API provider to be benchmarked
+type Target struct {
+	Name       string
+	Route      string // Route name, e.g. chat or embeddings
+	Method     string
+	Endpoint   string
+	Port       string // Port the server process listens on, for memory monitoring
+	Socket     string // Unix domain socket to connect to instead of the endpoint's host
+	Payload    []byte
+	ModelMix   ModelMix // When set, overrides the payload model per request
+	Header     http.Header
+	Body       map[string]interface{} // Fields set on every request body
+	Rate       int                    // Requests per second, 0 uses the global rate
+	Duration   int                    // Seconds, 0 uses the global duration
+	Container  string                 // Docker container sampled for memory and CPU instead of the process on Port
+	Protocol   string                 // h3 for HTTP/3 over QUIC, "" for HTTP/1.1 or HTTP/2 over TCP
+	Pprof      string                 // Base URL of the target's pprof endpoint, e.g. http://localhost:3001/debug/pprof
+	MetricsURL string                 // The target's JSON metrics endpoint with goroutine counts, "" if it has none
+	Client     *ClientSettings        // HTTP client tuning, nil for DefaultClientSettings
+	Cost       *CostConfig            // What running the target costs, nil to skip price-performance
+}
+
+// load returns the rate and duration this provider is attacked with
+func (p Target) load(opts Scenario) (rate int, duration int) {
+	rate, duration = opts.Rate, opts.Duration
+	if p.Rate > 0 {
+		rate = p.Rate
+	}
+	if p.Duration > 0 {
+		duration = p.Duration
+	}
+	return rate, duration
+}
+
+// Result holds the metrics from benchmarking one target
+type Result struct {
+	ProviderName      string
+	Route             string
+	Tags              Tags // The scenario's tags
+	TargetRate        int  // Requests per second the provider was attacked with, 0 with virtual users
+	VirtualUsers      *resultfile.VirtualUsers
+	Engine            string // Load engine, empty for vegeta
+	DurationSec       int
+	Metrics           *vegeta.Metrics
+	CPUUsage          float64 // Average server CPU percent (100 is one core), only measured for docker targets
+	ServerMemoryStats []ServerMemStat
+	DropReasons       map[string]int  // Track reasons for dropped requests
+	InvalidResponses  int             // 200 responses that failed content validation
+	ClientTimeouts    int             // Requests the load generator gave up on
+	ServerTimeouts    int             // 504/408 or timeout error responses sent by the target
+	Latencies         []time.Duration // As reported: corrected for coordinated omission with -correct-omission
+	WallLatency       LatencySummary  // Measured from when each request was actually sent
+	CorrectedLatency  LatencySummary  // Measured from when each request was scheduled to be sent
+	OmissionCorrected bool            // Metrics and Latencies are the corrected ones
+	Samples           []RequestSample // Per-request outcomes, persisted with -db for raw data exports
+	P99               PercentileEstimate
+	P999              PercentileEstimate
+	Traces            []TraceBreakdown        // Per-request latency decomposition, when tracing against the mocker
+	UpstreamRequests  int64                   // Requests that reached the mocker during the run, -1 if unknown
+	LeakWarnings      []string                // FD or goroutine counts that grew steadily during the run
+	Sockets           *resultfile.SocketUsage // Load host TIME_WAIT/ESTABLISHED counts, nil off Linux
+	SlowestRequests   []resultfile.SlowRequest
+	FailedRequests    []resultfile.FailedRequest // First failures with the request ID they were sent with
+	Scheduling        SchedulingReport
+	Assertions        []resultfile.AssertionResult
+	GatewayRuntime    *resultfile.GatewayRuntime   // Go runtime settings reported on the gateway's metrics endpoint
+	Profiles          *resultfile.Profiles         // pprof profiles captured mid-run, with Scenario.Profile
+	BodyChecks        *resultfile.BodyChecks       // Request bodies compared with the mocker's checksums, with Scenario.VerifyBody
+	ConnectionPhases  *resultfile.ConnectionPhases // Phase timings of sampled requests, with Scenario.PhaseSample
+	HTTPClient        ClientSettings               // The runner's HTTP client settings for this target
+	Protocols         map[string]int64             // Responses per protocol, e.g. HTTP/1.1 or HTTP/3.0
+	Repeat            *resultfile.RepeatSummary    // Metrics across every run of -repeat, on the last run only
+	Burn              []BurnPoint                  // Error budget burn per rolling window, with -slo-success
+	ErrorBudget       *resultfile.ErrorBudget      // Breaking point against -slo-success, nil without it
+	Window            *resultfile.AttackWindow     // Wall-clock span of the attack
+	SteadyState       *resultfile.SteadyState      // Metrics without the warm-up and drain transients, nil for short runs
+	PricePerformance  *resultfile.PricePerformance // Requests per dollar, nil for targets without a cost
+	BodySizes         *resultfile.BodySizes        // Bytes sent and received, and the sizes of successful responses
+	ArrivalTrace      *resultfile.ArrivalTrace     // The replayed trace, nil at a constant rate
+}
+
+// Scenario controls how each target is attacked
+type Scenario struct {
+	Rate     int  // Requests per second
+	Duration int  // Duration of each test in seconds
+	Cooldown int  // Cooldown between tests in seconds
+	Validate bool // Validate 200 response bodies
+	// Compare each request body with the checksum the mocker (-body-checksum) echoes
+	VerifyBody bool
+	// Fraction of requests traced with httptrace to time DNS, connect, TLS, TTFB and body read, 0 to disable
+	PhaseSample float64
+	Slowest     int  // Number of slowest requests kept per provider
+	Live        bool // Redraw a live dashboard every second during the attack
+	// Measure latencies from each request's scheduled send time instead of its actual one
+	CorrectOmission bool
+	MockerURL       string // Mocker base URL to fetch per-request traces from, empty to disable tracing
+	Targeter        string // Registered targeter building each request, name[:arg]; empty for the default
+	Engine          string // Registered load engine sending the requests, name[:arg]; empty for vegeta
+	Tags            Tags   // Labels saved with every result, e.g. machine=m5.2xlarge
+	// Start every attack on the next wall-clock multiple of this (e.g. 1m), 0 to start at once
+	AlignStart time.Duration
+	// Stream every request's result to this export as it arrives, nil to disable
+	RawOutput *RawResults
+	// Send at the arrival times of this trace instead of a constant rate, nil for -rate
+	ArrivalTrace *ArrivalTrace
+	// P99 a provider must stay within for its price-performance score to count, 0 for no target
+	CostTargetP99 time.Duration
+
+	// Virtual user mode: when VirtualUsers is set, that many users loop request, think for
+	// ThinkMin to ThinkMax, request, instead of sending at a constant rate
+	VirtualUsers int
+	ThinkMin     time.Duration
+	ThinkMax     time.Duration
+
+	// Soak mode: when SnapshotInterval is set, periodic snapshots are written to SnapshotFile
+	SnapshotInterval time.Duration
+	SnapshotFile     *os.File
+
+	TLSConfig *tls.Config // Client TLS settings for https endpoints, nil for plain http
+
+	// Profiling: when Profile is set, targets with a pprof URL get a CPU profile of
+	// ProfileSeconds and a heap profile captured mid-run and saved to ProfileDir
+	Profile        bool
+	ProfileSeconds int
+	ProfileDir     string
+
+	// Error budget tracking: when SLOSuccess is set, the first rolling window whose error rate burns
+	// the budget faster than BurnThreshold is reported as the provider's breaking point
+	SLOSuccess    float64 // Percent of requests that must succeed
+	SLOWindow     time.Duration
+	BurnThreshold float64
+
+	// Settle mode: instead of the fixed cooldown, wait until the target's RSS and CPU return
+	// to their pre-attack baseline, for at most SettleMaxWait
+	Settle             bool
+	SettleRSSTolerance float64 // Percent above baseline RSS
+	SettleCPUTolerance float64 // Percentage points above baseline CPU
+	SettleMaxWait      time.Duration
+}
+
+// MemStat captures memory statistics
+type MemStat struct {
+	Alloc      uint64
+	TotalAlloc uint64
+	Sys        uint64
+	NumGC      uint32
+}
+
+// ServerMemStat captures server memory usage over time
+type ServerMemStat struct {
+	Timestamp  time.Time
+	RSS        uint64  // Resident Set Size in bytes
+	VMS        uint64  // Virtual Memory Size in bytes
+	MemPercent float64 // Memory usage as percentage
+}
+
+// TargetNames returns the lowercased target names, as accepted by -provider
+func TargetNames(providers []Target) []string {
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = strings.ToLower(p.Name)
+	}
+	return names
+}
+
+// ChatPayload builds the chat completion payload template, with the long prompt when bigPayload is set
+func ChatPayload(bigPayload bool, model string) []byte {
+	var payload []byte
+
+	if bigPayload {
+		// Create payload template with dynamic content placeholders
+		payload, _ = json.Marshal(map[string]interface{}{
+			"messages": []map[string]string{
+				{
+					"role": "user",
+					"content": "This is a benchmark request #{request_index} at #{timestamp}. " +
+						"Please provide a comprehensive analysis of the following topics: " +
+						"1. Explain the concept of Proxy Gateway in the context of AI, including its architecture, benefits, and use cases. " +
+						"2. Discuss the role of load balancing and request routing in AI proxy gateways. " +
+						"3. Analyze the impact of caching and rate limiting on AI service performance. " +
+						"4. Describe common challenges in implementing AI proxy gateways and potential solutions. " +
+						"5. Compare different AI proxy gateway implementations and their trade-offs. " +
+						"6. What is the difference between a proxy gateway and a reverse proxy? " +
+						"7. What is the difference between a proxy gateway and a load balancer? " +
+						"8. What is the difference between a proxy gateway and a web server? " +
+						"9. What is the difference between a proxy gateway and a CDN? " +
+						"10. What is the difference between a proxy gateway and a firewall? " +
+						"11. What is the difference between a proxy gateway and a VPN? " +
+						"12. What is the difference between a proxy gateway and a WAF? " +
+						"13. What is the difference between a proxy gateway and a DDoS protection service? " +
+						"14. What is the difference between a proxy gateway and a DNS server? " +
+						"15. What is the difference between a proxy gateway and a web application firewall? " +
+						"16. What is the difference between a proxy gateway and a load balancer? " +
+						"17. What is the difference between a proxy gateway and a web server? " +
+						"18. What is the difference between a proxy gateway and a CDN? " +
+						"19. What is the difference between a proxy gateway and a firewall? " +
+						"20. What is the difference between a proxy gateway and a VPN? " +
+						"Please provide detailed explanations with examples and technical details for each point. ",
+				},
+			},
+			"model": "openai/" + model,
+		})
+	} else {
+		payload, _ = json.Marshal(map[string]interface{}{
+			"messages": []map[string]string{
+				{
+					"role":    "user",
+					"content": "This is a benchmark request #{request_index} at #{timestamp}. How are you?",
+				},
+			},
+			"model": "openai/" + model,
+		})
+	}
+	return payload
+}
+
+// LoadTargets builds a target per provider in the providers config (the built-in ones when
+// configPath is empty) for the given route. Ports and credentials are read from the environment.
+func LoadTargets(bigPayload bool, model string, suffix string, host string, configPath string, route Route) ([]Target, error) {
+	configs, err := loadProviderConfigs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load providers: %v", err)
+	}
+	return buildTargets(configs, bigPayload, model, suffix, host, route)
+}
+
+// LoadComposeTargets builds a target per gateway service declared with benchmark labels in a
+// docker-compose file, like LoadTargets does for a providers config
+func LoadComposeTargets(bigPayload bool, model string, suffix string, host string, composePath string, route Route) ([]Target, error) {
+	configs, err := loadComposeProviders(composePath, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover providers: %v", err)
+	}
+	return buildTargets(configs, bigPayload, model, suffix, host, route)
+}
+
+// buildTargets resolves provider configs into targets for the route
+func buildTargets(configs []ProviderConfig, bigPayload bool, model string, suffix string, host string, route Route) ([]Target, error) {
+	payload := ChatPayload(bigPayload, model)
+	if route.Name != ChatRoute {
+		payload = route.Body()
+	}
+
+	// Create providers with ports from .env
+	providers := make([]Target, 0, len(configs))
+	for _, c := range configs {
+		header, err := c.RequestHeaders()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure %s: %v", c.Name, err)
+		}
+		endpoint, err := c.Endpoint(route, suffix, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure %s: %v", c.Name, err)
+		}
+		container, err := ParseMonitor(c.Monitor)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure %s: %v", c.Name, err)
+		}
+		client, err := c.Client.Settings()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure %s: %v", c.Name, err)
+		}
+		port := c.localPort()
+		if port == "" && c.Socket() == "" {
+			port = urlPort(endpoint)
+		}
+		providers = append(providers, Target{
+			Name:       c.Name,
+			Route:      route.Name,
+			Method:     route.Method,
+			Endpoint:   endpoint,
+			Port:       port,
+			Socket:     c.Socket(),
+			Payload:    payload,
+			Header:     header,
+			Body:       c.Body,
+			Rate:       c.Rate,
+			Duration:   c.Duration,
+			Container:  container,
+			Protocol:   c.Protocol,
+			Pprof:      os.ExpandEnv(c.Pprof),
+			MetricsURL: os.ExpandEnv(c.MetricsURL),
+			Client:     &client,
+			Cost:       c.Cost,
+		})
+	}
+
+	return providers, nil
+}
+
+// Runner benchmarks targets one after another with the same scenario
+type Runner struct {
+	Scenario Scenario
+}
+
+// NewRunner creates a runner for the scenario
+func NewRunner(scenario Scenario) *Runner {
+	return &Runner{Scenario: scenario}
+}
+
+// Run benchmarks every target in order, cooling down or settling between them, and prints each
+// target's summary as it finishes. Cancelling ctx stops the current attack and skips the
+// remaining targets; the results of finished targets are still returned.
+func (r *Runner) Run(ctx context.Context, targets []Target) []Result {
+	return runBenchmarks(ctx, targets, r.Scenario)
+}
+
+func runBenchmarks(parent context.Context, providers []Target, opts Scenario) []Result {
+	results := make([]Result, 0, len(providers))
+	runID := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	for i, provider := range providers {
+		if parent.Err() != nil {
+			break
+		}
+		rate, duration := provider.load(opts)
+		if opts.VirtualUsers > 0 {
+			rate = 0
+			fmt.Printf("Benchmarking %s with %d virtual users for %ds...\n", provider.Name, opts.VirtualUsers, duration)
+		} else if opts.ArrivalTrace != nil {
+			rate = max(int(math.Round(opts.ArrivalTrace.offeredRate(time.Duration(duration)*time.Second))), 1)
+			fmt.Printf("Benchmarking %s with arrival trace %s for %ds...\n", provider.Name, opts.ArrivalTrace, duration)
+		} else {
+			fmt.Printf("Benchmarking %s at %d requests/s for %ds...\n", provider.Name, rate, duration)
+		}
+
+		clientSettings := provider.clientSettings()
+		httpTransport := &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: clientSettings.MaxIdleConnsPerHost,
+			MaxConnsPerHost:     0,
+			IdleConnTimeout:     clientSettings.IdleConnTimeout,
+			DisableCompression:  clientSettings.DisableCompression,
+			TLSClientConfig:     opts.TLSConfig,
+		}
+		if provider.Socket != "" {
+			httpTransport.Proxy = nil
+			httpTransport.DialContext = unixDialer(provider.Socket)
+		}
+
+		var transport http.RoundTripper = httpTransport
+		var h3Transport *http3.Transport
+		if provider.Protocol == ProtocolHTTP3 {
+			h3Transport = newHTTP3Transport(opts.TLSConfig)
+			h3Transport.DisableCompression = clientSettings.DisableCompression
+			transport = h3Transport
+		}
+		protocols := newProtocolCounter()
+		phases := newPhaseSampler(opts.PhaseSample)
+		transport = &traceTransport{next: transport, runID: runID, trace: opts.MockerURL != "", verifyBody: opts.VerifyBody, protocols: protocols, phases: phases}
+
+		httpClient := &http.Client{
+			Transport: transport,
+			Timeout:   clientSettings.Timeout,
+		}
+
+		// Define the attack
+		targeter, err := newTargeter(opts.Targeter, provider)
+		if err != nil {
+			log.Printf("Warning: Skipping %s: %v", provider.Name, err)
+			continue
+		}
+		engine, err := newEngine(opts.Engine, httpClient)
+		if err != nil {
+			log.Printf("Warning: Skipping %s: %v", provider.Name, err)
+			continue
+		}
+
+		// Wait for the wall-clock boundary before monitoring, so idle time isn't sampled
+		if err := waitForStart(parent, provider.Name, opts.AlignStart); err != nil {
+			break
+		}
+
+		// Setup memory monitoring for the server
+		var serverMemStats []ServerMemStat
+		var memMutex sync.Mutex
+		stopMonitoring := make(chan struct{})
+		var wg sync.WaitGroup
+
+		// Initialize drop reasons tracking
+		dropReasons := make(map[string]int)
+		invalidResponses := 0
+		var checks *bodyChecks
+		if opts.VerifyBody {
+			checks = &bodyChecks{}
+		}
+		clientTimeouts, serverTimeouts := 0, 0
+
+		// Start server memory and leak monitoring
+		var leaks *leakMonitor
+		var docker *dockerMonitor
+		var serverProcess *processGroup
+		if provider.Container != "" {
+			// Containerized targets live in another pid namespace, so they are sampled through the Docker API
+			docker = newDockerMonitor(provider.Container)
+			if _, err := docker.Stats(); err != nil {
+				log.Printf("Warning: Could not monitor container %s for %s: %v", provider.Container, provider.Name, err)
+				docker = nil
+			} else {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					docker.Run(stopMonitoring, &serverMemStats, &memMutex)
+				}()
+			}
+		} else if serverProcess, err = findServerProcess(provider); err != nil {
+			log.Printf("Warning: Could not find server process for %s: %v", provider.Name, err)
+		} else {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				monitorServerMemory(serverProcess, stopMonitoring, &serverMemStats, &memMutex)
+			}()
+
+			leaks = newLeakMonitor(serverProcess, provider.MetricsURL)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				leaks.Run(stopMonitoring)
+			}()
+		}
+
+		// Watch the load host's own sockets, since running out of ephemeral ports fails requests client side
+		ports := newPortMonitor()
+		if ports != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ports.Run(stopMonitoring)
+			}()
+		}
+
+		// Record the idle baseline the target has to return to before the next provider runs
+		var baseline serverBaseline
+		hasBaseline := false
+		if opts.Settle && serverProcess != nil {
+			if baseline, err = measureBaseline(serverProcess); err != nil {
+				log.Printf("Warning: Could not measure baseline for %s: %v", provider.Name, err)
+			} else {
+				hasBaseline = true
+			}
+		}
+
+		var soakRec *soakRecorder
+		if opts.SnapshotInterval > 0 {
+			soakRec = newSoakRecorder(provider.Name, opts.SnapshotInterval, serverProcess, provider.MetricsURL, opts.SnapshotFile)
+		}
+
+		var dashboard *liveDashboard
+		if opts.Live {
+			dashboard = newLiveDashboard(provider.Name, serverProcess)
+		}
+
+		// Snapshot the mocker request counter to measure what actually reached the upstream
+		var mockerRequestsBefore int64 = -1
+		if opts.MockerURL != "" {
+			if n, err := fetchMockerRequests(opts.MockerURL, opts.TLSConfig); err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				mockerRequestsBefore = n
+			}
+		}
+
+		// Create context with timeout for the attack, allowing 240s for in-flight requests to drain
+		ctx, cancel := context.WithTimeout(parent,
+			time.Duration(duration)*time.Second+240*time.Second)
+		defer cancel()
+
+		// Run the benchmark
+		var metrics vegeta.Metrics
+		var latencies, wallLatencies, correctedLatencies []time.Duration
+		var samples []RequestSample
+		var clientTraces []ClientTrace
+		var failedRequests []resultfile.FailedRequest
+		failed := 0
+		slowestRequests := newSlowestTracker(opts.Slowest)
+		var pacer vegeta.Pacer = vegeta.Rate{Freq: rate, Per: time.Second}
+		if dashboard != nil {
+			dashboard.Start()
+		}
+		if soakRec != nil {
+			soakRec.Start()
+		}
+		// Vegeta schedules request seq at seq+1 intervals after the attack starts, or at the
+		// seq-th arrival of a trace
+		interval := time.Second / time.Duration(max(rate, 1))
+		attackStart := time.Now().Add(interval)
+		due := func(seq uint64) time.Duration { return time.Duration(seq) * interval }
+		if opts.ArrivalTrace != nil {
+			pacer, attackStart, due = opts.ArrivalTrace, time.Now(), opts.ArrivalTrace.Offset
+		}
+		// k6 schedules requests itself, after a startup delay, and numbers none of them, so its
+		// requests have no known send time to be late for
+		scheduled := opts.VirtualUsers == 0 && nonDefaultEngine(opts.Engine) != "k6"
+		var profiling *profileCapture
+		if opts.Profile && provider.Pprof != "" {
+			profiling = startProfileCapture(provider.Pprof, provider.Name, runID, opts.ProfileDir, opts.ProfileSeconds, time.Duration(duration)*time.Second)
+		}
+		var attack <-chan *vegeta.Result
+		stopAttack := engine.Stop
+		attackBegan := time.Now()
+		if opts.VirtualUsers > 0 {
+			users := newVUAttacker(httpClient)
+			attack, stopAttack = users.Attack(targeter, opts.VirtualUsers, opts.ThinkMin, opts.ThinkMax, time.Duration(duration)*time.Second, provider.Name), users.Stop
+		} else {
+			attack = engine.Attack(targeter, pacer, time.Duration(duration)*time.Second, provider.Name)
+		}
+		for res := range attack {
+			// Closed-loop requests have no schedule to fall behind
+			corrected := res.Latency
+			if scheduled {
+				corrected = correctOmission(res.Latency, res.Timestamp, attackStart, due(res.Seq))
+			}
+			wallLatencies = append(wallLatencies, res.Latency)
+			correctedLatencies = append(correctedLatencies, corrected)
+			if opts.CorrectOmission {
+				res.Latency = corrected
+			}
+
+			metrics.Add(res)
+			latencies = append(latencies, res.Latency)
+			if opts.Slowest > 0 {
+				slowestRequests.Add(res)
+			}
+			if soakRec != nil {
+				soakRec.Add(res)
+			}
+			if dashboard != nil {
+				dashboard.Add(res)
+			}
+			if opts.MockerURL != "" {
+				clientTraces = append(clientTraces, newClientTrace(runID, provider.Name, res))
+			}
+
+			kind := classifyTimeout(res)
+			switch kind {
+			case clientTimeout:
+				clientTimeouts++
+			case serverTimeout:
+				serverTimeouts++
+			}
+
+			// Track drop reasons, keeping the request IDs of the first failures
+			invalid := false
+			reason := ""
+			bodyReason := ""
+			if checks != nil {
+				bodyReason = checks.Add(res)
+			}
+			if res.Error != "" {
+				reason = res.Error
+			} else if res.Code != 200 {
+				reason = fmt.Sprintf("HTTP %d", res.Code)
+			} else if opts.Validate {
+				// Some gateways return 200 with empty or malformed bodies under load
+				if err := validateChatCompletion(res.Body); err != nil {
+					invalid = true
+					invalidResponses++
+					reason = fmt.Sprintf("invalid 200: %v", err)
+				}
+			}
+			if reason == "" {
+				reason = bodyReason
+			}
+			if ports != nil {
+				ports.Observe(res.Error)
+			}
+			if reason != "" {
+				dropReasons[reason]++
+				failed++
+				if len(failedRequests) < maxFailedRequests {
+					failedRequests = append(failedRequests, newFailedRequest(runID, provider.Name, res, reason))
+				}
+			}
+			sample := newRequestSample(res, kind, invalid)
+			samples = append(samples, sample)
+			if opts.RawOutput != nil {
+				opts.RawOutput.Add(runID, provider.Name, res, sample.Outcome)
+			}
+
+			// Check if context is done
+			select {
+			case <-ctx.Done():
+				log.Printf("Attack for %s timed out", provider.Name)
+				stopAttack()
+				dropReasons["context_timeout"]++
+				clientTimeouts++
+				goto EndAttack
+			default:
+				// Continue with the attack
+			}
+		}
+
+	EndAttack:
+		window := attackWindow(attackBegan, time.Now(), opts.AlignStart)
+		metrics.Close()
+		if dashboard != nil {
+			dashboard.Stop()
+		}
+		if soakRec != nil {
+			soakRec.Stop()
+		}
+
+		scheduling := SchedulingReport{OfferedRate: float64(rate), AchievedRate: metrics.Rate}
+		if scheduled {
+			scheduling = checkScheduling(samples, rate, &metrics, opts.ArrivalTrace)
+		}
+
+		// Seconds of the run are counted from when the first request was due, or for k6 from
+		// its first request, so its startup isn't counted as a slow first second
+		runStart := attackStart.Add(-interval)
+		if !scheduled && len(samples) > 0 {
+			runStart = samples[0].SentAt
+			for _, s := range samples[1:] {
+				if s.SentAt.Before(runStart) {
+					runStart = s.SentAt
+				}
+			}
+		}
+
+		var burn []BurnPoint
+		var budget *resultfile.ErrorBudget
+		if opts.SLOSuccess > 0 {
+			burn = burnRates(samples, runStart, opts.SLOWindow, opts.SLOSuccess)
+			budget = errorBudget(burn, opts)
+		}
+
+		// Estimate tail percentiles along with their uncertainty
+		sorted := sortedLatencies(latencies)
+		p99 := estimatePercentile(sorted, 0.99)
+		p999 := estimatePercentile(sorted, 0.999)
+
+		var upstreamRequests int64 = -1
+		if mockerRequestsBefore >= 0 {
+			if n, err := fetchMockerRequests(opts.MockerURL, opts.TLSConfig); err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				upstreamRequests = n - mockerRequestsBefore
+			}
+		}
+
+		// Join client timings with what the mocker observed for the same trace IDs
+		var traces []TraceBreakdown
+		if opts.MockerURL != "" {
+			mockerTraces, err := fetchMockerTraces(opts.MockerURL, opts.TLSConfig)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				traces = correlateTraces(clientTraces, mockerTraces)
+			}
+		}
+
+		// Stop memory monitoring
+		close(stopMonitoring)
+		wg.Wait()
+		if h3Transport != nil {
+			h3Transport.Close()
+		}
+
+		// Lock while copying memory stats to ensure thread safety
+		memMutex.Lock()
+		serverMemStatsCopy := make([]ServerMemStat, len(serverMemStats))
+		copy(serverMemStatsCopy, serverMemStats)
+		memMutex.Unlock()
+
+		var leakWarnings []string
+		if leaks != nil {
+			leakWarnings = leaks.Warnings()
+		}
+
+		sockets := ports.Usage()
+		gatewayRuntime := fetchGatewayRuntime(provider.MetricsURL)
+
+		var profiles *resultfile.Profiles
+		if profiling != nil {
+			var errs []error
+			profiles, errs = profiling.Wait()
+			for _, err := range errs {
+				log.Printf("Warning: Could not capture %s's %v", provider.Name, err)
+			}
+		}
+
+		var cpuUsage float64
+		if docker != nil {
+			cpuUsage = docker.CPUPercent()
+		}
+
+		// Add results
+		results = append(results, Result{
+			ProviderName:      provider.Name,
+			Route:             provider.Route,
+			Tags:              opts.Tags,
+			TargetRate:        rate,
+			ArrivalTrace:      arrivalTraceSummary(opts.ArrivalTrace, duration),
+			VirtualUsers:      virtualUserSummary(opts, &metrics),
+			Engine:            nonDefaultEngine(opts.Engine),
+			DurationSec:       duration,
+			Metrics:           &metrics,
+			CPUUsage:          cpuUsage,
+			ServerMemoryStats: serverMemStatsCopy,
+			DropReasons:       dropReasons,
+			InvalidResponses:  invalidResponses,
+			ClientTimeouts:    clientTimeouts,
+			ServerTimeouts:    serverTimeouts,
+			Latencies:         latencies,
+			WallLatency:       summarizeLatencies(wallLatencies),
+			CorrectedLatency:  summarizeLatencies(correctedLatencies),
+			OmissionCorrected: opts.CorrectOmission,
+			Samples:           samples,
+			P99:               p99,
+			P999:              p999,
+			Traces:            traces,
+			UpstreamRequests:  upstreamRequests,
+			LeakWarnings:      leakWarnings,
+			Sockets:           sockets,
+			SlowestRequests:   slowestRequests.Requests(),
+			FailedRequests:    failedRequests,
+			Scheduling:        scheduling,
+			GatewayRuntime:    gatewayRuntime,
+			Profiles:          profiles,
+			BodyChecks:        checks.Summary(),
+			ConnectionPhases:  phases.Summary(),
+			HTTPClient:        clientSettings,
+			Protocols:         protocols.Counts(),
+			Burn:              burn,
+			ErrorBudget:       budget,
+			Window:            window,
+			SteadyState:       steadyState(samples, runStart),
+			BodySizes:         bodySizes(samples),
+			PricePerformance:  pricePerformance(provider.Cost, &metrics, serverMemStatsCopy, duration, opts.CostTargetP99),
+		})
+
+		fmt.Println(metrics.StatusCodes)
+
+		// Print summary
+		fmt.Printf("Results for %s:\n", provider.Name)
+		if len(opts.Tags) > 0 {
+			fmt.Printf("  Tags: %s\n", opts.Tags)
+		}
+		fmt.Printf("  Attack Window: %s to %s\n", window.Start, window.End)
+		fmt.Printf("  Requests: %s\n", report.Int(int64(metrics.Requests)))
+		if vus := results[len(results)-1].VirtualUsers; vus != nil {
+			fmt.Printf("  Request Rate: %s/s\n", report.Float(metrics.Rate, 2))
+			fmt.Printf("  Virtual Users: %s (think %s - %s, %s in flight on average)\n", report.Int(int64(vus.Users)),
+				report.Duration(opts.ThinkMin), report.Duration(opts.ThinkMax), report.Float(vus.MeanInFlight, 1))
+		} else {
+			offered := "offered"
+			if opts.ArrivalTrace != nil {
+				offered = "trace"
+			}
+			fmt.Printf("  Request Rate: %s/s (%s %s/s)\n", report.Float(metrics.Rate, 2), offered, report.Int(int64(rate)))
+			if scheduled {
+				fmt.Printf("  Late Requests: %s (max schedule lag %s)\n", report.Int(int64(scheduling.Late)), report.Duration(scheduling.MaxLag))
+			}
+		}
+		fmt.Printf("  Success Rate: %s%%\n", report.Float(100.0*metrics.Success, 2))
+		if opts.Validate {
+			fmt.Printf("  Invalid 200 Responses: %s\n", report.Int(int64(invalidResponses)))
+			fmt.Printf("  Valid Success Rate: %s%%\n", report.Float(validSuccessRate(&metrics, invalidResponses), 2))
+		}
+		fmt.Printf("  Mean Latency: %s\n", report.Duration(metrics.Latencies.Mean))
+		fmt.Printf("  P50 Latency: %s\n", report.Duration(metrics.Latencies.P50))
+		fmt.Printf("  P99 Latency: %s (95%% CI %s - %s)\n", report.Duration(metrics.Latencies.P99), report.Duration(p99.Low), report.Duration(p99.High))
+		fmt.Printf("  P99.9 Latency: %s (95%% CI %s - %s)\n", report.Duration(p999.Value), report.Duration(p999.Low), report.Duration(p999.High))
+		fmt.Printf("  Max Latency: %s\n", report.Duration(metrics.Latencies.Max))
+		other, otherName := results[len(results)-1].CorrectedLatency, "Corrected Latency (from scheduled send)"
+		if opts.CorrectOmission {
+			other, otherName = results[len(results)-1].WallLatency, "Wall Latency (from actual send)"
+		}
+		if scheduled {
+			fmt.Printf("  %s: P50 %s, P99 %s, P99.9 %s, Max %s\n", otherName, report.Duration(other.P50),
+				report.Duration(other.P99), report.Duration(other.P999), report.Duration(other.Max))
+		}
+		fmt.Printf("  Throughput: %s/s\n", report.Float(metrics.Throughput, 2))
+		fmt.Printf("  Protocols: %s\n", protocols)
+		if gatewayRuntime != nil && gatewayRuntime.Frontend != "" {
+			fmt.Printf("  Gateway Frontend: %s (an extra hop, not comparable with runs without it)\n", gatewayRuntime.Frontend)
+		}
+		printBodySizes(results[len(results)-1].BodySizes)
+		if p := results[len(results)-1].PricePerformance; p != nil {
+			fmt.Printf("  Price-Performance: %s\n", formatPricePerformance(p))
+		}
+		if clientSettings != DefaultClientSettings {
+			fmt.Printf("  HTTP Client: %s\n", clientSettings)
+		}
+		if profiles != nil {
+			fmt.Printf("  Profiles: %s\n", formatProfiles(profiles))
+		}
+		if checks != nil {
+			fmt.Printf("  Body Checks: %s\n", checks)
+			if checks.intact+checks.mismatched+checks.truncated == 0 {
+				fmt.Printf("  Warning: No response carried a body checksum; run the mocker with -body-checksum behind a gateway that forwards upstream headers\n")
+			}
+		}
+		if budget != nil {
+			fmt.Printf("  Breaking Point: %s\n", formatBreakingPoint(budget))
+		}
+		fmt.Printf("  Client Timeouts: %s\n", report.Int(int64(clientTimeouts)))
+		fmt.Printf("  Server Timeouts: %s\n", report.Int(int64(serverTimeouts)))
+		for _, warning := range leakWarnings {
+			fmt.Printf("  Warning: %s\n", warning)
+		}
+		if warning := scheduling.Warning(); warning != "" {
+			fmt.Printf("  Warning: %s\n", warning)
+		}
+		if sockets != nil {
+			fmt.Printf("  Load Host Sockets: peak %s TIME_WAIT, %s ESTABLISHED (%s%% of %s ephemeral ports)\n", report.Int(int64(sockets.PeakTimeWait)),
+				report.Int(int64(sockets.PeakEstablished)), report.Float(sockets.PeakPortUsage, 1), report.Int(int64(sockets.EphemeralPorts)))
+		}
+		for _, warning := range portWarnings(sockets) {
+			fmt.Printf("  Warning: %s\n", warning)
+		}
+		for _, warning := range convergenceWarnings(p99, p999) {
+			fmt.Printf("  Warning: %s\n", warning)
+		}
+		if upstreamRequests >= 0 {
+			fmt.Printf("  Upstream Requests: %s (amplification %sx)\n", report.Int(upstreamRequests), report.Float(requestAmplification(upstreamRequests, metrics.Requests), 3))
+		}
+		if opts.MockerURL != "" {
+			printTraceSummary(traces, len(clientTraces))
+		}
+		printSteadyState(results[len(results)-1].SteadyState, metrics.Latencies.P99)
+		if p := results[len(results)-1].ConnectionPhases; p != nil {
+			printConnectionPhases(p)
+		}
+		printSlowestRequests(results[len(results)-1].SlowestRequests)
+		printFailedRequests(failedRequests, failed)
+		if soakRec != nil {
+			soakRec.PrintDrift()
+		}
+
+		if docker != nil {
+			fmt.Printf("  Server CPU (container %s): %s%%\n", provider.Container, report.Float(cpuUsage, 1))
+		}
+
+		// Print server memory stats summary if available
+		if len(serverMemStatsCopy) > 0 {
+			var peakMem uint64
+			for _, stat := range serverMemStatsCopy {
+				if stat.RSS > peakMem {
+					peakMem = stat.RSS
+				}
+			}
+			fmt.Printf("  Server Peak Memory: %s MB\n\n", report.Float(float64(peakMem)/(1024*1024), 2))
+		} else {
+			fmt.Println("  No server memory statistics available")
+		}
+
+		// Apply cooldown period between tests (except after the last one)
+		if i < len(providers)-1 && hasBaseline {
+			fmt.Printf("Waiting up to %s for %s to return to baseline...\n", opts.SettleMaxWait, provider.Name)
+			if !waitForSettle(serverProcess, baseline, opts.SettleRSSTolerance, opts.SettleCPUTolerance, opts.SettleMaxWait) {
+				log.Printf("Warning: %s did not return to baseline within %s", provider.Name, opts.SettleMaxWait)
+			}
+		} else if i < len(providers)-1 && opts.Cooldown > 0 {
+			fmt.Printf("Cooling down for %d seconds...\n", opts.Cooldown)
+			time.Sleep(time.Duration(opts.Cooldown) * time.Second)
+		}
+	}
+
+	return results
+}
+
+// getProcessByPort finds every process listening on a TCP port. Workers sharing the port with
+// SO_REUSEPORT each have their own listener.
+func getProcessByPort(port string) (*processGroup, error) {
+	portNum, err := strconv.ParseUint(port, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port number: %v", err)
+	}
+
+	conns, err := net.Connections("tcp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connections: %v", err)
+	}
+
+	listening := func(conn net.ConnectionStat) bool {
+		return conn.Laddr.Port == uint32(portNum) && conn.Status == "LISTEN"
+	}
+	var pids []int32
+	for _, conn := range conns {
+		if listening(conn) && conn.Pid > 0 {
+			pids = append(pids, conn.Pid)
+			pids = append(pids, listeningRelatives(conn.Pid, "tcp", listening)...)
+		}
+	}
+	return newProcessGroup(pids, "port "+port)
+}
+
+// monitorServerMemory collects memory stats of the server process
+func monitorServerMemory(p *processGroup, stop <-chan struct{}, stats *[]ServerMemStat, mutex *sync.Mutex) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			memInfo, err := p.MemoryInfo()
+			if err != nil {
+				continue
+			}
+
+			memPercent, err := p.MemoryPercent()
+			if err != nil {
+				memPercent = 0.0
+			}
+
+			memStat := ServerMemStat{
+				Timestamp:  time.Now(),
+				RSS:        memInfo.RSS, // Resident Set Size
+				VMS:        memInfo.VMS, // Virtual Memory Size
+				MemPercent: float64(memPercent),
+			}
+
+			mutex.Lock()
+			*stats = append(*stats, memStat)
+			mutex.Unlock()
+		}
+	}
+}
+
+// WithoutPlaceholders replaces the per-request placeholders with constants, so every request body is identical
+func WithoutPlaceholders(payload []byte) []byte {
+	payload = bytes.ReplaceAll(payload, []byte("#{request_index}"), []byte("0"))
+	return bytes.ReplaceAll(payload, []byte("#{timestamp}"), []byte("2025-01-01T00:00:00Z"))
+}
+
+func createTargeter(provider Target) vegeta.Targeter {
+	// Create a counter for round-robin message selection
+	var requestCounter int64
+	var counterMutex sync.Mutex
+
+	return func(tgt *vegeta.Target) error {
+		// Get next message index in round-robin fashion
+		counterMutex.Lock()
+		requestCounter++
+		counterMutex.Unlock()
+
+		// Replace placeholders with values, wherever they appear in the route's payload
+		updatedPayload := bytes.ReplaceAll(provider.Payload, []byte("#{request_index}"), []byte(strconv.FormatInt(requestCounter, 10)))
+		updatedPayload = bytes.ReplaceAll(updatedPayload, []byte("#{timestamp}"), []byte(time.Now().Format(time.RFC3339)))
+
+		var err error
+		if updatedPayload, err = applyBodyOverrides(provider, updatedPayload); err != nil {
+			return err
+		}
+
+		tgt.Method = provider.Method
+		tgt.URL = provider.Endpoint
+		tgt.Body = updatedPayload
+		tgt.Header = provider.Header.Clone()
+
+		return nil
+	}
+}
+
+// applyBodyOverrides sets the target's body fields and model mix on a request payload
+func applyBodyOverrides(provider Target, body []byte) ([]byte, error) {
+	if len(provider.Body) == 0 && len(provider.ModelMix) == 0 {
+		return body, nil
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	for field, value := range provider.Body {
+		payload[field] = value
+	}
+	if len(provider.ModelMix) > 0 {
+		payload["model"] = provider.ModelMix.Pick()
+	}
+	return json.Marshal(payload)
+}
+
+// serializeResult summarizes a benchmark result for persistence
+func serializeResult(res Result) resultfile.ProviderResult {
+	// Count status codes
+	statusCodes := make(map[string]int)
+	for code, count := range res.Metrics.StatusCodes {
+		statusCodes[code] = int(count)
+	}
+
+	// Calculate peak and average server memory if available
+	var peakMem uint64
+	var totalMem uint64
+	for _, stat := range res.ServerMemoryStats {
+		if stat.RSS > peakMem {
+			peakMem = stat.RSS
+		}
+		totalMem += stat.RSS
+	}
+
+	var avgMem float64
+	if len(res.ServerMemoryStats) > 0 {
+		avgMem = float64(totalMem) / float64(len(res.ServerMemoryStats)) / (1024 * 1024)
+	}
+
+	summary := resultfile.ProviderResult{
+		Requests:           res.Metrics.Requests,
+		Route:              res.Route,
+		Tags:               res.Tags,
+		TargetRate:         res.TargetRate,
+		VirtualUsers:       res.VirtualUsers,
+		ArrivalTrace:       res.ArrivalTrace,
+		Engine:             res.Engine,
+		DurationSec:        res.DurationSec,
+		Rate:               res.Metrics.Rate,
+		SuccessRate:        100.0 * res.Metrics.Success,
+		MeanLatencyMs:      float64(res.Metrics.Latencies.Mean) / float64(time.Millisecond),
+		P50LatencyMs:       float64(res.Metrics.Latencies.P50) / float64(time.Millisecond),
+		P99LatencyMs:       float64(res.Metrics.Latencies.P99) / float64(time.Millisecond),
+		MaxLatencyMs:       float64(res.Metrics.Latencies.Max) / float64(time.Millisecond),
+		ThroughputRPS:      res.Metrics.Throughput,
+		Timestamp:          formatTimestamp(time.Now()),
+		AttackWindow:       res.Window,
+		PricePerformance:   res.PricePerformance,
+		BodySizes:          res.BodySizes,
+		StatusCodeCounts:   statusCodes,
+		ServerPeakMemoryMB: float64(peakMem) / (1024 * 1024),
+		ServerAvgMemoryMB:  avgMem,
+		ServerAvgCPU:       res.CPUUsage,
+		InvalidResponses:   res.InvalidResponses,
+		ValidSuccessRate:   validSuccessRate(res.Metrics, res.InvalidResponses),
+		P999LatencyMs:      float64(res.P999.Value) / float64(time.Millisecond),
+		P99CILowMs:         float64(res.P99.Low) / float64(time.Millisecond),
+		P99CIHighMs:        float64(res.P99.High) / float64(time.Millisecond),
+		P999CILowMs:        float64(res.P999.Low) / float64(time.Millisecond),
+		P999CIHighMs:       float64(res.P999.High) / float64(time.Millisecond),
+		Warnings:           convergenceWarnings(res.P99, res.P999),
+		LateRequests:       res.Scheduling.Late,
+		MaxScheduleLagMs:   float64(res.Scheduling.MaxLag) / float64(time.Millisecond),
+		GeneratorSaturated: res.Scheduling.Saturated,
+		LeakWarnings:       res.LeakWarnings,
+		Sockets:            res.Sockets,
+		SlowestRequests:    res.SlowestRequests,
+		FailedRequests:     res.FailedRequests,
+		Assertions:         res.Assertions,
+		ClientTimeouts:     res.ClientTimeouts,
+		ServerTimeouts:     res.ServerTimeouts,
+		GatewayRuntime:     res.GatewayRuntime,
+		Profiles:           res.Profiles,
+		BodyChecks:         res.BodyChecks,
+		ConnectionPhases:   res.ConnectionPhases,
+		HTTPClient:         res.HTTPClient.Summary(),
+		OmissionCorrected:  res.OmissionCorrected,
+		WallLatency:        latencySummary(res.WallLatency),
+		CorrectedLatency:   latencySummary(res.CorrectedLatency),
+		SteadyState:        res.SteadyState,
+		Protocols:          res.Protocols,
+		Repeat:             res.Repeat,
+		ErrorBudget:        res.ErrorBudget,
+		// DropReasons:        res.DropReasons, // Include drop reasons in output
+	}
+
+	if warning := res.Scheduling.Warning(); warning != "" {
+		summary.Warnings = append(summary.Warnings, warning)
+	}
+	summary.Warnings = append(summary.Warnings, portWarnings(res.Sockets)...)
+
+	if res.UpstreamRequests >= 0 {
+		upstream := res.UpstreamRequests
+		amplification := requestAmplification(upstream, res.Metrics.Requests)
+		summary.UpstreamRequests = &upstream
+		summary.Amplification = &amplification
+	}
+
+	return summary
+}
+
+func SaveResults(results []Result, outputFile string) error {
+	// Keep the other providers' results, migrating files written by older versions
+	file := resultfile.New()
+	if _, err := os.Stat(outputFile); err == nil {
+		existing, err := resultfile.Load(outputFile)
+		if err != nil {
+			log.Printf("Warning: Could not load existing results file: %v", err)
+		} else {
+			file = existing
+		}
+	}
+
+	// Update or add new results
+	for _, res := range results {
+		file.Providers[strings.ToLower(res.ProviderName)] = serializeResult(res)
+	}
+
+	if err := file.Save(outputFile); err != nil {
+		return fmt.Errorf("failed to save results: %v", err)
+	}
+
+	fmt.Printf("Results saved to %s\n", outputFile)
+	return nil
+}
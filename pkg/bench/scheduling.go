@@ -0,0 +1,124 @@
+package bench
+
+import (
+	"fmt"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+const (
+	minLateThreshold   = time.Millisecond
+	maxLateFraction    = 0.01 // More late requests than this means the generator fell behind
+	minAchievedPercent = 95   // Achieved rate below this share of the offered rate is saturation
+)
+
+// SchedulingReport compares the load the generator was asked for with what it delivered
+type SchedulingReport struct {
+	OfferedRate  float64       // Requests per second requested
+	AchievedRate float64       // Requests per second actually sent
+	Late         int           // Requests sent more than a request interval after their scheduled time
+	MaxLag       time.Duration // Worst delay between scheduled and actual send time
+	Saturated    bool          // The load generator, not the target, limited the run
+}
+
+// checkScheduling reconstructs each request's scheduled send time from its sequence number
+// (the first request plus seq intervals at a constant rate, or the seq-th arrival of a trace)
+// and measures how far behind the actual send time was. A generator short of CPU or sockets
+// sends late, which lowers the offered load and hides latency from the results.
+func checkScheduling(samples []RequestSample, rate int, metrics *vegeta.Metrics, trace *ArrivalTrace) SchedulingReport {
+	report := SchedulingReport{OfferedRate: float64(rate), AchievedRate: metrics.Rate}
+	if rate <= 0 || len(samples) == 0 {
+		return report
+	}
+
+	interval := time.Second / time.Duration(rate)
+	threshold := max(interval, minLateThreshold)
+	due := func(seq uint64) time.Duration { return time.Duration(seq) * interval }
+	if trace != nil {
+		due = trace.Offset
+	}
+
+	var start time.Time
+	for _, s := range samples {
+		if s.Seq == 0 {
+			start = s.SentAt
+			break
+		}
+	}
+	if start.IsZero() {
+		return report
+	}
+
+	for _, s := range samples {
+		lag := scheduleLag(s.SentAt, start, due(s.Seq))
+		report.MaxLag = max(report.MaxLag, lag)
+		if lag > threshold {
+			report.Late++
+		}
+	}
+
+	report.Saturated = float64(report.Late) > maxLateFraction*float64(len(samples)) ||
+		report.AchievedRate < report.OfferedRate*minAchievedPercent/100
+	return report
+}
+
+// scheduleLag is how long after its scheduled time, due after start, a request was sent
+func scheduleLag(sentAt, start time.Time, due time.Duration) time.Duration {
+	return sentAt.Sub(start.Add(due))
+}
+
+// correctOmission adds a request's schedule lag to its latency. A target that stalls holds up
+// the requests queued behind it, and measuring those from when they were sent rather than when
+// they should have been hides the stall (coordinated omission). The corrected latency is what a
+// client sending on schedule would have waited.
+func correctOmission(latency time.Duration, sentAt, start time.Time, due time.Duration) time.Duration {
+	return latency + max(scheduleLag(sentAt, start, due), 0)
+}
+
+// LatencySummary is the latency distribution of a run, measured either from the actual send
+// time (wall) or from the scheduled send time (corrected for coordinated omission)
+type LatencySummary struct {
+	Mean, P50, P99, P999, Max time.Duration
+}
+
+// summarizeLatencies computes the mean, percentiles and maximum of a run's latencies
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	var summary LatencySummary
+	if len(latencies) == 0 {
+		return summary
+	}
+	sorted := sortedLatencies(latencies)
+	var total time.Duration
+	for _, l := range sorted {
+		total += l
+	}
+	summary.Mean = total / time.Duration(len(sorted))
+	summary.P50 = estimatePercentile(sorted, 0.5).Value
+	summary.P99 = estimatePercentile(sorted, 0.99).Value
+	summary.P999 = estimatePercentile(sorted, 0.999).Value
+	summary.Max = sorted[len(sorted)-1]
+	return summary
+}
+
+// latencySummary converts a summary to the results file's milliseconds
+func latencySummary(s LatencySummary) *resultfile.LatencySummary {
+	return &resultfile.LatencySummary{
+		MeanMs: float64(s.Mean) / float64(time.Millisecond),
+		P50Ms:  float64(s.P50) / float64(time.Millisecond),
+		P99Ms:  float64(s.P99) / float64(time.Millisecond),
+		P999Ms: float64(s.P999) / float64(time.Millisecond),
+		MaxMs:  float64(s.Max) / float64(time.Millisecond),
+	}
+}
+
+// Warning explains a saturated load generator, or returns "" when the offered load was delivered
+func (r SchedulingReport) Warning() string {
+	if !r.Saturated {
+		return ""
+	}
+	return fmt.Sprintf("load generator could not keep up: achieved %.2f of %.0f requests/s offered, %d requests sent late "+
+		"(max lag %s); the runner was likely the bottleneck, not the target", r.AchievedRate, r.OfferedRate, r.Late, r.MaxLag.Round(time.Microsecond))
+}
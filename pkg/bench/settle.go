@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// serverBaseline is a target's resource usage while idle, before it is attacked
+type serverBaseline struct {
+	RSS uint64
+	CPU float64 // Percent of one core
+}
+
+// measureBaseline samples the RSS and CPU usage of the target processes over one second
+func measureBaseline(p *processGroup) (serverBaseline, error) {
+	memInfo, err := p.MemoryInfo()
+	if err != nil {
+		return serverBaseline{}, fmt.Errorf("failed to read memory info: %v", err)
+	}
+	cpu, err := p.Percent(time.Second)
+	if err != nil {
+		return serverBaseline{}, fmt.Errorf("failed to read CPU usage: %v", err)
+	}
+	return serverBaseline{RSS: memInfo.RSS, CPU: cpu}, nil
+}
+
+// waitForSettle blocks until the process's RSS is within rssTolerance percent of the baseline
+// and its CPU usage within cpuTolerance percentage points of it, or until maxWait passes.
+// It reports whether the process settled.
+func waitForSettle(p *processGroup, baseline serverBaseline, rssTolerance float64, cpuTolerance float64, maxWait time.Duration) bool {
+	deadline := time.Now().Add(maxWait)
+	for {
+		current, err := measureBaseline(p)
+		if err != nil {
+			log.Printf("Warning: Could not sample server while cooling down: %v", err)
+			return false
+		}
+
+		rssDelta := percentChange(float64(baseline.RSS), float64(current.RSS))
+		cpuDelta := current.CPU - baseline.CPU
+		fmt.Printf("  RSS %+.1f%% of baseline, CPU %.1f%% (baseline %.1f%%)\n", rssDelta, current.CPU, baseline.CPU)
+		if rssDelta <= rssTolerance && cpuDelta <= cpuTolerance {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
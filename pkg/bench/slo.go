@@ -0,0 +1,136 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// SLOFailureExitCode is the exit status when any -assert fails, distinct from the status 1 of
+// runs that could not complete
+const SLOFailureExitCode = 3
+
+// sloMetric reads one metric from a provider's results
+type sloMetric struct {
+	value    func(r resultfile.ProviderResult) float64
+	duration bool // Thresholds are durations (e.g. 50ms), bare numbers are milliseconds
+}
+
+var sloMetrics = map[string]sloMetric{
+	"mean":            {func(r resultfile.ProviderResult) float64 { return r.MeanLatencyMs }, true},
+	"p50":             {func(r resultfile.ProviderResult) float64 { return r.P50LatencyMs }, true},
+	"p99":             {func(r resultfile.ProviderResult) float64 { return r.P99LatencyMs }, true},
+	"p999":            {func(r resultfile.ProviderResult) float64 { return r.P999LatencyMs }, true},
+	"max":             {func(r resultfile.ProviderResult) float64 { return r.MaxLatencyMs }, true},
+	"success":         {func(r resultfile.ProviderResult) float64 { return r.SuccessRate }, false},
+	"valid_success":   {func(r resultfile.ProviderResult) float64 { return r.ValidSuccessRate }, false},
+	"throughput":      {func(r resultfile.ProviderResult) float64 { return r.ThroughputRPS }, false},
+	"client_timeouts": {func(r resultfile.ProviderResult) float64 { return float64(r.ClientTimeouts) }, false},
+	"server_timeouts": {func(r resultfile.ProviderResult) float64 { return float64(r.ServerTimeouts) }, false},
+	"peak_memory_mb":  {func(r resultfile.ProviderResult) float64 { return r.ServerPeakMemoryMB }, false},
+}
+
+// sloOperators are matched in order, so two character operators win over their prefixes
+var sloOperators = []string{"<=", ">=", "<", ">"}
+
+// SLOAssertion is one parsed -assert condition, e.g. p99<50ms
+type SLOAssertion struct {
+	Expr      string
+	Metric    string
+	Op        string
+	Threshold float64 // Milliseconds for latency metrics
+}
+
+// ParseAssertions parses a comma separated list of conditions such as "p99<50ms,success>99.5"
+func ParseAssertions(s string) ([]SLOAssertion, error) {
+	var assertions []SLOAssertion
+	for _, expr := range strings.Split(s, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+
+		a := SLOAssertion{Expr: expr}
+		var value string
+		for _, op := range sloOperators {
+			if i := strings.Index(expr, op); i > 0 {
+				a.Metric, a.Op, value = strings.TrimSpace(expr[:i]), op, strings.TrimSpace(expr[i+len(op):])
+				break
+			}
+		}
+		if a.Op == "" {
+			return nil, fmt.Errorf("%q has no comparison (use <, <=, > or >=)", expr)
+		}
+
+		metric, ok := sloMetrics[strings.ToLower(a.Metric)]
+		if !ok {
+			return nil, fmt.Errorf("%q: unknown metric %q", expr, a.Metric)
+		}
+		a.Metric = strings.ToLower(a.Metric)
+
+		threshold, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil && metric.duration {
+			var d time.Duration
+			if d, err = time.ParseDuration(value); err == nil {
+				threshold = float64(d) / float64(time.Millisecond)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%q: invalid threshold %q", expr, value)
+		}
+		a.Threshold = threshold
+
+		assertions = append(assertions, a)
+	}
+	return assertions, nil
+}
+
+// Check evaluates the assertion against a provider's results
+func (a SLOAssertion) Check(r resultfile.ProviderResult) resultfile.AssertionResult {
+	actual := sloMetrics[a.Metric].value(r)
+
+	var passed bool
+	switch a.Op {
+	case "<":
+		passed = actual < a.Threshold
+	case "<=":
+		passed = actual <= a.Threshold
+	case ">":
+		passed = actual > a.Threshold
+	case ">=":
+		passed = actual >= a.Threshold
+	}
+
+	return resultfile.AssertionResult{
+		Assertion: a.Expr,
+		Metric:    a.Metric,
+		Actual:    actual,
+		Threshold: a.Threshold,
+		Passed:    passed,
+	}
+}
+
+// EvaluateAssertions checks every assertion against every provider, prints a pass/fail table
+// and reports whether all of them passed
+func EvaluateAssertions(results []Result, assertions []SLOAssertion) bool {
+	allPassed := true
+	fmt.Println("\nSLO Assertions:")
+	for i := range results {
+		summary := serializeResult(results[i])
+		for _, a := range assertions {
+			check := a.Check(summary)
+			results[i].Assertions = append(results[i].Assertions, check)
+
+			status := "PASS"
+			if !check.Passed {
+				status = "FAIL"
+				allPassed = false
+			}
+			fmt.Printf("  %-4s %-12s %-24s actual %s\n", status, results[i].ProviderName, a.Expr, report.Float(check.Actual, 3))
+		}
+	}
+	return allPassed
+}
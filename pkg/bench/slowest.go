@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// slowestTracker keeps the n slowest results of an attack in a min-heap, so the fastest of
+// them is replaced first
+type slowestTracker struct {
+	n       int
+	results slowHeap
+}
+
+type slowHeap []vegeta.Result
+
+func (h slowHeap) Len() int            { return len(h) }
+func (h slowHeap) Less(i, j int) bool  { return h[i].Latency < h[j].Latency }
+func (h slowHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowHeap) Push(x interface{}) { *h = append(*h, x.(vegeta.Result)) }
+func (h *slowHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func newSlowestTracker(n int) *slowestTracker {
+	return &slowestTracker{n: n}
+}
+
+// Add considers a result, keeping a copy without its body
+func (t *slowestTracker) Add(res *vegeta.Result) {
+	if len(t.results) == t.n && res.Latency <= t.results[0].Latency {
+		return
+	}
+	kept := *res
+	kept.Body = nil
+	if len(t.results) < t.n {
+		heap.Push(&t.results, kept)
+		return
+	}
+	t.results[0] = kept
+	heap.Fix(&t.results, 0)
+}
+
+// Requests returns the kept requests, slowest first
+func (t *slowestTracker) Requests() []resultfile.SlowRequest {
+	sorted := append(slowHeap(nil), t.results...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Latency > sorted[j].Latency })
+
+	requests := make([]resultfile.SlowRequest, 0, len(sorted))
+	for _, res := range sorted {
+		requests = append(requests, resultfile.SlowRequest{
+			Seq:          res.Seq,
+			Timestamp:    res.Timestamp.UTC().Format(time.RFC3339Nano),
+			LatencyMs:    float64(res.Latency) / float64(time.Millisecond),
+			Code:         int(res.Code),
+			BytesIn:      res.BytesIn,
+			BytesOut:     res.BytesOut,
+			Error:        res.Error,
+			ServerTiming: parseServerTiming(res.Headers.Values("Server-Timing")),
+		})
+	}
+	return requests
+}
+
+// parseServerTiming reads the durations, in milliseconds, of Server-Timing header entries such
+// as "queue_wait;dur=0.4, http_request;dur=12.1". Entries without a duration are skipped.
+func parseServerTiming(values []string) map[string]float64 {
+	var timings map[string]float64
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			params := strings.Split(entry, ";")
+			name := strings.TrimSpace(params[0])
+			for _, param := range params[1:] {
+				key, dur, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || key != "dur" {
+					continue
+				}
+				ms, err := strconv.ParseFloat(dur, 64)
+				if err != nil || name == "" {
+					continue
+				}
+				if timings == nil {
+					timings = make(map[string]float64)
+				}
+				timings[name] = ms
+			}
+		}
+	}
+	return timings
+}
+
+// printSlowestRequests prints the slowest requests with the target's timing breakdown, if it sent one
+func printSlowestRequests(requests []resultfile.SlowRequest) {
+	if len(requests) == 0 {
+		return
+	}
+	fmt.Printf("  Slowest Requests:\n")
+	for _, r := range requests {
+		line := fmt.Sprintf("    #%d at %s: %s, HTTP %d, %s bytes in", r.Seq, r.Timestamp,
+			report.Duration(msDuration(r.LatencyMs)), r.Code, report.Int(int64(r.BytesIn)))
+		if r.Error != "" {
+			line += fmt.Sprintf(", error: %s", r.Error)
+		}
+		fmt.Println(line)
+
+		if len(r.ServerTiming) > 0 {
+			names := make([]string, 0, len(r.ServerTiming))
+			for name := range r.ServerTiming {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			parts := make([]string, 0, len(names))
+			for _, name := range names {
+				parts = append(parts, fmt.Sprintf("%s %s", name, report.Duration(msDuration(r.ServerTiming[name]))))
+			}
+			fmt.Printf("      server: %s\n", strings.Join(parts, ", "))
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// SoakSnapshot captures the state of a target at one point of a soak test
+type SoakSnapshot struct {
+	Provider      string  `json:"provider"`
+	Timestamp     string  `json:"timestamp"`
+	ElapsedSec    float64 `json:"elapsed_sec"`
+	Requests      uint64  `json:"requests"`
+	SuccessRate   float64 `json:"success_rate"`
+	MeanLatencyMs float64 `json:"mean_latency_ms"`
+	P50LatencyMs  float64 `json:"p50_latency_ms"`
+	P99LatencyMs  float64 `json:"p99_latency_ms"`
+	RSSMB         float64 `json:"rss_mb"`
+	NumFDs        int32   `json:"num_fds"`
+	NumThreads    int32   `json:"num_threads"`
+	Goroutines    int     `json:"goroutines"` // -1 when the target does not expose it
+}
+
+// soakRecorder accumulates per-interval metrics and writes a snapshot every interval. Snapshots
+// are taken on their own goroutine, so sampling the target never holds up the results.
+type soakRecorder struct {
+	provider   string
+	interval   time.Duration
+	proc       *processGroup
+	metricsURL string
+	encoder    *json.Encoder
+
+	mu     sync.Mutex
+	window vegeta.Metrics // Results since the last snapshot
+
+	started   time.Time
+	snapshots []SoakSnapshot
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newSoakRecorder(provider string, interval time.Duration, proc *processGroup, metricsURL string, out *os.File) *soakRecorder {
+	return &soakRecorder{
+		provider:   provider,
+		interval:   interval,
+		proc:       proc,
+		metricsURL: metricsURL,
+		encoder:    json.NewEncoder(out),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start begins writing a snapshot every interval until Stop is called
+func (s *soakRecorder) Start() {
+	s.started = time.Now()
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.snapshot()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the snapshots and writes a last one for the results since the previous
+func (s *soakRecorder) Stop() {
+	close(s.stop)
+	<-s.done
+	s.snapshot()
+}
+
+// Add records a result in the current window
+func (s *soakRecorder) Add(res *vegeta.Result) {
+	s.mu.Lock()
+	s.window.Add(res)
+	s.mu.Unlock()
+}
+
+// snapshot writes a snapshot of the current window and starts a new one
+func (s *soakRecorder) snapshot() {
+	s.mu.Lock()
+	window := s.window
+	s.window = vegeta.Metrics{}
+	s.mu.Unlock()
+	window.Close()
+
+	now := time.Now()
+	snapshot := SoakSnapshot{
+		Provider:      strings.ToLower(s.provider),
+		Timestamp:     formatTimestamp(now),
+		ElapsedSec:    now.Sub(s.started).Seconds(),
+		Requests:      window.Requests,
+		SuccessRate:   100.0 * window.Success,
+		MeanLatencyMs: float64(window.Latencies.Mean) / float64(time.Millisecond),
+		P50LatencyMs:  float64(window.Latencies.P50) / float64(time.Millisecond),
+		P99LatencyMs:  float64(window.Latencies.P99) / float64(time.Millisecond),
+		Goroutines:    fetchGoroutines(s.metricsURL),
+	}
+
+	if s.proc != nil {
+		if memInfo, err := s.proc.MemoryInfo(); err == nil {
+			snapshot.RSSMB = float64(memInfo.RSS) / (1024 * 1024)
+		}
+		if fds, err := s.proc.NumFDs(); err == nil {
+			snapshot.NumFDs = fds
+		}
+		if threads, err := s.proc.NumThreads(); err == nil {
+			snapshot.NumThreads = threads
+		}
+	}
+
+	if err := s.encoder.Encode(snapshot); err != nil {
+		log.Printf("Warning: Could not write soak snapshot: %v", err)
+	}
+	s.snapshots = append(s.snapshots, snapshot)
+
+	fmt.Printf("  [%s] %s: %s requests, %s%% success, P99 %s, RSS %s MB, FDs %d, goroutines %d\n",
+		time.Duration(snapshot.ElapsedSec*float64(time.Second)).Round(time.Second), s.provider,
+		report.Int(int64(snapshot.Requests)), report.Float(snapshot.SuccessRate, 2), report.Duration(window.Latencies.P99),
+		report.Float(snapshot.RSSMB, 2), snapshot.NumFDs, snapshot.Goroutines)
+}
+
+// PrintDrift compares the first and last snapshots to surface leaks and slow degradation
+func (s *soakRecorder) PrintDrift() {
+	if len(s.snapshots) < 2 {
+		fmt.Println("  Not enough soak snapshots to compute drift")
+		return
+	}
+
+	first, last := s.snapshots[0], s.snapshots[len(s.snapshots)-1]
+	fmt.Printf("  Soak Drift (first -> last snapshot):\n")
+	fmt.Printf("    RSS: %.2f MB -> %.2f MB (%+.1f%%)\n", first.RSSMB, last.RSSMB, percentChange(first.RSSMB, last.RSSMB))
+	fmt.Printf("    P99 Latency: %.2f ms -> %.2f ms (%+.1f%%)\n", first.P99LatencyMs, last.P99LatencyMs, percentChange(first.P99LatencyMs, last.P99LatencyMs))
+	fmt.Printf("    FDs: %d -> %d\n", first.NumFDs, last.NumFDs)
+	if first.Goroutines >= 0 && last.Goroutines >= 0 {
+		fmt.Printf("    Goroutines: %d -> %d\n", first.Goroutines, last.Goroutines)
+	}
+}
+
+func percentChange(from, to float64) float64 {
+	if from == 0 {
+		return 0
+	}
+	return 100.0 * (to - from) / from
+}
+
+// fetchGoroutines reads the goroutine count from a gateway metrics endpoint, or -1 if unavailable
+func fetchGoroutines(metricsURL string) int {
+	if metricsURL == "" {
+		return -1
+	}
+
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(metricsURL)
+	if err != nil {
+		return -1
+	}
+	defer resp.Body.Close()
+
+	var metrics struct {
+		Goroutines *int `json:"goroutines"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil || metrics.Goroutines == nil {
+		return -1
+	}
+	return *metrics.Goroutines
+}
+
+// fetchGatewayRuntime reads the Go runtime settings from a gateway metrics endpoint, or nil if unavailable
+func fetchGatewayRuntime(metricsURL string) *resultfile.GatewayRuntime {
+	if metricsURL == "" {
+		return nil
+	}
+
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(metricsURL)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var metrics struct {
+		Runtime *resultfile.GatewayRuntime `json:"runtime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+		return nil
+	}
+	return metrics.Runtime
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	psnet "github.com/shirou/gopsutil/net"
+)
+
+// unixScheme marks endpoints served on a unix domain socket, e.g. unix:///tmp/bifrost.sock
+const unixScheme = "unix://"
+
+// unixSocketPath returns the socket path of a unix:// endpoint, or "" for network endpoints
+func unixSocketPath(endpoint string) string {
+	if !strings.HasPrefix(endpoint, unixScheme) {
+		return ""
+	}
+	return strings.TrimPrefix(endpoint, unixScheme)
+}
+
+// localURL builds a URL on the local host, bracketing IPv6 literals such as ::1
+func localURL(host string, port string, path string) string {
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, port), path)
+}
+
+// urlPort returns the port of an http(s) URL, including the scheme's default port
+func urlPort(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	if port := u.Port(); port != "" {
+		return port
+	}
+	switch u.Scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+// unixDialer dials the socket whatever address the request URL names
+func unixDialer(socket string) func(ctx context.Context, network string, addr string) (net.Conn, error) {
+	return func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+}
+
+// findServerProcess finds the processes serving a provider, by its unix socket or its port
+func findServerProcess(provider Target) (*processGroup, error) {
+	if provider.Socket != "" {
+		return getProcessBySocket(provider.Socket)
+	}
+	if provider.Protocol == ProtocolHTTP3 {
+		// QUIC servers listen on UDP, often next to a TCP listener on the same port
+		if p, err := getProcessByUDPPort(provider.Port); err == nil {
+			return p, nil
+		}
+	}
+	return getProcessByPort(provider.Port)
+}
+
+func getProcessBySocket(socket string) (*processGroup, error) {
+	conns, err := psnet.Connections("unix")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connections: %v", err)
+	}
+
+	// Only the server side of a unix socket carries its path
+	var pids []int32
+	for _, conn := range conns {
+		if conn.Laddr.IP == socket {
+			pids = append(pids, conn.Pid)
+		}
+	}
+	return newProcessGroup(pids, "socket "+socket)
+}
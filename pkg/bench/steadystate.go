@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+const (
+	// steadyMinSeconds is the shortest run steady-state detection is attempted on
+	steadyMinSeconds = 6
+	// steadyShift is the change of the rolling P99 that counts as a transient, 25%
+	steadyShift = 0.25
+	// steadyMaxTrim is the largest fraction of the run trimmed at either end
+	steadyMaxTrim = 1.0 / 3
+)
+
+// steadyState finds the steady-state part of a run and summarizes it. The P99 of every second,
+// smoothed over its neighbours, forms a series; a least-squares changepoint in the first third
+// ends the warm-up transient and one in the last third starts the drain tail, if the P99 level
+// shifts by more than steadyShift across it. Requests count against the second they were sent
+// in. Runs shorter than steadyMinSeconds return nil.
+func steadyState(samples []RequestSample, start time.Time) *resultfile.SteadyState {
+	var buckets [][]RequestSample
+	for _, s := range samples {
+		second := max(int(s.SentAt.Sub(start)/time.Second), 0)
+		for len(buckets) <= second {
+			buckets = append(buckets, nil)
+		}
+		buckets[second] = append(buckets[second], s)
+	}
+	n := len(buckets)
+	if n < steadyMinSeconds {
+		return nil
+	}
+
+	// Log P99 over each second and its neighbours; seconds without requests repeat the last level
+	series := make([]float64, n)
+	for i := range buckets {
+		var window []time.Duration
+		for j := max(i-1, 0); j <= min(i+1, n-1); j++ {
+			for _, s := range buckets[j] {
+				window = append(window, s.Latency)
+			}
+		}
+		if len(window) == 0 {
+			if i > 0 {
+				series[i] = series[i-1]
+			}
+			continue
+		}
+		p99 := estimatePercentile(sortedLatencies(window), 0.99).Value
+		series[i] = math.Log(float64(max(p99, time.Microsecond)))
+	}
+
+	maxTrim := int(float64(n) * steadyMaxTrim)
+	warmup := 0
+	if k, shift := changepoint(series, 1, maxTrim); shift > math.Log(1+steadyShift) {
+		warmup = k
+	}
+	rest := series[warmup:]
+	tail := 0
+	if k, shift := changepoint(rest, len(rest)-maxTrim, len(rest)-1); shift > math.Log(1+steadyShift) {
+		tail = len(rest) - k
+	}
+
+	var latencies []time.Duration
+	ok := 0
+	for _, bucket := range buckets[warmup : n-tail] {
+		for _, s := range bucket {
+			latencies = append(latencies, s.Latency)
+			if s.Outcome == "ok" {
+				ok++
+			}
+		}
+	}
+	steady := &resultfile.SteadyState{
+		StartSec:       float64(warmup),
+		EndSec:         float64(n - tail),
+		WarmupTrimSec:  float64(warmup),
+		TailTrimSec:    float64(tail),
+		Requests:       len(latencies),
+		TrimmedPercent: 100 * float64(len(samples)-len(latencies)) / float64(len(samples)),
+	}
+	if len(latencies) > 0 {
+		steady.SuccessRate = 100 * float64(ok) / float64(len(latencies))
+		steady.Latency = latencySummary(summarizeLatencies(latencies))
+	}
+	return steady
+}
+
+// changepoint returns the split k in [lo, hi] that best divides series into two levels, by
+// least squares, and how far apart the levels are. It returns 0, 0 when there is no split.
+func changepoint(series []float64, lo, hi int) (int, float64) {
+	lo, hi = max(lo, 1), min(hi, len(series)-1)
+	best, bestCost, shift := 0, math.Inf(1), 0.0
+	for k := lo; k <= hi; k++ {
+		left, leftCost := meanAndSSE(series[:k])
+		right, rightCost := meanAndSSE(series[k:])
+		if cost := leftCost + rightCost; cost < bestCost {
+			best, bestCost, shift = k, cost, math.Abs(left-right)
+		}
+	}
+	return best, shift
+}
+
+// meanAndSSE returns the mean of values and the sum of their squared deviations from it
+func meanAndSSE(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sse float64
+	for _, v := range values {
+		sse += (v - mean) * (v - mean)
+	}
+	return mean, sse
+}
+
+// printSteadyState prints the trimmed metrics next to the raw ones when a transient was trimmed
+func printSteadyState(s *resultfile.SteadyState, rawP99 time.Duration) {
+	if s == nil || (s.WarmupTrimSec == 0 && s.TailTrimSec == 0) || s.Latency == nil {
+		return
+	}
+	ms := func(v float64) string { return report.Duration(msDuration(v)) }
+	fmt.Printf("  Steady State: %ss to %ss (trimmed %ss warm-up, %ss tail, %s%% of requests)\n", report.Float(s.StartSec, 0), report.Float(s.EndSec, 0),
+		report.Float(s.WarmupTrimSec, 0), report.Float(s.TailTrimSec, 0), report.Float(s.TrimmedPercent, 1))
+	fmt.Printf("    P50 %s, P99 %s (raw %s), success %s%%\n", ms(s.Latency.P50Ms), ms(s.Latency.P99Ms), report.Duration(rawP99), report.Float(s.SuccessRate, 2))
+}
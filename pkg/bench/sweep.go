@@ -0,0 +1,280 @@
+package bench
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SweepConfig describes the gateway settings to sweep over
+type SweepConfig struct {
+	Binary            string   // Path to the gateway binary
+	ExtraArgs         []string // Extra arguments passed to every gateway run
+	Concurrency       []int    // Bifrost provider concurrency values
+	BufferSize        []int    // Bifrost provider buffer size values
+	InitialPoolSize   []int    // Bifrost initial pool size values
+	ServerConcurrency []int    // fasthttp server concurrency values
+	GOMAXPROCS        []int    // Gateway GOMAXPROCS values, 0 for the gateway's default
+	GOGC              []string // Gateway GOGC values, "" for the default
+	GOMEMLIMIT        []string // Gateway GOMEMLIMIT values, "" for the default
+	StartupTimeout    time.Duration
+	Output            string
+}
+
+// SweepPoint is one parameter set of the sweep together with its results
+type SweepPoint struct {
+	Concurrency       int
+	BufferSize        int
+	InitialPoolSize   int
+	ServerConcurrency int
+	GOMAXPROCS        int
+	GOGC              string
+	GOMEMLIMIT        string
+
+	ThroughputRPS float64
+	SuccessRate   float64
+	P99LatencyMs  float64
+	PeakMemoryMB  float64
+	Err           error
+}
+
+// ParseIntList parses a comma separated list of integers
+func ParseIntList(s string) ([]int, error) {
+	var values []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q: %v", part, err)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return values, nil
+}
+
+// ParseStringList parses a comma separated list of strings, keeping empty values
+func ParseStringList(s string) []string {
+	values := strings.Split(s, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// RunSweep restarts the gateway with every combination of settings, runs the same scenario
+// against it and prints a tuning table
+func RunSweep(provider Target, cfg SweepConfig, opts Scenario) []SweepPoint {
+	// Expand every combination, with the settings listed last varying fastest
+	points := []SweepPoint{{}}
+	cross := func(n int, set func(p *SweepPoint, i int)) {
+		next := make([]SweepPoint, 0, len(points)*n)
+		for _, p := range points {
+			for i := 0; i < n; i++ {
+				set(&p, i)
+				next = append(next, p)
+			}
+		}
+		points = next
+	}
+	cross(len(cfg.Concurrency), func(p *SweepPoint, i int) { p.Concurrency = cfg.Concurrency[i] })
+	cross(len(cfg.BufferSize), func(p *SweepPoint, i int) { p.BufferSize = cfg.BufferSize[i] })
+	cross(len(cfg.InitialPoolSize), func(p *SweepPoint, i int) { p.InitialPoolSize = cfg.InitialPoolSize[i] })
+	cross(len(cfg.ServerConcurrency), func(p *SweepPoint, i int) { p.ServerConcurrency = cfg.ServerConcurrency[i] })
+	cross(len(cfg.GOMAXPROCS), func(p *SweepPoint, i int) { p.GOMAXPROCS = cfg.GOMAXPROCS[i] })
+	cross(len(cfg.GOGC), func(p *SweepPoint, i int) { p.GOGC = cfg.GOGC[i] })
+	cross(len(cfg.GOMEMLIMIT), func(p *SweepPoint, i int) { p.GOMEMLIMIT = cfg.GOMEMLIMIT[i] })
+
+	// The gateway reports the runtime settings it actually used on /metrics
+	if provider.MetricsURL == "" {
+		provider.MetricsURL = "http://localhost:" + provider.Port + "/metrics"
+	}
+
+	// Cooldown is applied between sweep points rather than between providers
+	cooldown := opts.Cooldown
+	opts.Cooldown = 0
+
+	for i := range points {
+		point := &points[i]
+		fmt.Printf("\nSweep %d/%d: concurrency=%d buffer-size=%d initial-pool-size=%d server-concurrency=%d gomaxprocs=%s gogc=%s gomemlimit=%s\n",
+			i+1, len(points), point.Concurrency, point.BufferSize, point.InitialPoolSize, point.ServerConcurrency,
+			orDefault(strconv.Itoa(point.GOMAXPROCS), "0"), orDefault(point.GOGC, ""), orDefault(point.GOMEMLIMIT, ""))
+
+		cmd, err := startGateway(provider.Port, cfg, *point)
+		if err != nil {
+			point.Err = err
+			log.Printf("Warning: %v", err)
+			continue
+		}
+
+		results := runBenchmarks(context.Background(), []Target{provider}, opts)
+		stopGateway(cmd)
+
+		if len(results) > 0 {
+			res := results[0]
+			var peakMem uint64
+			for _, stat := range res.ServerMemoryStats {
+				if stat.RSS > peakMem {
+					peakMem = stat.RSS
+				}
+			}
+			point.ThroughputRPS = res.Metrics.Throughput
+			point.SuccessRate = 100.0 * res.Metrics.Success
+			point.P99LatencyMs = float64(res.Metrics.Latencies.P99) / float64(time.Millisecond)
+			point.PeakMemoryMB = float64(peakMem) / (1024 * 1024)
+		}
+
+		if i < len(points)-1 && cooldown > 0 {
+			fmt.Printf("Cooling down for %d seconds...\n", cooldown)
+			time.Sleep(time.Duration(cooldown) * time.Second)
+		}
+	}
+
+	printSweepTable(points)
+	if cfg.Output != "" {
+		saveSweep(points, cfg.Output)
+	}
+	return points
+}
+
+// startGateway launches the gateway binary with the given settings and waits for it to listen
+func startGateway(port string, cfg SweepConfig, point SweepPoint) (*exec.Cmd, error) {
+	args := []string{
+		"-port", port,
+		"-concurrency", strconv.Itoa(point.Concurrency),
+		"-buffer-size", strconv.Itoa(point.BufferSize),
+		"-initial-pool-size", strconv.Itoa(point.InitialPoolSize),
+		"-server-concurrency", strconv.Itoa(point.ServerConcurrency),
+	}
+	if point.GOMAXPROCS > 0 {
+		args = append(args, "-gomaxprocs", strconv.Itoa(point.GOMAXPROCS))
+	}
+	if point.GOGC != "" {
+		args = append(args, "-gogc", point.GOGC)
+	}
+	if point.GOMEMLIMIT != "" {
+		args = append(args, "-gomemlimit", point.GOMEMLIMIT)
+	}
+	if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+		args = append(args, "-openai-key", key)
+	}
+	args = append(args, cfg.ExtraArgs...)
+
+	cmd := exec.Command(cfg.Binary, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start gateway: %v", err)
+	}
+
+	deadline := time.Now().Add(cfg.StartupTimeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", "localhost:"+port, 500*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return cmd, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	stopGateway(cmd)
+	return nil, fmt.Errorf("gateway did not start listening on port %s within %s", port, cfg.StartupTimeout)
+}
+
+// stopGateway sends SIGTERM to the gateway and kills it if it does not exit in time
+func stopGateway(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+}
+
+// orDefault shows a sweep setting left at the gateway's default as "default"
+func orDefault(value string, unset string) string {
+	if value == unset {
+		return "default"
+	}
+	return value
+}
+
+func printSweepTable(points []SweepPoint) {
+	fmt.Printf("\nGateway Tuning Table:\n")
+	fmt.Printf("%-12s %-12s %-12s %-12s %-10s %-8s %-10s | %-14s %-10s %-12s %-12s\n",
+		"concurrency", "buffer-size", "pool-size", "server-conc", "gomaxprocs", "gogc", "gomemlimit",
+		"throughput/s", "success%", "p99 (ms)", "peak mem MB")
+	for _, p := range points {
+		settings := fmt.Sprintf("%-12d %-12d %-12d %-12d %-10s %-8s %-10s", p.Concurrency, p.BufferSize, p.InitialPoolSize, p.ServerConcurrency,
+			orDefault(strconv.Itoa(p.GOMAXPROCS), "0"), orDefault(p.GOGC, ""), orDefault(p.GOMEMLIMIT, ""))
+		if p.Err != nil {
+			fmt.Printf("%s | error: %v\n", settings, p.Err)
+			continue
+		}
+		fmt.Printf("%s | %-14.2f %-10.2f %-12.2f %-12.2f\n", settings, p.ThroughputRPS, p.SuccessRate, p.P99LatencyMs, p.PeakMemoryMB)
+	}
+}
+
+func saveSweep(points []SweepPoint, outputFile string) {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		log.Printf("Warning: Could not create sweep file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"concurrency", "buffer_size", "initial_pool_size", "server_concurrency", "gomaxprocs", "gogc", "gomemlimit",
+		"throughput_rps", "success_rate", "p99_latency_ms", "peak_memory_mb", "error"})
+	for _, p := range points {
+		errMsg := ""
+		if p.Err != nil {
+			errMsg = p.Err.Error()
+		}
+		w.Write([]string{
+			strconv.Itoa(p.Concurrency),
+			strconv.Itoa(p.BufferSize),
+			strconv.Itoa(p.InitialPoolSize),
+			strconv.Itoa(p.ServerConcurrency),
+			strconv.Itoa(p.GOMAXPROCS),
+			p.GOGC,
+			p.GOMEMLIMIT,
+			strconv.FormatFloat(p.ThroughputRPS, 'f', 2, 64),
+			strconv.FormatFloat(p.SuccessRate, 'f', 2, 64),
+			strconv.FormatFloat(p.P99LatencyMs, 'f', 2, 64),
+			strconv.FormatFloat(p.PeakMemoryMB, 'f', 2, 64),
+			errMsg,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Warning: Could not write sweep file: %v", err)
+		return
+	}
+
+	fmt.Printf("Sweep results saved to %s\n", outputFile)
+}
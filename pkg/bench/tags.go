@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tagNamePattern keeps tag names usable as JSON paths in SQL queries over saved results
+var tagNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Tags are name=value labels describing a run, e.g. machine=m5.2xlarge or bifrost=v1.2.0. They
+// implement flag.Value, so -tag can be repeated.
+type Tags map[string]string
+
+// String lists the tags sorted by name, e.g. "bifrost=v1.2.0,machine=m5.2xlarge"
+func (t Tags) String() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + t[name]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set adds one name=value tag
+func (t *Tags) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || !tagNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid tag %q, use name=value with a name of letters, digits, '.', '_' or '-'", s)
+	}
+	if *t == nil {
+		*t = make(Tags)
+	}
+	(*t)[name] = strings.TrimSpace(value)
+	return nil
+}
+
+// Matches reports whether tags has every one of t's tags
+func (t Tags) Matches(tags map[string]string) bool {
+	for name, value := range t {
+		if got, ok := tags[name]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// groupLabel names the group a run falls in when grouping by the given tags, e.g.
+// "machine=m5.2xlarge,bifrost=v1.2.0". Runs without a tag are grouped under name=-.
+func groupLabel(tags map[string]string, groupBy []string) string {
+	pairs := make([]string, len(groupBy))
+	for i, name := range groupBy {
+		value, ok := tags[name]
+		if !ok {
+			value = "-"
+		}
+		pairs[i] = name + "=" + value
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,137 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// DefaultTargeter fills the route's payload template, with the target's body fields and model mix
+const DefaultTargeter = "default"
+
+// TargeterFactory builds the targeter a target is attacked with. arg is the text after the colon
+// in -targeter name:arg, e.g. the log file to sample payloads from, and "" without one. Factories
+// are called once per target, before its attack starts.
+type TargeterFactory func(target Target, arg string) (vegeta.Targeter, error)
+
+var (
+	targetersMu sync.RWMutex
+	targeters   = map[string]TargeterFactory{
+		DefaultTargeter: func(target Target, _ string) (vegeta.Targeter, error) { return createTargeter(target), nil },
+		"jsonl":         jsonlTargeter,
+	}
+)
+
+// RegisterTargeter makes a targeter selectable by name with -targeter or Scenario.Targeter.
+// Call it from an init function, like database/sql drivers; it panics if the name is taken.
+func RegisterTargeter(name string, factory TargeterFactory) {
+	targetersMu.Lock()
+	defer targetersMu.Unlock()
+
+	if name == "" || strings.Contains(name, ":") {
+		panic(fmt.Sprintf("bench: invalid targeter name %q", name))
+	}
+	if _, ok := targeters[name]; ok {
+		panic(fmt.Sprintf("bench: targeter %q registered twice", name))
+	}
+	targeters[name] = factory
+}
+
+// TargeterNames lists the registered targeters
+func TargeterNames() []string {
+	targetersMu.RLock()
+	defer targetersMu.RUnlock()
+	return targeterNames()
+}
+
+// targeterNames lists the registered targeters; callers hold targetersMu
+func targeterNames() []string {
+	var names []string
+	for name := range targeters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// LookupTargeter validates a name[:arg] targeter setting; "" is the default targeter
+func LookupTargeter(spec string) (factory TargeterFactory, arg string, err error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	if name == "" {
+		name = DefaultTargeter
+	}
+
+	targetersMu.RLock()
+	defer targetersMu.RUnlock()
+	factory, ok := targeters[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown targeter %q (registered: %s)", name, strings.Join(targeterNames(), ", "))
+	}
+	return factory, arg, nil
+}
+
+// newTargeter builds the targeter spec names for a target
+func newTargeter(spec string, target Target) (vegeta.Targeter, error) {
+	factory, arg, err := LookupTargeter(spec)
+	if err != nil {
+		return nil, err
+	}
+	targeter, err := factory(target, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create targeter %q: %v", spec, err)
+	}
+	return targeter, nil
+}
+
+// jsonlTargeter samples request bodies at random from a file with one JSON body per line, e.g.
+// requests captured from production traffic. The target's body fields and model mix still apply.
+func jsonlTargeter(target Target, path string) (vegeta.Targeter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("jsonl needs a file, e.g. -targeter jsonl:traffic.jsonl")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var bodies [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		body := bytes.TrimSpace(scanner.Bytes())
+		if len(body) == 0 {
+			continue
+		}
+		if !json.Valid(body) {
+			return nil, fmt.Errorf("%s:%d is not valid JSON", path, line)
+		}
+		bodies = append(bodies, bytes.Clone(body))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if len(bodies) == 0 {
+		return nil, fmt.Errorf("%s has no request bodies", path)
+	}
+
+	return func(tgt *vegeta.Target) error {
+		body, err := applyBodyOverrides(target, bodies[rand.IntN(len(bodies))])
+		if err != nil {
+			return err
+		}
+		tgt.Method = target.Method
+		tgt.URL = target.Endpoint
+		tgt.Body = body
+		tgt.Header = target.Header.Clone()
+		return nil
+	}, nil
+}
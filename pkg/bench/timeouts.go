@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// timeoutKind says which side of the connection gave up on a request
+type timeoutKind int
+
+const (
+	noTimeout     timeoutKind = iota
+	clientTimeout             // the load generator gave up waiting
+	serverTimeout             // the target answered with a timeout response
+)
+
+// clientTimeoutMarkers are substrings of Go HTTP client errors caused by local timeouts
+var clientTimeoutMarkers = []string{
+	"Client.Timeout exceeded",
+	"context deadline exceeded",
+	"i/o timeout",
+	"TLS handshake timeout",
+}
+
+// classifyTimeout distinguishes client-side timeouts from server-sent 504/timeout responses,
+// since the two point at different fixes (load generator settings vs gateway/upstream capacity)
+func classifyTimeout(res *vegeta.Result) timeoutKind {
+	if res.Error != "" && res.Code == 0 {
+		for _, marker := range clientTimeoutMarkers {
+			if strings.Contains(res.Error, marker) {
+				return clientTimeout
+			}
+		}
+		return noTimeout
+	}
+
+	switch {
+	case res.Code == http.StatusGatewayTimeout, res.Code == http.StatusRequestTimeout:
+		return serverTimeout
+	case res.Code >= 500 && mentionsTimeout(res.Body):
+		// Gateways often wrap upstream timeouts in a generic 5xx
+		return serverTimeout
+	}
+	return noTimeout
+}
+
+func mentionsTimeout(body []byte) bool {
+	body = bytes.ToLower(body)
+	return bytes.Contains(body, []byte("timeout")) ||
+		bytes.Contains(body, []byte("timed out")) ||
+		bytes.Contains(body, []byte("deadline exceeded"))
+}
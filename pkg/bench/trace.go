@@ -0,0 +1,236 @@
+package bench
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// traceHeader carries the per-request trace ID from the runner through the gateway to the mocker
+const traceHeader = "X-Trace-Id"
+
+// requestIDHeader carries the per-request ID that correlates runner, gateway and mocker logs
+const requestIDHeader = "X-Request-ID"
+
+// maxFailedRequests caps the failed requests whose IDs are kept per provider
+const maxFailedRequests = 100
+
+// traceTransport stamps every outgoing request with a unique request ID derived from the
+// vegeta attack name and sequence number. When tracing, the same ID is sent as trace ID so
+// results can be joined with mocker records.
+type traceTransport struct {
+	next       http.RoundTripper
+	runID      string
+	trace      bool
+	verifyBody bool             // Compare each body with the checksum the mocker echoes, see bodyCheckHeader
+	protocols  *protocolCounter // Counts the protocol of every response
+	phases     *phaseSampler    // Times the connection phases of sampled requests, nil without sampling
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	seq, err := strconv.ParseUint(req.Header.Get("X-Vegeta-Seq"), 10, 64)
+	if err != nil {
+		return t.roundTrip(req)
+	}
+
+	id := traceID(t.runID, req.Header.Get("X-Vegeta-Attack"), seq)
+	req = req.Clone(req.Context())
+	req.Header.Set(requestIDHeader, id)
+	if t.trace {
+		req.Header.Set(traceHeader, id)
+	}
+	req, phases := t.phases.Trace(req, seq)
+	resp, err := t.roundTrip(req)
+	if err == nil && phases != nil {
+		phases.Wrap(resp)
+	}
+	return resp, err
+}
+
+// roundTrip sends a request on the underlying transport, counting the protocol of its response
+func (t *traceTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	var sent sentBody
+	if t.verifyBody {
+		var err error
+		if sent, err = requestBody(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && t.protocols != nil {
+		t.protocols.Add(resp.Proto)
+	}
+	if err == nil && t.verifyBody {
+		resp.Header.Set(bodyCheckHeader, sent.check(resp.Header.Get))
+	}
+	return resp, err
+}
+
+// traceID builds the trace ID for a request of the given attack
+func traceID(runID string, attack string, seq uint64) string {
+	return fmt.Sprintf("%s-%s-%d", runID, strings.ToLower(attack), seq)
+}
+
+// newFailedRequest records the ID of a failed request along with why it failed
+func newFailedRequest(runID string, attack string, res *vegeta.Result, reason string) resultfile.FailedRequest {
+	return resultfile.FailedRequest{
+		RequestID: traceID(runID, attack, res.Seq),
+		Seq:       res.Seq,
+		Timestamp: formatTimestamp(res.Timestamp),
+		Code:      int(res.Code),
+		Reason:    reason,
+	}
+}
+
+// printFailedRequests prints the IDs of the first failed requests, to look up in gateway and mocker logs
+func printFailedRequests(requests []resultfile.FailedRequest, failed int) {
+	if len(requests) == 0 {
+		return
+	}
+	fmt.Printf("  Failed Requests (%s, first %d shown):\n", report.Int(int64(failed)), min(len(requests), 5))
+	for _, r := range requests[:min(len(requests), 5)] {
+		fmt.Printf("    %s at %s: %s\n", r.RequestID, r.Timestamp, r.Reason)
+	}
+}
+
+// ClientTrace is the runner's view of a single traced request
+type ClientTrace struct {
+	TraceID string
+	SentAt  time.Time
+	Latency time.Duration
+}
+
+// MockerTrace is the mocker's record of a single traced request
+type MockerTrace struct {
+	TraceID     string    `json:"trace_id"`
+	ReceivedAt  time.Time `json:"received_at"`
+	RespondedAt time.Time `json:"responded_at"`
+}
+
+// TraceBreakdown decomposes the client-observed latency of one request
+type TraceBreakdown struct {
+	TraceID        string  `json:"trace_id"`
+	ClientMs       float64 `json:"client_ms"`
+	ToUpstreamMs   float64 `json:"to_upstream_ms"`   // client send -> mocker receive (network + gateway ingress)
+	UpstreamMs     float64 `json:"upstream_ms"`      // mocker receive -> mocker respond
+	FromUpstreamMs float64 `json:"from_upstream_ms"` // mocker respond -> client receive (gateway egress + network)
+}
+
+// newClientTrace records the runner side of a vegeta result
+func newClientTrace(runID string, attack string, res *vegeta.Result) ClientTrace {
+	return ClientTrace{
+		TraceID: traceID(runID, attack, res.Seq),
+		SentAt:  res.Timestamp,
+		Latency: res.Latency,
+	}
+}
+
+// fetchMockerTraces downloads and clears the trace records collected by the mocker
+func fetchMockerTraces(mockerURL string, tlsConfig *tls.Config) (map[string]MockerTrace, error) {
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(strings.TrimRight(mockerURL, "/") + "/traces?reset=true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch mocker traces: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch mocker traces: HTTP %d", resp.StatusCode)
+	}
+
+	if dropped := resp.Header.Get("X-Traces-Dropped"); dropped != "" && dropped != "0" {
+		log.Printf("Warning: the mocker overwrote its %s oldest traces, so they can't be correlated", dropped)
+	}
+
+	var records []MockerTrace
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("failed to parse mocker traces: %v", err)
+	}
+
+	traces := make(map[string]MockerTrace, len(records))
+	for _, r := range records {
+		traces[r.TraceID] = r
+	}
+	return traces, nil
+}
+
+// correlateTraces joins client traces with mocker traces. Requests that never reached
+// the mocker (errors, or gateways that drop the trace header) are skipped.
+func correlateTraces(client []ClientTrace, mocker map[string]MockerTrace) []TraceBreakdown {
+	breakdowns := make([]TraceBreakdown, 0, len(client))
+	for _, c := range client {
+		m, ok := mocker[c.TraceID]
+		if !ok {
+			continue
+		}
+		receivedAt := c.SentAt.Add(c.Latency)
+		breakdowns = append(breakdowns, TraceBreakdown{
+			TraceID:        c.TraceID,
+			ClientMs:       float64(c.Latency) / float64(time.Millisecond),
+			ToUpstreamMs:   float64(m.ReceivedAt.Sub(c.SentAt)) / float64(time.Millisecond),
+			UpstreamMs:     float64(m.RespondedAt.Sub(m.ReceivedAt)) / float64(time.Millisecond),
+			FromUpstreamMs: float64(receivedAt.Sub(m.RespondedAt)) / float64(time.Millisecond),
+		})
+	}
+	return breakdowns
+}
+
+// printTraceSummary prints the mean latency decomposition across correlated requests
+func printTraceSummary(breakdowns []TraceBreakdown, total int) {
+	if len(breakdowns) == 0 {
+		fmt.Println("  No requests could be correlated with mocker traces (the gateway must forward X-Trace-Id, which Bifrost only does with -passthrough)")
+		return
+	}
+
+	var mean TraceBreakdown
+	for _, b := range breakdowns {
+		mean.ClientMs += b.ClientMs
+		mean.ToUpstreamMs += b.ToUpstreamMs
+		mean.UpstreamMs += b.UpstreamMs
+		mean.FromUpstreamMs += b.FromUpstreamMs
+	}
+	n := float64(len(breakdowns))
+
+	ms := func(v float64) string { return report.Duration(msDuration(v)) }
+	fmt.Printf("  Traced Requests: %s/%s\n", report.Int(int64(len(breakdowns))), report.Int(int64(total)))
+	fmt.Printf("  Mean Client Latency: %s\n", ms(mean.ClientMs/n))
+	fmt.Printf("    To Upstream (network + gateway): %s\n", ms(mean.ToUpstreamMs/n))
+	fmt.Printf("    Upstream: %s\n", ms(mean.UpstreamMs/n))
+	fmt.Printf("    From Upstream (gateway + network): %s\n", ms(mean.FromUpstreamMs/n))
+}
+
+// SaveTraces writes per-request latency breakdowns for every provider as JSON lines
+func SaveTraces(results []Result, outputFile string) {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		log.Printf("Warning: Could not create trace file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, res := range results {
+		for _, b := range res.Traces {
+			record := struct {
+				Provider string `json:"provider"`
+				TraceBreakdown
+			}{strings.ToLower(res.ProviderName), b}
+			if err := encoder.Encode(record); err != nil {
+				log.Printf("Warning: Could not write trace record: %v", err)
+				return
+			}
+		}
+	}
+
+	fmt.Printf("Traces saved to %s\n", outputFile)
+}
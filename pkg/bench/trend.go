@@ -0,0 +1,352 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// TrendPoint is one run of a provider in a trend report
+type TrendPoint struct {
+	Run           string // Run ID for the database, file name for a results directory
+	Time          time.Time
+	TargetRate    int
+	P99Ms         float64
+	P99CILowMs    float64 // 0 when the run recorded no confidence interval
+	P99CIHighMs   float64
+	ThroughputRPS float64
+	Tags          map[string]string
+}
+
+// TrendShift is a run whose metric moved significantly away from the runs before it
+type TrendShift struct {
+	Baseline float64 // Mean of the preceding runs
+	Change   float64 // Percent change from the baseline mean
+	Z        float64 // Standard deviations from the baseline mean
+}
+
+// trendOptions are the significance settings of a trend report
+type trendOptions struct {
+	Window    int     // Preceding runs the baseline is computed from
+	Z         float64 // Standard deviations from the baseline that count as a shift
+	MinChange float64 // Smallest change in percent that counts as a shift, however quiet the baseline
+}
+
+// RunTrend implements the `trend` command, printing each provider's P99 and throughput across
+// runs with significant shifts highlighted
+func RunTrend(args []string) error {
+	fs := flag.NewFlagSet("trend", flag.ExitOnError)
+	dbPath := fs.String("db", "", "SQLite results database to read runs from")
+	dir := fs.String("dir", "", "Directory of results files (e.g., results-2025-06-01.json) to read runs from, instead of -db")
+	provider := fs.String("provider", "", "Only show this provider (empty for all providers)")
+	rate := fs.Int("rate", 0, "Only show runs at this target rate (0 for all rates)")
+	scenario := fs.String("scenario", "", "Only show runs of this scenario (-db only)")
+	window := fs.Int("window", 5, "Number of preceding runs each run is compared with")
+	z := fs.Float64("z", 3, "Standard deviations from the preceding runs that make a shift significant")
+	minChange := fs.Float64("min-change", 5, "Smallest change in percent reported as a shift")
+	output := fs.String("output", "", "Also write the time series to this CSV file")
+	var tags Tags
+	fs.Var(&tags, "tag", "Only show runs with this name=value tag (repeatable)")
+	groupBy := fs.String("group-by", "", "Comma separated tag names to split each provider's runs into separate series by, e.g. machine,bifrost")
+	fs.Parse(args)
+
+	if (*dbPath == "") == (*dir == "") {
+		return fmt.Errorf("trend needs exactly one of -db or -dir")
+	}
+	if *window < 2 {
+		return fmt.Errorf("-window must be at least 2")
+	}
+
+	var series map[string][]TrendPoint
+	var err error
+	if *dbPath != "" {
+		series, err = loadTrendDB(*dbPath, *rate, *scenario)
+	} else {
+		series, err = loadTrendDir(*dir, *rate)
+	}
+	if err != nil {
+		return err
+	}
+	if *provider != "" {
+		name := strings.ToLower(*provider)
+		series = map[string][]TrendPoint{name: series[name]}
+	}
+	var groups []string
+	if *groupBy != "" {
+		groups = ParseStringList(*groupBy)
+	}
+	series = groupTrend(series, tags, groups)
+
+	providers := make([]string, 0, len(series))
+	for name, points := range series {
+		if len(points) > 0 {
+			providers = append(providers, name)
+		}
+	}
+	if len(providers) == 0 {
+		fmt.Println("No runs found")
+		return nil
+	}
+	sort.Strings(providers)
+
+	opts := trendOptions{Window: *window, Z: *z, MinChange: *minChange}
+	for _, name := range providers {
+		printTrend(name, series[name], opts)
+	}
+
+	if *output != "" {
+		if err := saveTrend(series, providers, opts, *output); err != nil {
+			return err
+		}
+		fmt.Printf("\nTrend saved to %s\n", *output)
+	}
+	return nil
+}
+
+// loadTrendDB reads every provider's runs from the results database, oldest first
+func loadTrendDB(dbPath string, rate int, scenario string) (map[string][]TrendPoint, error) {
+	db, err := openResultsDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT r.id, r.started_at, p.provider, p.target_rate, p.p99_latency_ms, p.throughput_rps, p.summary
+		FROM provider_results p JOIN runs r ON r.id = p.run_id
+		WHERE (? = 0 OR p.target_rate = ?) AND (? = '' OR r.scenario = ?)
+		ORDER BY r.started_at, r.id`, rate, rate, scenario, scenario)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runs: %v", err)
+	}
+	defer rows.Close()
+
+	series := make(map[string][]TrendPoint)
+	for rows.Next() {
+		var runID int64
+		var startedAt, provider, summaryJSON string
+		var p TrendPoint
+		if err := rows.Scan(&runID, &startedAt, &provider, &p.TargetRate, &p.P99Ms, &p.ThroughputRPS, &summaryJSON); err != nil {
+			return nil, fmt.Errorf("failed to read runs: %v", err)
+		}
+		p.Run = strconv.FormatInt(runID, 10)
+		p.Time, _ = time.Parse(time.RFC3339, startedAt)
+
+		var summary resultfile.ProviderResult
+		if err := json.Unmarshal([]byte(summaryJSON), &summary); err == nil {
+			p.P99CILowMs, p.P99CIHighMs = summary.P99CILowMs, summary.P99CIHighMs
+			p.Tags = summary.Tags
+		}
+		series[provider] = append(series[provider], p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read runs: %v", err)
+	}
+	return series, nil
+}
+
+// loadTrendDir reads every results file in a directory, ordering runs by their recorded timestamp
+func loadTrendDir(dir string, rate int) (map[string][]TrendPoint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list results files: %v", err)
+	}
+
+	series := make(map[string][]TrendPoint)
+	for _, path := range paths {
+		file, err := resultfile.Load(path)
+		if err != nil {
+			log.Printf("Warning: Skipping %s: %v", path, err)
+			continue
+		}
+		for provider, summary := range file.Providers {
+			if rate != 0 && summary.TargetRate != rate {
+				continue
+			}
+			p := TrendPoint{
+				Run:           filepath.Base(path),
+				TargetRate:    summary.TargetRate,
+				P99Ms:         summary.P99LatencyMs,
+				P99CILowMs:    summary.P99CILowMs,
+				P99CIHighMs:   summary.P99CIHighMs,
+				ThroughputRPS: summary.ThroughputRPS,
+				Tags:          summary.Tags,
+			}
+			if p.Time, err = time.Parse(time.RFC3339, summary.Timestamp); err != nil {
+				log.Printf("Warning: Skipping %s in %s: invalid timestamp %q", provider, path, summary.Timestamp)
+				continue
+			}
+			series[provider] = append(series[provider], p)
+		}
+	}
+
+	for _, points := range series {
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	}
+	return series, nil
+}
+
+// groupTrend keeps the runs matching every filter tag and splits each provider's runs by the
+// values of the groupBy tags, so runs on different machines or versions aren't compared as one
+// series. Series are then keyed e.g. "bifrost [machine=m5.2xlarge]".
+func groupTrend(series map[string][]TrendPoint, filter Tags, groupBy []string) map[string][]TrendPoint {
+	if len(filter) == 0 && len(groupBy) == 0 {
+		return series
+	}
+
+	grouped := make(map[string][]TrendPoint)
+	for provider, points := range series {
+		for _, p := range points {
+			if !filter.Matches(p.Tags) {
+				continue
+			}
+			key := provider
+			if len(groupBy) > 0 {
+				key = fmt.Sprintf("%s [%s]", provider, groupLabel(p.Tags, groupBy))
+			}
+			grouped[key] = append(grouped[key], p)
+		}
+	}
+	return grouped
+}
+
+// detectShift compares a value with the mean of the preceding runs. It is a shift when it lies more
+// than opts.Z standard deviations and at least opts.MinChange percent away from that mean.
+func detectShift(value float64, baseline []float64, opts trendOptions) (TrendShift, bool) {
+	if len(baseline) < 2 {
+		return TrendShift{}, false
+	}
+
+	var mean float64
+	for _, v := range baseline {
+		mean += v
+	}
+	mean /= float64(len(baseline))
+	var variance float64
+	for _, v := range baseline {
+		variance += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(baseline)-1))
+
+	shift := TrendShift{Baseline: mean, Change: percentChange(mean, value)}
+	if stddev > 0 {
+		shift.Z = (value - mean) / stddev
+	} else {
+		shift.Z = math.Inf(int(math.Copysign(1, value-mean)))
+	}
+	return shift, math.Abs(shift.Z) >= opts.Z && math.Abs(shift.Change) >= opts.MinChange
+}
+
+// p99Shift detects a P99 shift, ignoring it when the run's own confidence interval still contains
+// the baseline mean, since the run then can't tell the two apart
+func p99Shift(points []TrendPoint, i int, opts trendOptions) (TrendShift, bool) {
+	baseline := make([]float64, 0, opts.Window)
+	for _, p := range points[max(i-opts.Window, 0):i] {
+		baseline = append(baseline, p.P99Ms)
+	}
+	shift, ok := detectShift(points[i].P99Ms, baseline, opts)
+	if !ok {
+		return shift, false
+	}
+
+	p := points[i]
+	if p.P99CIHighMs > 0 && shift.Baseline >= p.P99CILowMs && shift.Baseline <= p.P99CIHighMs {
+		return shift, false
+	}
+	return shift, true
+}
+
+// throughputShift detects a throughput shift against the preceding runs
+func throughputShift(points []TrendPoint, i int, opts trendOptions) (TrendShift, bool) {
+	baseline := make([]float64, 0, opts.Window)
+	for _, p := range points[max(i-opts.Window, 0):i] {
+		baseline = append(baseline, p.ThroughputRPS)
+	}
+	return detectShift(points[i].ThroughputRPS, baseline, opts)
+}
+
+// formatShift describes a shift for the trend table, e.g. "^ +42.0% (z=6.3)"
+func formatShift(shift TrendShift, ok bool) string {
+	if !ok {
+		return ""
+	}
+	arrow := "^"
+	if shift.Change < 0 {
+		arrow = "v"
+	}
+	return fmt.Sprintf("%s %+.1f%% (z=%.1f)", arrow, shift.Change, shift.Z)
+}
+
+func printTrend(provider string, points []TrendPoint, opts trendOptions) {
+	fmt.Printf("\nTrend for %s (%d runs, shifts against the previous %d runs):\n", provider, len(points), opts.Window)
+	fmt.Printf("%-24s %-21s %-6s %-12s %-22s %-14s %-22s\n",
+		"run", "time", "rate", "p99 (ms)", "p99 shift", "throughput/s", "throughput shift")
+
+	shifts := 0
+	for i, p := range points {
+		p99, p99OK := p99Shift(points, i, opts)
+		rps, rpsOK := throughputShift(points, i, opts)
+		if p99OK || rpsOK {
+			shifts++
+		}
+		fmt.Printf("%-24s %-21s %-6d %-12s %-22s %-14s %-22s\n", p.Run, formatTimestamp(p.Time), p.TargetRate,
+			report.Float(p.P99Ms, 2), formatShift(p99, p99OK), report.Float(p.ThroughputRPS, 2), formatShift(rps, rpsOK))
+	}
+
+	if len(points) > 1 {
+		first, last := points[0], points[len(points)-1]
+		fmt.Printf("Over %d runs: P99 %+.1f%%, throughput %+.1f%%, runs with a significant shift: %d\n", len(points),
+			percentChange(first.P99Ms, last.P99Ms), percentChange(first.ThroughputRPS, last.ThroughputRPS), shifts)
+	}
+}
+
+// shiftColumn is a significant shift's percent change for the CSV, empty otherwise
+func shiftColumn(shift TrendShift, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(shift.Change, 'f', 2, 64)
+}
+
+// saveTrend writes every provider's time series with its significant shifts as CSV
+func saveTrend(series map[string][]TrendPoint, providers []string, opts trendOptions, outputFile string) error {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create trend file: %v", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"provider", "run", "time", "target_rate", "p99_latency_ms", "p99_ci_low_ms", "p99_ci_high_ms",
+		"throughput_rps", "p99_shift_percent", "throughput_shift_percent", "tags"})
+	for _, provider := range providers {
+		points := series[provider]
+		for i, p := range points {
+			w.Write([]string{
+				provider,
+				p.Run,
+				formatTimestamp(p.Time),
+				strconv.Itoa(p.TargetRate),
+				strconv.FormatFloat(p.P99Ms, 'f', 3, 64),
+				strconv.FormatFloat(p.P99CILowMs, 'f', 3, 64),
+				strconv.FormatFloat(p.P99CIHighMs, 'f', 3, 64),
+				strconv.FormatFloat(p.ThroughputRPS, 'f', 2, 64),
+				shiftColumn(p99Shift(points, i, opts)),
+				shiftColumn(throughputShift(points, i, opts)),
+				Tags(p.Tags).String(),
+			})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
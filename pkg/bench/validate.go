@@ -0,0 +1,60 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// chatCompletionResponse is the subset of an OpenAI chat completion used for validation
+type chatCompletionResponse struct {
+	Object  string `json:"object"`
+	Choices []struct {
+		Message *struct {
+			Role    string  `json:"role"`
+			Content *string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+		TotalTokens      int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// validateChatCompletion checks that a 200 response body is a semantically valid chat completion.
+// The returned error message is short and stable so it can be used as a drop reason.
+func validateChatCompletion(body []byte) error {
+	if len(body) == 0 {
+		return fmt.Errorf("empty body")
+	}
+
+	var resp chatCompletionResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("malformed json")
+	}
+
+	if len(resp.Choices) == 0 {
+		return fmt.Errorf("no choices")
+	}
+
+	if resp.Choices[0].Message == nil {
+		return fmt.Errorf("missing message")
+	}
+
+	if resp.Usage == nil {
+		return fmt.Errorf("missing usage")
+	}
+
+	return nil
+}
+
+// validSuccessRate returns the success rate as a percentage, excluding semantically invalid 200s
+func validSuccessRate(metrics *vegeta.Metrics, invalidResponses int) float64 {
+	if metrics.Requests == 0 {
+		return 0
+	}
+	successes := metrics.Success*float64(metrics.Requests) - float64(invalidResponses)
+	return 100.0 * successes / float64(metrics.Requests)
+}
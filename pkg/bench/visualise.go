@@ -0,0 +1,251 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	chart "github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 450
+
+	chartTicks = 5
+)
+
+// chartQuantiles are the percentiles drawn on latency charts
+var chartQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// chartPalette colors series in order, wrapping around for many providers
+var chartPalette = []drawing.Color{
+	{R: 0x1f, G: 0x77, B: 0xb4, A: 0xff},
+	{R: 0xff, G: 0x7f, B: 0x0e, A: 0xff},
+	{R: 0x2c, G: 0xa0, B: 0x2c, A: 0xff},
+	{R: 0xd6, G: 0x27, B: 0x28, A: 0xff},
+	{R: 0x94, G: 0x67, B: 0xbd, A: 0xff},
+	{R: 0x8c, G: 0x56, B: 0x4b, A: 0xff},
+}
+
+// chartBackground leaves room above the plot for the title and below it for bar labels
+var chartBackground = chart.Style{Padding: chart.Box{Top: 50, Left: 10, Right: 20, Bottom: 30}}
+
+// chartRenderer is something go-chart can draw, a line or a bar chart
+type chartRenderer interface {
+	Render(rp chart.RendererProvider, w io.Writer) error
+}
+
+// chartSeries is one named set of values, bars per group or points of a line
+type chartSeries struct {
+	Name   string
+	X      []float64 // Only used by line charts
+	Values []float64
+}
+
+// lineStyle is the style of the i-th line
+func lineStyle(i int) chart.Style {
+	return chart.Style{StrokeColor: chartPalette[i%len(chartPalette)], StrokeWidth: 2}
+}
+
+// barStyle is the style of the i-th series' bars
+func barStyle(i int) chart.Style {
+	c := chartPalette[i%len(chartPalette)]
+	return chart.Style{StrokeColor: c, FillColor: c}
+}
+
+// niceCeiling rounds v up to 1, 2 or 5 times a power of ten so axis ticks are readable
+func niceCeiling(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if v <= m*exp {
+			return m * exp
+		}
+	}
+	return 10 * exp
+}
+
+// formatTick prints an axis value without trailing zeros
+func formatTick(v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return s
+}
+
+// axisTicks returns a range from zero past maxValue with evenly spaced, round ticks. go-chart
+// picks ticks like 4.2 on its own and refuses a range where all values are equal, like a burn
+// rate that stays at zero.
+func axisTicks(maxValue float64) (*chart.ContinuousRange, []chart.Tick) {
+	step := niceCeiling(maxValue / chartTicks)
+	top := step * max(math.Ceil(maxValue/step), 1)
+	var ticks []chart.Tick
+	for v := 0.0; v <= top+step/2; v += step {
+		ticks = append(ticks, chart.Tick{Value: v, Label: formatTick(v)})
+	}
+	return &chart.ContinuousRange{Min: 0, Max: top}, ticks
+}
+
+// legend lists the series names in their colors. Bar charts have no series of their own, so
+// it is drawn from a chart holding only the names and styles.
+func legend(series []chartSeries) chart.Renderable {
+	c := &chart.Chart{}
+	for i, s := range series {
+		c.Series = append(c.Series, chart.ContinuousSeries{Name: s.Name, Style: lineStyle(i)})
+	}
+	return chart.Legend(c)
+}
+
+// barChart draws grouped bars, one group per label and one bar per series within it
+func barChart(title, yLabel string, groups []string, series []chartSeries) chartRenderer {
+	maxValue := 0.0
+	var bars []chart.Value
+	for g, label := range groups {
+		for i, s := range series {
+			if g >= len(s.Values) {
+				continue
+			}
+			maxValue = math.Max(maxValue, s.Values[g])
+			bars = append(bars, chart.Value{Label: label, Value: s.Values[g], Style: barStyle(i)})
+		}
+	}
+	yRange, yTicks := axisTicks(maxValue)
+	return chart.BarChart{
+		Title:      title,
+		Width:      chartWidth,
+		Height:     chartHeight,
+		Background: chartBackground,
+		YAxis:      chart.YAxis{Name: yLabel, Range: yRange, Ticks: yTicks},
+		Bars:       bars,
+		Elements:   []chart.Renderable{legend(series)},
+	}
+}
+
+// lineChart draws one line per series over a shared x axis
+func lineChart(title, yLabel, xLabel string, series []chartSeries) chartRenderer {
+	maxValue, maxX := 0.0, 0.0
+	c := chart.Chart{
+		Title:      title,
+		Width:      chartWidth,
+		Height:     chartHeight,
+		Background: chartBackground,
+	}
+	for i, s := range series {
+		for j, v := range s.Values {
+			maxValue = math.Max(maxValue, v)
+			maxX = math.Max(maxX, s.X[j])
+		}
+		c.Series = append(c.Series, chart.ContinuousSeries{Name: s.Name, Style: lineStyle(i), XValues: s.X, YValues: s.Values})
+	}
+	xRange, xTicks := axisTicks(maxX)
+	yRange, yTicks := axisTicks(maxValue)
+	c.XAxis = chart.XAxis{Name: xLabel, Range: xRange, Ticks: xTicks}
+	c.YAxis = chart.YAxis{Name: yLabel, Range: yRange, Ticks: yTicks}
+	c.Elements = []chart.Renderable{chart.Legend(&c)}
+	return c
+}
+
+// latencySeries returns a provider's chart percentiles in milliseconds
+func latencySeries(result Result) chartSeries {
+	sorted := sortedLatencies(result.Latencies)
+	s := chartSeries{Name: result.ProviderName}
+	for _, q := range chartQuantiles {
+		s.Values = append(s.Values, float64(estimatePercentile(sorted, q).Value)/1e6)
+	}
+	return s
+}
+
+// memorySeries returns a provider's server RSS in MB against seconds since the first sample
+func memorySeries(result Result) chartSeries {
+	s := chartSeries{Name: result.ProviderName}
+	for _, stat := range result.ServerMemoryStats {
+		s.X = append(s.X, stat.Timestamp.Sub(result.ServerMemoryStats[0].Timestamp).Seconds())
+		s.Values = append(s.Values, float64(stat.RSS)/1024/1024)
+	}
+	return s
+}
+
+// WriteCharts renders latency percentile, memory and error budget burn charts per provider, plus combined comparisons, into dir
+func WriteCharts(results []Result, dir, format string) error {
+	renderer := map[string]chart.RendererProvider{"svg": chart.SVG, "png": chart.PNG}[format]
+	if renderer == nil {
+		return fmt.Errorf("unknown chart format %q, use svg or png", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create chart directory: %v", err)
+	}
+
+	groups := make([]string, len(chartQuantiles))
+	for i, q := range chartQuantiles {
+		groups[i] = percentileLabel(q)
+	}
+
+	save := func(name string, c chartRenderer) error {
+		path := filepath.Join(dir, name+"."+format)
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		if err := c.Render(renderer, f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to render %s: %v", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		fmt.Printf("Chart written to %s\n", path)
+		return nil
+	}
+
+	var latencies, memory, burn []chartSeries
+	for _, result := range results {
+		name := strings.ToLower(result.ProviderName)
+		lat := latencySeries(result)
+		mem := memorySeries(result)
+		latencies = append(latencies, lat)
+
+		if err := save(name+"-latency", barChart(result.ProviderName+" latency percentiles", "ms", groups, []chartSeries{lat})); err != nil {
+			return err
+		}
+		if result.ErrorBudget != nil {
+			s := burnSeries(result)
+			burn = append(burn, s)
+			threshold := thresholdSeries(result.ErrorBudget.BurnThreshold, []chartSeries{s})
+			if err := save(name+"-burn", lineChart(result.ProviderName+" error budget burn rate", "burn rate", "seconds", []chartSeries{s, threshold})); err != nil {
+				return err
+			}
+		}
+		if len(mem.Values) == 0 {
+			log.Printf("Warning: no server memory samples for %s, skipping its memory chart", result.ProviderName)
+			continue
+		}
+		memory = append(memory, mem)
+		if err := save(name+"-memory", lineChart(result.ProviderName+" server memory", "RSS MB", "seconds", []chartSeries{mem})); err != nil {
+			return err
+		}
+	}
+
+	if len(results) < 2 {
+		return nil
+	}
+	if len(burn) > 0 {
+		threshold := thresholdSeries(results[0].ErrorBudget.BurnThreshold, burn)
+		if err := save("comparison-burn", lineChart("Error budget burn rate", "burn rate", "seconds", append(burn, threshold))); err != nil {
+			return err
+		}
+	}
+	if err := save("comparison-latency", barChart("Latency percentiles", "ms", groups, latencies)); err != nil {
+		return err
+	}
+	if len(memory) > 0 {
+		return save("comparison-memory", lineChart("Server memory", "RSS MB", "seconds", memory))
+	}
+	return nil
+}
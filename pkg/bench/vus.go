@@ -0,0 +1,266 @@
+package bench
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+
+	vegeta "github.com/tsenart/vegeta/v12/lib"
+)
+
+// vuAttacker runs a closed-loop workload: each virtual user sends a request, waits for the
+// response, thinks for a random time and sends the next one, the way people use a chat
+// application. Unlike vegeta's constant rate, the offered load drops when the target slows
+// down, so it measures latency at a given concurrency rather than at a given rate.
+type vuAttacker struct {
+	client   *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	seqMu sync.Mutex
+	seq   uint64
+	began time.Time
+}
+
+func newVUAttacker(client *http.Client) *vuAttacker {
+	return &vuAttacker{client: client, stop: make(chan struct{})}
+}
+
+// Attack starts users virtual users that loop until duration has passed, returning results in
+// the shape vegeta's attacker produces. The channel closes once every user's last request is done.
+func (a *vuAttacker) Attack(tr vegeta.Targeter, users int, thinkMin, thinkMax time.Duration, duration time.Duration, name string) <-chan *vegeta.Result {
+	results := make(chan *vegeta.Result)
+	a.began = time.Now()
+	deadline := a.began.Add(duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Stagger the first requests over one think time so users don't move in lockstep
+			if !a.sleep(thinkTime(0, thinkMax)) {
+				return
+			}
+			for time.Now().Before(deadline) {
+				results <- a.hit(tr, name)
+				if !a.sleep(thinkTime(thinkMin, thinkMax)) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Stop ends every user's loop after its current request, returning false if already stopped
+func (a *vuAttacker) Stop() bool {
+	stopped := false
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		stopped = true
+	})
+	return stopped
+}
+
+// sleep waits for d, returning false if the attack was stopped meanwhile
+func (a *vuAttacker) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-a.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// thinkTime is uniformly distributed between min and max
+func thinkTime(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + rand.N(max-min)
+}
+
+// hit sends one request the way vegeta's attacker does, with the same headers and error strings
+func (a *vuAttacker) hit(tr vegeta.Targeter, name string) *vegeta.Result {
+	res := vegeta.Result{Attack: name}
+	var err error
+
+	a.seqMu.Lock()
+	res.Timestamp = a.began.Add(time.Since(a.began))
+	res.Seq = a.seq
+	a.seq++
+	a.seqMu.Unlock()
+
+	defer func() {
+		res.Latency = time.Since(res.Timestamp)
+		if err != nil {
+			res.Error = err.Error()
+		}
+	}()
+
+	var tgt vegeta.Target
+	if err = tr(&tgt); err != nil {
+		a.Stop()
+		return &res
+	}
+	res.Method, res.URL = tgt.Method, tgt.URL
+
+	req, err := tgt.Request()
+	if err != nil {
+		return &res
+	}
+	if name != "" {
+		req.Header.Set("X-Vegeta-Attack", name)
+	}
+	req.Header.Set("X-Vegeta-Seq", strconv.FormatUint(res.Seq, 10))
+
+	r, err := a.client.Do(req)
+	if err != nil {
+		return &res
+	}
+	defer r.Body.Close()
+
+	if res.Body, err = io.ReadAll(r.Body); err != nil {
+		return &res
+	}
+	res.BytesIn = uint64(len(res.Body))
+	if req.ContentLength != -1 {
+		res.BytesOut = uint64(req.ContentLength)
+	}
+	if res.Code = uint16(r.StatusCode); res.Code < 200 || res.Code >= 400 {
+		res.Error = r.Status
+	}
+	res.Headers = r.Header
+	return &res
+}
+
+// virtualUserSummary describes a closed-loop run. By Little's law the requests in flight
+// average the request rate times mean latency, which is below the user count by the share of
+// time users spend thinking.
+func virtualUserSummary(opts Scenario, metrics *vegeta.Metrics) *resultfile.VirtualUsers {
+	if opts.VirtualUsers <= 0 {
+		return nil
+	}
+	return &resultfile.VirtualUsers{
+		Users:        opts.VirtualUsers,
+		ThinkMinMs:   float64(opts.ThinkMin) / float64(time.Millisecond),
+		ThinkMaxMs:   float64(opts.ThinkMax) / float64(time.Millisecond),
+		MeanInFlight: metrics.Rate * metrics.Latencies.Mean.Seconds(),
+	}
+}
+
+// VirtualUserPoint is one provider's results at one virtual user count
+type VirtualUserPoint struct {
+	Provider      string
+	Users         int
+	MeanInFlight  float64
+	ThroughputRPS float64
+	SuccessRate   float64
+	MeanLatencyMs float64
+	P50LatencyMs  float64
+	P99LatencyMs  float64
+}
+
+// RunVirtualUserSweep runs the closed-loop workload against every provider at each user count
+// and prints a concurrency vs latency table per provider
+func RunVirtualUserSweep(providers []Target, users []int, opts Scenario, outputFile string) []VirtualUserPoint {
+	var points []VirtualUserPoint
+	for i, n := range users {
+		fmt.Printf("\n%d virtual users (%d/%d)\n", n, i+1, len(users))
+
+		opts.VirtualUsers = n
+		for _, res := range runBenchmarks(context.Background(), providers, opts) {
+			point := VirtualUserPoint{
+				Provider:      strings.ToLower(res.ProviderName),
+				Users:         n,
+				ThroughputRPS: res.Metrics.Throughput,
+				SuccessRate:   100.0 * res.Metrics.Success,
+				MeanLatencyMs: float64(res.Metrics.Latencies.Mean) / float64(time.Millisecond),
+				P50LatencyMs:  float64(res.Metrics.Latencies.P50) / float64(time.Millisecond),
+				P99LatencyMs:  float64(res.Metrics.Latencies.P99) / float64(time.Millisecond),
+			}
+			if res.VirtualUsers != nil {
+				point.MeanInFlight = res.VirtualUsers.MeanInFlight
+			}
+			points = append(points, point)
+		}
+
+		if i < len(users)-1 && opts.Cooldown > 0 {
+			fmt.Printf("Cooling down for %d seconds...\n", opts.Cooldown)
+			time.Sleep(time.Duration(opts.Cooldown) * time.Second)
+		}
+	}
+
+	printVirtualUserTable(providers, points)
+	if outputFile != "" {
+		saveVirtualUserSweep(points, outputFile)
+	}
+	return points
+}
+
+func printVirtualUserTable(providers []Target, points []VirtualUserPoint) {
+	for _, p := range providers {
+		name := strings.ToLower(p.Name)
+		fmt.Printf("\nConcurrency Table for %s:\n", p.Name)
+		fmt.Printf("%-8s | %-10s %-14s %-10s %-14s %-14s %-14s\n",
+			"users", "in flight", "throughput/s", "success%", "mean", "p50", "p99")
+		for _, point := range points {
+			if point.Provider != name {
+				continue
+			}
+			fmt.Printf("%-8d | %-10s %-14s %-10s %-14s %-14s %-14s\n", point.Users,
+				report.Float(point.MeanInFlight, 1), report.Float(point.ThroughputRPS, 2), report.Float(point.SuccessRate, 2),
+				report.Duration(msDuration(point.MeanLatencyMs)), report.Duration(msDuration(point.P50LatencyMs)),
+				report.Duration(msDuration(point.P99LatencyMs)))
+		}
+	}
+}
+
+func saveVirtualUserSweep(points []VirtualUserPoint, outputFile string) {
+	file, err := os.Create(outputFile)
+	if err != nil {
+		log.Printf("Warning: Could not create virtual user sweep file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"provider", "users", "mean_in_flight", "throughput_rps", "success_rate", "mean_latency_ms", "p50_latency_ms", "p99_latency_ms"})
+	for _, p := range points {
+		w.Write([]string{
+			p.Provider,
+			strconv.Itoa(p.Users),
+			strconv.FormatFloat(p.MeanInFlight, 'f', 2, 64),
+			strconv.FormatFloat(p.ThroughputRPS, 'f', 2, 64),
+			strconv.FormatFloat(p.SuccessRate, 'f', 2, 64),
+			strconv.FormatFloat(p.MeanLatencyMs, 'f', 3, 64),
+			strconv.FormatFloat(p.P50LatencyMs, 'f', 3, 64),
+			strconv.FormatFloat(p.P99LatencyMs, 'f', 3, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Printf("Warning: Could not write virtual user sweep file: %v", err)
+		return
+	}
+
+	fmt.Printf("Virtual user sweep results saved to %s\n", outputFile)
+}
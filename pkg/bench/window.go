@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bifrost-benchmarks/resultfile"
+)
+
+// alignedStart returns the first wall-clock multiple of align after now, e.g. the next full
+// minute for 1m, so attacks start on boundaries external monitoring aggregates on. Multiples are
+// counted in UTC. A zero align starts at once.
+func alignedStart(now time.Time, align time.Duration) time.Time {
+	if align <= 0 {
+		return now
+	}
+	return now.Truncate(align).Add(align)
+}
+
+// waitForStart sleeps until the aligned start of the next attack, returning early with ctx's
+// error if it is cancelled meanwhile
+func waitForStart(ctx context.Context, provider string, align time.Duration) error {
+	start := alignedStart(time.Now(), align)
+	wait := time.Until(start)
+	if wait <= 0 {
+		return nil
+	}
+	fmt.Printf("Starting %s at %s, in %s\n", provider, start.UTC().Format(time.RFC3339), wait.Round(time.Millisecond))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// attackWindow is the wall-clock span of one attack, from its first request to the last result
+func attackWindow(start, end time.Time, align time.Duration) *resultfile.AttackWindow {
+	window := &resultfile.AttackWindow{
+		Start: start.UTC().Format(time.RFC3339Nano),
+		End:   end.UTC().Format(time.RFC3339Nano),
+	}
+	if align > 0 {
+		window.AlignedTo = align.String()
+	}
+	return window
+}
@@ -0,0 +1,361 @@
+// Package resultfile defines the versioned results file written by the benchmark runner,
+// and loads files written by older versions of it.
+package resultfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Version is the schema version written by this package. Bump it, and add a migration to
+// Parse, whenever a field is removed or changes meaning. Adding optional fields is fine.
+const Version = 2
+
+// File is a results file: the latest run of every benchmarked provider
+type File struct {
+	SchemaVersion int                       `json:"schema_version"`
+	Providers     map[string]ProviderResult `json:"providers"`
+}
+
+// ProviderResult is the persisted summary of a provider's benchmark run
+type ProviderResult struct {
+	Requests           uint64            `json:"requests"`
+	Route              string            `json:"route,omitempty"`         // API route, e.g. chat or embeddings
+	Tags               map[string]string `json:"tags,omitempty"`          // Labels given with -tag, e.g. machine=m5.2xlarge
+	TargetRate         int               `json:"target_rate,omitempty"`   // Offered requests per second
+	ArrivalTrace       *ArrivalTrace     `json:"arrival_trace,omitempty"` // Arrival-time trace replayed instead of a constant rate
+	VirtualUsers       *VirtualUsers     `json:"virtual_users,omitempty"` // Closed-loop workload, instead of a target rate
+	Engine             string            `json:"engine,omitempty"`        // Load engine other than vegeta that sent the requests
+	DurationSec        int               `json:"duration_sec,omitempty"`  // Attack duration
+	Rate               float64           `json:"rate"`
+	LateRequests       int               `json:"late_requests"` // Sent more than a request interval behind schedule
+	MaxScheduleLagMs   float64           `json:"max_schedule_lag_ms"`
+	GeneratorSaturated bool              `json:"generator_saturated,omitempty"` // The load generator, not the target, limited the run
+	SuccessRate        float64           `json:"success_rate"`
+	MeanLatencyMs      float64           `json:"mean_latency_ms"`
+	P50LatencyMs       float64           `json:"p50_latency_ms"`
+	P99LatencyMs       float64           `json:"p99_latency_ms"`
+	MaxLatencyMs       float64           `json:"max_latency_ms"`
+	ThroughputRPS      float64           `json:"throughput_rps"`
+	Timestamp          string            `json:"timestamp"`
+	AttackWindow       *AttackWindow     `json:"attack_window,omitempty"` // When the attack ran, to match external monitoring
+	StatusCodeCounts   map[string]int    `json:"status_code_counts"`
+	ServerPeakMemoryMB float64           `json:"server_peak_memory_mb"`
+	ServerAvgMemoryMB  float64           `json:"server_avg_memory_mb"`
+	ServerAvgCPU       float64           `json:"server_avg_cpu_percent,omitempty"` // Only measured for docker targets, 100 is one core
+	DropReasons        map[string]int    `json:"drop_reasons"`
+	InvalidResponses   int               `json:"invalid_responses"`
+	ValidSuccessRate   float64           `json:"valid_success_rate"`
+	P999LatencyMs      float64           `json:"p999_latency_ms"`
+	P99CILowMs         float64           `json:"p99_ci_low_ms"`
+	P99CIHighMs        float64           `json:"p99_ci_high_ms"`
+	P999CILowMs        float64           `json:"p999_ci_low_ms"`
+	P999CIHighMs       float64           `json:"p999_ci_high_ms"`
+	Warnings           []string          `json:"warnings,omitempty"`
+	LeakWarnings       []string          `json:"leak_warnings,omitempty"`
+	ClientTimeouts     int               `json:"client_timeouts"`
+	ServerTimeouts     int               `json:"server_timeouts"`
+	UpstreamRequests   *int64            `json:"upstream_requests,omitempty"`
+	Amplification      *float64          `json:"request_amplification,omitempty"`
+	Assertions         []AssertionResult `json:"assertions,omitempty"`
+	SlowestRequests    []SlowRequest     `json:"slowest_requests,omitempty"`
+	FailedRequests     []FailedRequest   `json:"failed_requests,omitempty"`
+	GatewayRuntime     *GatewayRuntime   `json:"gateway_runtime,omitempty"`    // Go runtime settings the gateway reported on -metrics-url
+	Profiles           *Profiles         `json:"profiles,omitempty"`           // pprof profiles captured mid-run with -profile
+	BodyChecks         *BodyChecks       `json:"body_checks,omitempty"`        // Request bodies compared with the mocker's checksums, with -verify-body
+	ConnectionPhases   *ConnectionPhases `json:"connection_phases,omitempty"`  // DNS, connect, TLS, TTFB and body read of sampled requests, with -sample-phases
+	HTTPClient         *HTTPClient       `json:"http_client,omitempty"`        // The runner's HTTP client settings for the provider
+	OmissionCorrected  bool              `json:"omission_corrected,omitempty"` // Headline latencies are measured from the scheduled send time
+	WallLatency        *LatencySummary   `json:"wall_latency,omitempty"`
+	CorrectedLatency   *LatencySummary   `json:"corrected_latency,omitempty"` // Corrected for coordinated omission
+	SteadyState        *SteadyState      `json:"steady_state,omitempty"`      // Metrics without the warm-up and drain transients
+	PricePerformance   *PricePerformance `json:"price_performance,omitempty"` // Requests per dollar, for providers with a cost in the providers config
+	BodySizes          *BodySizes        `json:"body_sizes,omitempty"`        // Bytes sent and received, and the sizes of successful responses
+	Protocols          map[string]int64  `json:"protocols,omitempty"`         // Responses per protocol, e.g. HTTP/1.1 or HTTP/3.0
+	Repeat             *RepeatSummary    `json:"repeat,omitempty"`            // Spread across the runs of -repeat; the other fields are from the last run
+	ErrorBudget        *ErrorBudget      `json:"error_budget,omitempty"`      // Burn against the -slo-success SLO
+	Sockets            *SocketUsage      `json:"load_host_sockets,omitempty"` // The load generator's own TCP sockets during the run
+}
+
+// SocketUsage is the load generator host's TCP socket counts during a run. Sockets are counted
+// host-wide, so other traffic on the host is included.
+type SocketUsage struct {
+	PeakTimeWait    int     `json:"peak_time_wait"`
+	PeakEstablished int     `json:"peak_established"`
+	EphemeralPorts  int     `json:"ephemeral_ports"`         // Size of net.ipv4.ip_local_port_range
+	PeakPortUsage   float64 `json:"peak_port_usage_percent"` // TIME_WAIT plus ESTABLISHED as a percent of EphemeralPorts
+	PortErrors      int     `json:"port_errors"`             // Requests that failed because no ephemeral port was free
+}
+
+// VirtualUsers describes a closed-loop run, where each user waits for its response and thinks
+// before sending the next request
+type VirtualUsers struct {
+	Users        int     `json:"users"`
+	ThinkMinMs   float64 `json:"think_min_ms"`
+	ThinkMaxMs   float64 `json:"think_max_ms"`
+	MeanInFlight float64 `json:"mean_in_flight"` // Request rate times mean latency
+}
+
+// ErrorBudget is how fast a run burned the error budget of a success rate SLO
+type ErrorBudget struct {
+	SLOSuccess    float64        `json:"slo_success"` // Percent of requests that must succeed
+	WindowSec     float64        `json:"window_sec"`  // Rolling window the burn rate is computed over
+	BurnThreshold float64        `json:"burn_threshold"`
+	MaxBurnRate   float64        `json:"max_burn_rate"`
+	BreakingPoint *BreakingPoint `json:"breaking_point,omitempty"` // nil when the SLO held for the whole run
+}
+
+// BreakingPoint is the first window that burned the error budget faster than the threshold
+type BreakingPoint struct {
+	AtSec       float64 `json:"at_sec"`      // End of the window, in seconds since the attack started
+	OfferedRPS  float64 `json:"offered_rps"` // Load level: requests sent per second in the window
+	SuccessRate float64 `json:"success_rate"`
+	BurnRate    float64 `json:"burn_rate"` // Error rate as a multiple of the error budget
+}
+
+// RepeatSummary is the spread of a provider's key metrics across repeated runs
+type RepeatSummary struct {
+	Runs    int                    `json:"runs"`
+	Metrics map[string]MetricStats `json:"metrics"` // Keyed by throughput_rps, success_rate, mean_latency_ms, p50_latency_ms and p99_latency_ms
+}
+
+// MetricStats are the mean, standard deviation and 95% confidence interval of the mean of a metric
+type MetricStats struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	CILow  float64 `json:"ci_low"`
+	CIHigh float64 `json:"ci_high"`
+}
+
+// GatewayRuntime is the Go runtime tuning a gateway ran with
+type GatewayRuntime struct {
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	GOGC       int    `json:"gogc"`             // -1 when the GC is off
+	GOMEMLIMIT int64  `json:"gomemlimit_bytes"` // math.MaxInt64 when there is no limit
+	NumCPU     int    `json:"num_cpu"`
+	GoVersion  string `json:"go_version"`
+	Ballast    int64  `json:"ballast_bytes,omitempty"` // Heap ballast, with the gateway's -ballast
+	Frontend   string `json:"frontend,omitempty"`      // Proxy hop in front of the gateway's own server, like Bifrost's -http2 frontend
+}
+
+// BodyChecks count successful responses by whether the upstream received the request body as sent
+type BodyChecks struct {
+	Intact     int `json:"intact"`
+	Mismatched int `json:"mismatched"` // Corrupted bodies
+	Truncated  int `json:"truncated"`  // Bodies the upstream received fewer bytes of
+	Unverified int `json:"unverified"` // Responses without the mocker's checksum headers
+}
+
+// AttackWindow is the wall-clock span a provider was attacked in, to line the run up with
+// external monitoring such as Grafana or cloud metrics
+type AttackWindow struct {
+	Start     string `json:"start"`                // First request sent, UTC, RFC3339 with nanoseconds
+	End       string `json:"end"`                  // Last result received
+	AlignedTo string `json:"aligned_to,omitempty"` // Wall-clock boundary the start waited for with -align-start, e.g. 1m0s
+}
+
+// SteadyState is a run with its warm-up transient and drain tail trimmed, found by changepoint
+// detection on the rolling P99. The top-level metrics are the raw, untrimmed ones.
+type SteadyState struct {
+	StartSec       float64         `json:"start_sec"` // Seconds into the attack the steady state began
+	EndSec         float64         `json:"end_sec"`
+	WarmupTrimSec  float64         `json:"warmup_trimmed_sec"`
+	TailTrimSec    float64         `json:"tail_trimmed_sec"`
+	Requests       int             `json:"requests"`
+	TrimmedPercent float64         `json:"trimmed_percent"` // Percent of requests left out
+	SuccessRate    float64         `json:"success_rate"`
+	Latency        *LatencySummary `json:"latency,omitempty"`
+}
+
+// ArrivalTrace is the arrival-time trace a provider was attacked with, replayed at its recorded
+// times (divided by the speed-up) and looped when the attack outlasted it
+type ArrivalTrace struct {
+	File     string  `json:"file"`
+	Arrivals int     `json:"arrivals"`
+	SpanSec  float64 `json:"span_sec"` // One pass at the replay speed
+	Speed    float64 `json:"speed"`
+	MeanRPS  float64 `json:"mean_rps"`
+	PeakRPS  int     `json:"peak_rps"` // Most arrivals in one second
+	Passes   float64 `json:"passes"`   // Times the trace was replayed over the attack
+}
+
+// BodySizes are the request and response body bytes of a run. Response bodies are counted after
+// decoding any Content-Encoding; headers are not counted.
+type BodySizes struct {
+	BytesIn   uint64       `json:"bytes_in"`  // Response body bytes of every request
+	BytesOut  uint64       `json:"bytes_out"` // Request body bytes of every request
+	Responses int          `json:"responses"` // Successful responses the size summary is over
+	Response  *SizeSummary `json:"response,omitempty"`
+}
+
+// SizeSummary is the distribution of body sizes
+type SizeSummary struct {
+	MinBytes  uint64  `json:"min_bytes"`
+	MeanBytes float64 `json:"mean_bytes"`
+	P50Bytes  uint64  `json:"p50_bytes"`
+	P90Bytes  uint64  `json:"p90_bytes"`
+	P99Bytes  uint64  `json:"p99_bytes"`
+	MaxBytes  uint64  `json:"max_bytes"`
+}
+
+// PricePerformance prices a run from the provider's cost config at its measured rates
+type PricePerformance struct {
+	HourlyUSD         float64 `json:"hourly_usd"` // Infrastructure, memory and per-request costs at the run's rates
+	RunCostUSD        float64 `json:"run_cost_usd"`
+	RequestsPerDollar float64 `json:"requests_per_dollar"` // Successful requests per dollar
+	TargetP99Ms       float64 `json:"target_p99_ms,omitempty"`
+	MeetsTarget       bool    `json:"meets_target"`
+	Score             float64 `json:"score"` // requests_per_dollar, 0 when the P99 misses the target
+}
+
+// HTTPClient is how the runner's HTTP client was tuned for a provider, set per provider with
+// the client section of the providers config
+type HTTPClient struct {
+	MaxIdleConnsPerHost int     `json:"max_idle_conns_per_host"`
+	IdleConnTimeoutMs   float64 `json:"idle_conn_timeout_ms"`
+	TimeoutMs           float64 `json:"timeout_ms"`
+	DisableCompression  bool    `json:"disable_compression"`
+}
+
+// ConnectionPhases time a sample of requests phase by phase with httptrace, so a slow gateway can
+// be told apart from slow connection setup. Setup phases only count requests that went through
+// them, i.e. that didn't reuse a pooled connection.
+type ConnectionPhases struct {
+	SampleRate     float64       `json:"sample_rate"` // Fraction of requests traced
+	Sampled        int           `json:"sampled"`
+	NewConnections int           `json:"new_connections"`     // Sampled requests that didn't reuse a connection
+	DNS            *PhaseLatency `json:"dns,omitempty"`       // Resolving the host name
+	Connect        *PhaseLatency `json:"connect,omitempty"`   // Dialing TCP
+	TLS            *PhaseLatency `json:"tls,omitempty"`       // The TLS handshake
+	TTFB           *PhaseLatency `json:"ttfb,omitempty"`      // Request written to the first response byte
+	BodyRead       *PhaseLatency `json:"body_read,omitempty"` // First response byte to the end of the body
+}
+
+// PhaseLatency is the distribution of one connection phase, in milliseconds
+type PhaseLatency struct {
+	Count  int     `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// Profiles are the pprof files captured from a Go target while it was under load
+type Profiles struct {
+	CPU        string `json:"cpu,omitempty"`  // Path of the CPU profile
+	CPUSeconds int    `json:"cpu_seconds"`    // How long the CPU profile sampled
+	Heap       string `json:"heap,omitempty"` // Path of the heap profile, taken after the CPU profile
+}
+
+// LatencySummary is a run's latency distribution measured one way, in milliseconds
+type LatencySummary struct {
+	MeanMs float64 `json:"mean_ms"`
+	P50Ms  float64 `json:"p50_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	P999Ms float64 `json:"p999_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// SlowRequest is one of the slowest requests of a run, kept to root-cause tail latency
+type SlowRequest struct {
+	Seq          uint64             `json:"seq"`
+	Timestamp    string             `json:"timestamp"`
+	LatencyMs    float64            `json:"latency_ms"`
+	Code         int                `json:"code"`
+	BytesIn      uint64             `json:"bytes_in"`
+	BytesOut     uint64             `json:"bytes_out"`
+	Error        string             `json:"error,omitempty"`
+	ServerTiming map[string]float64 `json:"server_timing,omitempty"` // Milliseconds per Server-Timing entry sent by the target
+}
+
+// FailedRequest is one of the first failed requests of a run, identified by the X-Request-ID it was sent with
+type FailedRequest struct {
+	RequestID string `json:"request_id"`
+	Seq       uint64 `json:"seq"`
+	Timestamp string `json:"timestamp"`
+	Code      int    `json:"code"`
+	Reason    string `json:"reason"` // The drop reason it was counted under
+}
+
+// AssertionResult is the outcome of one SLO assertion, e.g. p99<50ms, against a provider
+type AssertionResult struct {
+	Assertion string  `json:"assertion"`
+	Metric    string  `json:"metric"`
+	Actual    float64 `json:"actual"`
+	Threshold float64 `json:"threshold"` // Milliseconds for latency metrics
+	Passed    bool    `json:"passed"`
+}
+
+// New returns an empty results file at the current schema version
+func New() *File {
+	return &File{SchemaVersion: Version, Providers: make(map[string]ProviderResult)}
+}
+
+// Load reads a results file of any known version, migrated to the current version
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read results file: %v", err)
+	}
+	return Parse(data)
+}
+
+// Parse decodes a results file of any known version, migrated to the current version
+func Parse(data []byte) (*File, error) {
+	var probe struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse results file: %v", err)
+	}
+
+	// Version 1 files have no schema_version: they are a bare map of provider name to result
+	version := 1
+	if probe.SchemaVersion != nil {
+		version = *probe.SchemaVersion
+	}
+
+	switch {
+	case version == 1:
+		return migrateV1(data)
+	case version == Version:
+		f := New()
+		if err := json.Unmarshal(data, f); err != nil {
+			return nil, fmt.Errorf("failed to parse results file: %v", err)
+		}
+		return f, nil
+	case version > Version:
+		return nil, fmt.Errorf("results file has schema version %d, newer than supported version %d", version, Version)
+	default:
+		return nil, fmt.Errorf("unknown results schema version %d", version)
+	}
+}
+
+// migrateV1 wraps a version 1 provider map in the current envelope
+func migrateV1(data []byte) (*File, error) {
+	f := New()
+	if err := json.Unmarshal(data, &f.Providers); err != nil {
+		return nil, fmt.Errorf("failed to parse version 1 results file: %v", err)
+	}
+	return f, nil
+}
+
+// Save writes the file at the current schema version
+func (f *File) Save(path string) error {
+	f.SchemaVersion = Version
+
+	// Keep assertions such as p99<50ms readable instead of HTML escaped
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return fmt.Errorf("failed to marshal results: %v", err)
+	}
+	if err := os.WriteFile(path, data.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write results file: %v", err)
+	}
+	return nil
+}